@@ -4,12 +4,14 @@ import (
 	"../token"
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -36,6 +38,14 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	} else {
+		return token.Position{}
+	}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -55,8 +65,21 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
+// Integer literal
+
+type IntegerLiteral struct {
+	Token token.Token
+	Value int64
+}
+
+func (il *IntegerLiteral) expressionNode()      {}
+func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+
 // Number literal
 
 type NumberLiteral struct {
@@ -66,6 +89,7 @@ type NumberLiteral struct {
 
 func (il *NumberLiteral) expressionNode()      {}
 func (il *NumberLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *NumberLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *NumberLiteral) String() string       { return il.Token.Literal }
 
 // Boolean literal
@@ -77,6 +101,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 // Null literal
@@ -87,6 +112,7 @@ type Null struct {
 
 func (n *Null) expressionNode()      {}
 func (n *Null) TokenLiteral() string { return n.Token.Literal }
+func (n *Null) Pos() token.Position  { return n.Token.Pos }
 func (n *Null) String() string       { return n.Token.Literal }
 
 // Expression statement
@@ -98,6 +124,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -115,6 +142,7 @@ type DeclareExpression struct {
 
 func (ds *DeclareExpression) expressionNode()      {}
 func (ds *DeclareExpression) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DeclareExpression) Pos() token.Position  { return ds.Token.Pos }
 func (ds *DeclareExpression) String() string {
 	return fmt.Sprintf("(%v := %v)", ds.Name.String(), ds.Value.String())
 }
@@ -125,12 +153,17 @@ type AssignExpression struct {
 	Token token.Token
 	Name  Expression
 	Value Expression
+
+	// Operator is the operator of a compound assignment, like "+" in
+	// "x += 1", or empty for a plain assignment.
+	Operator string
 }
 
 func (as *AssignExpression) expressionNode()      {}
 func (as *AssignExpression) TokenLiteral() string { return as.Token.Literal }
+func (as *AssignExpression) Pos() token.Position  { return as.Token.Pos }
 func (as *AssignExpression) String() string {
-	return fmt.Sprintf("(%v = %v)", as.Name.String(), as.Value.String())
+	return fmt.Sprintf("(%v %v= %v)", as.Name.String(), as.Operator, as.Value.String())
 }
 
 // Return statement
@@ -142,6 +175,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 func (rs *ReturnStatement) String() string {
 	return fmt.Sprintf("return %v;", rs.ReturnValue.String())
 }
@@ -156,6 +190,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	return fmt.Sprintf("(%v%v)", pe.Operator, pe.Right.String())
 }
@@ -171,6 +206,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	return fmt.Sprintf("(%v %v %v)", ie.Left.String(), ie.Operator, ie.Right.String())
 }
@@ -186,6 +222,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -202,15 +239,114 @@ func (ie *IfExpression) String() string {
 	return out.String() + ")"
 }
 
+// Try expression
+
+type TryExpression struct {
+	Token   token.Token
+	Body    *BlockStatement
+	ErrName *Identifier
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(try ")
+	out.WriteString(te.Body.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.ErrName != nil {
+			out.WriteString("(" + te.ErrName.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String() + ")"
+}
+
+// Match expression
+
+type MatchExpression struct {
+	Token   token.Token
+	Subject Expression
+	Arms    []*MatchArm
+	End     token.Position
+}
+
+// MatchArm is one "pattern if guard => body" in a match expression. Guard
+// is nil if the arm doesn't have one. A body which is just an expression,
+// rather than a block, is put in a block whose token is the "=>".
+type MatchArm struct {
+	Pattern Expression
+	Guard   Expression
+	Body    *BlockStatement
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) String() string {
+	arms := []string{}
+	for _, arm := range me.Arms {
+		s := arm.Pattern.String()
+		if arm.Guard != nil {
+			s += " if " + arm.Guard.String()
+		}
+
+		arms = append(arms, s+" => "+arm.Body.String())
+	}
+
+	return "(match " + me.Subject.String() + " { " + strings.Join(arms, ", ") + " })"
+}
+
+// Import expression
+
+type ImportExpression struct {
+	Token token.Token
+	Path  Expression
+	Names []*Identifier
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *ImportExpression) String() string {
+	if len(ie.Names) == 0 {
+		return fmt.Sprintf("(import %v)", ie.Path.String())
+	}
+
+	names := []string{}
+	for _, n := range ie.Names {
+		names = append(names, n.String())
+	}
+
+	return fmt.Sprintf("(import %v from %v)",
+		strings.Join(names, ", "), ie.Path.String())
+}
+
 // Block statement
 
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+
+	// End is the position of the closing brace
+	End token.Position
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -227,10 +363,15 @@ type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+
+	// Patterns holds the pattern each parameter is destructured with, or
+	// nil for a plain parameter. It's nil if no parameters are patterns.
+	Patterns []Expression
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -259,6 +400,7 @@ type ModelLiteral struct {
 
 func (ml *ModelLiteral) expressionNode()      {}
 func (ml *ModelLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *ModelLiteral) Pos() token.Position  { return ml.Token.Pos }
 func (ml *ModelLiteral) String() string {
 	params := []string{}
 	for _, p := range ml.Parameters {
@@ -285,10 +427,14 @@ type LambdaExpression struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       Expression
+
+	// Patterns is the same as in a FunctionLiteral
+	Patterns []Expression
 }
 
 func (le *LambdaExpression) expressionNode()      {}
 func (le *LambdaExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LambdaExpression) Pos() token.Position  { return le.Token.Pos }
 func (le *LambdaExpression) String() string {
 	params := []string{}
 	for _, p := range le.Parameters {
@@ -305,10 +451,14 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+
+	// End is the position of the closing bracket
+	End token.Position
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -334,17 +484,65 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return `"` + sl.Token.Literal + `"` }
 
+// Char Literal
+
+type CharLiteral struct {
+	Token token.Token
+	Value rune
+}
+
+func (cl *CharLiteral) expressionNode()      {}
+func (cl *CharLiteral) TokenLiteral() string { return cl.Token.Literal }
+func (cl *CharLiteral) Pos() token.Position  { return cl.Token.Pos }
+func (cl *CharLiteral) String() string       { return "'" + cl.Token.Literal + "'" }
+
+// Interpolated String
+
+// An InterpolatedString is a string with expressions in it, like
+// "x = ${x}", or any triple quoted string. Its parts are StringLiterals for
+// the text and the expressions between them.
+type InterpolatedString struct {
+	Token token.Token // the opening quote
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(`"`)
+
+	for _, part := range is.Parts {
+		if str, ok := part.(*StringLiteral); ok {
+			out.WriteString(str.Value)
+		} else {
+			out.WriteString("${" + part.String() + "}")
+		}
+	}
+
+	out.WriteString(`"`)
+
+	return out.String()
+}
+
 // Array Literal
 
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+
+	// End is the position of the closing bracket
+	End token.Position
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -370,6 +568,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -382,21 +581,66 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// Slice Expression
+
+// A SliceExpression takes part of an array or a string, like a[1:3] or
+// a[::-1]. Any of the bounds can be left out, and are nil if they are.
+type SliceExpression struct {
+	Token token.Token // the '['
+	Left  Expression
+	Start Expression
+	End   Expression
+	Step  Expression
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() token.Position  { return se.Token.Pos }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	bound := func(e Expression) {
+		if e != nil {
+			out.WriteString(e.String())
+		}
+	}
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	bound(se.Start)
+	out.WriteString(":")
+	bound(se.End)
+
+	if se.Step != nil {
+		out.WriteString(":")
+		bound(se.Step)
+	}
+
+	out.WriteString("])")
+
+	return out.String()
+}
+
 // Hash literal
 
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+
+	// End is the position of the closing brace
+	End token.Position
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+": "+value.String())
+	for _, key := range hl.Keys() {
+		pairs = append(pairs, key.String()+": "+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -406,6 +650,21 @@ func (hl *HashLiteral) String() string {
 	return out.String()
 }
 
+// Keys returns the keys of the hash literal, in the order they were
+// written in.
+func (hl *HashLiteral) Keys() []Expression {
+	keys := []Expression{}
+	for key := range hl.Pairs {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Pos().Offset < keys[j].Pos().Offset
+	})
+
+	return keys
+}
+
 // While expression
 
 type WhileExpression struct {
@@ -416,6 +675,7 @@ type WhileExpression struct {
 
 func (we *WhileExpression) expressionNode()      {}
 func (we *WhileExpression) TokenLiteral() string { return we.Token.Literal }
+func (we *WhileExpression) Pos() token.Position  { return we.Token.Pos }
 func (we *WhileExpression) String() string {
 	var out bytes.Buffer
 
@@ -432,16 +692,26 @@ func (we *WhileExpression) String() string {
 
 type ForExpression struct {
 	Token token.Token
-	Var   Expression
-	Set   Expression
-	Body  *BlockStatement
+	Var   *Identifier
+
+	// Element is the name or pattern for the element, after the counter,
+	// or nil if there isn't one
+	Element Expression
+
+	Set  Expression
+	Body *BlockStatement
 }
 
 func (fe *ForExpression) expressionNode()      {}
 func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) Pos() token.Position  { return fe.Token.Pos }
 func (fe *ForExpression) String() string {
-	return fmt.Sprintf("(for (%v | %v) { %v })",
-		fe.Var.String(), fe.Set.String(), fe.Body.String())
+	v := fe.Var.String()
+	if fe.Element != nil {
+		v += ", " + fe.Element.String()
+	}
+
+	return fmt.Sprintf("(for (%v | %v) { %v })", v, fe.Set.String(), fe.Body.String())
 }
 
 // Break statement
@@ -452,6 +722,7 @@ type BreakStatement struct {
 
 func (bs *BreakStatement) statementNode()       {}
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
 
 // Next statement
@@ -462,4 +733,5 @@ type NextStatement struct {
 
 func (ns *NextStatement) statementNode()       {}
 func (ns *NextStatement) TokenLiteral() string { return ns.Token.Literal }
+func (ns *NextStatement) Pos() token.Position  { return ns.Token.Pos }
 func (ns *NextStatement) String() string       { return ns.Token.Literal + ";" }
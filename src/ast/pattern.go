@@ -0,0 +1,212 @@
+package ast
+
+import (
+	"../token"
+	"fmt"
+	"strings"
+)
+
+// Patterns are the left hand sides of destructuring declarations and
+// assignments, like "[a, b, ...rest] := xs", and can also be used as function
+// parameters and loop variables. Each part of a pattern is either another
+// pattern, a DefaultPattern, or the name (or, when assigning, the index or
+// field) the value is bound to.
+//
+// The patterns in match arms can also have literals and ranges, which are
+// compared against the value, and ModelPatterns, but not defaults.
+
+// Array pattern
+
+type ArrayPattern struct {
+	Token    token.Token
+	Elements []Expression
+
+	// Rest is bound to an array of the elements after the ones in
+	// Elements, if it isn't nil
+	Rest *Identifier
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// Required returns how many elements the array has to have: every element
+// up to the last one without a default.
+func (ap *ArrayPattern) Required() int {
+	for i := len(ap.Elements) - 1; i >= 0; i-- {
+		if _, ok := ap.Elements[i].(*DefaultPattern); !ok {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// Hash pattern
+
+type HashPattern struct {
+	Token  token.Token
+	Fields []*PatternField
+
+	// Rest is bound to a hash of the pairs which aren't in Fields, if it
+	// isn't nil
+	Rest *Identifier
+}
+
+// PatternField binds the value of a key in a hash to a pattern. Key is
+// either an identifier or a string literal, as in a hash literal.
+type PatternField struct {
+	Key   Expression
+	Value Expression
+}
+
+// Name returns the key the field is bound from.
+func (pf *PatternField) Name() string {
+	switch key := pf.Key.(type) {
+	case *Identifier:
+		return key.Value
+	case *StringLiteral:
+		return key.Value
+	default:
+		return key.String()
+	}
+}
+
+// Shorthand reports whether the field can be written as just its key, as
+// in "{x}" or "{x = 1}".
+func (pf *PatternField) Shorthand() bool {
+	key, ok := pf.Key.(*Identifier)
+	if !ok {
+		return false
+	}
+
+	value := pf.Value
+	if def, ok := value.(*DefaultPattern); ok {
+		value = def.Target
+	}
+
+	id, ok := value.(*Identifier)
+	return ok && id.Value == key.Value
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) String() string {
+	fields := []string{}
+	for _, f := range hp.Fields {
+		if f.Shorthand() {
+			fields = append(fields, f.Value.String())
+		} else {
+			fields = append(fields, f.Key.String()+": "+f.Value.String())
+		}
+	}
+
+	if hp.Rest != nil {
+		fields = append(fields, "..."+hp.Rest.String())
+	}
+
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// Default pattern
+
+// DefaultPattern is part of a pattern with a value to use when there's
+// nothing to destructure, or the value is null, like "b = 2" in
+// "[a, b = 2] := xs".
+type DefaultPattern struct {
+	Token   token.Token
+	Target  Expression
+	Default Expression
+}
+
+func (dp *DefaultPattern) expressionNode()      {}
+func (dp *DefaultPattern) TokenLiteral() string { return dp.Token.Literal }
+func (dp *DefaultPattern) Pos() token.Position  { return dp.Token.Pos }
+func (dp *DefaultPattern) String() string {
+	return fmt.Sprintf("%v = %v", dp.Target.String(), dp.Default.String())
+}
+
+// Rest element
+
+// RestElement is "...name", which is only valid as the last element of an
+// array or hash which becomes a pattern.
+type RestElement struct {
+	Token token.Token
+	Name  *Identifier
+}
+
+func (re *RestElement) expressionNode()      {}
+func (re *RestElement) TokenLiteral() string { return re.Token.Literal }
+func (re *RestElement) Pos() token.Position  { return re.Token.Pos }
+func (re *RestElement) String() string       { return "..." + re.Name.String() }
+
+// Model pattern
+
+// ModelPattern matches an instance of a model, or of a model descended from
+// it, like "vec(x, y)". The arguments, if there are any, are matched
+// against the model's properties in order.
+type ModelPattern struct {
+	Token     token.Token
+	Model     Expression
+	Arguments []Expression
+}
+
+func (mp *ModelPattern) expressionNode()      {}
+func (mp *ModelPattern) TokenLiteral() string { return mp.Token.Literal }
+func (mp *ModelPattern) Pos() token.Position  { return mp.Token.Pos }
+func (mp *ModelPattern) String() string {
+	args := []string{}
+	for _, arg := range mp.Arguments {
+		args = append(args, arg.String())
+	}
+
+	return mp.Model.String() + "(" + strings.Join(args, ", ") + ")"
+}
+
+// PatternNames returns the identifiers a pattern binds, in the order they
+// appear. For a plain identifier, that's just the identifier itself.
+func PatternNames(pattern Expression) []*Identifier {
+	names := []*Identifier{}
+
+	switch p := pattern.(type) {
+	case *Identifier:
+		names = append(names, p)
+	case *ArrayPattern:
+		for _, el := range p.Elements {
+			names = append(names, PatternNames(el)...)
+		}
+
+		if p.Rest != nil {
+			names = append(names, p.Rest)
+		}
+	case *HashPattern:
+		for _, f := range p.Fields {
+			names = append(names, PatternNames(f.Value)...)
+		}
+
+		if p.Rest != nil {
+			names = append(names, p.Rest)
+		}
+	case *DefaultPattern:
+		names = append(names, PatternNames(p.Target)...)
+	case *ModelPattern:
+		for _, arg := range p.Arguments {
+			names = append(names, PatternNames(arg)...)
+		}
+	}
+
+	return names
+}
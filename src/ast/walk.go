@@ -0,0 +1,160 @@
+package ast
+
+// Inspect visits a node and everything inside it, in the order they appear
+// in the source, calling f for each one. If f returns false, the nodes
+// inside that node aren't visited.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, stmt := range n.Statements {
+			Inspect(stmt, f)
+		}
+	case *BlockStatement:
+		for _, stmt := range n.Statements {
+			Inspect(stmt, f)
+		}
+	case *ExpressionStatement:
+		inspectExpr(n.Expression, f)
+	case *ReturnStatement:
+		inspectExpr(n.ReturnValue, f)
+	case *PrefixExpression:
+		inspectExpr(n.Right, f)
+	case *InfixExpression:
+		inspectExpr(n.Left, f)
+		inspectExpr(n.Right, f)
+	case *DeclareExpression:
+		inspectExpr(n.Name, f)
+		inspectExpr(n.Value, f)
+	case *AssignExpression:
+		inspectExpr(n.Name, f)
+		inspectExpr(n.Value, f)
+	case *IfExpression:
+		inspectExpr(n.Condition, f)
+		inspectBlock(n.Consequence, f)
+		inspectBlock(n.Alternative, f)
+	case *WhileExpression:
+		inspectExpr(n.Condition, f)
+		inspectBlock(n.Body, f)
+	case *ForExpression:
+		inspectExpr(n.Var, f)
+		inspectExpr(n.Element, f)
+		inspectExpr(n.Set, f)
+		inspectBlock(n.Body, f)
+	case *TryExpression:
+		inspectBlock(n.Body, f)
+		if n.ErrName != nil {
+			Inspect(n.ErrName, f)
+		}
+		inspectBlock(n.Catch, f)
+		inspectBlock(n.Finally, f)
+	case *FunctionLiteral:
+		inspectParams(n.Parameters, n.Patterns, f)
+		inspectBlock(n.Body, f)
+	case *LambdaExpression:
+		inspectParams(n.Parameters, n.Patterns, f)
+		inspectExpr(n.Body, f)
+	case *ModelLiteral:
+		for _, param := range n.Parameters {
+			Inspect(param, f)
+		}
+		if n.ParentName != nil {
+			inspectExpr(*n.ParentName, f)
+		}
+		for _, arg := range n.ParentArgs {
+			inspectExpr(arg, f)
+		}
+	case *ImportExpression:
+		for _, name := range n.Names {
+			Inspect(name, f)
+		}
+		inspectExpr(n.Path, f)
+	case *CallExpression:
+		inspectExpr(n.Function, f)
+		for _, arg := range n.Arguments {
+			inspectExpr(arg, f)
+		}
+	case *IndexExpression:
+		inspectExpr(n.Left, f)
+		inspectExpr(n.Index, f)
+	case *SliceExpression:
+		inspectExpr(n.Left, f)
+		inspectExpr(n.Start, f)
+		inspectExpr(n.End, f)
+		inspectExpr(n.Step, f)
+	case *InterpolatedString:
+		for _, part := range n.Parts {
+			inspectExpr(part, f)
+		}
+	case *ArrayLiteral:
+		for _, elem := range n.Elements {
+			inspectExpr(elem, f)
+		}
+	case *ArrayPattern:
+		for _, elem := range n.Elements {
+			inspectExpr(elem, f)
+		}
+		if n.Rest != nil {
+			Inspect(n.Rest, f)
+		}
+	case *HashPattern:
+		for _, field := range n.Fields {
+			if !field.Shorthand() {
+				inspectExpr(field.Key, f)
+			}
+			inspectExpr(field.Value, f)
+		}
+		if n.Rest != nil {
+			Inspect(n.Rest, f)
+		}
+	case *DefaultPattern:
+		inspectExpr(n.Target, f)
+		inspectExpr(n.Default, f)
+	case *RestElement:
+		Inspect(n.Name, f)
+	case *ModelPattern:
+		inspectExpr(n.Model, f)
+		for _, arg := range n.Arguments {
+			inspectExpr(arg, f)
+		}
+	case *MatchExpression:
+		inspectExpr(n.Subject, f)
+		for _, arm := range n.Arms {
+			inspectExpr(arm.Pattern, f)
+			inspectExpr(arm.Guard, f)
+			inspectBlock(arm.Body, f)
+		}
+	case *HashLiteral:
+		for _, key := range n.Keys() {
+			inspectExpr(key, f)
+			inspectExpr(n.Pairs[key], f)
+		}
+	}
+}
+
+func inspectExpr(e Expression, f func(Node) bool) {
+	if e != nil {
+		Inspect(e, f)
+	}
+}
+
+// inspectParams visits the parameters of a function, or the patterns they're
+// destructured with.
+func inspectParams(params []*Identifier, patterns []Expression, f func(Node) bool) {
+	for i, param := range params {
+		if patterns != nil && patterns[i] != nil {
+			Inspect(patterns[i], f)
+		} else {
+			Inspect(param, f)
+		}
+	}
+}
+
+func inspectBlock(b *BlockStatement, f func(Node) bool) {
+	if b != nil {
+		Inspect(b, f)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"./check"
+	"./lexer"
+	"./parser"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// runCheck runs the check subcommand, which reports problems found in the
+// files it's given, or the .lang files in the directories it's given, or
+// else standard input. It returns the status to exit with: 1 if there were
+// any problems, and 2 if anything couldn't be checked.
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	quiet := flags.Bool("q", false, "only report errors, not warnings")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s check [-q] [path ...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		return checkSource("", string(src), *quiet)
+	}
+
+	status := 0
+
+	for _, path := range flags.Args() {
+		files, err := langFiles(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		for _, file := range files {
+			s := checkFile(file, *quiet)
+			if s > status {
+				status = s
+			}
+		}
+	}
+
+	return status
+}
+
+func checkFile(file string, quiet bool) int {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	return checkSource(file, string(src), quiet)
+}
+
+func checkSource(file, src string, quiet bool) int {
+	l := lexer.NewFile(file, src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, err := range p.ErrorList() {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		return 2
+	}
+
+	status := 0
+
+	for _, problem := range check.Program(program) {
+		if quiet && problem.Warning {
+			continue
+		}
+
+		fmt.Println(problem)
+		status = 1
+	}
+
+	return status
+}
@@ -0,0 +1,602 @@
+// Package check finds mistakes in programs without running them. It works
+// out which names are declared in which scopes, and reports identifiers
+// which aren't declared anywhere, calls to functions and models with the
+// wrong number of arguments, declarations which shadow another, unused
+// variables, and code which can never run.
+package check
+
+import (
+	"../ast"
+	"../evaluator"
+	"../token"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Problem is something wrong with a program. Errors are things which will
+// fail when they're run, while warnings are only suspicious.
+type Problem struct {
+	Pos     token.Position
+	Message string
+	Warning bool
+}
+
+func (p *Problem) Error() string {
+	if p.Warning {
+		return fmt.Sprintf("%v: warning: %s", p.Pos, p.Message)
+	}
+
+	return fmt.Sprintf("%v: %s", p.Pos, p.Message)
+}
+
+// Program checks a program, returning its problems in the order they
+// appear in the source.
+func Program(program *ast.Program) []*Problem {
+	c := run(program)
+
+	c.checkCalls()
+	c.checkUnused()
+
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		return c.problems[i].Pos.Offset < c.problems[j].Pos.Offset
+	})
+
+	return c.problems
+}
+
+// Resolve finds every name declared in a program, in the order they're
+// declared, along with the identifiers which refer to each one.
+func Resolve(program *ast.Program) []*Binding {
+	c := run(program)
+
+	bindings := []*Binding{}
+	for _, s := range c.scopes {
+		for _, b := range s.names {
+			bindings = append(bindings, b)
+		}
+	}
+
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].Decl.Token.Pos.Offset < bindings[j].Decl.Token.Pos.Offset
+	})
+
+	return bindings
+}
+
+// run works out the scopes of a program, finding any undeclared names and
+// unreachable code on the way.
+func run(program *ast.Program) *checker {
+	c := &checker{builtins: make(map[string]bool)}
+
+	for _, name := range evaluator.BuiltinNames() {
+		c.builtins[name] = true
+	}
+
+	c.statements(program.Statements, c.newScope(nil, false))
+
+	// function bodies are checked once the scopes around them are
+	// complete, since they can refer to names declared after them
+	for len(c.deferred) > 0 {
+		next := c.deferred[0]
+		c.deferred = c.deferred[1:]
+		next()
+	}
+
+	return c
+}
+
+type checker struct {
+	builtins map[string]bool
+	scopes   []*scope
+	deferred []func()
+	calls    []call
+	problems []*Problem
+}
+
+// scope is the names declared in an environment. A new scope is made for
+// the top level, each function, each loop body and each catch block - the
+// same places the evaluator makes an environment.
+type scope struct {
+	outer    *scope
+	names    map[string]*Binding
+	function bool
+}
+
+// Binding is a declared name, and the identifiers which refer to it.
+type Binding struct {
+	Name string
+
+	// Decl is the identifier where the name is first declared, and Value
+	// is the value it's declared with, if it's declared by := or =
+	Decl  *ast.Identifier
+	Value ast.Expression
+
+	// Refs are the identifiers which use or assign to the name after it's
+	// declared
+	Refs []*ast.Identifier
+
+	TopLevel bool
+
+	used bool
+
+	// local is whether the binding should be reported if it isn't used.
+	// Top-level names might be imported by another file, and parameters
+	// often have to be there whether they're used or not.
+	local bool
+
+	// arity is how many arguments the value takes, if it's always a
+	// function or model, or -1 if it isn't known
+	arity int
+	kind  string
+}
+
+// call is a call to a named function, which is checked once every
+// assignment to the name has been seen.
+type call struct {
+	binding *Binding
+	pos     token.Position
+	args    int
+}
+
+func (c *checker) newScope(outer *scope, function bool) *scope {
+	s := &scope{outer: outer, names: make(map[string]*Binding), function: function}
+	c.scopes = append(c.scopes, s)
+	return s
+}
+
+func (s *scope) lookup(name string) *Binding {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func (s *scope) inFunction() bool {
+	for ; s != nil; s = s.outer {
+		if s.function {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *scope) declare(id *ast.Identifier, local bool) *Binding {
+	if b, ok := s.names[id.Value]; ok {
+		b.Refs = append(b.Refs, id)
+		b.arity = -1
+		return b
+	}
+
+	b := &Binding{Name: id.Value, Decl: id, TopLevel: s.outer == nil, local: local, arity: -1}
+	s.names[id.Value] = b
+
+	return b
+}
+
+func (c *checker) errorf(pos token.Position, format string, a ...interface{}) {
+	c.problems = append(c.problems, &Problem{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *checker) warnf(pos token.Position, format string, a ...interface{}) {
+	c.problems = append(c.problems, &Problem{Pos: pos, Message: fmt.Sprintf(format, a...), Warning: true})
+}
+
+func (c *checker) statements(stmts []ast.Statement, s *scope) {
+	// only the first unreachable statement in a block is reported
+	exit, reported := "", false
+
+	for _, stmt := range stmts {
+		if exit != "" && !reported {
+			c.warnf(stmt.Pos(), "unreachable code after %s", exit)
+			reported = true
+		}
+
+		switch stmt := stmt.(type) {
+		case *ast.ExpressionStatement:
+			c.expr(stmt.Expression, s)
+		case *ast.ReturnStatement:
+			c.expr(stmt.ReturnValue, s)
+			exit = "return"
+		case *ast.BreakStatement:
+			exit = "break"
+		case *ast.NextStatement:
+			exit = "next"
+		}
+	}
+}
+
+func (c *checker) expr(e ast.Expression, s *scope) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		c.reference(e, s)
+	case *ast.PrefixExpression:
+		c.expr(e.Right, s)
+	case *ast.InfixExpression:
+		c.expr(e.Left, s)
+
+		// the right of a dot is the name of a field
+		if e.Operator != "." {
+			c.expr(e.Right, s)
+		}
+	case *ast.DeclareExpression:
+		c.expr(e.Value, s)
+		c.declare(e, s)
+	case *ast.AssignExpression:
+		c.expr(e.Value, s)
+		c.assign(e, s)
+	case *ast.CallExpression:
+		c.expr(e.Function, s)
+		c.exprs(e.Arguments, s)
+
+		if id, ok := e.Function.(*ast.Identifier); ok {
+			if b := s.lookup(id.Value); b != nil {
+				c.calls = append(c.calls, call{binding: b, pos: id.Token.Pos, args: len(e.Arguments)})
+			}
+		}
+	case *ast.ArrayLiteral:
+		c.exprs(e.Elements, s)
+	case *ast.InterpolatedString:
+		c.exprs(e.Parts, s)
+	case *ast.HashLiteral:
+		for key, value := range e.Pairs {
+			// identifier keys are just names, like fields
+			if _, ok := key.(*ast.Identifier); !ok {
+				c.expr(key, s)
+			}
+
+			c.expr(value, s)
+		}
+	case *ast.IndexExpression:
+		c.expr(e.Left, s)
+		c.expr(e.Index, s)
+	case *ast.SliceExpression:
+		c.expr(e.Left, s)
+
+		for _, bound := range []ast.Expression{e.Start, e.End, e.Step} {
+			if bound != nil {
+				c.expr(bound, s)
+			}
+		}
+	case *ast.IfExpression:
+		c.expr(e.Condition, s)
+		c.statements(e.Consequence.Statements, s)
+
+		if e.Alternative != nil {
+			c.statements(e.Alternative.Statements, s)
+		}
+	case *ast.WhileExpression:
+		c.expr(e.Condition, s)
+		c.statements(e.Body.Statements, s)
+	case *ast.ForExpression:
+		c.expr(e.Set, s)
+
+		body := c.newScope(s, false)
+		body.declare(e.Var, false)
+		if e.Element != nil {
+			c.pattern(e.Element, body, true, func(id *ast.Identifier) {
+				body.declare(id, false)
+			})
+		}
+
+		c.statements(e.Body.Statements, body)
+	case *ast.MatchExpression:
+		c.expr(e.Subject, s)
+
+		for _, arm := range e.Arms {
+			body := c.newScope(s, false)
+			c.matchPattern(arm.Pattern, body)
+
+			if arm.Guard != nil {
+				c.expr(arm.Guard, body)
+			}
+
+			c.statements(arm.Body.Statements, body)
+		}
+	case *ast.TryExpression:
+		c.statements(e.Body.Statements, s)
+
+		if e.Catch != nil {
+			catch := c.newScope(s, false)
+			if e.ErrName != nil {
+				catch.declare(e.ErrName, false)
+			}
+
+			c.statements(e.Catch.Statements, catch)
+		}
+
+		if e.Finally != nil {
+			c.statements(e.Finally.Statements, s)
+		}
+	case *ast.FunctionLiteral:
+		body := c.function(e.Parameters, e.Patterns, s)
+		c.deferred = append(c.deferred, func() {
+			c.patterns(e.Patterns, body)
+			c.statements(e.Body.Statements, body)
+		})
+	case *ast.LambdaExpression:
+		body := c.function(e.Parameters, e.Patterns, s)
+		c.deferred = append(c.deferred, func() {
+			c.patterns(e.Patterns, body)
+			c.expr(e.Body, body)
+		})
+	case *ast.ModelLiteral:
+		c.model(e, s)
+	case *ast.ImportExpression:
+		c.expr(e.Path, s)
+
+		for _, name := range e.Names {
+			s.declare(name, false)
+		}
+	}
+}
+
+func (c *checker) exprs(exprs []ast.Expression, s *scope) {
+	for _, e := range exprs {
+		c.expr(e, s)
+	}
+}
+
+func (c *checker) reference(id *ast.Identifier, s *scope) {
+	if b := s.lookup(id.Value); b != nil {
+		b.Refs = append(b.Refs, id)
+		b.used = true
+		return
+	}
+
+	if c.builtins[id.Value] || (id.Value == "this" && s.inFunction()) {
+		return
+	}
+
+	c.errorf(id.Token.Pos, "identifier not found: %s", id.Value)
+}
+
+// function makes the scope of a function's body, with its parameters
+// declared in it. Parameters which are patterns are declared by patterns,
+// once the scopes around the function are complete.
+func (c *checker) function(params []*ast.Identifier, patterns []ast.Expression, s *scope) *scope {
+	body := c.newScope(s, true)
+
+	for i, param := range params {
+		if patterns == nil || patterns[i] == nil {
+			body.declare(param, false)
+		}
+	}
+
+	return body
+}
+
+func (c *checker) patterns(patterns []ast.Expression, body *scope) {
+	for _, p := range patterns {
+		if p != nil {
+			c.pattern(p, body, true, func(id *ast.Identifier) {
+				body.declare(id, false)
+			})
+		}
+	}
+}
+
+// pattern checks the defaults in a destructuring pattern, calling bind for
+// each name in it. Indices and fields can only be assigned to, not
+// declared.
+func (c *checker) pattern(e ast.Expression, s *scope, declare bool, bind func(*ast.Identifier)) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		bind(e)
+	case *ast.ArrayPattern:
+		for _, elem := range e.Elements {
+			c.pattern(elem, s, declare, bind)
+		}
+
+		if e.Rest != nil {
+			bind(e.Rest)
+		}
+	case *ast.HashPattern:
+		for _, field := range e.Fields {
+			c.pattern(field.Value, s, declare, bind)
+		}
+
+		if e.Rest != nil {
+			bind(e.Rest)
+		}
+	case *ast.DefaultPattern:
+		c.expr(e.Default, s)
+		c.pattern(e.Target, s, declare, bind)
+	default:
+		if declare {
+			c.errorf(e.Pos(), "cannot declare %v. expected an identifier", e.String())
+			return
+		}
+
+		c.target(e, s)
+	}
+}
+
+func (c *checker) model(m *ast.ModelLiteral, s *scope) {
+	if m.ParentName == nil {
+		return
+	}
+
+	parent := *m.ParentName
+	c.expr(parent, s)
+
+	if id, ok := parent.(*ast.Identifier); ok {
+		if b := s.lookup(id.Value); b != nil {
+			c.calls = append(c.calls, call{binding: b, pos: id.Token.Pos, args: len(m.ParentArgs)})
+		}
+	}
+
+	// the arguments to the parent are evaluated when the model is
+	// instantiated, with its properties declared
+	props := c.newScope(s, false)
+	for _, prop := range m.Parameters {
+		props.declare(prop, false)
+	}
+
+	c.deferred = append(c.deferred, func() {
+		c.exprs(m.ParentArgs, props)
+	})
+}
+
+// matchPattern checks the pattern of a match arm, declaring the names in it
+// in the arm's scope.
+func (c *checker) matchPattern(e ast.Expression, s *scope) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if e.Value != "_" {
+			s.declare(e, false)
+		}
+	case *ast.ArrayPattern:
+		for _, elem := range e.Elements {
+			c.matchPattern(elem, s)
+		}
+
+		if e.Rest != nil {
+			s.declare(e.Rest, false)
+		}
+	case *ast.HashPattern:
+		for _, field := range e.Fields {
+			c.matchPattern(field.Value, s)
+		}
+
+		if e.Rest != nil {
+			s.declare(e.Rest, false)
+		}
+	case *ast.ModelPattern:
+		c.expr(e.Model, s)
+
+		for _, arg := range e.Arguments {
+			c.matchPattern(arg, s)
+		}
+	default:
+		// literals and ranges
+		c.expr(e, s)
+	}
+}
+
+func (c *checker) declare(d *ast.DeclareExpression, s *scope) {
+	switch name := d.Name.(type) {
+	case *ast.Identifier:
+		c.declareName(name, d.Value, s)
+	case *ast.ArrayPattern, *ast.HashPattern:
+		c.pattern(name, s, true, func(id *ast.Identifier) {
+			c.declareName(id, nil, s)
+		})
+	default:
+		c.errorf(d.Token.Pos, "cannot declare %v. expected an identifier", d.Name.String())
+	}
+}
+
+// declareName declares a name in a scope with :=, warning if it shadows
+// another.
+func (c *checker) declareName(id *ast.Identifier, value ast.Expression, s *scope) {
+	if _, ok := s.names[id.Value]; ok {
+		s.declare(id, s.outer != nil)
+		return
+	}
+
+	if outer := s.lookup(id.Value); outer != nil {
+		pos := outer.Decl.Token.Pos
+		c.warnf(id.Token.Pos, "%s shadows the declaration at %d:%d", id.Value, pos.Line, pos.Column)
+	}
+
+	b := s.declare(id, s.outer != nil)
+	b.Value = value
+	b.arity, b.kind = arity(value)
+}
+
+func (c *checker) assign(a *ast.AssignExpression, s *scope) {
+	switch name := a.Name.(type) {
+	case *ast.Identifier:
+	case *ast.ArrayPattern, *ast.HashPattern:
+		c.pattern(name, s, false, func(id *ast.Identifier) {
+			c.assignName(id, nil, s)
+		})
+
+		return
+	default:
+		c.target(a.Name, s)
+		return
+	}
+
+	id := a.Name.(*ast.Identifier)
+
+	// a compound assignment uses the current value, so the name has to be
+	// declared already
+	if a.Operator != "" {
+		c.expr(id, s)
+		if b := s.lookup(id.Value); b != nil {
+			b.arity = -1
+		}
+
+		return
+	}
+
+	c.assignName(id, a.Value, s)
+}
+
+// assignName assigns to a name with =, which declares it if it isn't
+// declared anywhere.
+func (c *checker) assignName(id *ast.Identifier, value ast.Expression, s *scope) {
+	if b := s.lookup(id.Value); b != nil {
+		b.Refs = append(b.Refs, id)
+		b.arity = -1
+	} else {
+		s.declare(id, s.outer != nil).Value = value
+	}
+}
+
+// target checks an index or field being assigned to, which uses the value
+// being indexed.
+func (c *checker) target(e ast.Expression, s *scope) {
+	switch e := e.(type) {
+	case *ast.IndexExpression:
+		c.expr(e.Left, s)
+		c.expr(e.Index, s)
+	case *ast.SliceExpression:
+		c.expr(e, s)
+	case *ast.InfixExpression:
+		c.expr(e.Left, s)
+	}
+}
+
+// arity returns how many arguments a declared value takes, if it's a
+// function or a model.
+func arity(value ast.Expression) (int, string) {
+	switch value := value.(type) {
+	case *ast.FunctionLiteral:
+		return len(value.Parameters), "function"
+	case *ast.LambdaExpression:
+		return len(value.Parameters), "function"
+	case *ast.ModelLiteral:
+		return len(value.Parameters), "model"
+	default:
+		return -1, ""
+	}
+}
+
+func (c *checker) checkCalls() {
+	for _, call := range c.calls {
+		b := call.binding
+		if b.arity < 0 || b.arity == call.args {
+			continue
+		}
+
+		c.errorf(call.pos, "invalid number of arguments to %s %s. expected %v, got %v",
+			b.kind, b.Name, b.arity, call.args)
+	}
+}
+
+func (c *checker) checkUnused() {
+	for _, s := range c.scopes {
+		for _, b := range s.names {
+			if b.local && !b.used && !strings.HasPrefix(b.Name, "_") {
+				c.warnf(b.Decl.Token.Pos, "%s is declared but never used", b.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package check
+
+import (
+	"../lexer"
+	"../parser"
+	"testing"
+)
+
+func checkInput(t *testing.T, input string) []string {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	problems := []string{}
+	for _, problem := range Program(program) {
+		problems = append(problems, problem.Error())
+	}
+
+	return problems
+}
+
+func TestProblems(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`x := 1; print(x + y);`, []string{"1:19: identifier not found: y"}},
+		{`f := fn () { g(); }; g := fn () {}; f();`, nil},
+		{`f := fn (n) { if (n == 0) { return 1; }; n * f(n - 1); }; f(3);`, nil},
+		{`this;`, []string{"1:1: identifier not found: this"}},
+		{`f := fn () { this; }; f();`, nil},
+		{`h := {a: 1}; h.a; h.b = 2;`, nil},
+		{`x = 1; x;`, nil},
+		{`x += 1;`, []string{"1:1: identifier not found: x"}},
+		{`f := fn () { n := 0; n += 1; }; f();`, nil},
+		{`[a, b = a] := [1]; {c, ...d} := {}; print(b, c, d);`, nil},
+		{`[a, b] := [1, 2]; [a, b] = [b, a]; h := {}; [h.x, h[c]] = [1, 2];`, []string{"1:53: identifier not found: c"}},
+		{`[a = b] := [];`, []string{"1:6: identifier not found: b"}},
+		{`f := fn () { [a, b] := [1, 2]; a; }; f();`, []string{"1:18: warning: b is declared but never used"}},
+		{`f := fn ([a, b], {c = n}) { a + b + c; }; n := 1; f([1, 2], {});`, nil},
+		{`for (i, [k, v] | [[1, 2]]) { print(i, k, v); }; k;`, []string{"1:49: identifier not found: k"}},
+		{`[a.b] := [1];`, []string{"1:3: cannot declare (a . b). expected an identifier"}},
+		{`a := [1]; a[i:] = a[:j:k];`, []string{
+			"1:13: identifier not found: i",
+			"1:22: identifier not found: j",
+			"1:24: identifier not found: k",
+		}},
+		{`match 1 { [a, ...b] if a => b, {c} => c, vec(d, _) => d, n => n };`, nil},
+		{`match 1 { a => a }; a;`, []string{"1:21: identifier not found: a"}},
+		{`a := 1; print("${a} ${b}");`, []string{"1:23: identifier not found: b"}},
+		{`match x { 0..y => z };`, []string{
+			"1:7: identifier not found: x",
+			"1:14: identifier not found: y",
+			"1:19: identifier not found: z",
+		}},
+		{`import a from "x"; a;`, nil},
+		{`for (i | 1..3) { print(i); }; i;`, []string{"1:31: identifier not found: i"}},
+		{`try { 1; } catch (e) { e; };`, nil},
+		{
+			`f := fn (a, b) { a + b; }; f(1);`,
+			[]string{"1:28: invalid number of arguments to function f. expected 2, got 1"},
+		},
+		{`f := \(a) = a; f(1, 2);`, []string{"1:16: invalid number of arguments to function f. expected 1, got 2"}},
+		{`f := fn (a) {}; f = fn (a, b) {}; f(1, 2);`, nil},
+		{`m := model (a, b); m(1);`, []string{"1:20: invalid number of arguments to model m. expected 2, got 1"}},
+		{
+			`m := model (a, b); n := model (c) : m (c); n(1);`,
+			[]string{"1:37: invalid number of arguments to model m. expected 2, got 1"},
+		},
+		{`m := model (a) : vec (a, b);`, []string{"1:26: identifier not found: b"}},
+		{
+			`x := 1; f := fn () { x := 2; x; }; f();`,
+			[]string{"1:22: warning: x shadows the declaration at 1:1"},
+		},
+		{`f := fn () { y := 1; }; f();`, []string{"1:14: warning: y is declared but never used"}},
+		{`f := fn () { _y := 1; }; f();`, nil},
+		{`unused := 1;`, nil},
+		{
+			`f := fn () { return 1; print(2); print(3); }; f();`,
+			[]string{"1:24: warning: unreachable code after return"},
+		},
+		{
+			`while (true) { break; print(1); };`,
+			[]string{"1:23: warning: unreachable code after break"},
+		},
+	}
+
+	for _, tt := range tests {
+		problems := checkInput(t, tt.input)
+
+		if len(problems) != len(tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, problems)
+			continue
+		}
+
+		for i, problem := range problems {
+			if problem != tt.expected[i] {
+				t.Errorf("%s: expected %q, got %q", tt.input, tt.expected[i], problem)
+			}
+		}
+	}
+}
+
+func TestModelMethods(t *testing.T) {
+	problems := checkInput(t, `animal := model (name, type);
+animal._new = fn () { print("Creating a new", this.type + "!"); };
+
+sheep := model (name) : animal (name, "sheep");
+s := sheep("x");`)
+
+	if len(problems) != 0 {
+		t.Errorf("expected no problems, got %q", problems)
+	}
+}
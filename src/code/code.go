@@ -0,0 +1,306 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	// Values
+	OpConstant Opcode = iota
+	OpNull
+	OpTrue
+	OpFalse
+	OpArray
+	OpHash
+	OpClosure
+	OpModel
+	OpThis
+	OpInterpolate
+
+	// Stack manipulation
+	OpPop
+	OpDup
+
+	// Operators
+	OpInfix
+	OpPrefix
+	OpIndex
+	OpSetIndex
+	OpGetField
+	OpSetField
+	OpUpdateIndex
+	OpUpdateField
+	OpSlice
+	OpSetSlice
+	OpUpdateSlice
+
+	// Variables
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetFree
+	OpSetFree
+	OpGetBuiltin
+	OpClearLocals
+
+	// Control flow
+	OpJump
+	OpJumpNotTruthy
+	OpJumpNotNull
+	OpCall
+	OpReturn
+
+	// Loops
+	OpForInit
+	OpForNext
+	OpForElement
+	OpWhileInit
+	OpLoopAppend
+	OpLoopEnd
+	OpBreak
+	OpNext
+
+	// Errors
+	OpSetupTry
+	OpPopTry
+	OpErrorHash
+	OpThrow
+
+	// Modules
+	OpImport
+
+	// Destructuring
+	OpUnpackArray
+	OpUnpackHash
+
+	// Pattern matching
+	OpMatchArray
+	OpMatchHash
+	OpMatchModel
+	OpMatchRange
+	OpMatchEqual
+	OpNoMatch
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpNull:     {"OpNull", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpArray:    {"OpArray", []int{2}},
+	OpHash:     {"OpHash", []int{2}},
+	OpClosure:  {"OpClosure", []int{2}},
+	OpModel:    {"OpModel", []int{2}},
+	OpThis:     {"OpThis", []int{}},
+
+	// the number of parts of the string
+	OpInterpolate: {"OpInterpolate", []int{2}},
+
+	OpPop: {"OpPop", []int{}},
+	OpDup: {"OpDup", []int{}},
+
+	OpInfix:    {"OpInfix", []int{1}},
+	OpPrefix:   {"OpPrefix", []int{1}},
+	OpIndex:    {"OpIndex", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{}},
+	OpGetField: {"OpGetField", []int{2}},
+	OpSetField: {"OpSetField", []int{2}},
+
+	// the compound assignments, like +=, which also take the operator
+	OpUpdateIndex: {"OpUpdateIndex", []int{1}},
+	OpUpdateField: {"OpUpdateField", []int{2, 1}},
+
+	// slices take the start, end and step, which are null if they're left
+	// out
+	OpSlice:       {"OpSlice", []int{}},
+	OpSetSlice:    {"OpSetSlice", []int{}},
+	OpUpdateSlice: {"OpUpdateSlice", []int{1}},
+
+	OpGetGlobal:   {"OpGetGlobal", []int{2}},
+	OpSetGlobal:   {"OpSetGlobal", []int{2}},
+	OpGetLocal:    {"OpGetLocal", []int{2}},
+	OpSetLocal:    {"OpSetLocal", []int{2}},
+	OpGetFree:     {"OpGetFree", []int{2}},
+	OpSetFree:     {"OpSetFree", []int{2}},
+	OpGetBuiltin:  {"OpGetBuiltin", []int{2}},
+	OpClearLocals: {"OpClearLocals", []int{2, 2}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJumpNotNull:   {"OpJumpNotNull", []int{2}},
+	OpCall:          {"OpCall", []int{1}},
+	OpReturn:        {"OpReturn", []int{}},
+
+	OpForInit:    {"OpForInit", []int{2}},
+	OpForNext:    {"OpForNext", []int{2}},
+	OpForElement: {"OpForElement", []int{}},
+	OpWhileInit:  {"OpWhileInit", []int{2}},
+	OpLoopAppend: {"OpLoopAppend", []int{}},
+	OpLoopEnd:    {"OpLoopEnd", []int{}},
+	OpBreak:      {"OpBreak", []int{}},
+	OpNext:       {"OpNext", []int{}},
+
+	OpSetupTry:  {"OpSetupTry", []int{2}},
+	OpPopTry:    {"OpPopTry", []int{}},
+	OpErrorHash: {"OpErrorHash", []int{}},
+	OpThrow:     {"OpThrow", []int{}},
+
+	OpImport: {"OpImport", []int{2}},
+
+	// the number of elements, how many are required and whether there's a
+	// rest element
+	OpUnpackArray: {"OpUnpackArray", []int{2, 2, 1}},
+	// a constant array of the keys, and whether there's a rest element
+	OpUnpackHash: {"OpUnpackHash", []int{2, 1}},
+
+	// the match opcodes push whether the value matched, with the parts of
+	// it to match next underneath if it did
+	OpMatchArray: {"OpMatchArray", []int{2, 1}},
+	OpMatchHash:  {"OpMatchHash", []int{2, 1}},
+	// the number of properties to match
+	OpMatchModel: {"OpMatchModel", []int{2}},
+	// whether the range includes its end
+	OpMatchRange: {"OpMatchRange", []int{1}},
+	OpMatchEqual: {"OpMatchEqual", []int{}},
+	OpNoMatch:    {"OpNoMatch", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes an instruction from its opcode and operands.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction, returning them along
+// with the number of bytes they took up.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
+
+// Operators lists the infix and prefix operators, which are referred to by
+// their index in OpInfix and OpPrefix instructions.
+var Operators = []string{
+	"+", "-", "*", "/", "%", "**",
+	"<", ">", "<=", ">=", "==", "!=",
+	"&&", "||", "..", "..<", "in",
+	"<<", ">>", "&", "|", "^",
+	"!", "~",
+}
+
+// OperatorIndex finds the index of an operator in Operators.
+func OperatorIndex(op string) (int, bool) {
+	for i, o := range Operators {
+		if o == op {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
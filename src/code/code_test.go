@@ -0,0 +1,54 @@
+package code
+
+import "testing"
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpInfix, []int{3}, []byte{byte(OpInfix), 3}},
+		{OpClearLocals, []int{1, 2}, []byte{byte(OpClearLocals), 0, 1, 0, 2}},
+		{OpPop, []int{}, []byte{byte(OpPop)}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if string(instruction) != string(tt.expected) {
+			t.Errorf("expected %v, got %v", tt.expected, instruction)
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	ins := Instructions{}
+	ins = append(ins, Make(OpConstant, 1)...)
+	ins = append(ins, Make(OpInfix, 0)...)
+	ins = append(ins, Make(OpClearLocals, 2, 3)...)
+	ins = append(ins, Make(OpReturn)...)
+
+	expected := `0000 OpConstant 1
+0003 OpInfix 0
+0005 OpClearLocals 2 3
+0010 OpReturn
+`
+
+	if ins.String() != expected {
+		t.Errorf("wrong instructions:\nexpected %q\ngot %q", expected, ins.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	def, err := Lookup(byte(OpClearLocals))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operands, read := ReadOperands(def, Make(OpClearLocals, 513, 7)[1:])
+	if read != 4 || operands[0] != 513 || operands[1] != 7 {
+		t.Errorf("wrong operands %v (read %d)", operands, read)
+	}
+}
@@ -0,0 +1,1095 @@
+package compiler
+
+import (
+	"../ast"
+	"../code"
+	"../evaluator"
+	"../object"
+	"../token"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	MODEL_TEMPLATE_OBJ    = "MODEL_TEMPLATE"
+)
+
+// Position records that the instructions from Offset onwards were compiled
+// from the node at Pos, until the next Position.
+type Position struct {
+	Offset int
+	Pos    token.Position
+}
+
+// CompiledFunction is the bytecode of a function, lambda or program, which
+// the virtual machine turns into a closure when it's defined.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+
+	Name       string
+	Lambda     bool
+	Parameters []string
+	Body       string
+
+	// Free lists where each free variable is found when the closure is
+	// made, in terms of the enclosing function's scopes.
+	Free []Symbol
+
+	Positions  []Position
+	LocalNames []string
+}
+
+func (cf *CompiledFunction) Type() object.ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Lambda {
+		return fmt.Sprintf("\\(%v) = %v", strings.Join(cf.Parameters, ", "), cf.Body)
+	}
+
+	return fmt.Sprintf("fn (%v) { %v }", strings.Join(cf.Parameters, ", "), cf.Body)
+}
+func (cf *CompiledFunction) Equals(other object.Object) bool {
+	return cf == other
+}
+
+// PositionOf finds the position of the node that the instruction at the
+// given offset was compiled from.
+func (cf *CompiledFunction) PositionOf(offset int) token.Position {
+	i := sort.Search(len(cf.Positions), func(i int) bool {
+		return cf.Positions[i].Offset > offset
+	})
+
+	if i == 0 {
+		return token.Position{}
+	}
+
+	return cf.Positions[i-1].Pos
+}
+
+// ModelTemplate holds what's known about a model literal at compile time.
+type ModelTemplate struct {
+	Name       string
+	Properties []*ast.Identifier
+	HasParent  bool
+}
+
+func (mt *ModelTemplate) Type() object.ObjectType { return MODEL_TEMPLATE_OBJ }
+func (mt *ModelTemplate) Inspect() string         { return "<model template>" }
+func (mt *ModelTemplate) Equals(other object.Object) bool {
+	return mt == other
+}
+
+// Bytecode is the result of compiling a program.
+type Bytecode struct {
+	Main        *CompiledFunction
+	Constants   []object.Object
+	GlobalNames []string
+}
+
+// Error is a problem found while compiling, at a position in the source.
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("%v: %s", e.Pos, e.Message)
+	}
+
+	return e.Message
+}
+
+// control is an entry in the stack of loops and try expressions which
+// enclose the code being compiled, used to work out what has to be undone
+// by a return, break or next.
+type control struct {
+	loop bool
+
+	// handler is whether a try expression's handler is installed, and
+	// finally is the block which has to be run when leaving it
+	handler bool
+	finally *ast.BlockStatement
+}
+
+type compilationScope struct {
+	instructions code.Instructions
+	positions    []Position
+	control      []control
+	function     bool
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+	scopes      []*compilationScope
+	errors      []*Error
+	pos         token.Position
+	builtins    map[string]int
+}
+
+func New() *Compiler {
+	builtins := make(map[string]int)
+	for i, name := range evaluator.BuiltinNames() {
+		builtins[name] = i
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: NewSymbolTable(),
+		scopes:      []*compilationScope{{}},
+		errors:      []*Error{},
+		builtins:    builtins,
+	}
+}
+
+func (c *Compiler) Errors() []*Error {
+	return c.errors
+}
+
+// Compile compiles a program into bytecode. If there were any errors, they
+// can be found with Errors.
+func (c *Compiler) Compile(program *ast.Program) *Bytecode {
+	c.compileStatements(program.Statements)
+	c.emit(code.OpReturn)
+
+	main := &CompiledFunction{
+		Instructions: c.scope().instructions,
+		NumLocals:    c.symbolTable.NumLocals(),
+		Name:         "<main>",
+		Positions:    c.scope().positions,
+		LocalNames:   c.symbolTable.LocalNames(),
+	}
+
+	return &Bytecode{
+		Main:        main,
+		Constants:   c.constants,
+		GlobalNames: c.symbolTable.GlobalNames(),
+	}
+}
+
+func (c *Compiler) addError(format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{Pos: c.pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *Compiler) compile(node ast.Node) {
+	outerPos := c.pos
+	c.pos = node.Pos()
+
+	c.compileNode(node)
+
+	c.pos = outerPos
+}
+
+func (c *Compiler) compileNode(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		c.compileStatements(node.Statements)
+	case *ast.ExpressionStatement:
+		c.compile(node.Expression)
+	case *ast.ReturnStatement:
+		c.compile(node.ReturnValue)
+		c.unwind(-1)
+		c.emit(code.OpReturn)
+	case *ast.BreakStatement:
+		c.compileLoopControl(code.OpBreak, "break")
+	case *ast.NextStatement:
+		c.compileLoopControl(code.OpNext, "next")
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.NumberLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Number{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.CharLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Char{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.Null:
+		c.emit(code.OpNull)
+	case *ast.PrefixExpression:
+		c.compile(node.Right)
+		c.emitOperator(code.OpPrefix, node.Operator)
+	case *ast.InfixExpression:
+		c.compile(node.Left)
+
+		if node.Operator == "." {
+			id, ok := node.Right.(*ast.Identifier)
+			if !ok {
+				c.addError("not an identifier")
+				return
+			}
+
+			c.emit(code.OpGetField, c.addConstant(&object.String{Value: id.Value}))
+			return
+		}
+
+		c.compile(node.Right)
+		c.emitOperator(code.OpInfix, node.Operator)
+	case *ast.DeclareExpression:
+		c.compileDeclare(node)
+	case *ast.AssignExpression:
+		c.compileAssign(node)
+	case *ast.IfExpression:
+		c.compileIf(node)
+	case *ast.Identifier:
+		c.compileIdentifier(node)
+	case *ast.FunctionLiteral:
+		c.compileFunction(node, "")
+	case *ast.LambdaExpression:
+		c.compileFunction(node, "")
+	case *ast.CallExpression:
+		c.compile(node.Function)
+		for _, arg := range node.Arguments {
+			c.compile(arg)
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.ArrayLiteral:
+		for _, elem := range node.Elements {
+			c.compile(elem)
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		c.compileHash(node)
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			c.compile(part)
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+	case *ast.IndexExpression:
+		c.compile(node.Left)
+		c.compile(node.Index)
+		c.emit(code.OpIndex)
+	case *ast.SliceExpression:
+		c.compile(node.Left)
+		c.compileSliceBounds(node)
+		c.emit(code.OpSlice)
+	case *ast.WhileExpression:
+		c.compileWhile(node)
+	case *ast.ForExpression:
+		c.compileFor(node)
+	case *ast.ModelLiteral:
+		c.compileModel(node, "")
+	case *ast.TryExpression:
+		c.compileTry(node)
+	case *ast.MatchExpression:
+		c.compileMatch(node)
+	case *ast.ImportExpression:
+		c.compileImport(node)
+	default:
+		c.addError("compilation for %T not yet implemented!", node)
+	}
+}
+
+// compileStatements compiles a list of statements so that they leave the
+// value of the last one on the stack.
+func (c *Compiler) compileStatements(stmts []ast.Statement) {
+	if len(stmts) == 0 {
+		c.emit(code.OpNull)
+		return
+	}
+
+	for i, stmt := range stmts {
+		c.compile(stmt)
+
+		if i < len(stmts)-1 {
+			c.emit(code.OpPop)
+		}
+	}
+}
+
+func (c *Compiler) emitOperator(op code.Opcode, operator string) {
+	index, ok := code.OperatorIndex(operator)
+	if !ok {
+		c.addError("unknown operator: %s", operator)
+		return
+	}
+
+	c.emit(op, index)
+}
+
+func (c *Compiler) compileIdentifier(node *ast.Identifier) {
+	if node.Value == "this" && c.scope().function {
+		c.emit(code.OpThis)
+		return
+	}
+
+	c.load(c.resolve(node.Value))
+}
+
+// resolve finds the symbol a name refers to. Names which aren't declared
+// anywhere yet are either builtins, or globals which will be declared
+// later on.
+func (c *Compiler) resolve(name string) Symbol {
+	if symbol, ok := c.symbolTable.Resolve(name); ok {
+		return symbol
+	}
+
+	if index, ok := c.builtins[name]; ok {
+		return Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	}
+
+	return c.symbolTable.Global().Define(name)
+}
+
+func (c *Compiler) load(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	}
+}
+
+// store sets the value of a symbol to the value on top of the stack,
+// leaving the value there.
+func (c *Compiler) store(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+func (c *Compiler) compileDeclare(node *ast.DeclareExpression) {
+	switch name := node.Name.(type) {
+	case *ast.Identifier:
+		// functions and models are declared before their value is
+		// compiled, so they can refer to themselves
+		switch value := node.Value.(type) {
+		case *ast.FunctionLiteral, *ast.LambdaExpression:
+			symbol := c.symbolTable.Define(name.Value)
+			c.compileFunction(value, name.Value)
+			c.store(symbol)
+		case *ast.ModelLiteral:
+			symbol := c.symbolTable.Define(name.Value)
+			c.compileModel(value, name.Value)
+			c.store(symbol)
+		default:
+			c.compile(node.Value)
+			c.store(c.symbolTable.Define(name.Value))
+		}
+	case *ast.ArrayPattern, *ast.HashPattern:
+		c.compile(node.Value)
+		c.emit(code.OpDup)
+		c.compileDestructure(name, true)
+	case *ast.IndexExpression:
+		c.addError("cannot declare (:=) a hash field. try assigning (=)")
+	default:
+		c.addError("cannot declare %v. expected an id or index expression", node.Name.String())
+	}
+}
+
+func (c *Compiler) compileAssign(node *ast.AssignExpression) {
+	if node.Operator != "" {
+		c.compileCompoundAssign(node)
+		return
+	}
+
+	switch name := node.Name.(type) {
+	case *ast.Identifier:
+		c.compile(node.Value)
+
+		symbol, ok := c.symbolTable.Resolve(name.Value)
+		if !ok {
+			symbol = c.symbolTable.Define(name.Value)
+		}
+
+		c.store(symbol)
+	case *ast.IndexExpression:
+		c.compile(node.Value)
+		c.compile(name.Left)
+		c.compile(name.Index)
+		c.emit(code.OpSetIndex)
+	case *ast.SliceExpression:
+		c.compile(node.Value)
+		c.compile(name.Left)
+		c.compileSliceBounds(name)
+		c.emit(code.OpSetSlice)
+	case *ast.InfixExpression:
+		if name.Operator != "." {
+			c.addError("cannot assign any infix operator other than '.'")
+			return
+		}
+
+		id, ok := name.Right.(*ast.Identifier)
+		if !ok {
+			c.addError("expected an identifier")
+			return
+		}
+
+		c.compile(node.Value)
+		c.compile(name.Left)
+		c.emit(code.OpSetField, c.addConstant(&object.String{Value: id.Value}))
+	case *ast.ArrayPattern, *ast.HashPattern:
+		c.compile(node.Value)
+		c.emit(code.OpDup)
+		c.compileDestructure(name, false)
+	default:
+		c.addError("cannot assign to %v", node.Name.String())
+	}
+}
+
+// compileDestructure binds the names in a pattern to the parts of the value
+// on top of the stack, which is popped. The names are declared if declare
+// is true, and assigned to otherwise.
+func (c *Compiler) compileDestructure(pattern ast.Expression, declare bool) {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		// the elements are pushed so that the first is on top
+		c.emit(code.OpUnpackArray, len(pattern.Elements), pattern.Required(), hasRest(pattern.Rest))
+
+		for _, elem := range pattern.Elements {
+			c.compileDestructure(elem, declare)
+		}
+
+		if pattern.Rest != nil {
+			c.compileDestructure(pattern.Rest, declare)
+		}
+	case *ast.HashPattern:
+		keys := []object.Object{}
+		for _, field := range pattern.Fields {
+			keys = append(keys, &object.String{Value: field.Name()})
+		}
+
+		c.emit(code.OpUnpackHash, c.addConstant(&object.Array{Elements: keys}), hasRest(pattern.Rest))
+
+		for _, field := range pattern.Fields {
+			c.compileDestructure(field.Value, declare)
+		}
+
+		if pattern.Rest != nil {
+			c.compileDestructure(pattern.Rest, declare)
+		}
+	case *ast.DefaultPattern:
+		jump := c.emit(code.OpJumpNotNull, 9999)
+		c.compile(pattern.Default)
+		c.changeOperand(jump, c.offset())
+
+		c.compileDestructure(pattern.Target, declare)
+	case *ast.Identifier:
+		if declare {
+			c.store(c.symbolTable.Define(pattern.Value))
+		} else {
+			symbol, ok := c.symbolTable.Resolve(pattern.Value)
+			if !ok {
+				symbol = c.symbolTable.Define(pattern.Value)
+			}
+
+			c.store(symbol)
+		}
+
+		c.emit(code.OpPop)
+	case *ast.IndexExpression:
+		if declare {
+			c.addError("cannot declare (:=) a hash field. try assigning (=)")
+			return
+		}
+
+		c.compile(pattern.Left)
+		c.compile(pattern.Index)
+		c.emit(code.OpSetIndex)
+		c.emit(code.OpPop)
+	case *ast.SliceExpression:
+		if declare {
+			c.addError("cannot declare %v. expected an identifier", pattern.String())
+			return
+		}
+
+		c.compile(pattern.Left)
+		c.compileSliceBounds(pattern)
+		c.emit(code.OpSetSlice)
+		c.emit(code.OpPop)
+	case *ast.InfixExpression:
+		id, ok := pattern.Right.(*ast.Identifier)
+		if declare || pattern.Operator != "." || !ok {
+			c.addError("cannot assign to %v", pattern.String())
+			return
+		}
+
+		c.compile(pattern.Left)
+		c.emit(code.OpSetField, c.addConstant(&object.String{Value: id.Value}))
+		c.emit(code.OpPop)
+	default:
+		if declare {
+			c.addError("cannot declare %v. expected an id or index expression", pattern.String())
+		} else {
+			c.addError("cannot assign to %v", pattern.String())
+		}
+	}
+}
+
+// compileMatch compiles a match expression. The subject stays on the stack
+// while the arms are tried, and each arm's pattern tests a copy of it.
+func (c *Compiler) compileMatch(node *ast.MatchExpression) {
+	c.compile(node.Subject)
+
+	end := []int{}
+	for _, arm := range node.Arms {
+		clear, first := c.enterBlock()
+		c.emit(code.OpDup)
+
+		fail := c.compilePattern(arm.Pattern)
+		if arm.Guard != nil {
+			c.compile(arm.Guard)
+			fail = append(fail, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+
+		c.emit(code.OpPop)
+		c.compile(arm.Body)
+		c.leaveBlock(clear, first)
+		end = append(end, c.emit(code.OpJump, 9999))
+
+		c.patchJumps(fail)
+	}
+
+	c.emit(code.OpNoMatch)
+	c.patchJumps(end)
+}
+
+// compilePattern compiles a test of the value on top of the stack against
+// a match pattern, binding the names in it. The value is popped whether it
+// matches or not, and the jumps returned are taken if it doesn't.
+func (c *Compiler) compilePattern(pattern ast.Expression) []int {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			c.store(c.symbolTable.Define(pattern.Value))
+		}
+
+		c.emit(code.OpPop)
+		return nil
+	case *ast.ArrayPattern:
+		c.emit(code.OpMatchArray, len(pattern.Elements), hasRest(pattern.Rest))
+		return c.compileParts(withRest(pattern.Elements, pattern.Rest))
+	case *ast.HashPattern:
+		keys := []object.Object{}
+		patterns := []ast.Expression{}
+		for _, field := range pattern.Fields {
+			keys = append(keys, &object.String{Value: field.Name()})
+			patterns = append(patterns, field.Value)
+		}
+
+		c.emit(code.OpMatchHash, c.addConstant(&object.Array{Elements: keys}), hasRest(pattern.Rest))
+		return c.compileParts(withRest(patterns, pattern.Rest))
+	case *ast.ModelPattern:
+		c.compile(pattern.Model)
+		c.emit(code.OpMatchModel, len(pattern.Arguments))
+		return c.compileParts(pattern.Arguments)
+	case *ast.InfixExpression:
+		inclusive := 0
+		if pattern.Operator == ".." {
+			inclusive = 1
+		}
+
+		c.compile(pattern.Left)
+		c.compile(pattern.Right)
+		c.emit(code.OpMatchRange, inclusive)
+		return []int{c.emit(code.OpJumpNotTruthy, 9999)}
+	default:
+		c.compile(pattern)
+		c.emit(code.OpMatchEqual)
+		return []int{c.emit(code.OpJumpNotTruthy, 9999)}
+	}
+}
+
+// compileParts compiles the patterns for the parts of a value pushed by one
+// of the match opcodes, first on top. If one of them doesn't match, the
+// parts after it are popped before jumping.
+func (c *Compiler) compileParts(patterns []ast.Expression) []int {
+	fail := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+
+	parts := make([][]int, len(patterns))
+	canFail := false
+	for i, p := range patterns {
+		parts[i] = c.compilePattern(p)
+		canFail = canFail || len(parts[i]) > 0
+	}
+
+	if !canFail {
+		return fail
+	}
+
+	matched := c.emit(code.OpJump, 9999)
+
+	for i := range patterns {
+		c.patchJumps(parts[i])
+
+		if i < len(patterns)-1 {
+			c.emit(code.OpPop)
+		}
+	}
+
+	fail = append(fail, c.emit(code.OpJump, 9999))
+	c.changeOperand(matched, c.offset())
+
+	return fail
+}
+
+func withRest(patterns []ast.Expression, rest *ast.Identifier) []ast.Expression {
+	if rest == nil {
+		return patterns
+	}
+
+	return append(append([]ast.Expression{}, patterns...), rest)
+}
+
+func hasRest(rest *ast.Identifier) int {
+	if rest != nil {
+		return 1
+	}
+
+	return 0
+}
+
+// compileCompoundAssign compiles an assignment like "x += 1", evaluating
+// the target before the value like the evaluator does.
+func (c *Compiler) compileCompoundAssign(node *ast.AssignExpression) {
+	operator, ok := code.OperatorIndex(node.Operator)
+	if !ok {
+		c.addError("unknown operator: %s", node.Operator)
+		return
+	}
+
+	switch name := node.Name.(type) {
+	case *ast.Identifier:
+		c.compileIdentifier(name)
+		c.compile(node.Value)
+		c.emit(code.OpInfix, operator)
+
+		symbol, ok := c.symbolTable.Resolve(name.Value)
+		if !ok {
+			symbol = c.symbolTable.Define(name.Value)
+		}
+
+		c.store(symbol)
+	case *ast.IndexExpression:
+		c.compile(name.Left)
+		c.compile(name.Index)
+		c.compile(node.Value)
+		c.emit(code.OpUpdateIndex, operator)
+	case *ast.SliceExpression:
+		c.compile(name.Left)
+		c.compileSliceBounds(name)
+		c.compile(node.Value)
+		c.emit(code.OpUpdateSlice, operator)
+	case *ast.InfixExpression:
+		if name.Operator != "." {
+			c.addError("cannot assign any infix operator other than '.'")
+			return
+		}
+
+		id, ok := name.Right.(*ast.Identifier)
+		if !ok {
+			c.addError("expected an identifier")
+			return
+		}
+
+		c.compile(name.Left)
+		c.compile(node.Value)
+		c.emit(code.OpUpdateField, c.addConstant(&object.String{Value: id.Value}), operator)
+	default:
+		c.addError("cannot assign to %v", node.Name.String())
+	}
+}
+
+// compileSliceBounds pushes the start, end and step of a slice, with null
+// for any which are left out.
+func (c *Compiler) compileSliceBounds(node *ast.SliceExpression) {
+	for _, bound := range []ast.Expression{node.Start, node.End, node.Step} {
+		if bound == nil {
+			c.emit(code.OpNull)
+		} else {
+			c.compile(bound)
+		}
+	}
+}
+
+func (c *Compiler) compileIf(node *ast.IfExpression) {
+	c.compile(node.Condition)
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.compile(node.Consequence)
+	jump := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthy, c.offset())
+
+	if node.Alternative != nil {
+		c.compile(node.Alternative)
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	c.changeOperand(jump, c.offset())
+}
+
+func (c *Compiler) compileFunction(node ast.Expression, name string) {
+	var params []*ast.Identifier
+	var patterns []ast.Expression
+	var body string
+	lambda := false
+
+	switch node := node.(type) {
+	case *ast.FunctionLiteral:
+		params = node.Parameters
+		patterns = node.Patterns
+		body = node.Body.String()
+	case *ast.LambdaExpression:
+		params = node.Parameters
+		patterns = node.Patterns
+		body = node.Body.String()
+		lambda = true
+	}
+
+	c.enterFunction()
+
+	names := []string{}
+	symbols := []Symbol{}
+	for _, param := range params {
+		symbols = append(symbols, c.symbolTable.Define(param.Value))
+		names = append(names, param.String())
+	}
+
+	// parameters which are patterns are destructured before the body runs
+	for i, pattern := range patterns {
+		if pattern != nil {
+			c.load(symbols[i])
+			c.compileDestructure(pattern, true)
+		}
+	}
+
+	switch node := node.(type) {
+	case *ast.FunctionLiteral:
+		c.compile(node.Body)
+	case *ast.LambdaExpression:
+		c.compile(node.Body)
+	}
+
+	c.emit(code.OpReturn)
+
+	fn := c.leaveFunction()
+	fn.Name = name
+	fn.Lambda = lambda
+	fn.NumParameters = len(params)
+	fn.Parameters = names
+	fn.Body = body
+
+	c.emit(code.OpClosure, c.addConstant(fn))
+}
+
+func (c *Compiler) enterFunction() {
+	c.scopes = append(c.scopes, &compilationScope{function: true})
+	c.symbolTable = NewFunctionTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveFunction() *CompiledFunction {
+	scope := c.scope()
+	table := c.symbolTable
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.symbolTable = table.Outer
+
+	return &CompiledFunction{
+		Instructions: scope.instructions,
+		NumLocals:    table.NumLocals(),
+		Free:         table.FreeSymbols,
+		Positions:    scope.positions,
+		LocalNames:   table.LocalNames(),
+	}
+}
+
+// enterBlock starts a block with its own names, which are cleared each
+// time the block is entered so that closures made inside it don't share
+// variables between iterations of a loop.
+func (c *Compiler) enterBlock() (clear, start int) {
+	c.symbolTable = NewBlockTable(c.symbolTable)
+	start = c.symbolTable.NumLocals()
+
+	return c.emit(code.OpClearLocals, start, 0), start
+}
+
+func (c *Compiler) leaveBlock(clear, start int) {
+	c.changeOperand(clear, start, c.symbolTable.NumLocals()-start)
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) compileHash(node *ast.HashLiteral) {
+	keys := node.Keys()
+
+	for _, key := range keys {
+		c.compile(node.Pairs[key])
+
+		if id, ok := key.(*ast.Identifier); ok {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: id.Value}))
+		} else {
+			c.compile(key)
+		}
+	}
+
+	c.emit(code.OpHash, len(keys))
+}
+
+func (c *Compiler) compileWhile(node *ast.WhileExpression) {
+	init := c.emit(code.OpWhileInit, 9999)
+	start := c.offset()
+
+	c.compile(node.Condition)
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.pushControl(control{loop: true})
+	c.compile(node.Body)
+	c.popControl()
+
+	c.emit(code.OpLoopAppend)
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(init, c.offset())
+	c.changeOperand(jumpNotTruthy, c.offset())
+	c.emit(code.OpLoopEnd)
+}
+
+func (c *Compiler) compileFor(node *ast.ForExpression) {
+	c.compile(node.Set)
+
+	init := c.emit(code.OpForInit, 9999)
+	start := c.offset()
+	next := c.emit(code.OpForNext, 9999)
+
+	clear, first := c.enterBlock()
+	c.store(c.symbolTable.Define(node.Var.Value))
+	c.emit(code.OpPop)
+
+	if node.Element != nil {
+		c.emit(code.OpForElement)
+		c.compileDestructure(node.Element, true)
+	}
+
+	c.pushControl(control{loop: true})
+	c.compile(node.Body)
+	c.popControl()
+	c.leaveBlock(clear, first)
+
+	c.emit(code.OpLoopAppend)
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(init, c.offset())
+	c.changeOperand(next, c.offset())
+	c.emit(code.OpLoopEnd)
+}
+
+func (c *Compiler) compileLoopControl(op code.Opcode, name string) {
+	ctl := c.scope().control
+
+	loop := -1
+	for i := len(ctl) - 1; i >= 0; i-- {
+		if ctl[i].loop {
+			loop = i
+			break
+		}
+	}
+
+	if loop < 0 {
+		c.addError("%s outside of a loop", name)
+		return
+	}
+
+	c.unwind(loop)
+	c.emit(op)
+}
+
+// unwind emits the code needed to leave every try expression above the
+// given index in the control stack, removing their handlers and running
+// their finally blocks.
+func (c *Compiler) unwind(to int) {
+	scope := c.scope()
+	ctl := scope.control
+
+	for i := len(ctl) - 1; i > to; i-- {
+		if ctl[i].loop {
+			continue
+		}
+
+		if ctl[i].handler {
+			c.emit(code.OpPopTry)
+		}
+
+		if ctl[i].finally != nil {
+			// a return or break in the finally block itself shouldn't
+			// run it again
+			scope.control = ctl[:i]
+			c.compile(ctl[i].finally)
+			c.emit(code.OpPop)
+			scope.control = ctl
+		}
+	}
+}
+
+func (c *Compiler) compileTry(node *ast.TryExpression) {
+	setup := c.emit(code.OpSetupTry, 9999)
+
+	c.pushControl(control{handler: true, finally: node.Finally})
+	c.compile(node.Body)
+	c.popControl()
+
+	c.emit(code.OpPopTry)
+	jumps := []int{c.emit(code.OpJump, 9999)}
+
+	// the error being handled is pushed onto the stack when the handler
+	// is jumped to
+	c.changeOperand(setup, c.offset())
+
+	if node.Catch != nil {
+		handled := -1
+		if node.Finally != nil {
+			handled = c.emit(code.OpSetupTry, 9999)
+		}
+
+		c.pushControl(control{handler: node.Finally != nil, finally: node.Finally})
+
+		clear, first := c.enterBlock()
+		if node.ErrName != nil {
+			c.emit(code.OpErrorHash)
+			c.store(c.symbolTable.Define(node.ErrName.Value))
+		}
+		c.emit(code.OpPop)
+
+		c.compile(node.Catch)
+		c.leaveBlock(clear, first)
+
+		c.popControl()
+
+		if node.Finally == nil {
+			c.patchJumps(jumps)
+			return
+		}
+
+		c.emit(code.OpPopTry)
+		jumps = append(jumps, c.emit(code.OpJump, 9999))
+		c.changeOperand(handled, c.offset())
+	}
+
+	// an error which wasn't caught, or happened in the catch block, is
+	// rethrown after the finally block has run
+	c.compile(node.Finally)
+	c.emit(code.OpPop)
+	c.emit(code.OpThrow)
+
+	c.patchJumps(jumps)
+	c.compile(node.Finally)
+	c.emit(code.OpPop)
+}
+
+func (c *Compiler) patchJumps(jumps []int) {
+	for _, jump := range jumps {
+		c.changeOperand(jump, c.offset())
+	}
+}
+
+func (c *Compiler) compileModel(node *ast.ModelLiteral, name string) {
+	template := &ModelTemplate{
+		Name:       name,
+		Properties: node.Parameters,
+		HasParent:  node.ParentName != nil,
+	}
+
+	if node.ParentName != nil {
+		c.compile(*node.ParentName)
+
+		// the arguments given to the parent are compiled into a function
+		// taking the model's properties
+		c.enterFunction()
+		for _, prop := range node.Parameters {
+			c.symbolTable.Define(prop.Value)
+		}
+
+		for _, arg := range node.ParentArgs {
+			c.compile(arg)
+		}
+		c.emit(code.OpArray, len(node.ParentArgs))
+		c.emit(code.OpReturn)
+
+		fn := c.leaveFunction()
+		fn.Name = "<parent arguments>"
+		fn.NumParameters = len(node.Parameters)
+
+		c.emit(code.OpClosure, c.addConstant(fn))
+	}
+
+	c.emit(code.OpModel, c.addConstant(template))
+}
+
+func (c *Compiler) compileImport(node *ast.ImportExpression) {
+	c.compile(node.Path)
+
+	names := []object.Object{}
+	for _, name := range node.Names {
+		names = append(names, &object.String{Value: name.Value})
+	}
+
+	c.emit(code.OpImport, c.addConstant(&object.Array{Elements: names}))
+
+	for _, name := range node.Names {
+		c.emit(code.OpDup)
+		c.emit(code.OpGetField, c.addConstant(&object.String{Value: name.Value}))
+		c.store(c.symbolTable.Define(name.Value))
+		c.emit(code.OpPop)
+	}
+}
+
+func (c *Compiler) scope() *compilationScope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+func (c *Compiler) pushControl(ctl control) {
+	scope := c.scope()
+	scope.control = append(scope.control, ctl)
+}
+
+func (c *Compiler) popControl() {
+	scope := c.scope()
+	scope.control = scope.control[:len(scope.control)-1]
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// offset is the offset of the next instruction to be emitted.
+func (c *Compiler) offset() int {
+	return len(c.scope().instructions)
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	scope := c.scope()
+	offset := len(scope.instructions)
+
+	if n := len(scope.positions); n == 0 || scope.positions[n-1].Pos != c.pos {
+		scope.positions = append(scope.positions, Position{Offset: offset, Pos: c.pos})
+	}
+
+	scope.instructions = append(scope.instructions, code.Make(op, operands...)...)
+
+	return offset
+}
+
+func (c *Compiler) changeOperand(offset int, operands ...int) {
+	ins := c.scope().instructions
+	op := code.Opcode(ins[offset])
+
+	copy(ins[offset:], code.Make(op, operands...))
+}
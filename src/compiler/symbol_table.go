@@ -0,0 +1,151 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	FreeScope    SymbolScope = "FREE"
+	BuiltinScope SymbolScope = "BUILTIN"
+)
+
+// Symbol is a name which has been resolved to a slot in one of the scopes.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable maps names to the slots they're stored in. There are three
+// kinds of table: the global table of a program, the table of a function,
+// and the table of a block, like the body of a for loop, which can declare
+// its own names but stores them in the slots of the enclosing function.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	// FreeSymbols are the symbols of enclosing functions which are
+	// referred to in this function, in the order they're captured.
+	FreeSymbols []Symbol
+
+	store map[string]Symbol
+	free  map[string]Symbol
+
+	// owner is the table whose slots locals are stored in. It's the table
+	// itself for functions and programs.
+	owner *SymbolTable
+	block bool
+
+	// localNames and globalNames record which name each slot belongs to,
+	// for error messages.
+	localNames  []string
+	globalNames []string
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{
+		store: make(map[string]Symbol),
+		free:  make(map[string]Symbol),
+	}
+
+	s.owner = s
+	return s
+}
+
+// NewFunctionTable creates the table of a function defined inside outer.
+func NewFunctionTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockTable creates the table of a block inside outer.
+func NewBlockTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.owner = outer.owner
+	s.block = true
+	return s
+}
+
+func (s *SymbolTable) isGlobal() bool {
+	return s.Outer == nil
+}
+
+// Define declares a name in this table, returning the existing symbol if
+// the name's already declared here.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+
+	var symbol Symbol
+
+	if s.isGlobal() {
+		symbol = Symbol{Name: name, Scope: GlobalScope, Index: len(s.globalNames)}
+		s.globalNames = append(s.globalNames, name)
+	} else {
+		symbol = Symbol{Name: name, Scope: LocalScope, Index: len(s.owner.localNames)}
+		s.owner.localNames = append(s.owner.localNames, name)
+	}
+
+	s.store[name] = symbol
+	return symbol
+}
+
+// Resolve finds the symbol a name refers to, looking through the enclosing
+// tables. Names from enclosing functions are captured as free variables.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	if symbol, ok := s.store[name]; ok {
+		return symbol, true
+	}
+
+	if symbol, ok := s.free[name]; ok {
+		return symbol, true
+	}
+
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	symbol, ok := s.Outer.Resolve(name)
+	if !ok || s.block || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.free[original.Name] = symbol
+
+	return symbol
+}
+
+// Global returns the global table at the root of this one.
+func (s *SymbolTable) Global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+
+	return s
+}
+
+// NumLocals is the number of local slots needed by the function this
+// table belongs to.
+func (s *SymbolTable) NumLocals() int {
+	return len(s.owner.localNames)
+}
+
+// LocalNames gives the name of each local slot in the function this table
+// belongs to.
+func (s *SymbolTable) LocalNames() []string {
+	return s.owner.localNames
+}
+
+// GlobalNames gives the name of each global slot.
+func (s *SymbolTable) GlobalNames() []string {
+	return s.Global().globalNames
+}
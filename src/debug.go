@@ -0,0 +1,76 @@
+package main
+
+import (
+	"./debugger"
+	"./lexer"
+	"./object"
+	"./parser"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// runDebug runs the debug subcommand, which runs a file in the command line
+// debugger, or else serves the Debug Adapter Protocol on standard input and
+// output for an editor to launch programs with. It returns the status to
+// exit with.
+func runDebug(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	dap := flags.Bool("dap", false, "serve the Debug Adapter Protocol on standard input and output")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s debug file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s debug -dap\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *dap {
+		if err := debugger.ServeDAP(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		return 0
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	fileName := flags.Arg(0)
+
+	bytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	text := string(bytes)
+
+	env := newEnvironment()
+	env.Runtime().Sources[fileName] = text
+
+	if abs, err := filepath.Abs(fileName); err == nil {
+		env.Runtime().Importing = []string{abs}
+	}
+
+	p := parser.New(lexer.NewFile(fileName, text))
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(p.ErrorList(), text)
+		return 1
+	}
+
+	fmt.Println("type help to see the debugger's commands")
+
+	if err, ok := debugger.Run(program, env, fileName, os.Stdout).(*object.Error); ok {
+		printRuntimeError(err, env.Runtime().Sources[err.Pos.File])
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,309 @@
+package debugger
+
+import (
+	"../ast"
+	"../evaluator"
+	"../object"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cli is a command line front end for the debugger. Commands are read from
+// the program's standard input, so they can be mixed with input the
+// program reads itself.
+type cli struct {
+	d    *Debugger
+	rt   *object.Runtime
+	out  io.Writer
+	file string
+
+	stop *Stop
+	last string
+	quit bool
+}
+
+type cliCommand struct {
+	names []string
+	args  string
+	help  string
+
+	// run runs the command, returning true and an action if the program
+	// should carry on
+	run func(c *cli, arg string) (Action, bool)
+}
+
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{[]string{"break", "b"}, "<line|file:line|function>", "set a breakpoint", (*cli).setBreakpoint},
+		{[]string{"delete", "d"}, "<line|file:line|function>", "remove a breakpoint", (*cli).deleteBreakpoint},
+		{[]string{"breakpoints", "bl"}, "", "list the breakpoints", (*cli).listBreakpoints},
+		{[]string{"continue", "c"}, "", "run until the next breakpoint", resume(Continue)},
+		{[]string{"step", "s"}, "", "run the next statement, stepping into calls", resume(StepIn)},
+		{[]string{"next", "n"}, "", "run the next statement, stepping over calls", resume(StepOver)},
+		{[]string{"out", "o"}, "", "run until the current function returns", resume(StepOut)},
+		{[]string{"print", "p"}, "<expr>", "evaluate an expression in the current frame", (*cli).print},
+		{[]string{"env", "e"}, "", "show the variables the current statement can see", (*cli).env},
+		{[]string{"backtrace", "bt"}, "", "show the call stack", (*cli).backtrace},
+		{[]string{"list", "l"}, "", "show the code around the current statement", (*cli).list},
+		{[]string{"help", "h"}, "", "show this help", (*cli).help},
+		{[]string{"quit", "q"}, "", "stop the program and exit", (*cli).stopProgram},
+	}
+}
+
+func resume(action Action) func(c *cli, arg string) (Action, bool) {
+	return func(c *cli, arg string) (Action, bool) {
+		return action, true
+	}
+}
+
+// Run evaluates a program, pausing before its first statement and then at
+// each breakpoint to read commands from the runtime's standard input. It
+// returns the result of the program, or nil if it was quit.
+func Run(program *ast.Program, env *object.Environment, file string, out io.Writer) object.Object {
+	rt := env.Runtime()
+
+	c := &cli{rt: rt, out: out, file: file}
+	c.d = New(true, c.pause)
+
+	rt.Debugger = c.d
+	defer func() { rt.Debugger = nil }()
+
+	result := evaluator.Eval(program, env)
+	if c.quit {
+		return nil
+	}
+
+	return result
+}
+
+func (c *cli) pause(stop *Stop) Action {
+	c.stop = stop
+
+	pos := stop.Pos()
+	fmt.Fprintf(c.out, "stopped at %v in %s (%s)\n", pos, stop.Frames[0].Name, stop.Reason)
+	c.printLine(pos.File, pos.Line, true)
+
+	for {
+		fmt.Fprint(c.out, "(debug) ")
+
+		line, err := c.rt.ReadLine()
+		if err != nil {
+			fmt.Fprintln(c.out)
+			action, _ := c.stopProgram("")
+			return action
+		}
+
+		line = strings.TrimSpace(line)
+
+		// an empty line repeats the last command, which is handy for
+		// stepping through a lot of statements
+		if line == "" {
+			line = c.last
+		}
+
+		if line == "" {
+			continue
+		}
+
+		c.last = line
+
+		if action, resume := c.command(line); resume {
+			return action
+		}
+	}
+}
+
+func (c *cli) command(line string) (Action, bool) {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i:])
+	}
+
+	for _, cmd := range cliCommands {
+		for _, n := range cmd.names {
+			if n == name {
+				return cmd.run(c, arg)
+			}
+		}
+	}
+
+	fmt.Fprintf(c.out, "unknown command %s. type help to see the commands\n", name)
+	return Continue, false
+}
+
+// breakpoint parses the argument to break or delete, which is a line, a
+// file and line, or a function name.
+func (c *cli) breakpoint(arg string) (file string, line int, function string) {
+	if n, err := strconv.Atoi(arg); err == nil {
+		return c.file, n, ""
+	}
+
+	if i := strings.LastIndex(arg, ":"); i >= 0 {
+		if n, err := strconv.Atoi(arg[i+1:]); err == nil {
+			return arg[:i], n, ""
+		}
+	}
+
+	return "", 0, arg
+}
+
+func (c *cli) setBreakpoint(arg string) (Action, bool) {
+	if arg == "" {
+		fmt.Fprintln(c.out, "usage: break <line|file:line|function>")
+		return Continue, false
+	}
+
+	file, line, function := c.breakpoint(arg)
+	if function != "" {
+		c.d.AddFunctionBreakpoint(function)
+		fmt.Fprintf(c.out, "breakpoint set on %s\n", function)
+	} else {
+		c.d.AddLineBreakpoint(file, line)
+		fmt.Fprintf(c.out, "breakpoint set at %s:%d\n", file, line)
+	}
+
+	return Continue, false
+}
+
+func (c *cli) deleteBreakpoint(arg string) (Action, bool) {
+	file, line, function := c.breakpoint(arg)
+
+	var removed bool
+	if function != "" {
+		removed = c.d.RemoveFunctionBreakpoint(function)
+	} else {
+		removed = c.d.RemoveLineBreakpoint(file, line)
+	}
+
+	if !removed {
+		fmt.Fprintf(c.out, "no breakpoint at %s\n", arg)
+	}
+
+	return Continue, false
+}
+
+func (c *cli) listBreakpoints(arg string) (Action, bool) {
+	points := c.d.Breakpoints()
+	if len(points) == 0 {
+		fmt.Fprintln(c.out, "no breakpoints")
+	}
+
+	for _, point := range points {
+		fmt.Fprintln(c.out, "  "+point)
+	}
+
+	return Continue, false
+}
+
+func (c *cli) print(arg string) (Action, bool) {
+	if arg == "" {
+		fmt.Fprintln(c.out, "usage: print <expr>")
+		return Continue, false
+	}
+
+	result := c.d.Evaluate(arg, c.stop.Env)
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintf(c.out, "%s error: %s\n", err.Kind, err.Message)
+	} else {
+		fmt.Fprintln(c.out, inspect(result))
+	}
+
+	return Continue, false
+}
+
+// inspect returns how a value is shown when the program's paused.
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "null"
+	}
+
+	return obj.Inspect()
+}
+
+// summary shortens the inspected form of a value to fit on a line.
+func summary(obj object.Object) string {
+	const max = 60
+
+	s := inspect(obj)
+	if i := strings.Index(s, "\n"); i >= 0 {
+		s = s[:i] + " ..."
+	}
+
+	if len(s) > max {
+		s = s[:max] + "..."
+	}
+
+	return s
+}
+
+func (c *cli) env(arg string) (Action, bool) {
+	for _, scope := range Scopes(c.stop.Env) {
+		fmt.Fprintf(c.out, "%s:\n", scope.Name)
+
+		for _, b := range scope.Bindings {
+			fmt.Fprintf(c.out, "  %s = %s\n", b.Name, summary(b.Value))
+		}
+	}
+
+	return Continue, false
+}
+
+func (c *cli) backtrace(arg string) (Action, bool) {
+	for i, frame := range c.stop.Frames {
+		fmt.Fprintf(c.out, "  #%d %s at %v\n", i, frame.Name, frame.Pos)
+	}
+
+	return Continue, false
+}
+
+func (c *cli) list(arg string) (Action, bool) {
+	pos := c.stop.Pos()
+
+	for line := pos.Line - 3; line <= pos.Line+3; line++ {
+		c.printLine(pos.File, line, line == pos.Line)
+	}
+
+	return Continue, false
+}
+
+// printLine shows a line of a file, marking it if it's the current line.
+func (c *cli) printLine(file string, line int, current bool) {
+	lines := strings.Split(c.rt.Sources[file], "\n")
+	if line < 1 || line > len(lines) {
+		return
+	}
+
+	marker := " "
+	if current {
+		marker = ">"
+	}
+
+	fmt.Fprintf(c.out, "%s %4d | %s\n", marker, line, lines[line-1])
+}
+
+func (c *cli) help(arg string) (Action, bool) {
+	for _, cmd := range cliCommands {
+		usage := strings.TrimSpace(strings.Join(cmd.names, ", ") + " " + cmd.args)
+		fmt.Fprintf(c.out, "  %-38s %s\n", usage, cmd.help)
+	}
+
+	return Continue, false
+}
+
+// stopProgram stops the program by cancelling its context, so the
+// debugger isn't asked about any more statements.
+func (c *cli) stopProgram(arg string) (Action, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c.rt.SetContext(ctx)
+	c.rt.Debugger = nil
+	c.quit = true
+
+	return Continue, true
+}
@@ -0,0 +1,665 @@
+package debugger
+
+import (
+	"../ast"
+	"../evaluator"
+	"../lexer"
+	"../object"
+	"../parser"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The Debug Adapter Protocol is spoken over the same kind of stream as the
+// Language Server Protocol: each message is some JSON, after a header
+// giving its length.
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// threadID is the ID of the only thread a program has.
+const threadID = 1
+
+type dapServer struct {
+	in *bufio.Reader
+
+	// mu guards writing to out, and the sequence number of the messages
+	// which are written
+	mu  sync.Mutex
+	out io.Writer
+	seq int
+
+	d       *Debugger
+	env     *object.Environment
+	program *ast.Program
+	cancel  context.CancelFunc
+
+	launched, configured bool
+	done                 chan struct{}
+
+	// resume is sent the action to take when the program is paused
+	resume chan Action
+
+	// state guards the fields below it, which are set by the goroutine
+	// running the program
+	state       sync.Mutex
+	stop        *Stop
+	terminating bool
+
+	// refs are the scopes and values which can be expanded in the
+	// client. They're only valid while the program stays paused.
+	refs []interface{}
+}
+
+type dapHandler func(s *dapServer, args json.RawMessage) (interface{}, error)
+
+var dapHandlers map[string]dapHandler
+
+func init() {
+	dapHandlers = map[string]dapHandler{
+		"initialize":              (*dapServer).initialize,
+		"launch":                  (*dapServer).launch,
+		"setBreakpoints":          (*dapServer).setBreakpoints,
+		"setFunctionBreakpoints":  (*dapServer).setFunctionBreakpoints,
+		"setExceptionBreakpoints": (*dapServer).setExceptionBreakpoints,
+		"configurationDone":       (*dapServer).configurationDone,
+		"threads":                 (*dapServer).threads,
+		"stackTrace":              (*dapServer).stackTrace,
+		"scopes":                  (*dapServer).scopes,
+		"variables":               (*dapServer).variables,
+		"evaluate":                (*dapServer).evaluate,
+		"continue":                resumeWith(Continue),
+		"next":                    resumeWith(StepOver),
+		"stepIn":                  resumeWith(StepIn),
+		"stepOut":                 resumeWith(StepOut),
+		"pause":                   (*dapServer).pause,
+	}
+}
+
+// ServeDAP runs a Debug Adapter Protocol server, reading requests from in
+// and writing responses and events to out, until the client disconnects.
+// The program to debug is given by the client's launch request.
+func ServeDAP(in io.Reader, out io.Writer) error {
+	s := &dapServer{
+		in:     bufio.NewReader(in),
+		out:    out,
+		done:   make(chan struct{}),
+		resume: make(chan Action),
+	}
+
+	s.d = New(false, s.pauseProgram)
+
+	for {
+		content, err := readDAPMessage(s.in)
+		if err == io.EOF {
+			s.terminate()
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req dapRequest
+		if err := json.Unmarshal(content, &req); err != nil {
+			return err
+		}
+
+		if req.Command == "disconnect" || req.Command == "terminate" {
+			s.terminate()
+			s.respond(&req, nil, nil)
+
+			if req.Command == "disconnect" {
+				return nil
+			}
+
+			continue
+		}
+
+		h, ok := dapHandlers[req.Command]
+		if !ok {
+			s.respond(&req, nil, fmt.Errorf("unsupported request: %s", req.Command))
+			continue
+		}
+
+		body, err := h(s, req.Arguments)
+		s.respond(&req, body, err)
+
+		// the initialized event has to come after the response to the
+		// initialize request
+		if req.Command == "initialize" {
+			s.event("initialized", nil)
+		}
+	}
+}
+
+func readDAPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if i := strings.Index(line, ":"); i >= 0 && strings.EqualFold(line[:i], "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid content length: %q", line)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("missing content length")
+	}
+
+	content := make([]byte, length)
+	_, err := io.ReadFull(r, content)
+
+	return content, err
+}
+
+func (s *dapServer) write(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	switch msg := msg.(type) {
+	case *dapResponse:
+		msg.Seq = s.seq
+	case *dapEvent:
+		msg.Seq = s.seq
+	}
+
+	content, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func (s *dapServer) respond(req *dapRequest, body interface{}, err error) {
+	resp := &dapResponse{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+
+	if err != nil {
+		resp.Message = err.Error()
+	}
+
+	s.write(resp)
+}
+
+func (s *dapServer) event(name string, body interface{}) {
+	s.write(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// outputWriter sends what a program prints to the client.
+type outputWriter struct {
+	s        *dapServer
+	category string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.s.event("output", map[string]string{"category": w.category, "output": string(p)})
+	return len(p), nil
+}
+
+func (s *dapServer) initialize(args json.RawMessage) (interface{}, error) {
+	return map[string]bool{
+		"supportsConfigurationDoneRequest": true,
+		"supportsFunctionBreakpoints":      true,
+		"supportsEvaluateForHovers":        true,
+		"supportTerminateDebuggee":         true,
+	}, nil
+}
+
+func (s *dapServer) launch(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(a.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	text := string(bytes)
+
+	p := parser.New(lexer.NewFile(path, text))
+	s.program = p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		msgs := []string{}
+		for _, err := range p.ErrorList() {
+			msgs = append(msgs, err.Error())
+		}
+
+		return nil, fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+
+	s.env = object.NewEnvironment()
+
+	rt := s.env.Runtime()
+	rt.Sources[path] = text
+	rt.Importing = []string{path}
+	rt.Debugger = s.d
+
+	// standard input is used by the protocol, so the program can't read it
+	rt.Stdin = strings.NewReader("")
+	rt.Stdout = &outputWriter{s, "stdout"}
+	rt.Stderr = &outputWriter{s, "stderr"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rt.SetContext(ctx)
+	s.cancel = cancel
+
+	if a.StopOnEntry {
+		s.d.action = StepIn
+	}
+
+	s.launched = true
+	s.start()
+
+	return nil, nil
+}
+
+func (s *dapServer) configurationDone(args json.RawMessage) (interface{}, error) {
+	s.configured = true
+	s.start()
+
+	return nil, nil
+}
+
+// start runs the program once it's been launched and the client has set
+// its breakpoints.
+func (s *dapServer) start() {
+	if !s.launched || !s.configured {
+		return
+	}
+
+	go func() {
+		defer close(s.done)
+
+		result := evaluator.Eval(s.program, s.env)
+
+		exitCode := 0
+		if err, ok := result.(*object.Error); ok {
+			exitCode = 1
+
+			s.state.Lock()
+			terminating := s.terminating
+			s.state.Unlock()
+
+			if !terminating {
+				s.event("output", map[string]string{
+					"category": "stderr",
+					"output":   fmt.Sprintf("%v: %s\n", err.Pos, err.Inspect()),
+				})
+			}
+		}
+
+		s.event("exited", map[string]int{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+}
+
+// pauseProgram is called by the debugger, in the goroutine running the
+// program, when it's paused. It waits for the client to say what to do.
+func (s *dapServer) pauseProgram(stop *Stop) Action {
+	s.state.Lock()
+	if s.terminating {
+		s.state.Unlock()
+		return Continue
+	}
+
+	s.stop = stop
+	s.refs = nil
+	s.state.Unlock()
+
+	s.event("stopped", map[string]interface{}{
+		"reason":            stop.Reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	})
+
+	action := <-s.resume
+
+	s.state.Lock()
+	s.stop = nil
+	s.refs = nil
+	s.state.Unlock()
+
+	return action
+}
+
+// terminate stops the program, if it's running.
+func (s *dapServer) terminate() {
+	if !s.launched || !s.configured {
+		return
+	}
+
+	s.state.Lock()
+	s.terminating = true
+	paused := s.stop != nil
+	s.state.Unlock()
+
+	s.cancel()
+
+	if paused {
+		s.resume <- Continue
+	}
+
+	<-s.done
+}
+
+func resumeWith(action Action) dapHandler {
+	return func(s *dapServer, args json.RawMessage) (interface{}, error) {
+		s.state.Lock()
+		paused := s.stop != nil
+		s.state.Unlock()
+
+		if !paused {
+			return nil, fmt.Errorf("the program isn't paused")
+		}
+
+		s.resume <- action
+
+		return map[string]bool{"allThreadsContinued": true}, nil
+	}
+}
+
+func (s *dapServer) pause(args json.RawMessage) (interface{}, error) {
+	s.d.Pause()
+	return nil, nil
+}
+
+func (s *dapServer) setBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	path, err := filepath.Abs(a.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []int{}
+	verified := []map[string]interface{}{}
+
+	for _, bp := range a.Breakpoints {
+		lines = append(lines, bp.Line)
+		verified = append(verified, map[string]interface{}{"verified": true, "line": bp.Line})
+	}
+
+	s.d.SetLineBreakpoints(path, lines)
+
+	return map[string]interface{}{"breakpoints": verified}, nil
+}
+
+func (s *dapServer) setFunctionBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Breakpoints []struct {
+			Name string `json:"name"`
+		} `json:"breakpoints"`
+	}
+
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	verified := []map[string]bool{}
+
+	for _, bp := range a.Breakpoints {
+		names = append(names, bp.Name)
+		verified = append(verified, map[string]bool{"verified": true})
+	}
+
+	s.d.SetFunctionBreakpoints(names)
+
+	return map[string]interface{}{"breakpoints": verified}, nil
+}
+
+func (s *dapServer) setExceptionBreakpoints(args json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (s *dapServer) threads(args json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"threads": []map[string]interface{}{{"id": threadID, "name": "main"}},
+	}, nil
+}
+
+// paused returns what the program is stopped at, or an error if it's
+// running.
+func (s *dapServer) paused() (*Stop, error) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	if s.stop == nil {
+		return nil, fmt.Errorf("the program isn't paused")
+	}
+
+	return s.stop, nil
+}
+
+func (s *dapServer) stackTrace(args json.RawMessage) (interface{}, error) {
+	stop, err := s.paused()
+	if err != nil {
+		return nil, err
+	}
+
+	frames := []map[string]interface{}{}
+	for i, frame := range stop.Frames {
+		frames = append(frames, map[string]interface{}{
+			"id":     i + 1,
+			"name":   frame.Name,
+			"line":   frame.Pos.Line,
+			"column": frame.Pos.Column,
+			"source": dapSource{Name: filepath.Base(frame.Pos.File), Path: frame.Pos.File},
+		})
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// frame returns the frame with the ID the client gave it.
+func (s *dapServer) frame(id int) (*Frame, error) {
+	stop, err := s.paused()
+	if err != nil {
+		return nil, err
+	}
+
+	if id < 1 || id > len(stop.Frames) {
+		return &stop.Frames[0], nil
+	}
+
+	return &stop.Frames[id-1], nil
+}
+
+// ref returns the reference the client can use to expand a scope or value,
+// or 0 if it can't be expanded.
+func (s *dapServer) ref(v interface{}) int {
+	switch v := v.(type) {
+	case Scope:
+	case *object.Array:
+		if len(v.Elements) == 0 {
+			return 0
+		}
+	case *object.Hash:
+		if v.Len() == 0 {
+			return 0
+		}
+	default:
+		return 0
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.refs = append(s.refs, v)
+	return len(s.refs)
+}
+
+func (s *dapServer) scopes(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		FrameID int `json:"frameId"`
+	}
+
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	frame, err := s.frame(a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []map[string]interface{}{}
+	for _, scope := range Scopes(frame.Env) {
+		scopes = append(scopes, map[string]interface{}{
+			"name":               scope.Name,
+			"variablesReference": s.ref(scope),
+			"expensive":          false,
+		})
+	}
+
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *dapServer) variable(name string, value object.Object) dapVariable {
+	return dapVariable{Name: name, Value: summary(value), VariablesReference: s.ref(value)}
+}
+
+func (s *dapServer) variables(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.paused(); err != nil {
+		return nil, err
+	}
+
+	s.state.Lock()
+	var v interface{}
+	if a.VariablesReference > 0 && a.VariablesReference <= len(s.refs) {
+		v = s.refs[a.VariablesReference-1]
+	}
+	s.state.Unlock()
+
+	vars := []dapVariable{}
+
+	switch v := v.(type) {
+	case Scope:
+		for _, b := range v.Bindings {
+			vars = append(vars, s.variable(b.Name, b.Value))
+		}
+	case *object.Array:
+		for i, elem := range v.Elements {
+			vars = append(vars, s.variable(strconv.Itoa(i), elem))
+		}
+	case *object.Hash:
+		for _, pair := range v.Pairs() {
+			vars = append(vars, s.variable(pair.Key.Inspect(), pair.Value))
+		}
+	}
+
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (s *dapServer) evaluate(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+
+	frame, err := s.frame(a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.d.Evaluate(a.Expression, frame.Env)
+	if err, ok := result.(*object.Error); ok {
+		return nil, fmt.Errorf("%s error: %s", err.Kind, err.Message)
+	}
+
+	return map[string]interface{}{
+		"result":             summary(result),
+		"variablesReference": s.ref(result),
+	}, nil
+}
@@ -0,0 +1,388 @@
+// Package debugger lets programs run by the evaluator be paused at
+// breakpoints, stepped through a statement at a time, and inspected while
+// they're paused. The debugger itself doesn't decide what to do when the
+// program stops - that's up to a front end, such as the command line one in
+// this package or a Debug Adapter Protocol server.
+package debugger
+
+import (
+	"../ast"
+	"../evaluator"
+	"../lexer"
+	"../object"
+	"../parser"
+	"../token"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Action is what the program does after it's been paused.
+type Action int
+
+const (
+	// Continue runs until the next breakpoint.
+	Continue Action = iota
+
+	// StepIn stops at the next statement, even if it's inside a function
+	// called by the current one.
+	StepIn
+
+	// StepOver stops at the next statement in the current function, or
+	// the one it returns to.
+	StepOver
+
+	// StepOut stops once the current function returns.
+	StepOut
+)
+
+// Stop describes the program when it's paused, before it runs a statement.
+type Stop struct {
+	// Reason is why the program stopped: "entry", "breakpoint",
+	// "function breakpoint", "step" or "pause".
+	Reason string
+
+	Stmt ast.Statement
+	Env  *object.Environment
+
+	// Frames are the functions being run, innermost first, each with the
+	// position it's stopped at and its environment
+	Frames []Frame
+}
+
+// Pos returns the position of the statement the program is stopped before.
+func (s *Stop) Pos() token.Position {
+	return s.Stmt.Pos()
+}
+
+// Frame is a function being run when the program is paused.
+type Frame struct {
+	Name string
+	Pos  token.Position
+	Env  *object.Environment
+}
+
+// Debugger pauses a program when it reaches a breakpoint or finishes a
+// step. It's given to a program's runtime as its object.Debugger.
+type Debugger struct {
+	// OnStop is called when the program is paused, and returns what the
+	// program should do next. The program stays paused until it returns.
+	OnStop func(stop *Stop) Action
+
+	mu        sync.Mutex
+	lines     map[string]map[int]bool
+	functions map[string]bool
+	pause     bool
+
+	action Action
+	depth  int
+
+	// frames are the environment and position of the last statement run
+	// at each depth of the call stack
+	frames []Frame
+
+	// last is the position of the last statement run, so a line with
+	// more than one statement on it only stops the program once
+	last    token.Position
+	started bool
+
+	evaluating bool
+}
+
+// New makes a debugger which calls onStop each time the program is paused.
+// If stopOnEntry is true, the program is paused before its first statement.
+func New(stopOnEntry bool, onStop func(stop *Stop) Action) *Debugger {
+	d := &Debugger{
+		OnStop:    onStop,
+		lines:     make(map[string]map[int]bool),
+		functions: make(map[string]bool),
+	}
+
+	if stopOnEntry {
+		d.action = StepIn
+	}
+
+	return d
+}
+
+// SetLineBreakpoints replaces the breakpoints in a file with breakpoints on
+// each of the given lines.
+func (d *Debugger) SetLineBreakpoints(file string, lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lines[file] = make(map[int]bool)
+	for _, line := range lines {
+		d.lines[file][line] = true
+	}
+}
+
+// AddLineBreakpoint adds a breakpoint on a line of a file.
+func (d *Debugger) AddLineBreakpoint(file string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.lines[file] == nil {
+		d.lines[file] = make(map[int]bool)
+	}
+
+	d.lines[file][line] = true
+}
+
+// RemoveLineBreakpoint removes a breakpoint from a line of a file,
+// returning false if there wasn't one there.
+func (d *Debugger) RemoveLineBreakpoint(file string, line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.lines[file][line] {
+		return false
+	}
+
+	delete(d.lines[file], line)
+	return true
+}
+
+// SetFunctionBreakpoints replaces the function breakpoints, which stop the
+// program when a function with one of the names is called. A method can
+// be given by its own name, or qualified by its model's, like
+// "vector._plus".
+func (d *Debugger) SetFunctionBreakpoints(names []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.functions = make(map[string]bool)
+	for _, name := range names {
+		d.functions[name] = true
+	}
+}
+
+// AddFunctionBreakpoint adds a function breakpoint.
+func (d *Debugger) AddFunctionBreakpoint(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.functions[name] = true
+}
+
+// RemoveFunctionBreakpoint removes a function breakpoint, returning false
+// if there wasn't one.
+func (d *Debugger) RemoveFunctionBreakpoint(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.functions[name] {
+		return false
+	}
+
+	delete(d.functions, name)
+	return true
+}
+
+// Breakpoints describes every breakpoint, in order.
+func (d *Debugger) Breakpoints() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	points := []string{}
+
+	for file, lines := range d.lines {
+		for line := range lines {
+			points = append(points, fmt.Sprintf("%s:%d", file, line))
+		}
+	}
+
+	for name := range d.functions {
+		points = append(points, name)
+	}
+
+	sort.Strings(points)
+
+	return points
+}
+
+// Pause stops the program before its next statement. It can be called
+// while the program is running in another goroutine.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pause = true
+}
+
+// Statement is called by the evaluator before each statement, and pauses
+// the program if it should stop there.
+func (d *Debugger) Statement(stmt ast.Statement, env *object.Environment) {
+	if d.evaluating {
+		return
+	}
+
+	rt := env.Runtime()
+	depth := len(rt.Stack)
+	pos := stmt.Pos()
+
+	entered := depth >= len(d.frames)
+	d.record(depth, pos, env)
+
+	newLine := pos.File != d.last.File || pos.Line != d.last.Line || entered
+	d.last = pos
+
+	reason := d.reason(rt, depth, pos, entered, newLine)
+	if reason == "" {
+		return
+	}
+
+	stop := &Stop{Reason: reason, Stmt: stmt, Env: env, Frames: d.stack(rt)}
+
+	d.started = true
+	d.action = d.OnStop(stop)
+	d.depth = depth
+}
+
+// record remembers the position and environment of the statement being run
+// at a depth of the call stack.
+func (d *Debugger) record(depth int, pos token.Position, env *object.Environment) {
+	for len(d.frames) <= depth {
+		d.frames = append(d.frames, Frame{})
+	}
+
+	d.frames = d.frames[:depth+1]
+	d.frames[depth].Pos = pos
+	d.frames[depth].Env = env
+}
+
+// reason returns why the program should stop before a statement, or an
+// empty string if it shouldn't.
+func (d *Debugger) reason(rt *object.Runtime, depth int, pos token.Position, entered, newLine bool) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case d.pause:
+		d.pause = false
+		return "pause"
+	case newLine && d.lines[pos.File][pos.Line]:
+		return "breakpoint"
+	case entered && depth > 0 && d.isFunctionBreakpoint(rt.Stack[depth-1].Name):
+		return "function breakpoint"
+	}
+
+	switch d.action {
+	case StepIn:
+		if !d.started {
+			return "entry"
+		}
+
+		return "step"
+	case StepOver:
+		if depth <= d.depth {
+			return "step"
+		}
+	case StepOut:
+		if depth < d.depth {
+			return "step"
+		}
+	}
+
+	return ""
+}
+
+func (d *Debugger) isFunctionBreakpoint(name string) bool {
+	if d.functions[name] {
+		return true
+	}
+
+	// methods are named after their model too
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return d.functions[name[i+1:]]
+	}
+
+	return false
+}
+
+// stack returns the frames of the call stack, innermost first.
+func (d *Debugger) stack(rt *object.Runtime) []Frame {
+	frames := []Frame{}
+
+	for depth := len(d.frames) - 1; depth >= 0; depth-- {
+		frame := d.frames[depth]
+		frame.Name = "<main>"
+		if depth > 0 && depth-1 < len(rt.Stack) {
+			frame.Name = rt.Stack[depth-1].Name
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+// Evaluate evaluates an expression in an environment of the paused program,
+// without stopping at any breakpoints it reaches.
+func (d *Debugger) Evaluate(code string, env *object.Environment) object.Object {
+	code = strings.TrimSpace(code)
+	if !strings.HasSuffix(code, ";") {
+		code += ";"
+	}
+
+	l := lexer.NewFile("<eval>", code)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return &object.Error{Message: p.ErrorList()[0].Message, Kind: object.RUNTIME_ERROR}
+	}
+
+	d.evaluating = true
+	defer func() { d.evaluating = false }()
+
+	rt := env.Runtime()
+	stack, pos := rt.Stack, rt.Pos
+	defer func() { rt.Stack, rt.Pos = stack, pos }()
+
+	return evaluator.Eval(program, env)
+}
+
+// Scope is an environment in the chain of environments a statement can
+// see.
+type Scope struct {
+	Name     string
+	Bindings []Binding
+}
+
+// Binding is a name declared in a scope.
+type Binding struct {
+	Name  string
+	Value object.Object
+}
+
+// Scopes returns the environments an environment can see, innermost first,
+// with their bindings sorted by name. The innermost is called "locals" and
+// the outermost "globals", and any others are "closure".
+func Scopes(env *object.Environment) []Scope {
+	scopes := []Scope{}
+
+	for e := env; e != nil; e = e.Outer() {
+		name := "closure"
+		switch {
+		case e.Outer() == nil:
+			name = "globals"
+		case e == env:
+			name = "locals"
+		}
+
+		bindings := []Binding{}
+		for n, value := range e.Bindings() {
+			bindings = append(bindings, Binding{Name: n, Value: value})
+		}
+
+		sort.Slice(bindings, func(i, j int) bool {
+			return bindings[i].Name < bindings[j].Name
+		})
+
+		scopes = append(scopes, Scope{Name: name, Bindings: bindings})
+	}
+
+	return scopes
+}
@@ -0,0 +1,390 @@
+package debugger
+
+import (
+	"../lexer"
+	"../object"
+	"../parser"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const source = `point := model (x, y);
+
+point.len = fn () {
+  sq := this.x * this.x + this.y * this.y;
+  return sq;
+};
+
+f := fn (n) {
+  a := n + 1;
+  return a * 2;
+};
+
+p := point(3, 4);
+print(p.len());
+print(f(1));
+`
+
+// debug runs the source in the command line debugger, with the commands
+// as its standard input, and returns what it printed.
+func debug(t *testing.T, commands ...string) string {
+	p := parser.New(lexer.NewFile("test.lang", source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatal(p.ErrorList()[0])
+	}
+
+	var out bytes.Buffer
+
+	env := object.NewEnvironment()
+	rt := env.Runtime()
+	rt.Sources["test.lang"] = source
+	rt.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	rt.Stdout = &out
+
+	if err, ok := Run(program, env, "test.lang", &out).(*object.Error); ok {
+		t.Fatalf("unexpected error: %s", err.Inspect())
+	}
+
+	return out.String()
+}
+
+func TestCLI(t *testing.T) {
+	tests := []struct {
+		commands []string
+		contains []string
+	}{
+		{
+			[]string{"c"},
+			[]string{"stopped at test.lang:1:1 in <main> (entry)", ">    1 | point := model (x, y);", "25 \n4 \n"},
+		},
+		{
+			[]string{"b 9", "bl", "c", "p n + 100", "n", "p a", "c"},
+			[]string{"  test.lang:9\n", "stopped at test.lang:9:3 in f (breakpoint)", "101\n", "stopped at test.lang:10:3 in f (step)", "2\n"},
+		},
+		{
+			[]string{"b point.len", "c", "env", "bt", "c"},
+			[]string{
+				"stopped at test.lang:4:3 in point.len (function breakpoint)",
+				"locals:\n  this = {x: 3, y: 4}\nglobals:\n",
+				"  #0 point.len at test.lang:4:3\n  #1 <main> at test.lang:14:1\n",
+			},
+		},
+		{
+			[]string{"b 14", "c", "s", "s", "out", "n", "c"},
+			[]string{
+				"stopped at test.lang:4:3 in point.len (step)",
+				"stopped at test.lang:5:3 in point.len (step)",
+				"25 \nstopped at test.lang:15:1 in <main> (step)",
+			},
+		},
+		{
+			[]string{"b len", "d len", "d 3", "c"},
+			[]string{"no breakpoint at 3"},
+		},
+		{
+			[]string{"p missing", "p 1 +", "wat", "q"},
+			[]string{"name error: identifier not found: missing", "runtime error: no prefix parse function", "unknown command wat"},
+		},
+	}
+
+	for i, tt := range tests {
+		out := debug(t, tt.commands...)
+
+		for _, s := range tt.contains {
+			if !strings.Contains(out, s) {
+				t.Errorf("test %d: expected output to contain %q, got:\n%s", i, s, out)
+			}
+		}
+	}
+}
+
+func TestQuit(t *testing.T) {
+	out := debug(t, "q")
+
+	if strings.Contains(out, "25") {
+		t.Errorf("expected quitting to stop the program, got:\n%s", out)
+	}
+}
+
+// dapClient talks to a Debug Adapter Protocol server running in another
+// goroutine.
+type dapClient struct {
+	t      *testing.T
+	w      io.WriteCloser
+	r      *bufio.Reader
+	seq    int
+	events []dapMessage
+}
+
+type dapMessage struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func (c *dapClient) read() dapMessage {
+	content, err := readDAPMessage(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var msg dapMessage
+	if err := json.Unmarshal(content, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return msg
+}
+
+// request sends a request and waits for its response, decoding the body
+// into v if it's given.
+func (c *dapClient) request(command string, args interface{}, v interface{}) dapMessage {
+	c.seq++
+
+	content, _ := json.Marshal(map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": args,
+	})
+
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(content), content)
+
+	for {
+		msg := c.read()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("expected a response to %d, got %+v", c.seq, msg)
+		}
+
+		if v != nil {
+			if !msg.Success {
+				c.t.Fatalf("%s failed: %s", command, msg.Message)
+			}
+
+			if err := json.Unmarshal(msg.Body, v); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+
+		return msg
+	}
+}
+
+// wait waits for an event, returning its body.
+func (c *dapClient) wait(event string) json.RawMessage {
+	for i, msg := range c.events {
+		if msg.Event == event {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return msg.Body
+		}
+	}
+
+	for {
+		msg := c.read()
+		if msg.Event == event {
+			return msg.Body
+		}
+
+		c.events = append(c.events, msg)
+	}
+}
+
+func (c *dapClient) stopped(reason string, line int) {
+	var stopped struct{ Reason string }
+	json.Unmarshal(c.wait("stopped"), &stopped)
+
+	if stopped.Reason != reason {
+		c.t.Errorf("expected to stop for %s, got %s", reason, stopped.Reason)
+	}
+
+	var trace struct {
+		StackFrames []struct {
+			Name string
+			Line int
+		}
+	}
+
+	c.request("stackTrace", map[string]int{"threadId": threadID}, &trace)
+
+	if len(trace.StackFrames) == 0 || trace.StackFrames[0].Line != line {
+		c.t.Errorf("expected to stop on line %d, got %+v", line, trace.StackFrames)
+	}
+}
+
+type dapVariables struct {
+	Variables []dapVariable
+}
+
+func (c *dapClient) variables(ref int) map[string]dapVariable {
+	var vars dapVariables
+	c.request("variables", map[string]int{"variablesReference": ref}, &vars)
+
+	m := make(map[string]dapVariable)
+	for _, v := range vars.Variables {
+		m[v.Name] = v
+	}
+
+	return m
+}
+
+func TestDAP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debugger")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "test.lang")
+	if err := ioutil.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	done := make(chan error)
+	go func() {
+		done <- ServeDAP(inR, outW)
+		outW.Close()
+	}()
+
+	c := &dapClient{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	var caps map[string]bool
+	c.request("initialize", map[string]string{"adapterID": "lang"}, &caps)
+	if !caps["supportsFunctionBreakpoints"] {
+		t.Errorf("expected function breakpoints to be supported, got %v", caps)
+	}
+
+	c.wait("initialized")
+
+	if msg := c.request("stackTrace", map[string]int{"threadId": threadID}, nil); msg.Success {
+		t.Errorf("expected a stack trace to fail before the program is paused")
+	}
+
+	c.request("launch", map[string]interface{}{"program": file}, nil)
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": file},
+		"breakpoints": []map[string]int{{"line": 9}},
+	}, nil)
+	c.request("setFunctionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]string{{"name": "len"}},
+	}, nil)
+	c.request("configurationDone", nil, nil)
+
+	c.stopped("function breakpoint", 4)
+
+	var scopes struct {
+		Scopes []struct {
+			Name               string
+			VariablesReference int
+		}
+	}
+
+	c.request("scopes", map[string]int{"frameId": 1}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "locals" {
+		t.Fatalf("wrong scopes: %+v", scopes.Scopes)
+	}
+
+	this, ok := c.variables(scopes.Scopes[0].VariablesReference)["this"]
+	if !ok || this.VariablesReference == 0 {
+		t.Fatalf("expected this to be in the locals and expandable, got %+v", this)
+	}
+
+	if x := c.variables(this.VariablesReference)["x"]; x.Value != "3" {
+		t.Errorf("expected this.x to be 3, got %+v", x)
+	}
+
+	var result struct{ Result string }
+	c.request("evaluate", map[string]interface{}{"expression": "this.x + this.y", "frameId": 1}, &result)
+	if result.Result != "7" {
+		t.Errorf("expected this.x + this.y to be 7, got %s", result.Result)
+	}
+
+	c.request("next", map[string]int{"threadId": threadID}, nil)
+	c.stopped("step", 5)
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	c.stopped("breakpoint", 9)
+
+	c.request("stepOut", map[string]int{"threadId": threadID}, nil)
+	c.wait("terminated")
+
+	output := ""
+	for _, msg := range c.events {
+		var body struct{ Output string }
+		if msg.Event == "output" {
+			json.Unmarshal(msg.Body, &body)
+			output += body.Output
+		}
+	}
+
+	if output != "25 \n4 \n" {
+		t.Errorf("expected the program's output to be sent, got %q", output)
+	}
+
+	c.request("disconnect", nil, nil)
+	inW.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+}
+
+func TestDAPDisconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debugger")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "loop.lang")
+	if err := ioutil.WriteFile(file, []byte("while true { x := 1; };\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	done := make(chan error)
+	go func() {
+		done <- ServeDAP(inR, outW)
+		outW.Close()
+	}()
+
+	c := &dapClient{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	c.request("initialize", map[string]string{"adapterID": "lang"}, nil)
+	c.wait("initialized")
+	c.request("launch", map[string]interface{}{"program": file, "stopOnEntry": true}, nil)
+	c.request("configurationDone", nil, nil)
+	c.stopped("entry", 1)
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	c.request("pause", map[string]int{"threadId": threadID}, nil)
+	c.stopped("pause", 1)
+
+	// disconnecting stops the program, even though it never finishes
+	c.request("disconnect", nil, nil)
+	inW.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+}
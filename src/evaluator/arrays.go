@@ -0,0 +1,371 @@
+package evaluator
+
+import (
+	"../ast"
+	"../object"
+	"../token"
+	"sort"
+	"strings"
+)
+
+// The methods of the array model are defined here, rather than with the
+// other builtin models, since the ones like map call functions.
+
+func initialiseArrayModel() bool {
+	object.ARRAY_MODEL.Methods = builtinMethods(map[string]*object.Builtin{
+		"_new": &object.Builtin{
+			Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+				hash, ok := this.(*object.Hash)
+				if !ok {
+					return newKindError(object.TYPE_ERROR, "array._new must be called on a new array")
+				}
+
+				value := hash.Get("value")
+
+				elements, ok := sequenceElements(value)
+				if !ok {
+					return newKindError(object.TYPE_ERROR, "cannot make an array of a %s", value.Type())
+				}
+
+				return &object.Array{Elements: append([]object.Object{}, elements...)}
+			},
+		},
+		"len": arrayMethod("len", 0, 0, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			return &object.Integer{Value: int64(len(arr.Elements))}
+		}),
+		"push": arrayMethod("push", 1, -1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			if err := env.Runtime().CheckAllocation(len(arr.Elements) + len(args)); err != nil {
+				return err
+			}
+
+			arr.Elements = append(arr.Elements, args...)
+			return arr
+		}),
+		"pop": arrayMethod("pop", 0, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			if len(arr.Elements) == 0 {
+				return newKindError(object.INDEX_ERROR, "cannot pop from an empty array")
+			}
+
+			i := len(arr.Elements) - 1
+			if len(args) == 1 {
+				var err *object.Error
+				if i, err = resolveIndex(args[0], len(arr.Elements)); err != nil {
+					return err
+				}
+			}
+
+			elem := arr.Elements[i]
+			arr.Elements = append(arr.Elements[:i], arr.Elements[i+1:]...)
+
+			return elem
+		}),
+		"insert": arrayMethod("insert", 2, 2, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			// the index can also be the length, to insert at the end
+			i := len(arr.Elements)
+			if n, ok := args[0].(*object.Integer); !ok || n.Value != int64(i) {
+				var err *object.Error
+				if i, err = resolveIndex(args[0], len(arr.Elements)); err != nil {
+					return err
+				}
+			}
+
+			if err := env.Runtime().CheckAllocation(len(arr.Elements) + 1); err != nil {
+				return err
+			}
+
+			arr.Elements = append(arr.Elements, nil)
+			copy(arr.Elements[i+1:], arr.Elements[i:])
+			arr.Elements[i] = args[1]
+
+			return arr
+		}),
+		"remove": arrayMethod("remove", 1, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			i := indexOf(arr.Elements, args[0])
+			if i < 0 {
+				return newKindError(object.INDEX_ERROR, "%s is not in the array", args[0].Inspect())
+			}
+
+			arr.Elements = append(arr.Elements[:i], arr.Elements[i+1:]...)
+			return arr
+		}),
+		"index_of": arrayMethod("index_of", 1, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			return &object.Integer{Value: int64(indexOf(arr.Elements, args[0]))}
+		}),
+		"reverse": arrayMethod("reverse", 0, 0, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			n := len(arr.Elements)
+
+			result := make([]object.Object, n)
+			for i, elem := range arr.Elements {
+				result[n-1-i] = elem
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"sort": arrayMethod("sort", 0, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			result := append([]object.Object{}, arr.Elements...)
+
+			var err object.Object
+			sort.SliceStable(result, func(i, j int) bool {
+				if err != nil {
+					return false
+				}
+
+				var less object.Object
+				switch {
+				case len(args) == 1:
+					less = applyFunction(args[0], []object.Object{result[i], result[j]}, env)
+				case isText(result[i]) && isText(result[j]):
+					// strings don't have '<', but they can still be sorted
+					return result[i].Inspect() < result[j].Inspect()
+				default:
+					less = evalInfixExpression("<", result[i], result[j], env)
+				}
+
+				if isError(less) {
+					err = less
+					return false
+				}
+
+				b, ok := less.(*object.Boolean)
+				if !ok {
+					err = newKindError(object.TYPE_ERROR, "expected the function given to array.sort to return a boolean. got %s",
+						less.Type())
+					return false
+				}
+
+				return b.Value
+			})
+
+			if err != nil {
+				return err
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"map": arrayMethod("map", 1, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			result := make([]object.Object, len(arr.Elements))
+
+			for i, elem := range arr.Elements {
+				result[i] = applyFunction(args[0], []object.Object{elem}, env)
+				if isError(result[i]) {
+					return result[i]
+				}
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"filter": arrayMethod("filter", 1, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			result := []object.Object{}
+
+			for _, elem := range arr.Elements {
+				keep := applyFunction(args[0], []object.Object{elem}, env)
+				if isError(keep) {
+					return keep
+				}
+
+				if isTruthy(keep) {
+					result = append(result, elem)
+				}
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"reduce": arrayMethod("reduce", 1, 2, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			elements := arr.Elements
+
+			var acc object.Object
+			if len(args) == 2 {
+				acc = args[1]
+			} else if len(elements) > 0 {
+				acc, elements = elements[0], elements[1:]
+			} else {
+				return newKindError(object.ARGUMENT_ERROR, "cannot reduce an empty array without an initial value")
+			}
+
+			for _, elem := range elements {
+				acc = applyFunction(args[0], []object.Object{acc, elem}, env)
+				if isError(acc) {
+					return acc
+				}
+			}
+
+			return acc
+		}),
+		"any": arrayMethod("any", 0, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			return testElements(arr, args, true, env)
+		}),
+		"all": arrayMethod("all", 0, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			return testElements(arr, args, false, env)
+		}),
+		"zip": arrayMethod("zip", 1, -1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			sequences := [][]object.Object{arr.Elements}
+			length := len(arr.Elements)
+
+			for i, arg := range args {
+				elements, ok := sequenceElements(arg)
+				if !ok {
+					return newKindError(object.TYPE_ERROR, "expected argument %d of array.zip to be an array. got %s",
+						i+1, arg.Type())
+				}
+
+				sequences = append(sequences, elements)
+				if len(elements) < length {
+					length = len(elements)
+				}
+			}
+
+			result := make([]object.Object, length)
+			for i := range result {
+				row := make([]object.Object, len(sequences))
+				for j, elements := range sequences {
+					row[j] = elements[i]
+				}
+
+				result[i] = &object.Array{Elements: row}
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"enumerate": arrayMethod("enumerate", 0, 0, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			result := make([]object.Object, len(arr.Elements))
+			for i, elem := range arr.Elements {
+				result[i] = &object.Array{Elements: []object.Object{&object.Integer{Value: int64(i)}, elem}}
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"flatten": arrayMethod("flatten", 0, 0, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			result := []object.Object{}
+
+			for _, elem := range arr.Elements {
+				if inner, ok := elem.(*object.Array); ok {
+					result = append(result, inner.Elements...)
+				} else {
+					result = append(result, elem)
+				}
+
+				if err := env.Runtime().CheckAllocation(len(result)); err != nil {
+					return err
+				}
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"unique": arrayMethod("unique", 0, 0, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			result := []object.Object{}
+
+			for _, elem := range arr.Elements {
+				if indexOf(result, elem) < 0 {
+					result = append(result, elem)
+				}
+			}
+
+			return &object.Array{Elements: result}
+		}),
+		"join": arrayMethod("join", 0, 1, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			sep := ""
+			if len(args) == 1 {
+				s, ok := args[0].(*object.String)
+				if !ok {
+					return newKindError(object.TYPE_ERROR, "expected argument 1 of array.join to be a string. got %s",
+						args[0].Type())
+				}
+
+				sep = s.Value
+			}
+
+			parts := make([]string, len(arr.Elements))
+			size := len(sep) * len(parts)
+			for i, elem := range arr.Elements {
+				parts[i] = elem.Inspect()
+				size += len(parts[i])
+			}
+
+			if err := env.Runtime().CheckAllocation(size); err != nil {
+				return err
+			}
+
+			return &object.String{Value: strings.Join(parts, sep)}
+		}),
+		"sum": arrayMethod("sum", 0, 0, func(env *object.Environment, arr *object.Array, args []object.Object) object.Object {
+			var total object.Object = &object.Integer{Value: 0}
+
+			for _, elem := range arr.Elements {
+				total = evalInfixExpression("+", total, elem, env)
+				if isError(total) {
+					return total
+				}
+			}
+
+			return total
+		}),
+	})
+
+	return true
+}
+
+// arrayMethod makes a method of the array model, which checks that it's
+// called on an array, with between min and max arguments. A max of -1 means
+// any number.
+func arrayMethod(
+	name string,
+	min, max int,
+	fn func(env *object.Environment, arr *object.Array, args []object.Object) object.Object,
+) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			arr, ok := this.(*object.Array)
+			if !ok {
+				return newKindError(object.TYPE_ERROR, "array.%s must be called on an array", name)
+			}
+
+			if err := object.CheckArgs("array."+name, min, max, len(args)); err != nil {
+				return err
+			}
+
+			return fn(env, arr, args)
+		},
+	}
+}
+
+func builtinMethods(methods map[string]*object.Builtin) map[*ast.Identifier]object.Object {
+	result := map[*ast.Identifier]object.Object{}
+	for name, method := range methods {
+		result[&ast.Identifier{Token: token.New(token.ID, name), Value: name}] = method
+	}
+
+	return result
+}
+
+// testElements implements any and all, which test each element with a
+// function if they're given one, and otherwise check whether it's truthy.
+// It stops at the first element whose result is stop.
+func testElements(arr *object.Array, args []object.Object, stop bool, env *object.Environment) object.Object {
+	for _, elem := range arr.Elements {
+		result := elem
+		if len(args) == 1 {
+			result = applyFunction(args[0], []object.Object{elem}, env)
+			if isError(result) {
+				return result
+			}
+		}
+
+		if isTruthy(result) == stop {
+			return nativeBoolToBooleanObject(stop)
+		}
+	}
+
+	return nativeBoolToBooleanObject(!stop)
+}
+
+func indexOf(elements []object.Object, value object.Object) int {
+	for i, elem := range elements {
+		if elem.Equals(value) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+var _ = initialiseArrayModel()
@@ -2,37 +2,59 @@ package evaluator
 
 import (
 	"../object"
-	"bufio"
 	"fmt"
-	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 var builtins = map[string]*object.Builtin{
 	"print": &object.Builtin{
-		Fn: func(this object.Object, args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			out := env.Runtime().Stdout
+
 			for _, arg := range args {
-				fmt.Print(arg.Inspect() + " ")
+				fmt.Fprint(out, arg.Inspect()+" ")
 			}
 
-			fmt.Println()
+			fmt.Fprintln(out)
+
+			return NULL
+		},
+	},
+	"eprint": &object.Builtin{
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			out := env.Runtime().Stderr
+
+			for _, arg := range args {
+				fmt.Fprint(out, arg.Inspect()+" ")
+			}
+
+			fmt.Fprintln(out)
 
 			return NULL
 		},
 	},
 	"err": &object.Builtin{
-		Fn: func(this object.Object, args ...object.Object) object.Object {
-			msg := ""
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			// rethrow a caught error, keeping its kind
+			if len(args) == 1 {
+				if hash, ok := args[0].(*object.Hash); ok && hash.Model == object.ERROR_MODEL {
+					return newKindError(hash.Get("kind").Inspect(), "%s", hash.Get("message").Inspect())
+				}
+			}
+
+			msg := []string{}
 
 			for _, arg := range args {
-				msg += arg.Inspect() + " "
+				msg = append(msg, arg.Inspect())
 			}
 
-			return newError(msg)
+			return newKindError(object.USER_ERROR, "%s", strings.Join(msg, " "))
 		},
 	},
 	"str": &object.Builtin{
-		Fn: func(this object.Object, args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("expected exactly one argument to 'str'")
 			}
@@ -40,55 +62,101 @@ var builtins = map[string]*object.Builtin{
 			return &object.String{Value: args[0].Inspect()}
 		},
 	},
+	"len": &object.Builtin{
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("expected exactly one argument to 'len'")
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Array:
+				return &object.Integer{Value: int64(len(arg.Elements))}
+			case *object.String:
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			case *object.Hash:
+				return &object.Integer{Value: int64(arg.Len())}
+			default:
+				return newError("expected an array, string or hash to be passed to 'len'")
+			}
+		},
+	},
+	"ord": &object.Builtin{
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("expected exactly one argument to 'ord'")
+			}
+
+			ch, ok := args[0].(*object.Char)
+			if !ok {
+				return newError("expected a character to be passed to 'ord'")
+			}
+
+			return &object.Integer{Value: int64(ch.Value)}
+		},
+	},
+	"chr": &object.Builtin{
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("expected exactly one argument to 'chr'")
+			}
+
+			code, ok := args[0].(*object.Integer)
+			if !ok {
+				return newError("expected an integer to be passed to 'chr'")
+			}
+
+			if code.Value < 0 || code.Value > utf8.MaxRune || !utf8.ValidRune(rune(code.Value)) {
+				return newKindError(object.ARGUMENT_ERROR, "invalid character code %d", code.Value)
+			}
+
+			return &object.Char{Value: rune(code.Value)}
+		},
+	},
 	"input": &object.Builtin{
-		Fn: func(this object.Object, args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("expected exactly one argument to 'input'")
 			}
 
 			arg := args[0]
 
-			reader := bufio.NewReader(os.Stdin)
-			fmt.Print(arg.Inspect())
+			rt := env.Runtime()
+			fmt.Fprint(rt.Stdout, arg.Inspect())
 
-			text, err := reader.ReadString('\n')
+			text, err := rt.ReadLine()
 			if err != nil {
 				return newError("could not read a line")
 			}
 
-			return &object.String{Value: text[0 : len(text)-1]}
+			return &object.String{Value: text}
 		},
 	},
 	"type": &object.Builtin{
-		Fn: func(this object.Object, args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("expected exactly one argument to 'type'")
 			}
 
-			arg := args[0]
-
-			hash, ok := arg.(*object.Hash)
-			if !ok {
-				return newError("expected a hash to be passed to 'type'")
+			model := object.ModelOf(args[0])
+			if model == nil {
+				return newError("expected a hash, a string or an array to be passed to 'type'")
 			}
 
-			return hash.Model
+			return model
 		},
 	},
 	"parent": &object.Builtin{
-		Fn: func(this object.Object, args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("expected exactly one argument to 'type'")
 			}
 
-			arg := args[0]
-
-			hash, ok := arg.(*object.Hash)
-			if !ok {
-				return newError("expected a hash to be passed to 'type'")
+			model := object.ModelOf(args[0])
+			if model == nil {
+				return newError("expected a hash, a string or an array to be passed to 'parent'")
 			}
 
-			parent := hash.Model.Parent
+			parent := model.Parent
 			if parent == nil {
 				return NULL
 			}
@@ -97,19 +165,24 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"sleep": &object.Builtin{
-		Fn: func(this object.Object, args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("expected exactly one argument to 'sleep'")
 			}
 
 			arg := args[0]
 
-			seconds, ok := arg.(*object.Number)
+			seconds, ok := object.ToFloat(arg)
 			if !ok {
 				return newError("expected a number to be passed to 'sleep'")
 			}
 
-			time.Sleep(time.Duration(seconds.Value) * time.Second)
+			// a cancelled context cuts the sleep short, and stops the
+			// program at its next step
+			select {
+			case <-time.After(time.Duration(seconds * float64(time.Second))):
+			case <-env.Runtime().Context().Done():
+			}
 
 			return NULL
 		},
@@ -0,0 +1,159 @@
+package evaluator
+
+import (
+	"../ast"
+	"../object"
+	"fmt"
+)
+
+// destructure binds the names in a pattern to the parts of a value,
+// declaring them if declare is true and assigning to them otherwise. It
+// returns an error, or nil if everything was bound.
+func destructure(pattern ast.Expression, value object.Object, declare bool, env *object.Environment) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		elements, err := unpackArray(value, len(pattern.Elements), pattern.Required(), pattern.Rest != nil)
+		if err != nil {
+			return err
+		}
+
+		for i, elem := range pattern.Elements {
+			if err := destructure(elem, elements[i], declare, env); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			return destructure(pattern.Rest, elements[len(elements)-1], declare, env)
+		}
+
+		return nil
+	case *ast.HashPattern:
+		keys := make([]string, len(pattern.Fields))
+		for i, field := range pattern.Fields {
+			keys[i] = field.Name()
+		}
+
+		values, err := unpackHash(value, keys, pattern.Rest != nil)
+		if err != nil {
+			return err
+		}
+
+		for i, field := range pattern.Fields {
+			if err := destructure(field.Value, values[i], declare, env); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			return destructure(pattern.Rest, values[len(values)-1], declare, env)
+		}
+
+		return nil
+	case *ast.DefaultPattern:
+		if value.Type() == object.NULL_OBJ {
+			value = Eval(pattern.Default, env)
+			if isError(value) {
+				return value
+			}
+		}
+
+		return destructure(pattern.Target, value, declare, env)
+	}
+
+	var result object.Object
+	if declare {
+		result = evalDeclareExpression(pattern, value, env)
+	} else {
+		result = evalAssignExpression(pattern, value, env)
+	}
+
+	if isError(result) {
+		return result
+	}
+
+	return nil
+}
+
+// unpackArray returns the elements of an array, or the characters of a
+// string, to be bound to a pattern with the given number of elements, the
+// first required of which have to be there. Missing elements are null, and
+// if rest is true, the remaining elements are added to the end, as an array
+// or a string.
+func unpackArray(value object.Object, count, required int, rest bool) ([]object.Object, *object.Error) {
+	all, ok := sequenceElements(value)
+	if !ok {
+		return nil, newKindError(object.TYPE_ERROR, "cannot destructure %s as an array", value.Type())
+	}
+
+	length := len(all)
+	if length < required || (!rest && length > count) {
+		expected, last := fmt.Sprint(count), count
+		if rest {
+			expected, last = fmt.Sprintf("at least %d", required), required
+		} else if required < count {
+			expected = fmt.Sprintf("%d to %d", required, count)
+		}
+
+		noun := "elements"
+		if last == 1 {
+			noun = "element"
+		}
+
+		return nil, newKindError(object.INDEX_ERROR, "cannot destructure an array of length %d. expected %s %s",
+			length, expected, noun)
+	}
+
+	elements := make([]object.Object, count)
+	for i := range elements {
+		if i < length {
+			elements[i] = all[i]
+		} else {
+			elements[i] = NULL
+		}
+	}
+
+	if rest {
+		remaining := []object.Object{}
+		if length > count {
+			remaining = append(remaining, all[count:]...)
+		}
+
+		elements = append(elements, newSequence(value, remaining))
+	}
+
+	return elements, nil
+}
+
+// unpackHash returns the values of the given keys in a hash, which are null
+// if they're missing. If rest is true, a hash of the other pairs is added
+// to the end.
+func unpackHash(value object.Object, keys []string, rest bool) ([]object.Object, *object.Error) {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return nil, newKindError(object.TYPE_ERROR, "cannot destructure %s as a hash", value.Type())
+	}
+
+	values := make([]object.Object, len(keys))
+	for i, key := range keys {
+		values[i] = hash.Get(key)
+	}
+
+	if rest {
+		named := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			named[key] = true
+		}
+
+		remaining := object.NewHash(object.OBJECT_MODEL)
+		for _, pair := range hash.Pairs() {
+			if key, ok := pair.Key.(*object.String); !ok || !named[key.Value] {
+				remaining.Insert(pair.Key, pair.Value)
+			}
+		}
+
+		values = append(values, remaining)
+	}
+
+	return values, nil
+}
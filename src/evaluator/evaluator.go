@@ -3,6 +3,7 @@ package evaluator
 import (
 	"../ast"
 	"../object"
+	"../token"
 	"fmt"
 	"math"
 	"strings"
@@ -15,6 +16,39 @@ var (
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	rt := env.Runtime()
+
+	outerPos := rt.Pos
+	rt.Pos = node.Pos()
+
+	var result object.Object
+	if err := rt.Step(); err != nil {
+		result = err
+	} else {
+		// blocks are only made of other statements, so they aren't
+		// stopped at themselves
+		if stmt, ok := node.(ast.Statement); ok && rt.Debugger != nil {
+			if _, block := node.(*ast.BlockStatement); !block {
+				rt.Debugger.Statement(stmt, env)
+			}
+		}
+
+		result = eval(node, env)
+	}
+
+	// errors are given the position of the innermost node they came from,
+	// along with the call stack at that point
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = rt.Pos
+		err.Trace = rt.Trace()
+	}
+
+	rt.Pos = outerPos
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -29,13 +63,19 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.BreakStatement:
-		return &object.LoopControlStatement{Literal: "break"}
+		return evalLoopControl("break", env)
 	case *ast.NextStatement:
-		return &object.LoopControlStatement{Literal: "next"}
+		return evalLoopControl("next", env)
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}
 	case *ast.NumberLiteral:
 		return &object.Number{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.CharLiteral:
+		return &object.Char{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.Null:
@@ -45,7 +85,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(node.Operator, right, env)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -70,6 +110,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 		return evalDeclareExpression(node.Name, right, env)
 	case *ast.AssignExpression:
+		if node.Operator != "" {
+			return evalCompoundAssignExpression(node, env)
+		}
+
 		right := Eval(node.Value, env)
 		if isError(right) {
 			return right
@@ -83,11 +127,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Patterns: node.Patterns, Env: env, Body: body}
 	case *ast.LambdaExpression:
 		params := node.Parameters
 		body := node.Body
-		return &object.Lambda{Parameters: params, Env: env, Body: &body}
+		return &object.Lambda{Parameters: params, Patterns: node.Patterns, Env: env, Body: &body}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -120,21 +164,31 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
 	case *ast.WhileExpression:
 		return evalWhileExpression(node, env)
 	case *ast.ForExpression:
 		return evalForExpression(node, env)
 	case *ast.ModelLiteral:
 		return evalModelLiteral(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+	case *ast.ImportExpression:
+		return evalImportExpression(node, env)
 	default:
 		return newError("evaluation for %T not yet implemented!", node)
 	}
-
-	return nil
 }
 
 func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return newKindError(object.RUNTIME_ERROR, format, a...)
+}
+
+func newKindError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: kind}
 }
 
 func isError(obj object.Object) bool {
@@ -176,10 +230,6 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		}
 	}
 
-	if _, ok := result.(*object.LoopControlStatement); ok {
-		return NULL
-	}
-
 	return result
 }
 
@@ -215,10 +265,10 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newKindError(object.NAME_ERROR, "identifier not found: %s", node.Value)
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func evalPrefixExpression(operator string, right object.Object, env *object.Environment) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
@@ -226,8 +276,10 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalMinusPrefixOperatorExpression(right)
 	case "+":
 		return evalMinusPrefixOperatorExpression(evalMinusPrefixOperatorExpression(right))
+	case "~":
+		return evalBitNotOperatorExpression(right, env)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -241,12 +293,31 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.NUMBER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			return newKindError(object.ARITHMETIC_ERROR, "integer overflow: -%d", right.Value)
+		}
+
+		return &object.Integer{Value: -right.Value}
+	case *object.Number:
+		return &object.Number{Value: -right.Value}
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 	}
+}
 
-	value := right.(*object.Number).Value
-	return &object.Number{Value: -value}
+func evalBitNotOperatorExpression(right object.Object, env *object.Environment) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.Number:
+		return newKindError(object.TYPE_ERROR, "expected an integer for '~'. got %s", right.Inspect())
+	case *object.Hash:
+		return evalHashPrefixExpression("~", right, env)
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: ~%s", right.Type())
+	}
 }
 
 func evalDeclareExpression(
@@ -256,15 +327,41 @@ func evalDeclareExpression(
 ) object.Object {
 	switch left := left.(type) {
 	case *ast.Identifier:
+		nameObject(right, left.Value)
 		return env.Declare(left.Value, right)
 	case *ast.IndexExpression:
 		return newError("cannot declare (:=) a hash field. try assigning (=)")
+	case *ast.ArrayPattern, *ast.HashPattern:
+		if err := destructure(left, right, true, env); err != nil {
+			return err
+		}
+
+		return right
 	default:
 		return newError("cannot declare %v. expected an id or index expression",
 			left.String())
 	}
 }
 
+// nameObject gives a name to an anonymous function, lambda or model when
+// it's first bound to an identifier, so it can be referred to in tracebacks.
+func nameObject(obj object.Object, name string) {
+	switch obj := obj.(type) {
+	case *object.Function:
+		if obj.Name == "" {
+			obj.Name = name
+		}
+	case *object.Lambda:
+		if obj.Name == "" {
+			obj.Name = name
+		}
+	case *object.Model:
+		if obj.Name == "" {
+			obj.Name = name
+		}
+	}
+}
+
 func evalAssignExpression(
 	left ast.Expression,
 	right object.Object,
@@ -275,13 +372,143 @@ func evalAssignExpression(
 		return env.Assign(left.Value, right)
 	case *ast.IndexExpression:
 		return evalAssignIndexExpression(left, right, env)
+	case *ast.SliceExpression:
+		obj := Eval(left.Left, env)
+		if isError(obj) {
+			return obj
+		}
+
+		bounds, err := evalSliceBounds(left, env)
+		if err != nil {
+			return err
+		}
+
+		return assignSlice(obj, bounds, right, env)
 	case *ast.InfixExpression:
 		return evalAssignInfixExpression(left, right, env)
+	case *ast.ArrayPattern, *ast.HashPattern:
+		if err := destructure(left, right, false, env); err != nil {
+			return err
+		}
+
+		return right
 	default:
 		return newError("not id")
 	}
 }
 
+// evalCompoundAssignExpression evaluates an assignment like "x += 1". The
+// target is evaluated before the value, and only once, so in
+// "a[f()] += 1" f is only called once.
+func evalCompoundAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch left := node.Name.(type) {
+	case *ast.Identifier:
+		current := evalIdentifier(left, env)
+		if isError(current) {
+			return current
+		}
+
+		right := Eval(node.Value, env)
+		if isError(right) {
+			return right
+		}
+
+		result := evalInfixExpression(node.Operator, current, right, env)
+		if isError(result) {
+			return result
+		}
+
+		return env.Assign(left.Value, result)
+	case *ast.IndexExpression:
+		obj := Eval(left.Left, env)
+		if isError(obj) {
+			return obj
+		}
+
+		elem := Eval(left.Index, env)
+		if isError(elem) {
+			return elem
+		}
+
+		right := Eval(node.Value, env)
+		if isError(right) {
+			return right
+		}
+
+		return updateIndex(obj, elem, node.Operator, right, env)
+	case *ast.SliceExpression:
+		obj := Eval(left.Left, env)
+		if isError(obj) {
+			return obj
+		}
+
+		bounds, err := evalSliceBounds(left, env)
+		if err != nil {
+			return err
+		}
+
+		right := Eval(node.Value, env)
+		if isError(right) {
+			return right
+		}
+
+		return updateSlice(obj, bounds, node.Operator, right, env)
+	case *ast.InfixExpression:
+		if left.Operator != "." {
+			return newError("cannot assign any infix operator other than '.'")
+		}
+
+		fieldId, ok := left.Right.(*ast.Identifier)
+		if !ok {
+			return newError("expected an identifier")
+		}
+
+		obj := Eval(left.Left, env)
+		if isError(obj) {
+			return obj
+		}
+
+		right := Eval(node.Value, env)
+		if isError(right) {
+			return right
+		}
+
+		return updateField(obj, fieldId.Value, node.Operator, right, env)
+	default:
+		return newError("cannot assign to %v", node.Name.String())
+	}
+}
+
+// updateIndex evaluates obj[elem] op= right.
+func updateIndex(obj, elem object.Object, operator string, right object.Object, env *object.Environment) object.Object {
+	current := evalIndexExpression(obj, elem)
+	if isError(current) {
+		return current
+	}
+
+	result := evalInfixExpression(operator, current, right, env)
+	if isError(result) {
+		return result
+	}
+
+	return assignIndex(obj, elem, result)
+}
+
+// updateField evaluates obj.name op= right.
+func updateField(obj object.Object, name, operator string, right object.Object, env *object.Environment) object.Object {
+	current := accessField(obj, name)
+	if isError(current) {
+		return current
+	}
+
+	result := evalInfixExpression(operator, current, right, env)
+	if isError(result) {
+		return result
+	}
+
+	return assignField(obj, name, result)
+}
+
 func evalAssignInfixExpression(
 	left *ast.InfixExpression,
 	right object.Object,
@@ -301,19 +528,36 @@ func evalAssignInfixExpression(
 		return newError("expected an identifier")
 	}
 
+	return assignField(obj, fieldId.Value, right)
+}
+
+func assignField(obj object.Object, name string, right object.Object) object.Object {
 	switch obj := obj.(type) {
 	case *object.Hash:
-		obj.Set(fieldId.Value, right)
-		return obj.Get(fieldId.Value)
+		obj.Set(name, right)
+		return obj.Get(name)
 	case *object.Model:
+		if obj.Builtin() {
+			return newKindError(object.TYPE_ERROR, "cannot change the builtin model %s", obj.Name)
+		}
+
 		fn := right
 		if fn.Type() != "FUNCTION" {
 			return newError("cannot assign a %v to a model field. expected a function",
 				right.Type())
 		}
 
-		obj.Methods[fieldId] = fn
-		return obj.Methods[fieldId]
+		// reuse the key of an existing method with the same name, so
+		// it's replaced rather than shadowed by a duplicate
+		for id := range obj.Methods {
+			if id.Value == name {
+				obj.Methods[id] = fn
+				return fn
+			}
+		}
+
+		obj.Methods[&ast.Identifier{Token: token.New(token.ID, name), Value: name}] = fn
+		return fn
 	default:
 		return newError("cannot assign fields of a %v. expected a hash or model",
 			obj.Type())
@@ -326,59 +570,64 @@ func evalAssignIndexExpression(
 	env *object.Environment,
 ) object.Object {
 	obj := Eval(left.Left, env)
+	if isError(obj) {
+		return obj
+	}
+
 	elem := Eval(left.Index, env)
+	if isError(elem) {
+		return elem
+	}
+
+	return assignIndex(obj, elem, right)
+}
 
+func assignIndex(obj, elem, right object.Object) object.Object {
 	switch obj := obj.(type) {
 	case *object.Array:
-		index, ok := elem.(*object.Number)
-		if !ok {
-			return newError("expected a number for an array index, not %v",
+		if !object.IsNumber(elem) {
+			return newKindError(object.TYPE_ERROR, "expected a number for an array index, not %v",
 				elem.Inspect())
 		}
 
-		if float64(int(index.Value)) != index.Value {
-			return newError("expected an integral number for an array index. got a real")
-		}
-
-		return assignArrayIndex(obj, int(index.Value), right)
+		return assignArrayIndex(obj, elem, right)
 	case *object.Hash:
-		key, ok := elem.(*object.String)
-		if !ok {
-			return newError("expected a string for a hash key, not %v",
-				elem.Inspect())
-		}
-
-		return assignHashKey(obj, *key, right)
+		return assignHashKey(obj, elem, right)
 	default:
-		return newError("cannot index %v\n", obj.Inspect())
+		return newError("cannot index %v", obj.Inspect())
 	}
-
-	return right
 }
 
 func assignArrayIndex(
 	array *object.Array,
-	index int,
+	index object.Object,
 	val object.Object,
 ) object.Object {
-	for index >= len(array.Elements) {
-		index %= len(array.Elements)
-	}
-
-	for index < 0 {
-		index += len(array.Elements)
+	idx, err := resolveIndex(index, len(array.Elements))
+	if err != nil {
+		return err
 	}
 
-	array.Elements[index] = val
+	array.Elements[idx] = val
 	return array
 }
 
 func assignHashKey(
 	hash *object.Hash,
-	key object.String,
+	key object.Object,
 	val object.Object,
 ) object.Object {
-	hash.Set(key.Value, val)
+	// a model's methods can't be replaced through its instances
+	if key, ok := key.(*object.String); ok {
+		if _, isMethod := hash.Model.GetMethod(key.Value); isMethod {
+			return hash
+		}
+	}
+
+	if err := hash.Insert(key, val); err != nil {
+		return err
+	}
+
 	return hash
 }
 
@@ -396,15 +645,17 @@ func evalInfixExpression(operator string, left, right object.Object, env *object
 		return nativeBoolToBooleanObject(!left.Equals(right))
 	case operator == "in":
 		return evalInOperator(operator, left, right)
-	case left.Type() == object.NUMBER_OBJ && right.Type() == object.NUMBER_OBJ:
-		return evalNumberInfixExpression(operator, left, right)
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return evalNumberInfixExpression(operator, left, right, env)
+	case left.Type() == object.CHAR_OBJ && right.Type() == object.CHAR_OBJ && operator != "+":
+		return evalCharInfixExpression(operator, left, right)
+	case isText(left) && isText(right):
+		return evalStringInfixExpression(operator, left, right, env)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s",
+		return newKindError(object.TYPE_ERROR, "type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
@@ -420,44 +671,41 @@ func evalInOperator(operator string, left, right object.Object) object.Object {
 
 		return FALSE
 	} else if right.Type() == object.HASH_OBJ {
-		if left.Type() != object.STRING_OBJ {
-			return newError("expected a string for a hash key, got %v",
-				left.Inspect())
-		}
-
-		key := left.(*object.String)
-
-		hash := right.(*object.Hash)
-		for k, _ := range hash.Pairs {
-			if key.Value == k.Value {
-				return TRUE
-			}
+		if err := object.CheckHashKey(left); err != nil {
+			return err
 		}
 
-		return FALSE
+		_, ok := right.(*object.Hash).Lookup(left)
+		return nativeBoolToBooleanObject(ok)
 	} else if right.Type() == object.STRING_OBJ {
 		rightString := right.(*object.String).Value
 		var s string
 
 		switch left := left.(type) {
-		case *object.Number:
+		case *object.Integer, *object.Number, *object.Char:
 			s = left.Inspect()
 		case *object.String:
 			s = left.Value
 		default:
-			return newError("expected a string or number to the left of 'in <string>'. got %v",
+			return newError("expected a string, character or number to the left of 'in <string>'. got %v",
 				left.Inspect())
 		}
 
 		return nativeBoolToBooleanObject(strings.Contains(rightString, s))
-	} else if right.Type() == object.NUMBER_OBJ {
-		if left.Type() != object.NUMBER_OBJ {
+	} else if object.IsNumber(right) {
+		if !object.IsNumber(left) {
 			return newError("expected a number to the left of 'in <number>'. got %v",
 				left.Inspect())
 		}
 
-		leftVal := left.(*object.Number).Value
-		rightVal := right.(*object.Number).Value
+		l, lok := left.(*object.Integer)
+		r, rok := right.(*object.Integer)
+		if lok && rok {
+			return nativeBoolToBooleanObject(l.Value != 0 && r.Value%l.Value == 0)
+		}
+
+		leftVal, _ := object.ToFloat(left)
+		rightVal, _ := object.ToFloat(right)
 
 		return nativeBoolToBooleanObject(math.Mod(rightVal, leftVal) == 0)
 	}
@@ -466,9 +714,18 @@ func evalInOperator(operator string, left, right object.Object) object.Object {
 		right.Inspect())
 }
 
-func evalNumberInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Number).Value
-	rightVal := right.(*object.Number).Value
+// evalNumberInfixExpression applies an operator to two numbers. If they're
+// both integers the result is too, and otherwise the integer is converted
+// to a float.
+func evalNumberInfixExpression(operator string, left, right object.Object, env *object.Environment) object.Object {
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		return evalIntegerInfixExpression(operator, l.Value, r.Value, env)
+	}
+
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
 
 	switch operator {
 	case "+":
@@ -495,38 +752,109 @@ func evalNumberInfixExpression(operator string, left, right object.Object) objec
 		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "<=":
 		return nativeBoolToBooleanObject(leftVal <= rightVal)
-	case "<<":
-		return &object.Number{Value: float64(int64(leftVal) << uint64(rightVal))}
-	case ">>":
-		return &object.Number{Value: float64(int64(leftVal) >> uint64(rightVal))}
-	case "&":
-		return &object.Number{Value: float64(int64(leftVal) & int64(rightVal))}
-	case "|":
-		return &object.Number{Value: float64(int64(leftVal) | int64(rightVal))}
-	case "..":
-		min := int64(leftVal)
-		max := int64(rightVal)
+	case "<<", ">>", "&", "|", "^", "..", "..<":
+		return newKindError(object.TYPE_ERROR, "expected integers for '%s'. got %s and %s",
+			operator, left.Inspect(), right.Inspect())
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
 
-		a := make([]object.Object, max-min+1)
-		for i := range a {
-			a[i] = &object.Number{Value: float64(min + int64(i))}
+func evalIntegerInfixExpression(operator string, left, right int64, env *object.Environment) object.Object {
+	var (
+		result int64
+		ok     = true
+	)
+
+	switch operator {
+	case "+":
+		result, ok = object.AddIntegers(left, right)
+	case "-":
+		result, ok = object.SubtractIntegers(left, right)
+	case "*":
+		result, ok = object.MultiplyIntegers(left, right)
+	case "**":
+		// a negative power can't be an integer
+		if right < 0 {
+			return &object.Number{Value: math.Pow(float64(left), float64(right))}
 		}
 
-		return &object.Array{Elements: a}
-	case "..<":
-		min := int64(leftVal)
-		max := int64(rightVal)
+		result, ok = object.PowerIntegers(left, right)
+	case "/", "%":
+		if right == 0 {
+			return newKindError(object.ARITHMETIC_ERROR, "division by zero: %d %s %d", left, operator, right)
+		}
 
-		a := make([]object.Object, max-min)
-		for i := range a {
-			a[i] = &object.Number{Value: float64(min + int64(i))}
+		// integer division rounds towards zero, like in Go
+		if operator == "/" {
+			result, ok = left/right, left != math.MinInt64 || right != -1
+		} else {
+			result = left % right
+		}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case "<<", ">>":
+		if right < 0 {
+			return newKindError(object.ARITHMETIC_ERROR, "negative shift count: %d %s %d", left, operator, right)
 		}
 
-		return &object.Array{Elements: a}
+		if operator == ">>" {
+			result = left >> uint64(right)
+		} else {
+			result = left << uint64(right)
+			ok = right < 64 && result>>uint64(right) == left
+		}
+	case "&":
+		result = left & right
+	case "|":
+		result = left | right
+	case "^":
+		result = left ^ right
+	case "..":
+		return evalRange(left, right+1, env)
+	case "..<":
+		return evalRange(left, right, env)
 	default:
-		return newError("unknown operator: %s %s %s",
-			left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+	}
+
+	if !ok {
+		return newKindError(object.ARITHMETIC_ERROR, "integer overflow: %d %s %d", left, operator, right)
 	}
+
+	return &object.Integer{Value: result}
+}
+
+// evalRange makes an array of the numbers from min up to, but not
+// including, max.
+func evalRange(min, max int64, env *object.Environment) object.Object {
+	n := max - min
+	if n < 0 {
+		n = 0
+	}
+
+	if err := env.Runtime().CheckAllocation(int(n)); err != nil {
+		return err
+	}
+
+	a := make([]object.Object, n)
+	for i := range a {
+		a[i] = &object.Integer{Value: min + int64(i)}
+	}
+
+	return &object.Array{Elements: a}
 }
 
 func evalHashInfixExpression(
@@ -555,6 +883,7 @@ func evalHashInfixExpression(
 		"||":  "or",
 		"&":   "bit_and",
 		"|":   "bit_or",
+		"^":   "bit_xor",
 		"in":  "in",
 	}
 
@@ -590,19 +919,100 @@ func evalHashInfixExpression(
 	}
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
+// evalHashPrefixExpression applies a prefix operator to an instance of a
+// model by calling the special method which overloads it.
+func evalHashPrefixExpression(operator string, hash *object.Hash, env *object.Environment) object.Object {
+	ops := map[string]string{
+		"~": "bit_not",
+	}
+
+	fnName := "_" + ops[operator]
+
+	o := hash.Get(fnName)
+	if o == NULL {
+		return newError("operator %v not overloaded. to overload, use the special method %v",
+			operator, fnName)
+	}
+
+	method, ok := o.(*object.MethodInstance)
+	if !ok {
+		return newError("%v must be a method, not a property", fnName)
+	}
+
+	return applyFunctionWithThisValue(method, hash, []object.Object{}, env)
+}
+
+func evalCharInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.Char).Value
+	rightVal := right.(*object.Char).Value
+
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+func evalInterpolatedString(is *ast.InterpolatedString, env *object.Environment) object.Object {
+	parts := make([]object.Object, len(is.Parts))
+
+	for i, part := range is.Parts {
+		parts[i] = Eval(part, env)
+		if isError(parts[i]) {
+			return parts[i]
+		}
+	}
+
+	return interpolate(parts, env)
+}
+
+// interpolate joins the parts of an interpolated string into one string,
+// writing each one as str() would.
+func interpolate(parts []object.Object, env *object.Environment) object.Object {
+	var out strings.Builder
+
+	for _, part := range parts {
+		out.WriteString(part.Inspect())
+	}
+
+	if err := env.Runtime().CheckAllocation(out.Len()); err != nil {
+		return err
+	}
+
+	return &object.String{Value: out.String()}
+}
+
+// isText reports whether an object is a string or a character, which can be
+// joined together with '+'.
+func isText(obj object.Object) bool {
+	return obj.Type() == object.STRING_OBJ || obj.Type() == object.CHAR_OBJ
+}
+
+func evalStringInfixExpression(operator string, left, right object.Object, env *object.Environment) object.Object {
+	leftVal := left.Inspect()
+	rightVal := right.Inspect()
 
 	switch operator {
 	case "+":
+		if err := env.Runtime().CheckAllocation(len(leftVal) + len(rightVal)); err != nil {
+			return err
+		}
+
 		return &object.String{Value: leftVal + rightVal}
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
@@ -610,25 +1020,98 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 func evalObjectAccessExpression(left object.Object, right ast.Expression) object.Object {
 	switch right := right.(type) {
 	case *ast.Identifier:
-		switch left := left.(type) {
-		case *object.Hash:
-			val := left.Get(right.Value)
+		return accessField(left, right.Value)
+	default:
+		return newError("not an identifier")
+	}
+}
 
+func accessField(left object.Object, name string) object.Object {
+	switch left := left.(type) {
+	case *object.Hash:
+		if val, ok := left.Field(name); ok {
 			return val
-		case *object.Model:
-			id := right.Value
+		}
 
-			if meth, ok := left.GetMethod(id); ok {
-				return meth
-			} else {
-				return NULL
-			}
-		default:
-			return newError("cannot access %v, expected a hash or a model", left.Inspect())
+		// the methods every hash has come last, so they don't hide keys
+		if meth, ok := object.HASH_MODEL.GetMethod(name); ok {
+			meth.This = left
+			return meth
+		}
+
+		return NULL
+	case *object.Model:
+		meth, ok := left.GetMethod(name)
+		if !ok {
+			return NULL
+		}
+
+		if left.Builtin() {
+			return unboundMethod(left, meth)
 		}
+
+		return meth
+	case *object.String, *object.Array:
+		return accessMethod(left, name)
 	default:
-		return newError("not an identifier")
+		return newError("cannot access %v, expected a hash, a model, a string or an array", left.Inspect())
+	}
+}
+
+// unboundMethod makes a method of a builtin model, accessed through the
+// model, take the value to call it on as its first argument, like
+// hash.keys(h). Unlike h.keys(), that can't be hidden by a key of the hash.
+func unboundMethod(model *object.Model, meth *object.MethodInstance) object.Object {
+	return &object.Builtin{
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			if len(args) == 0 || !instanceOf(args[0], model) {
+				article := "a"
+				if strings.ContainsAny(model.Name[:1], "aeiou") {
+					article = "an"
+				}
+
+				return newKindError(object.TYPE_ERROR, "%s.%s must be called on %s %s",
+					model.Name, meth.Name, article, model.Name)
+			}
+
+			bound := *meth
+			bound.This = args[0]
+
+			return applyFunction(&bound, args[1:], env)
+		},
+	}
+}
+
+// instanceOf reports whether a value is an instance of a model, or of a
+// model which extends it. Every hash counts as a hash, although hash isn't
+// the model of any of them.
+func instanceOf(obj object.Object, model *object.Model) bool {
+	if model == object.HASH_MODEL {
+		_, ok := obj.(*object.Hash)
+		return ok
+	}
+
+	for m := object.ModelOf(obj); m != nil; m = m.Parent {
+		if m.Equals(model) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// accessMethod gets a method of the builtin model of a value which isn't a
+// hash, like a string or an array, bound to the value.
+func accessMethod(obj object.Object, name string) object.Object {
+	model := object.ModelOf(obj)
+
+	meth, ok := model.GetMethod(name)
+	if !ok {
+		return newKindError(object.NAME_ERROR, "%s has no method %s", model.Name, name)
 	}
+
+	meth.This = obj
+	return meth
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
@@ -645,6 +1128,44 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Body, env)
+
+	if err, ok := result.(*object.Error); ok && !env.Runtime().Catch(err) {
+		return err
+	}
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if te.ErrName != nil {
+			catchEnv.Declare(te.ErrName.Value, err.ToHash())
+		}
+
+		result = Eval(te.Catch, catchEnv)
+	}
+
+	if te.Finally != nil {
+		// the result of the finally block is discarded, unless it's
+		// an error or it changes the control flow
+		fin := Eval(te.Finally, env)
+
+		if fin != nil {
+			ft := fin.Type()
+			if ft == object.RETURN_VALUE_OBJ ||
+				ft == object.ERROR_OBJ ||
+				ft == object.LOOP_CONTROL_STATEMENT_OBJ {
+				return fin
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+
+	return result
+}
+
 func evalWhileExpression(we *ast.WhileExpression, env *object.Environment) object.Object {
 	result := &object.Array{Elements: []object.Object{}}
 
@@ -655,7 +1176,7 @@ func evalWhileExpression(we *ast.WhileExpression, env *object.Environment) objec
 		}
 
 		if isTruthy(condition) {
-			res := Eval(we.Body, env)
+			res := evalLoopBody(we.Body, env)
 			if isError(res) {
 				return res
 			}
@@ -673,6 +1194,10 @@ func evalWhileExpression(we *ast.WhileExpression, env *object.Environment) objec
 			}
 
 			if res != nil {
+				if err := env.Runtime().CheckAllocation(len(result.Elements) + 1); err != nil {
+					return err
+				}
+
 				result.Elements = append(result.Elements, res)
 			}
 		} else {
@@ -683,39 +1208,72 @@ func evalWhileExpression(we *ast.WhileExpression, env *object.Environment) objec
 	return result
 }
 
+// evalLoopBody evaluates one iteration of the body of a loop, in which
+// break and next can be used.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) object.Object {
+	rt := env.Runtime()
+	rt.Loops++
+	defer func() { rt.Loops-- }()
+
+	return Eval(body, env)
+}
+
+// evalLoopControl evaluates break or next, which are errors outside of the
+// body of a loop, like they are for the compiler.
+func evalLoopControl(name string, env *object.Environment) object.Object {
+	if env.Runtime().Loops == 0 {
+		return newError("%s outside of a loop", name)
+	}
+
+	return &object.LoopControlStatement{Literal: name}
+}
+
 func evalForExpression(fe *ast.ForExpression, env *object.Environment) object.Object {
 	set := Eval(fe.Set, env)
-
-	varName, ok := fe.Var.(*ast.Identifier)
-	if !ok {
-		return newError("expected an identifier as the iterator name")
+	if isError(set) {
+		return set
 	}
 
 	switch set := set.(type) {
 	case *object.Array:
-		return evalForExpressionOverArray(set, varName, fe.Body, env)
+		return evalForExpressionOverArray(set, fe, env)
 	case *object.Hash:
-		return evalForExpressionOverHash(set, varName, fe.Body, env)
+		return evalForExpressionOverHash(set, fe, env)
 	case *object.String:
-		return evalForExpressionOverString(set, varName, fe.Body, env)
+		return evalForExpressionOverString(set, fe, env)
 	default:
 		return newError("invalid set %v. expected an array or a hash", set.Inspect())
 	}
 }
 
+// declareLoopVariables declares the variables of a for loop in the
+// environment of an iteration. The counter is given the index or key, and
+// the element, if there's a name or pattern for it, is bound to the element
+// or value.
+func declareLoopVariables(fe *ast.ForExpression, index, elem object.Object, env *object.Environment) object.Object {
+	env.Declare(fe.Var.Value, index)
+
+	if fe.Element == nil {
+		return nil
+	}
+
+	return destructure(fe.Element, elem, true, env)
+}
+
 func evalForExpressionOverArray(
 	array *object.Array,
-	varName *ast.Identifier,
-	body *ast.BlockStatement,
+	fe *ast.ForExpression,
 	env *object.Environment,
 ) object.Object {
 	result := &object.Array{Elements: []object.Object{}}
 
-	for elem, _ := range array.Elements {
+	for elem, value := range array.Elements {
 		e := object.NewEnclosedEnvironment(env)
-		e.Declare(varName.Value, &object.Number{Value: float64(elem)})
+		if err := declareLoopVariables(fe, &object.Integer{Value: int64(elem)}, value, e); err != nil {
+			return err
+		}
 
-		res := Eval(body, e)
+		res := evalLoopBody(fe.Body, e)
 		if isError(res) {
 			return res
 		}
@@ -742,17 +1300,18 @@ func evalForExpressionOverArray(
 
 func evalForExpressionOverString(
 	str *object.String,
-	varName *ast.Identifier,
-	body *ast.BlockStatement,
+	fe *ast.ForExpression,
 	env *object.Environment,
 ) object.Object {
 	result := &object.String{Value: ""}
 
-	for elem := 0; elem < len(str.Value); elem++ {
+	for elem, char := range []rune(str.Value) {
 		e := object.NewEnclosedEnvironment(env)
-		e.Declare(varName.Value, &object.Number{Value: float64(elem)})
+		if err := declareLoopVariables(fe, &object.Integer{Value: int64(elem)}, &object.Char{Value: char}, e); err != nil {
+			return err
+		}
 
-		res := Eval(body, e)
+		res := evalLoopBody(fe.Body, e)
 		if isError(res) {
 			return res
 		}
@@ -770,7 +1329,12 @@ func evalForExpressionOverString(
 		}
 
 		if res != nil && res != NULL {
-			result.Value += res.Inspect()
+			value := result.Value + res.Inspect()
+			if err := env.Runtime().CheckAllocation(len(value)); err != nil {
+				return err
+			}
+
+			result.Value = value
 		}
 	}
 
@@ -779,18 +1343,18 @@ func evalForExpressionOverString(
 
 func evalForExpressionOverHash(
 	hash *object.Hash,
-	varName *ast.Identifier,
-	body *ast.BlockStatement,
+	fe *ast.ForExpression,
 	env *object.Environment,
 ) object.Object {
 	result := object.NewHash(object.OBJECT_MODEL)
-	result.Pairs = make(map[object.String]object.Object)
 
-	for key, _ := range hash.Pairs {
+	for _, pair := range hash.Pairs() {
 		e := object.NewEnclosedEnvironment(env)
-		e.Declare(varName.Value, &key)
+		if err := declareLoopVariables(fe, pair.Key, pair.Value, e); err != nil {
+			return err
+		}
 
-		res := Eval(body, e)
+		res := evalLoopBody(fe.Body, e)
 		if isError(res) {
 			return res
 		}
@@ -808,7 +1372,7 @@ func evalForExpressionOverHash(
 		}
 
 		if res != nil && res != NULL {
-			result.Set(key.Value, res)
+			result.Insert(pair.Key, res)
 		}
 	}
 
@@ -817,88 +1381,161 @@ func evalForExpressionOverHash(
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.NUMBER_OBJ:
+	case left.Type() == object.ARRAY_OBJ && object.IsNumber(index):
 		return evalArrayIndexExpression(left, index)
-	case left.Type() == object.HASH_OBJ && index.Type() == object.STRING_OBJ:
+	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
-	case left.Type() == object.STRING_OBJ && index.Type() == object.NUMBER_OBJ:
+	case left.Type() == object.STRING_OBJ && object.IsNumber(index):
 		return evalStringIndexExpression(left, index)
 	default:
-		return newError("index operator not supported: %s[%s]",
+		return newKindError(object.TYPE_ERROR, "index operator not supported: %s[%s]",
 			left.Type(), index.Type())
 	}
 }
 
+// evalStringIndexExpression returns the character at an index in a string,
+// counting in characters rather than bytes.
 func evalStringIndexExpression(str, index object.Object) object.Object {
-	stringObject := str.(*object.String)
-	idx := int64(index.(*object.Number).Value)
-	max := int64(len(stringObject.Value) - 1)
+	chars := []rune(str.(*object.String).Value)
+
+	idx, err := resolveIndex(index, len(chars))
+	if err != nil {
+		return err
+	}
+
+	return &object.Char{Value: chars[idx]}
+}
+
+// sequenceElements returns the elements of an array, or the characters of
+// a string, which can be used in the same ways.
+func sequenceElements(obj object.Object) ([]object.Object, bool) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return obj.Elements, true
+	case *object.String:
+		chars := []object.Object{}
+		for _, ch := range obj.Value {
+			chars = append(chars, &object.Char{Value: ch})
+		}
+
+		return chars, true
+	default:
+		return nil, false
+	}
+}
 
-	for idx > max {
-		idx %= int64(len(stringObject.Value))
+// newSequence makes a sequence of the same type as like out of some
+// elements: a string, if like is a string and they're all characters, and
+// an array otherwise.
+func newSequence(like object.Object, elements []object.Object) object.Object {
+	if _, ok := like.(*object.String); !ok {
+		return &object.Array{Elements: elements}
 	}
 
-	for idx < 0 {
-		idx += int64(len(stringObject.Value))
+	var out strings.Builder
+	for _, elem := range elements {
+		ch, ok := elem.(*object.Char)
+		if !ok {
+			return &object.Array{Elements: elements}
+		}
+
+		out.WriteRune(ch.Value)
 	}
 
-	return &object.String{Value: string(stringObject.Value[idx])}
+	return &object.String{Value: out.String()}
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := int64(index.(*object.Number).Value)
-	max := int64(len(arrayObject.Elements) - 1)
 
-	for idx > max {
-		idx %= int64(len(arrayObject.Elements))
+	idx, err := resolveIndex(index, len(arrayObject.Elements))
+	if err != nil {
+		return err
+	}
+
+	return arrayObject.Elements[idx]
+}
+
+// resolveIndex converts an index, which should be an integer, into a
+// position in a sequence of the given length. Negative indices count
+// backwards from the end of the sequence.
+func resolveIndex(index object.Object, length int) (int, *object.Error) {
+	i, ok := index.(*object.Integer)
+	if !ok {
+		return 0, newKindError(object.INDEX_ERROR,
+			"expected an integer for an index. got %v", index.Inspect())
 	}
 
-	for idx < 0 {
-		idx += int64(len(arrayObject.Elements))
+	idx := i.Value
+	if idx < 0 {
+		idx += int64(length)
 	}
 
-	return arrayObject.Elements[idx]
+	if idx < 0 || idx >= int64(length) {
+		return 0, newKindError(object.INDEX_ERROR,
+			"index %v out of range for length %v", i.Value, length)
+	}
+
+	return int(idx), nil
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
-	key := index.(*object.String)
 
-	return hashObject.Get(key.Value)
+	// a string key can also be the name of one of the model's methods
+	if key, ok := index.(*object.String); ok {
+		return hashObject.Get(key.Value)
+	}
+
+	if err := object.CheckHashKey(index); err != nil {
+		return err
+	}
+
+	if val, ok := hashObject.Lookup(index); ok {
+		return val
+	}
+
+	return NULL
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.String]object.Object)
+	keys := []object.Object{}
+	values := []object.Object{}
 
-	for keyNode, valueNode := range node.Pairs {
-		value := Eval(valueNode, env)
+	for _, keyNode := range node.Keys() {
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
 
+		var key object.Object
+
 		switch keyNode := keyNode.(type) {
 		case *ast.Identifier:
-			str := object.String{Value: keyNode.Value}
-			pairs[str] = value
+			key = &object.String{Value: keyNode.Value}
 		default:
-			key := Eval(keyNode, env)
+			key = Eval(keyNode, env)
 			if isError(key) {
 				return key
 			}
+		}
 
-			str, ok := key.(*object.String)
-			if !ok {
-				return newError("unusable as hash key: %s. expected a string",
-					key.Type())
-			}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	return hashFromPairs(keys, values)
+}
+
+func hashFromPairs(keys, values []object.Object) object.Object {
+	hash := object.NewHash(object.OBJECT_MODEL)
 
-			pairs[*str] = value
+	for i, key := range keys {
+		if err := hash.Insert(key, values[i]); err != nil {
+			return err
 		}
 	}
 
-	hash := object.NewHash(object.OBJECT_MODEL)
-	hash.Pairs = pairs
 	return hash
 }
 
@@ -933,14 +1570,109 @@ func applyFunctionWithThisValue(
 	args []object.Object,
 	env *object.Environment,
 ) object.Object {
+	switch fn := fn.(type) {
+	case *object.Function:
+		return callFunction(fn, functionName(fn.Name), thisValue, args, env)
+	case *object.Lambda:
+		return callFunction(fn, functionName(fn.Name), thisValue, args, env)
+	case *object.Model:
+		m := fn
+
+		instance := m.Instantiate(args)
+		if isError(instance) {
+			return instance
+		}
+
+		hash := instance.(*object.Hash)
+
+		enclosedEnv := object.NewEnclosedEnvironment(env)
+		for i, prop := range m.Properties {
+			enclosedEnv.Declare(prop.Value, args[i])
+		}
+
+		if m.ParentInit != nil {
+			vals := applyFunction(m.ParentInit, args, env)
+			if isError(vals) {
+				return vals
+			}
+
+			parentArgs := vals.(*object.Array).Elements
+			if len(parentArgs) != len(m.Parent.Properties) {
+				return newKindError(object.ARGUMENT_ERROR, "invalid number of arguments to the parent model. expected %v, got %v",
+					len(m.Parent.Properties), len(parentArgs))
+			}
+
+			for i, name := range m.Parent.Properties {
+				hash.Set(name.Value, parentArgs[i])
+			}
+		} else if m.Parent != nil {
+			if len(m.ParentArgs) != len(m.Parent.Properties) {
+				return newKindError(object.ARGUMENT_ERROR, "invalid number of arguments to the parent model. expected %v, got %v",
+					len(m.Parent.Properties), len(m.ParentArgs))
+			}
+
+			for i, name := range m.Parent.Properties {
+				val := Eval(m.ParentArgs[i], enclosedEnv)
+				if isError(val) {
+					return val
+				}
+
+				hash.Set(name.Value, val)
+			}
+		}
+
+		if _new, ok := hash.Model.GetMethod("_new"); ok {
+			_new.This = hash
+			return applyFunction(_new, []object.Object{}, env)
+		}
+		return hash
+	case *object.MethodInstance:
+		switch inner := (*fn.Function).(type) {
+		case *object.Function, *object.Lambda:
+			return callFunction(inner, fn.FullName(), fn.This, args, env)
+		case object.Callable:
+			return inner.Call(fn.FullName(), fn.This, args)
+		default:
+			return applyFunctionWithThisValue(inner, fn.This, args, env)
+		}
+	case *object.Builtin:
+		return fn.Fn(env, thisValue, args...)
+	case object.Callable:
+		return fn.Call("", thisValue, args)
+	default:
+		return newKindError(object.TYPE_ERROR, "cannot call a %s", fn.Type())
+	}
+}
+
+// callFunction evaluates the body of a function or lambda, adding a frame
+// with the given name to the call stack while it runs.
+func callFunction(
+	fn object.Object,
+	name string,
+	thisValue object.Object,
+	args []object.Object,
+	env *object.Environment,
+) object.Object {
+	rt := env.Runtime()
+	rt.Push(name)
+	defer rt.Pop()
+
+	if err := rt.CheckDepth(); err != nil {
+		return err
+	}
+
 	switch fn := fn.(type) {
 	case *object.Function:
 		if len(fn.Parameters) != len(args) {
-			return newError("invalid number of arguments. expected %v, got %v",
+			return newKindError(object.ARGUMENT_ERROR, "invalid number of arguments. expected %v, got %v",
 				len(fn.Parameters), len(args))
 		}
 
-		extendedEnv := extendFunctionEnv(fn, args)
+		extendedEnv, err := extendEnv(fn.Env, fn.Parameters, fn.Patterns, args)
+		if err != nil {
+			return err
+		}
+
 		extendedEnv.Declare("this", thisValue)
 		evaluated := Eval(fn.Body, extendedEnv)
 		if isError(evaluated) {
@@ -954,11 +1686,15 @@ func applyFunctionWithThisValue(
 		return unwrapReturnValue(evaluated)
 	case *object.Lambda:
 		if len(fn.Parameters) != len(args) {
-			return newError("invalid number of arguments. expected %v, got %v",
+			return newKindError(object.ARGUMENT_ERROR, "invalid number of arguments. expected %v, got %v",
 				len(fn.Parameters), len(args))
 		}
 
-		extendedEnv := extendLambdaEnv(fn, args)
+		extendedEnv, err := extendEnv(fn.Env, fn.Parameters, fn.Patterns, args)
+		if err != nil {
+			return err
+		}
+
 		extendedEnv.Declare("this", thisValue)
 		evaluated := Eval(*fn.Body, extendedEnv)
 
@@ -967,61 +1703,42 @@ func applyFunctionWithThisValue(
 		}
 
 		return unwrapReturnValue(evaluated)
-	case *object.Model:
-		m := fn
-
-		hash := m.Instantiate(args).(*object.Hash)
-
-		enclosedEnv := object.NewEnclosedEnvironment(env)
-		for i, prop := range m.Properties {
-			enclosedEnv.Declare(prop.Value, args[i])
-		}
-
-		if m.Parent != nil {
-			for i, name := range m.Parent.Properties {
-				val := Eval(m.ParentArgs[i], enclosedEnv)
-				if isError(val) {
-					return val
-				}
-
-				hash.Set(name.Value, val)
-			}
-		}
-
-		if _new, ok := hash.Model.GetMethod("_new"); ok {
-			_new.Hash = hash
-			return applyFunction(_new, []object.Object{}, env)
-		}
-		return hash
-	case *object.MethodInstance:
-		res := applyFunctionWithThisValue(*fn.Function, fn.Hash, args, env)
-
-		return res
-	case *object.Builtin:
-		return fn.Fn(thisValue, args...)
 	default:
-		return newError("cannot call a %s", fn.Type())
+		return newKindError(object.TYPE_ERROR, "cannot call a %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
-
-	for paramIdx, param := range fn.Parameters {
-		env.Declare(param.Value, args[paramIdx])
+func functionName(name string) string {
+	if name == "" {
+		return "<anonymous>"
 	}
 
-	return env
+	return name
 }
 
-func extendLambdaEnv(fn *object.Lambda, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+// extendEnv makes the environment a function or lambda is called in, with
+// its parameters declared, or destructured if they're patterns.
+func extendEnv(
+	outer *object.Environment,
+	params []*ast.Identifier,
+	patterns []ast.Expression,
+	args []object.Object,
+) (*object.Environment, object.Object) {
+	env := object.NewEnclosedEnvironment(outer)
+
+	for paramIdx, param := range params {
+		if patterns != nil && patterns[paramIdx] != nil {
+			if err := destructure(patterns[paramIdx], args[paramIdx], true, env); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
 
-	for paramIdx, param := range fn.Parameters {
 		env.Declare(param.Value, args[paramIdx])
 	}
 
-	return env
+	return env, nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
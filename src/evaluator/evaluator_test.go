@@ -0,0 +1,202 @@
+package evaluator
+
+import (
+	"../lexer"
+	"../object"
+	"../parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func evalInput(t *testing.T, input string) object.Object {
+	l := lexer.NewFile("test.lang", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	return Eval(program, object.NewEnvironment())
+}
+
+func TestErrorPositions(t *testing.T) {
+	input := `f := fn (x) {
+  x + "a";
+};
+
+f(1);`
+
+	err, ok := evalInput(t, input).(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+
+	if err.Pos.String() != "test.lang:2:5" {
+		t.Errorf("expected the error at test.lang:2:5, got %v", err.Pos)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	input := `vector := model (x, y);
+
+check := fn (v) {
+  err("bad vector");
+};
+
+vector._plus = fn (other) {
+  check(other);
+};
+
+add := \(a, b) = a + b;
+
+add(vector(1, 2), 5);`
+
+	err, ok := evalInput(t, input).(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+
+	expected := []string{"add", "vector._plus", "check"}
+	if len(err.Trace) != len(expected) {
+		t.Fatalf("expected %d frames, got %v", len(expected), err.Trace)
+	}
+
+	for i, name := range expected {
+		if err.Trace[i].Name != name {
+			t.Errorf("frame %d: expected %s, got %s", i, name, err.Trace[i].Name)
+		}
+	}
+
+	if err.Trace[0].Pos.Line != 13 || err.Trace[2].Pos.Line != 8 {
+		t.Errorf("wrong call site positions: %v", err.Trace)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { err("oops"); } catch (e) { e.message; };`, "oops"},
+		{`try { err("oops"); } catch (e) { e.kind; };`, "error"},
+		{`try { 1 + "a"; } catch (e) { e.kind; };`, "type"},
+		{`try { [1, 2][2]; } catch (e) { e.kind; };`, "index"},
+		{`try { nope; } catch (e) { e.kind; };`, "name"},
+		{`try { (fn (x) {})(); } catch (e) { e.kind; };`, "argument"},
+		{`try { (model (x, y))(1); } catch (e) { e.kind; };`, "argument"},
+		{`m := model (x, y); try { (model (x) : m (x))(1); } catch (e) { e.kind; };`, "argument"},
+		{`try { 1; } catch (e) { 2; };`, "1"},
+		{`try { 1; } finally { 2; };`, "1"},
+		{`x := 0; try { err("a"); } catch { x = 1; } finally { x = x + 1; }; x;`, "2"},
+		{`f := fn () { try { return 1; } finally { print; }; 2; }; f();`, "1"},
+		{`try { try { err("a"); } catch (e) { err(e); }; } catch (e) { e.message; };`, "a"},
+		{`try { try { err("a"); } finally { 1; }; } catch (e) { e.message; };`, "a"},
+		{`try { err("a"); } catch (e) { type(e) == error; };`, "true"},
+	}
+
+	for _, tt := range tests {
+		result := evalInput(t, tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestUncaughtErrorInFinally(t *testing.T) {
+	result := evalInput(t, `try { 1; } finally { err("from finally"); };`)
+
+	err, ok := result.(*object.Error)
+	if !ok || err.Message != "from finally" {
+		t.Errorf("expected the error from the finally block, got %v", result.Inspect())
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`break;`, "ERROR: break outside of a loop"},
+		{`try { next; } catch (e) { e.message; };`, "next outside of a loop"},
+		{`for (i | [1, 2]) { f := fn () { break; }; f(); };`, "ERROR: break outside of a loop"},
+		{`for (i | [1, 2, 3]) { if (i == 1) { next; }; if (i == 2) { try { break; } catch { 0; }; }; i; };`, "[0]"},
+		{`x := 0; while (x < 3) { x += 1; if (x == 2) { break; }; x; };`, "[1]"},
+	}
+
+	for _, tt := range tests {
+		result := evalInput(t, tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"lib/math.lang": `square := \(x) = x * x; count := 0; count = count + 1;`,
+		"a.lang":        `import "b";`,
+		"b.lang":        `import "a";`,
+	}
+
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`m := import "lib/math.lang"; m.square(3);`, "9"},
+		{`import square from "lib/math"; square(4);`, "16"},
+		{`import "lib/math"; (import "lib/math").count;`, "1"},
+		{`import nope from "lib/math";`, "ERROR: lib/math has no top-level binding called nope"},
+		{`import "missing";`, "ERROR: could not find missing to import"},
+		{`import "a";`, "ERROR: import cycle: a.lang -> b.lang -> a.lang"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile(filepath.Join(dir, "main.lang"), tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		result := Eval(program, object.NewEnvironment())
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestImportSearchPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "lib.lang"), []byte(`x := 5;`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := object.NewEnvironment()
+	env.Runtime().SearchPaths = []string{dir}
+
+	l := lexer.New(`import x from "lib"; x;`)
+	result := Eval(parser.New(l).ParseProgram(), env)
+
+	if result.Inspect() != "5" {
+		t.Errorf("expected 5, got %s", result.Inspect())
+	}
+}
@@ -0,0 +1,245 @@
+package evaluator
+
+import (
+	"../ast"
+	"../object"
+)
+
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		armEnv := object.NewEnclosedEnvironment(env)
+
+		matched, err := match(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+
+		if !matched {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, armEnv)
+	}
+
+	return noMatch(subject)
+}
+
+// match reports whether a value matches a pattern, declaring the names in
+// the pattern in env as it goes. "_" matches anything without being
+// declared.
+func match(pattern ast.Expression, value object.Object, env *object.Environment) (bool, object.Object) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			env.Declare(pattern.Value, value)
+		}
+
+		return true, nil
+	case *ast.ArrayPattern:
+		elements, ok := matchArray(value, len(pattern.Elements), pattern.Rest != nil)
+		if !ok {
+			return false, nil
+		}
+
+		return matchEach(withRest(pattern.Elements, pattern.Rest), elements, env)
+	case *ast.HashPattern:
+		keys := make([]string, len(pattern.Fields))
+		patterns := make([]ast.Expression, len(pattern.Fields))
+		for i, field := range pattern.Fields {
+			keys[i], patterns[i] = field.Name(), field.Value
+		}
+
+		values, ok := matchHash(value, keys, pattern.Rest != nil)
+		if !ok {
+			return false, nil
+		}
+
+		return matchEach(withRest(patterns, pattern.Rest), values, env)
+	case *ast.ModelPattern:
+		model := Eval(pattern.Model, env)
+		if isError(model) {
+			return false, model
+		}
+
+		values, ok, err := matchModel(value, model, len(pattern.Arguments))
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			return false, nil
+		}
+
+		return matchEach(pattern.Arguments, values, env)
+	case *ast.InfixExpression:
+		low := Eval(pattern.Left, env)
+		if isError(low) {
+			return false, low
+		}
+
+		high := Eval(pattern.Right, env)
+		if isError(high) {
+			return false, high
+		}
+
+		ok, err := inRange(value, low, high, pattern.Operator == "..")
+		if err != nil {
+			return false, err
+		}
+
+		return ok, nil
+	default:
+		literal := Eval(pattern, env)
+		if isError(literal) {
+			return false, literal
+		}
+
+		return literal.Equals(value), nil
+	}
+}
+
+func matchEach(patterns []ast.Expression, values []object.Object, env *object.Environment) (bool, object.Object) {
+	for i, pattern := range patterns {
+		if ok, err := match(pattern, values[i], env); err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func withRest(patterns []ast.Expression, rest *ast.Identifier) []ast.Expression {
+	if rest == nil {
+		return patterns
+	}
+
+	return append(append([]ast.Expression{}, patterns...), rest)
+}
+
+// matchArray returns the elements of a value matched by an array pattern
+// with count elements, which it matches if it's an array or string of that
+// length, or at least that long if rest is true. The remaining elements come
+// last if rest is true.
+func matchArray(value object.Object, count int, rest bool) ([]object.Object, bool) {
+	all, ok := sequenceElements(value)
+	if !ok || len(all) < count || (!rest && len(all) > count) {
+		return nil, false
+	}
+
+	elements, _ := unpackArray(value, count, count, rest)
+	return elements, true
+}
+
+// matchHash returns the values of the given keys in a value matched by a
+// hash pattern, which it matches if it's a hash with all of the keys. A hash
+// of the other pairs comes last if rest is true.
+func matchHash(value object.Object, keys []string, rest bool) ([]object.Object, bool) {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return nil, false
+	}
+
+	for _, key := range keys {
+		if _, ok := hash.Lookup(&object.String{Value: key}); !ok {
+			return nil, false
+		}
+	}
+
+	values, _ := unpackHash(hash, keys, rest)
+	return values, true
+}
+
+// matchModel returns the first count properties of a value matched by a
+// model pattern, which it matches if it's an instance of the model or of
+// one of its descendants.
+func matchModel(value, model object.Object, count int) ([]object.Object, bool, *object.Error) {
+	m, ok := model.(*object.Model)
+	if !ok {
+		return nil, false, newKindError(object.TYPE_ERROR, "expected a model in a pattern. got %s", model.Type())
+	}
+
+	if count != 0 && count != len(m.Properties) {
+		return nil, false, newKindError(object.ARGUMENT_ERROR,
+			"invalid number of patterns for the properties of a model. expected %v, got %v",
+			len(m.Properties), count)
+	}
+
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return nil, false, nil
+	}
+
+	for parent := hash.Model; parent != nil; parent = parent.Parent {
+		if !parent.Equals(m) {
+			continue
+		}
+
+		values := make([]object.Object, count)
+		for i := range values {
+			values[i] = hash.Get(m.Properties[i].Value)
+		}
+
+		return values, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// inRange reports whether a value is a number between low and high, or a
+// character between two characters, which includes high if inclusive is
+// true.
+func inRange(value, low, high object.Object, inclusive bool) (bool, *object.Error) {
+	chars := low.Type() == object.CHAR_OBJ
+
+	lo, ok := ordinal(low)
+	hi, hiOk := ordinal(high)
+	if !ok || !hiOk || chars != (high.Type() == object.CHAR_OBJ) {
+		return false, newKindError(object.TYPE_ERROR,
+			"expected numbers or characters for a range in a pattern. got %s and %s", low.Inspect(), high.Inspect())
+	}
+
+	if chars != (value.Type() == object.CHAR_OBJ) {
+		return false, nil
+	}
+
+	v, ok := ordinal(value)
+	if !ok || v < lo {
+		return false, nil
+	}
+
+	if inclusive {
+		return v <= hi, nil
+	}
+
+	return v < hi, nil
+}
+
+// ordinal converts a number, or a character's code, to a float so it can be
+// compared with the bounds of a range.
+func ordinal(obj object.Object) (float64, bool) {
+	if ch, ok := obj.(*object.Char); ok {
+		return float64(ch.Value), true
+	}
+
+	return object.ToFloat(obj)
+}
+
+func noMatch(value object.Object) *object.Error {
+	return newKindError(object.MATCH_ERROR, "no pattern matched %s", value.Inspect())
+}
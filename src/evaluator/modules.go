@@ -0,0 +1,222 @@
+package evaluator
+
+import (
+	"../ast"
+	"../lexer"
+	"../object"
+	"../parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SearchPathVar is the environment variable holding a list of directories
+// to search for imported files, separated like $PATH.
+const SearchPathVar = "LANG_PATH"
+
+// Extension is the file extension of source files. It can be left off the
+// path given to import.
+const Extension = ".lang"
+
+// ModuleRunner runs the program of an imported file using the given
+// runtime, returning the file's top-level bindings.
+type ModuleRunner func(program *ast.Program, rt *object.Runtime) (map[string]object.Object, *object.Error)
+
+func evalImportExpression(node *ast.ImportExpression, env *object.Environment) object.Object {
+	path := Eval(node.Path, env)
+	if isError(path) {
+		return path
+	}
+
+	str, ok := path.(*object.String)
+	if !ok {
+		return newKindError(object.TYPE_ERROR, "expected a string path to import, got %v",
+			path.Inspect())
+	}
+
+	names := []string{}
+	for _, name := range node.Names {
+		names = append(names, name.Value)
+	}
+
+	module, err := ImportModule(str.Value, names, node.Token.Pos.File, env.Runtime(), runModule)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		env.Declare(name, module.Get(name))
+	}
+
+	return module
+}
+
+// ImportModule imports the file at the given path, relative to the file
+// containing the import, and returns a hash of its top-level bindings. If
+// any names are given, the hash only contains those bindings.
+func ImportModule(
+	path string,
+	names []string,
+	from string,
+	rt *object.Runtime,
+	run ModuleRunner,
+) (*object.Hash, *object.Error) {
+	module, err := importFile(path, from, rt, run)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return module, nil
+	}
+
+	selected := object.NewHash(object.OBJECT_MODEL)
+
+	for _, name := range names {
+		val, ok := module.Lookup(&object.String{Value: name})
+		if !ok {
+			return nil, newKindError(object.NAME_ERROR, "%s has no top-level binding called %s",
+				path, name)
+		}
+
+		selected.Set(name, val)
+	}
+
+	return selected, nil
+}
+
+// importFile runs the file at the given path, returning a hash of its
+// top-level bindings. Each file is only run once, with later imports of
+// the same file returning the cached hash.
+func importFile(path, from string, rt *object.Runtime, run ModuleRunner) (*object.Hash, *object.Error) {
+	file, ok := findModule(path, from, rt)
+	if !ok {
+		return nil, newKindError(object.IMPORT_ERROR, "could not find %s to import", path)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, newKindError(object.IMPORT_ERROR, "could not import %s: %s", path, err)
+	}
+
+	if module, ok := rt.Modules[abs]; ok {
+		return module, nil
+	}
+
+	for i, importing := range rt.Importing {
+		if importing == abs {
+			cycle := []string{}
+			for _, f := range append(rt.Importing[i:], abs) {
+				cycle = append(cycle, filepath.Base(f))
+			}
+
+			return nil, newKindError(object.IMPORT_ERROR, "import cycle: %s",
+				strings.Join(cycle, " -> "))
+		}
+	}
+
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, newKindError(object.IMPORT_ERROR, "could not import %s: %s", path, err)
+	}
+
+	text := string(bytes)
+	rt.Sources[file] = text
+
+	l := lexer.NewFile(file, text)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, newKindError(object.IMPORT_ERROR, "could not parse %s:\n  %s",
+			file, strings.Join(p.Errors(), "\n  "))
+	}
+
+	rt.Importing = append(rt.Importing, abs)
+	rt.Push("<module " + file + ">")
+
+	bindings, runErr := run(program, rt)
+
+	rt.Pop()
+	rt.Importing = rt.Importing[:len(rt.Importing)-1]
+
+	if runErr != nil {
+		return nil, runErr
+	}
+
+	// the bindings are sorted by name, so the module's keys are in the
+	// same order every time
+	names := []string{}
+	for name := range bindings {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	module := object.NewHash(object.OBJECT_MODEL)
+	for _, name := range names {
+		module.Set(name, bindings[name])
+	}
+
+	rt.Modules[abs] = module
+
+	return module, nil
+}
+
+func runModule(program *ast.Program, rt *object.Runtime) (map[string]object.Object, *object.Error) {
+	env := object.NewModuleEnvironment(rt)
+
+	if err, ok := Eval(program, env).(*object.Error); ok {
+		return nil, err
+	}
+
+	return env.Bindings(), nil
+}
+
+// findModule works out which file an import refers to. Relative paths are
+// looked for first in the directory of the importing file, then in each of
+// the runtime's search paths, and finally in the directories listed in
+// $LANG_PATH.
+func findModule(path, from string, rt *object.Runtime) (string, bool) {
+	candidates := []string{path}
+	if !strings.HasSuffix(path, Extension) {
+		candidates = append(candidates, path+Extension)
+	}
+
+	if filepath.IsAbs(path) {
+		return firstExisting(candidates)
+	}
+
+	dirs := []string{filepath.Dir(from)}
+	dirs = append(dirs, rt.SearchPaths...)
+	dirs = append(dirs, filepath.SplitList(os.Getenv(SearchPathVar))...)
+
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+
+		paths := make([]string, len(candidates))
+		for i, c := range candidates {
+			paths[i] = filepath.Join(dir, c)
+		}
+
+		if file, ok := firstExisting(paths); ok {
+			return file, true
+		}
+	}
+
+	return "", false
+}
+
+func firstExisting(paths []string) (string, bool) {
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+
+	return "", false
+}
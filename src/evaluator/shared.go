@@ -0,0 +1,169 @@
+package evaluator
+
+import (
+	"../object"
+	"sort"
+)
+
+// The functions in this file expose the operations the evaluator performs
+// on objects, so that other ways of running programs, like the virtual
+// machine, behave in exactly the same way.
+
+// ApplyFunction calls a function, builtin, model or method with the given
+// arguments, with this set to thisValue.
+func ApplyFunction(
+	fn object.Object,
+	thisValue object.Object,
+	args []object.Object,
+	env *object.Environment,
+) object.Object {
+	return applyFunctionWithThisValue(fn, thisValue, args, env)
+}
+
+// InfixOperation applies an infix operator, other than '.', to two values.
+func InfixOperation(operator string, left, right object.Object, env *object.Environment) object.Object {
+	return evalInfixExpression(operator, left, right, env)
+}
+
+// PrefixOperation applies a prefix operator to a value.
+func PrefixOperation(operator string, right object.Object, env *object.Environment) object.Object {
+	return evalPrefixExpression(operator, right, env)
+}
+
+// Interpolate joins the values of the parts of an interpolated string.
+func Interpolate(parts []object.Object, env *object.Environment) object.Object {
+	return interpolate(parts, env)
+}
+
+// IndexOperation evaluates left[index].
+func IndexOperation(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// SliceOperation evaluates left[start:end:step], where the bounds which
+// were left out are null.
+func SliceOperation(left, start, end, step object.Object, env *object.Environment) object.Object {
+	return slice(left, []object.Object{start, end, step}, env)
+}
+
+// AssignSlice evaluates obj[start:end:step] = val.
+func AssignSlice(obj, start, end, step, val object.Object, env *object.Environment) object.Object {
+	return assignSlice(obj, []object.Object{start, end, step}, val, env)
+}
+
+// UpdateSlice evaluates obj[start:end:step] op= val.
+func UpdateSlice(obj, start, end, step object.Object, operator string, val object.Object, env *object.Environment) object.Object {
+	return updateSlice(obj, []object.Object{start, end, step}, operator, val, env)
+}
+
+// AssignIndex evaluates obj[index] = val.
+func AssignIndex(obj, index, val object.Object) object.Object {
+	return assignIndex(obj, index, val)
+}
+
+// UpdateIndex evaluates obj[index] op= val, where op is an infix operator.
+func UpdateIndex(obj, index object.Object, operator string, val object.Object, env *object.Environment) object.Object {
+	return updateIndex(obj, index, operator, val, env)
+}
+
+// UnpackArray returns the elements of an array to be destructured by a
+// pattern with count elements, the first required of which have to be
+// there. Missing elements are null, and if rest is true, an array of the
+// remaining elements comes last.
+func UnpackArray(value object.Object, count, required int, rest bool) ([]object.Object, *object.Error) {
+	return unpackArray(value, count, required, rest)
+}
+
+// UnpackHash returns the values of the given keys in a hash to be
+// destructured, and a hash of the other pairs last if rest is true.
+func UnpackHash(value object.Object, keys []string, rest bool) ([]object.Object, *object.Error) {
+	return unpackHash(value, keys, rest)
+}
+
+// MatchArray returns the elements of a value matched by an array pattern
+// with count elements, or false if it doesn't match.
+func MatchArray(value object.Object, count int, rest bool) ([]object.Object, bool) {
+	return matchArray(value, count, rest)
+}
+
+// MatchHash returns the values of the given keys in a value matched by a
+// hash pattern, or false if it doesn't match.
+func MatchHash(value object.Object, keys []string, rest bool) ([]object.Object, bool) {
+	return matchHash(value, keys, rest)
+}
+
+// MatchModel returns the first count properties of a value matched by a
+// model pattern, or false if it isn't an instance of the model.
+func MatchModel(value, model object.Object, count int) ([]object.Object, bool, *object.Error) {
+	return matchModel(value, model, count)
+}
+
+// InRange reports whether a value matches a range pattern from low to
+// high.
+func InRange(value, low, high object.Object, inclusive bool) (bool, *object.Error) {
+	return inRange(value, low, high, inclusive)
+}
+
+// NoMatch returns the error raised when no arm of a match expression
+// matches a value.
+func NoMatch(value object.Object) *object.Error {
+	return noMatch(value)
+}
+
+// AccessField evaluates obj.name.
+func AccessField(obj object.Object, name string) object.Object {
+	return accessField(obj, name)
+}
+
+// AssignField evaluates obj.name = val.
+func AssignField(obj object.Object, name string, val object.Object) object.Object {
+	return assignField(obj, name, val)
+}
+
+// UpdateField evaluates obj.name op= val, where op is an infix operator.
+func UpdateField(obj object.Object, name, operator string, val object.Object, env *object.Environment) object.Object {
+	return updateField(obj, name, operator, val, env)
+}
+
+// HashFromPairs builds a hash from its keys and the corresponding values.
+func HashFromPairs(keys, values []object.Object) object.Object {
+	return hashFromPairs(keys, values)
+}
+
+// IsTruthy reports whether a value counts as true in a condition.
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// LookupBuiltin finds a builtin function or model by name.
+func LookupBuiltin(name string) (object.Object, bool) {
+	if model, ok := object.DefaultModels[name]; ok {
+		return model, true
+	}
+
+	if builtin, ok := builtins[name]; ok {
+		return builtin, true
+	}
+
+	return nil, false
+}
+
+// BuiltinNames returns the names of every builtin function and model, in
+// alphabetical order.
+func BuiltinNames() []string {
+	names := []string{}
+
+	for name := range object.DefaultModels {
+		names = append(names, name)
+	}
+
+	for name := range builtins {
+		if _, ok := object.DefaultModels[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,233 @@
+package evaluator
+
+import (
+	"../ast"
+	"../object"
+)
+
+func evalSliceExpression(se *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(se.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	bounds, err := evalSliceBounds(se, env)
+	if err != nil {
+		return err
+	}
+
+	return slice(left, bounds, env)
+}
+
+// evalSliceBounds evaluates the start, end and step of a slice, which are
+// null if they're left out.
+func evalSliceBounds(se *ast.SliceExpression, env *object.Environment) ([]object.Object, object.Object) {
+	bounds := []object.Object{}
+
+	for _, bound := range []ast.Expression{se.Start, se.End, se.Step} {
+		if bound == nil {
+			bounds = append(bounds, NULL)
+			continue
+		}
+
+		value := Eval(bound, env)
+		if isError(value) {
+			return nil, value
+		}
+
+		bounds = append(bounds, value)
+	}
+
+	return bounds, nil
+}
+
+// slice evaluates left[start:end:step], given the start, end and step. A
+// model can overload slicing with the special method _slice, which is
+// given the three of them.
+func slice(left object.Object, bounds []object.Object, env *object.Environment) object.Object {
+	switch left := left.(type) {
+	case *object.Array, *object.String:
+		elements, _ := sequenceElements(left)
+
+		from, count, step, err := sliceRange(bounds, len(elements))
+		if err != nil {
+			return err
+		}
+
+		result := make([]object.Object, count)
+		for i := range result {
+			result[i] = elements[from+i*step]
+		}
+
+		return newSequence(left, result)
+	case *object.Hash:
+		return callSliceMethod(left, "_slice", bounds, env)
+	default:
+		return newKindError(object.TYPE_ERROR, "slice operator not supported: %s", left.Type())
+	}
+}
+
+// assignSlice evaluates obj[start:end:step] = val. Without a step, the
+// slice is replaced by the elements of val, however many there are, and
+// with one there have to be as many as the slice has. A model can overload
+// it with the special method _set_slice, which is given the bounds and
+// val.
+func assignSlice(obj object.Object, bounds []object.Object, val object.Object, env *object.Environment) object.Object {
+	switch obj := obj.(type) {
+	case *object.Array:
+		values, ok := sequenceElements(val)
+		if !ok {
+			return newKindError(object.TYPE_ERROR, "expected an array to assign to a slice. got %s", val.Type())
+		}
+
+		from, count, step, err := sliceRange(bounds, len(obj.Elements))
+		if err != nil {
+			return err
+		}
+
+		if step == 1 {
+			length := len(obj.Elements) - count + len(values)
+			if err := env.Runtime().CheckAllocation(length); err != nil {
+				return err
+			}
+
+			elements := make([]object.Object, 0, length)
+			elements = append(elements, obj.Elements[:from]...)
+			elements = append(elements, values...)
+			obj.Elements = append(elements, obj.Elements[from+count:]...)
+
+			return obj
+		}
+
+		if len(values) != count {
+			return newKindError(object.INDEX_ERROR,
+				"cannot assign %d elements to a slice of %d elements with a step of %d", len(values), count, step)
+		}
+
+		for i, value := range values {
+			obj.Elements[from+i*step] = value
+		}
+
+		return obj
+	case *object.Hash:
+		return callSliceMethod(obj, "_set_slice", append(bounds, val), env)
+	default:
+		return newKindError(object.TYPE_ERROR, "cannot assign to a slice of a %s", obj.Type())
+	}
+}
+
+// updateSlice evaluates obj[start:end:step] op= right.
+func updateSlice(obj object.Object, bounds []object.Object, operator string, right object.Object, env *object.Environment) object.Object {
+	current := slice(obj, bounds, env)
+	if isError(current) {
+		return current
+	}
+
+	result := evalInfixExpression(operator, current, right, env)
+	if isError(result) {
+		return result
+	}
+
+	return assignSlice(obj, bounds, result, env)
+}
+
+func callSliceMethod(hash *object.Hash, name string, args []object.Object, env *object.Environment) object.Object {
+	o := hash.Get(name)
+	if _, ok := o.(*object.Null); ok {
+		return newError("slicing not overloaded. to overload, use the special method %v", name)
+	}
+
+	method, ok := o.(*object.MethodInstance)
+	if !ok {
+		return newError("%v must be a method, not a property", name)
+	}
+
+	return applyFunctionWithThisValue(method, hash, args, env)
+}
+
+// sliceRange works out which elements of a sequence of the given length a
+// slice takes: count of them, starting at from and going up by step. Like
+// indices, negative bounds count back from the end, but bounds past either
+// end are moved to it rather than being errors. A negative step goes
+// backwards, from the end of the sequence by default.
+func sliceRange(bounds []object.Object, length int) (from, count, step int, err *object.Error) {
+	step = 1
+	if bounds[2].Type() != object.NULL_OBJ {
+		s, ok := bounds[2].(*object.Integer)
+		if !ok {
+			return 0, 0, 0, newKindError(object.INDEX_ERROR, "expected an integer for the step of a slice. got %v",
+				bounds[2].Inspect())
+		}
+
+		if s.Value == 0 {
+			return 0, 0, 0, newKindError(object.INDEX_ERROR, "the step of a slice cannot be zero")
+		}
+
+		// a step further than the length only ever takes one element
+		switch {
+		case s.Value > int64(length):
+			step = length + 1
+		case s.Value < -int64(length):
+			step = -length - 1
+		default:
+			step = int(s.Value)
+		}
+	}
+
+	lower, upper := 0, length
+	if step < 0 {
+		lower, upper = -1, length-1
+	}
+
+	var start, end int
+	if step > 0 {
+		start, end = lower, upper
+	} else {
+		start, end = upper, lower
+	}
+
+	if start, err = sliceBound(bounds[0], start, lower, upper, length); err != nil {
+		return 0, 0, 0, err
+	}
+
+	if end, err = sliceBound(bounds[1], end, lower, upper, length); err != nil {
+		return 0, 0, 0, err
+	}
+
+	switch {
+	case step > 0 && end > start:
+		count = (end-start-1)/step + 1
+	case step < 0 && start > end:
+		count = (start-end-1)/-step + 1
+	}
+
+	return start, count, step, nil
+}
+
+// sliceBound resolves the start or end of a slice, which is def if it's
+// null, and is kept between lower and upper.
+func sliceBound(bound object.Object, def, lower, upper, length int) (int, *object.Error) {
+	if bound.Type() == object.NULL_OBJ {
+		return def, nil
+	}
+
+	i, ok := bound.(*object.Integer)
+	if !ok {
+		return 0, newKindError(object.INDEX_ERROR, "expected an integer for the bound of a slice. got %v",
+			bound.Inspect())
+	}
+
+	b := i.Value
+	if b < 0 {
+		b += int64(length)
+	}
+
+	switch {
+	case b < int64(lower):
+		return lower, nil
+	case b > int64(upper):
+		return upper, nil
+	default:
+		return int(b), nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"./format"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// runFmt runs the fmt subcommand, which formats the files it's given, or
+// the .lang files in the directories it's given, or else standard input.
+// It returns the status to exit with: 1 if -check found unformatted files,
+// and 2 if anything couldn't be formatted.
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the formatted code back to the files")
+	check := flags.Bool("check", false, "list the files which aren't formatted, without changing them")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s fmt [-w | -check] [path ...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "cannot use -w with standard input")
+			return 2
+		}
+
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		formatted, err := format.Source(string(src))
+		if err != nil {
+			printFmtError("<stdin>", err)
+			return 2
+		}
+
+		if *check {
+			if formatted != string(src) {
+				fmt.Println("<stdin>")
+				return 1
+			}
+
+			return 0
+		}
+
+		io.WriteString(os.Stdout, formatted)
+		return 0
+	}
+
+	status := 0
+
+	for _, path := range flags.Args() {
+		files, err := langFiles(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		for _, file := range files {
+			s := fmtFile(file, *write, *check)
+			if s > status {
+				status = s
+			}
+		}
+	}
+
+	return status
+}
+
+// langFiles returns the path if it's a file, or the .lang files inside it
+// if it's a directory.
+func langFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files := []string{}
+
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && filepath.Ext(file) == ".lang" {
+			files = append(files, file)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+func fmtFile(file string, write, check bool) int {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	formatted, err := format.Source(string(src))
+	if err != nil {
+		printFmtError(file, err)
+		return 2
+	}
+
+	changed := !bytes.Equal(src, []byte(formatted))
+
+	switch {
+	case check:
+		if changed {
+			fmt.Println(file)
+			return 1
+		}
+	case write:
+		if changed {
+			if err := ioutil.WriteFile(file, []byte(formatted), 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		}
+	default:
+		io.WriteString(os.Stdout, formatted)
+	}
+
+	return 0
+}
+
+func printFmtError(file string, err error) {
+	if perr, ok := err.(*format.ParseError); ok {
+		for _, e := range perr.Errors {
+			fmt.Fprintf(os.Stderr, "%s:%v\n", file, e)
+		}
+
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+}
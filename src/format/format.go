@@ -0,0 +1,775 @@
+// Package format lays out source code in the standard style: statements on
+// their own lines without semicolons, blocks indented by two spaces, spaces
+// around operators, and only the parentheses which are needed. Comments are kept, and so are
+// single blank lines between statements.
+package format
+
+import (
+	"../ast"
+	"../lexer"
+	"../parser"
+	"../token"
+	"bytes"
+	"strings"
+)
+
+const indent = "  "
+
+// ParseError is returned when the source can't be formatted because it
+// doesn't parse.
+type ParseError struct {
+	Errors []*parser.Error
+}
+
+func (e *ParseError) Error() string {
+	msgs := []string{}
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Source formats a program. Formatting its own output gives the same
+// output again.
+func Source(src string) (string, error) {
+	l := lexer.New(src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", &ParseError{Errors: p.ErrorList()}
+	}
+
+	f := &formatter{
+		lines:    strings.Split(src, "\n"),
+		comments: l.Comments(),
+	}
+
+	f.statements(program.Statements, token.Position{})
+	f.flushComments(-1)
+
+	out := strings.TrimRight(f.out.String(), "\n")
+	if out == "" {
+		return "", nil
+	}
+
+	return out + "\n", nil
+}
+
+type formatter struct {
+	out bytes.Buffer
+
+	// lines are the lines of the source, for checking where it has blank
+	// lines
+	lines []string
+
+	// comments are the comments which haven't been written yet
+	comments []token.Token
+
+	depth       int
+	atLineStart bool
+}
+
+// write writes some text, indenting it if it starts a line.
+func (f *formatter) write(s string) {
+	if f.atLineStart || f.out.Len() == 0 {
+		f.out.WriteString(strings.Repeat(indent, f.depth))
+		f.atLineStart = false
+	}
+
+	f.out.WriteString(s)
+}
+
+func (f *formatter) newline() {
+	f.out.WriteString("\n")
+	f.atLineStart = true
+}
+
+// blankLine writes an empty line, unless there's one already or this is the
+// start of a block.
+func (f *formatter) blankLine() {
+	b := f.out.Bytes()
+	if len(b) == 0 || bytes.HasSuffix(b, []byte("\n\n")) || bytes.HasSuffix(b, []byte("{\n")) ||
+		bytes.HasSuffix(b, []byte("[\n")) {
+		return
+	}
+
+	f.out.WriteString("\n")
+}
+
+// blankBefore reports whether the source has a blank line before pos.
+func (f *formatter) blankBefore(pos token.Position) bool {
+	line := pos.Line - 2
+	return line >= 0 && line < len(f.lines) && strings.TrimSpace(f.lines[line]) == ""
+}
+
+// flushComments writes the comments which come before the offset, or all of
+// them if it's negative. It's only called at the start of a line. A comment
+// which came after some code on the same line is put back at the end of the
+// line before.
+func (f *formatter) flushComments(offset int) {
+	for len(f.comments) > 0 && (offset < 0 || f.comments[0].Pos.Offset < offset) {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+
+		if f.isTrailing(c) && f.out.Len() > 0 {
+			f.out.Truncate(f.out.Len() - 1)
+			f.out.WriteString(" " + c.Literal + "\n")
+			continue
+		}
+
+		if f.blankBefore(c.Pos) {
+			f.blankLine()
+		}
+
+		f.write(c.Literal)
+		f.newline()
+	}
+}
+
+// isTrailing reports whether a comment came after some code on its line.
+func (f *formatter) isTrailing(c token.Token) bool {
+	line := f.lines[c.Pos.Line-1]
+	before := line[:c.Pos.Offset-lineStart(f.lines, c.Pos.Line)]
+
+	return strings.TrimSpace(before) != ""
+}
+
+// hasComments reports whether any comments haven't been written yet which
+// come before the offset.
+func (f *formatter) hasComments(offset int) bool {
+	return len(f.comments) > 0 && f.comments[0].Pos.Offset < offset
+}
+
+func lineStart(lines []string, line int) int {
+	offset := 0
+	for _, l := range lines[:line-1] {
+		offset += len(l) + 1
+	}
+
+	return offset
+}
+
+// statements writes a list of statements, each on its own line, followed by
+// any comments before end.
+func (f *formatter) statements(stmts []ast.Statement, end token.Position) {
+	for _, stmt := range stmts {
+		pos := startOf(stmt)
+
+		f.flushComments(pos.Offset)
+
+		if f.blankBefore(pos) {
+			f.blankLine()
+		}
+
+		f.statement(stmt)
+		f.newline()
+	}
+
+	if end.IsValid() {
+		f.flushComments(end.Offset)
+	}
+}
+
+func (f *formatter) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		f.expr(stmt.Expression, parser.LOWEST)
+	case *ast.ReturnStatement:
+		// a return without a value is given a null with no literal
+		if null, ok := stmt.ReturnValue.(*ast.Null); ok && null.Token.Literal == "" {
+			f.write("return")
+		} else {
+			f.write("return ")
+			f.expr(stmt.ReturnValue, parser.LOWEST)
+		}
+	case *ast.BreakStatement:
+		f.write("break")
+	case *ast.NextStatement:
+		f.write("next")
+	}
+}
+
+// block writes a block, keeping it on one line if it was on one line in
+// the source and still fits on one.
+func (f *formatter) block(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 && !f.hasComments(block.End.Offset) {
+		f.write("{}")
+		return
+	}
+
+	if block.Token.Pos.Line == block.End.Line && len(block.Statements) == 1 &&
+		!f.hasComments(block.End.Offset) {
+		mark := f.out.Len()
+
+		f.write("{ ")
+		f.statement(block.Statements[0])
+		f.write(" }")
+
+		if !strings.Contains(f.out.String()[mark:], "\n") {
+			return
+		}
+
+		f.out.Truncate(mark)
+	}
+
+	f.write("{")
+	f.newline()
+
+	f.depth++
+	f.statements(block.Statements, block.End)
+	f.depth--
+
+	f.write("}")
+}
+
+// precedence returns how tightly an expression binds, as a parser
+// precedence. Expressions which end with another expression bind as
+// loosely as that expression is parsed.
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(e.Token.Type)
+	case *ast.DeclareExpression, *ast.AssignExpression, *ast.LambdaExpression, *ast.ImportExpression, *ast.DefaultPattern:
+		return parser.ASSIGN
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	default:
+		return parser.INDEX + 1
+	}
+}
+
+// expr writes an expression, in parentheses if it binds less tightly than
+// min.
+func (f *formatter) expr(e ast.Expression, min int) {
+	if precedence(e) < min {
+		f.write("(")
+		defer f.write(")")
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		f.write(e.Value)
+	case *ast.IntegerLiteral:
+		f.write(e.Token.Literal)
+	case *ast.NumberLiteral:
+		f.write(e.Token.Literal)
+	case *ast.StringLiteral:
+		if e.Token.Type == token.RAW_STRING {
+			f.write("`" + e.Value + "`")
+		} else {
+			f.write(lexer.Quote(e.Value))
+		}
+	case *ast.CharLiteral:
+		f.write(lexer.QuoteChar(e.Value))
+	case *ast.InterpolatedString:
+		f.interpolated(e)
+	case *ast.Boolean:
+		f.write(e.Token.Literal)
+	case *ast.Null:
+		f.write("null")
+	case *ast.PrefixExpression:
+		f.write(e.Operator)
+		f.expr(e.Right, parser.PREFIX)
+	case *ast.InfixExpression:
+		f.infix(e)
+	case *ast.DeclareExpression:
+		f.expr(e.Name, parser.ASSIGN+1)
+		f.write(" := ")
+		f.expr(e.Value, parser.ASSIGN)
+	case *ast.AssignExpression:
+		f.expr(e.Name, parser.ASSIGN+1)
+		f.write(" " + e.Operator + "= ")
+		f.expr(e.Value, parser.ASSIGN)
+	case *ast.CallExpression:
+		f.expr(e.Function, parser.CALL)
+		f.write("(")
+		if f.hasComments(e.End.Offset) {
+			f.multiline(e.Arguments, e.End)
+		} else {
+			f.list(e.Arguments)
+		}
+		f.write(")")
+	case *ast.IndexExpression:
+		f.expr(e.Left, parser.CALL)
+		f.write("[")
+		f.expr(e.Index, parser.LOWEST)
+		f.write("]")
+	case *ast.SliceExpression:
+		f.expr(e.Left, parser.CALL)
+		f.write("[")
+		f.sliceBound(e.Start)
+		f.write(":")
+		f.sliceBound(e.End)
+		if e.Step != nil {
+			f.write(":")
+			f.sliceBound(e.Step)
+		}
+		f.write("]")
+	case *ast.ArrayLiteral:
+		f.array(e)
+	case *ast.HashLiteral:
+		f.hash(e)
+	case *ast.ArrayPattern:
+		f.write("[")
+		f.list(e.Elements)
+		f.rest(len(e.Elements) > 0, e.Rest)
+		f.write("]")
+	case *ast.HashPattern:
+		f.write("{")
+		for i, field := range e.Fields {
+			if i > 0 {
+				f.write(", ")
+			}
+
+			if field.Shorthand() {
+				f.expr(field.Value, parser.LOWEST)
+			} else {
+				f.pair(field.Key, field.Value)
+			}
+		}
+		f.rest(len(e.Fields) > 0, e.Rest)
+		f.write("}")
+	case *ast.DefaultPattern:
+		f.expr(e.Target, parser.ASSIGN+1)
+		f.write(" = ")
+		f.expr(e.Default, parser.ASSIGN)
+	case *ast.RestElement:
+		f.write("..." + e.Name.Value)
+	case *ast.ModelPattern:
+		f.expr(e.Model, parser.CALL)
+		f.write("(")
+		f.list(e.Arguments)
+		f.write(")")
+	case *ast.MatchExpression:
+		f.match(e)
+	case *ast.IfExpression:
+		f.ifExpr(e)
+	case *ast.WhileExpression:
+		f.write("while (")
+		f.expr(e.Condition, parser.LOWEST)
+		f.write(") ")
+		f.block(e.Body)
+	case *ast.ForExpression:
+		f.write("for (" + e.Var.Value)
+		if e.Element != nil {
+			f.write(", ")
+			f.expr(e.Element, parser.LOWEST)
+		}
+		f.write(" | ")
+		f.expr(e.Set, parser.LOWEST)
+		f.write(") ")
+		f.block(e.Body)
+	case *ast.TryExpression:
+		f.tryExpr(e)
+	case *ast.FunctionLiteral:
+		f.write("fn ")
+		f.params(e.Parameters, e.Patterns)
+		f.write(" ")
+		f.block(e.Body)
+	case *ast.LambdaExpression:
+		f.write("\\")
+		f.params(e.Parameters, e.Patterns)
+		f.write(" = ")
+		f.expr(e.Body, parser.ASSIGN)
+	case *ast.ModelLiteral:
+		f.write("model ")
+		f.params(e.Parameters, nil)
+
+		if e.ParentName != nil {
+			f.write(" : ")
+			f.expr(*e.ParentName, parser.INDEX+1)
+			f.write(" (")
+			f.list(e.ParentArgs)
+			f.write(")")
+		}
+	case *ast.ImportExpression:
+		f.write("import ")
+
+		if len(e.Names) > 0 {
+			names := []string{}
+			for _, name := range e.Names {
+				names = append(names, name.Value)
+			}
+
+			f.write(strings.Join(names, ", ") + " from ")
+		}
+
+		f.expr(e.Path, parser.LOWEST)
+	}
+}
+
+// operators which are written without spaces around them
+var tightOperators = map[string]bool{
+	".":   true,
+	"..":  true,
+	"..<": true,
+}
+
+func (f *formatter) infix(e *ast.InfixExpression) {
+	prec := parser.Precedence(e.Token.Type)
+
+	// operators are left associative, so the right operand needs brackets
+	// if it binds the same as the operator
+	f.expr(e.Left, prec)
+
+	if tightOperators[e.Operator] {
+		f.write(e.Operator)
+	} else {
+		f.write(" " + e.Operator + " ")
+	}
+
+	f.expr(e.Right, prec+1)
+}
+
+func (f *formatter) list(exprs []ast.Expression) {
+	for i, e := range exprs {
+		if i > 0 {
+			f.write(", ")
+		}
+
+		f.expr(e, parser.LOWEST)
+	}
+}
+
+// sliceBound writes the start, end or step of a slice, if it isn't left
+// out.
+func (f *formatter) sliceBound(bound ast.Expression) {
+	if bound != nil {
+		f.expr(bound, parser.LOWEST)
+	}
+}
+
+// params writes a parameter list, where the parameters which are patterns
+// are taken from patterns instead.
+func (f *formatter) params(params []*ast.Identifier, patterns []ast.Expression) {
+	f.write("(")
+
+	for i, p := range params {
+		if i > 0 {
+			f.write(", ")
+		}
+
+		if patterns != nil && patterns[i] != nil {
+			f.expr(patterns[i], parser.LOWEST)
+		} else {
+			f.write(p.Value)
+		}
+	}
+
+	f.write(")")
+}
+
+// rest writes the "...name" at the end of a pattern, if there is one.
+func (f *formatter) rest(comma bool, name *ast.Identifier) {
+	if name == nil {
+		return
+	}
+
+	if comma {
+		f.write(", ")
+	}
+
+	f.write("..." + name.Value)
+}
+
+// multiline writes a list of expressions with each on its own line, and the
+// comments between them on the lines they were on, up to the closing
+// bracket at end.
+func (f *formatter) multiline(exprs []ast.Expression, end token.Position) {
+	f.newline()
+	f.depth++
+
+	for i, e := range exprs {
+		f.flushComments(startOf(e).Offset)
+
+		f.expr(e, parser.LOWEST)
+		if i < len(exprs)-1 {
+			f.write(",")
+		}
+
+		f.newline()
+	}
+
+	f.flushComments(end.Offset)
+
+	f.depth--
+}
+
+// array writes an array literal, with an element on each line if the first
+// element was on a different line to the bracket in the source, or there
+// are comments inside it.
+func (f *formatter) array(e *ast.ArrayLiteral) {
+	f.write("[")
+	if len(e.Elements) > 0 && (startOf(e.Elements[0]).Line != e.Token.Pos.Line || f.hasComments(e.End.Offset)) {
+		f.multiline(e.Elements, e.End)
+	} else {
+		f.list(e.Elements)
+	}
+	f.write("]")
+}
+
+// hash writes a hash literal, with its pairs in the order they were written
+// in, and a pair on each line if the first key was on a different line to
+// the brace in the source, or there are comments inside it.
+func (f *formatter) hash(e *ast.HashLiteral) {
+	keys := e.Keys()
+
+	if len(keys) == 0 {
+		f.write("{}")
+		return
+	}
+
+	if startOf(keys[0]).Line == e.Token.Pos.Line && !f.hasComments(e.End.Offset) {
+		f.write("{")
+		for i, key := range keys {
+			if i > 0 {
+				f.write(", ")
+			}
+
+			f.pair(key, e.Pairs[key])
+		}
+		f.write("}")
+		return
+	}
+
+	f.write("{")
+	f.newline()
+	f.depth++
+
+	for i, key := range keys {
+		f.flushComments(startOf(key).Offset)
+
+		f.pair(key, e.Pairs[key])
+		if i < len(keys)-1 {
+			f.write(",")
+		}
+
+		f.newline()
+	}
+
+	f.flushComments(e.End.Offset)
+
+	f.depth--
+	f.write("}")
+}
+
+func (f *formatter) pair(key, value ast.Expression) {
+	f.expr(key, parser.LOWEST)
+	f.write(": ")
+	f.expr(value, parser.LOWEST)
+}
+
+// match writes a match expression, with an arm on each line unless the
+// first arm was on the same line as the match in the source.
+func (f *formatter) match(e *ast.MatchExpression) {
+	f.write("match (")
+	f.expr(e.Subject, parser.LOWEST)
+	f.write(")")
+
+	if startOf(e.Arms[0].Pattern).Line == e.Token.Pos.Line {
+		f.write(" { ")
+		for i, arm := range e.Arms {
+			if i > 0 {
+				f.write(", ")
+			}
+
+			f.arm(arm)
+		}
+		f.write(" }")
+		return
+	}
+
+	f.write(" {")
+	f.newline()
+	f.depth++
+
+	for i, arm := range e.Arms {
+		f.flushComments(startOf(arm.Pattern).Offset)
+
+		f.arm(arm)
+		if i < len(e.Arms)-1 {
+			f.write(",")
+		}
+
+		f.newline()
+	}
+
+	f.flushComments(e.End.Offset)
+
+	f.depth--
+	f.write("}")
+}
+
+func (f *formatter) arm(arm *ast.MatchArm) {
+	f.expr(arm.Pattern, parser.LOWEST)
+
+	if arm.Guard != nil {
+		f.write(" if ")
+		f.expr(arm.Guard, parser.LOWEST)
+	}
+
+	f.write(" => ")
+
+	if arm.Body.Token.Type == token.LBRACE {
+		f.block(arm.Body)
+	} else {
+		f.expr(arm.Body.Statements[0].(*ast.ExpressionStatement).Expression, parser.LOWEST)
+	}
+}
+
+func (f *formatter) ifExpr(e *ast.IfExpression) {
+	if e.Token.Type == token.ELIF {
+		f.write("elif (")
+	} else {
+		f.write("if (")
+	}
+
+	f.expr(e.Condition, parser.LOWEST)
+	f.write(") ")
+	f.block(e.Consequence)
+
+	if e.Alternative == nil {
+		return
+	}
+
+	// elif is parsed as an else block holding another if expression
+	if len(e.Alternative.Statements) == 1 {
+		if stmt, ok := e.Alternative.Statements[0].(*ast.ExpressionStatement); ok {
+			if elif, ok := stmt.Expression.(*ast.IfExpression); ok && elif.Token.Type == token.ELIF {
+				f.write(" ")
+				f.ifExpr(elif)
+				return
+			}
+		}
+	}
+
+	f.write(" else ")
+	f.block(e.Alternative)
+}
+
+func (f *formatter) tryExpr(e *ast.TryExpression) {
+	f.write("try ")
+	f.block(e.Body)
+
+	if e.Catch != nil {
+		f.write(" catch ")
+
+		if e.ErrName != nil {
+			f.write("(" + e.ErrName.Value + ") ")
+		}
+
+		f.block(e.Catch)
+	}
+
+	if e.Finally != nil {
+		f.write(" finally ")
+		f.block(e.Finally)
+	}
+}
+
+// startOf returns the position a node starts at. Infix expressions, calls
+// and so on have the position of their operator, rather than where their
+// first operand starts.
+func startOf(node ast.Node) token.Position {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		if node.Expression != nil {
+			return startOf(node.Expression)
+		}
+	case *ast.InfixExpression:
+		return startOf(node.Left)
+	case *ast.DeclareExpression:
+		return startOf(node.Name)
+	case *ast.AssignExpression:
+		return startOf(node.Name)
+	case *ast.CallExpression:
+		return startOf(node.Function)
+	case *ast.IndexExpression:
+		return startOf(node.Left)
+	case *ast.SliceExpression:
+		return startOf(node.Left)
+	}
+
+	return node.Pos()
+}
+
+// interpolated writes a string with expressions in it. A triple quoted
+// string stays one, with its lines indented one level further than the
+// code around it, unless its value couldn't be written like that.
+func (f *formatter) interpolated(s *ast.InterpolatedString) {
+	multiline := s.Token.Literal == `"""` && canIndent(s)
+
+	if multiline {
+		f.write(`"""`)
+		f.newline()
+		f.depth++
+	} else {
+		f.write(`"`)
+	}
+
+	for _, part := range s.Parts {
+		str, ok := part.(*ast.StringLiteral)
+		if !ok {
+			f.write("${")
+			f.expr(part, parser.LOWEST)
+			f.write("}")
+			continue
+		}
+
+		for i, line := range strings.Split(lexer.Escape(str.Value, multiline), "\n") {
+			if i > 0 {
+				f.newline()
+			}
+
+			if line != "" {
+				f.write(line)
+			}
+		}
+	}
+
+	if multiline {
+		f.depth--
+		if len(s.Parts) > 0 {
+			f.newline()
+		}
+		f.write(`"""`)
+	} else {
+		f.write(`"`)
+	}
+}
+
+// canIndent reports whether a triple quoted string keeps its value when its
+// lines are all indented, which it doesn't if they already all are, or if
+// some are only whitespace, since that would be stripped.
+func canIndent(s *ast.InterpolatedString) bool {
+	text := ""
+	for _, part := range s.Parts {
+		if str, ok := part.(*ast.StringLiteral); ok {
+			text += str.Value
+		} else {
+			text += "${}"
+		}
+	}
+
+	indented := true
+	found := false
+
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+
+		if strings.TrimLeft(line, " \t") == "" {
+			return false
+		}
+
+		found = true
+		indented = indented && (line[0] == ' ' || line[0] == '\t')
+	}
+
+	return !found || !indented
+}
@@ -0,0 +1,178 @@
+package format
+
+import (
+	"../lexer"
+	"../parser"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x:=1+2*3;", "x := 1 + 2 * 3\n"},
+		{"y = ((1 + 2)) * 3;", "y = (1 + 2) * 3\n"},
+		{"z := (1 - 2) - (3 - 4);", "z := 1 - 2 - (3 - 4)\n"},
+		{"a := [1,2,3]; r := 1 .. 10;", "a := [1, 2, 3]\nr := 1..10\n"},
+		{"a.b.c(1)[2] = -(-x);", "a.b.c(1)[2] = --x\n"},
+		{"x+=1; a[i]<<=(b+1);", "x += 1\na[i] <<= b + 1\n"},
+		{"b := a[1:n-1]; c := a[ : ]; a[::-(1)] = b;", "b := a[1:n - 1]\nc := a[:]\na[::-1] = b\n"},
+		{"f := fn(a,b){return a+b;};", "f := fn (a, b) { return a + b }\n"},
+		{"f := fn(){\nreturn;\n};", "f := fn () {\n  return\n}\n"},
+		{"g := \\(x) = x*2;", "g := \\(x) = x * 2\n"},
+		{"[a,b=1,...c]:=d; {x,y:[z],...w}=h;", "[a, b = 1, ...c] := d\n{x, y: [z], ...w} = h\n"},
+		{"f := fn([a,b],{c=1}){};", "f := fn ([a, b], {c = 1}) {}\n"},
+		{"match x {1=>a,[b,...c] if b>0=>{ print(b); },vec(d,_)=>d};", "match (x) { 1 => a, [b, ...c] if b > 0 => { print(b) }, vec(d, _) => d }\n"},
+		{"match x {\n1..9=>a\n# other\n_=>b\n}", "match (x) {\n  1..9 => a,\n  # other\n  _ => b\n}\n"},
+		{"h := {\"a\": 1, \"b\": 2,};", "h := {\"a\": 1, \"b\": 2}\n"},
+		{
+			"h := {\n    \"b\": 1,\n\"a\": fn(x){\nx;\n}};",
+			"h := {\n  \"b\": 1,\n  \"a\": fn (x) {\n    x\n  }\n}\n",
+		},
+		{
+			"if(x){a;}elif(y){b;}else{\nc;\n};",
+			"if (x) { a } elif (y) { b } else {\n  c\n}\n",
+		},
+		{
+			"try {\n1/0;\n} catch (e) {\nnext;\n} finally {};",
+			"try {\n  1 / 0\n} catch (e) {\n  next\n} finally {}\n",
+		},
+		{"for (i|xs) { break; };", "for (i | xs) { break }\n"},
+		{"for (i,[a,b]|xs) { a; };", "for (i, [a, b] | xs) { a }\n"},
+		{"m := model (a) : p (a, 1);", "m := model (a) : p (a, 1)\n"},
+		{"import x, y from \"lib\";", "import x, y from \"lib\"\n"},
+		{"s := \"a\\\"b\\n\\\\\";", "s := \"a\\\"b\\n\\\\\"\n"},
+		{"s := \"x = ${x+1}, \\${y}\" + `\\n`;", "s := \"x = ${x + 1}, \\${y}\" + `\\n`\n"},
+		{
+			"f := fn () {\ns := \"\"\"\n    a \"\"\\\"\n\n      ${b}\n\"\"\";\n};",
+			"f := fn () {\n  s := \"\"\"\n    a \\\"\"\"\n\n      ${b}\n  \"\"\"\n}\n",
+		},
+		{"s := \"\"\"  a\n  b\"\"\"; t := \"\"\"  c\"\"\";", "s := \"\"\"\n    a\n  b\n\"\"\"\nt := \"  c\"\n"},
+		{`c := ['a','\'','"','\u{9}','\u{e9}'];`, `c := ['a', '\'', '"', '\t', 'é']` + "\n"},
+		{"a;\n\n\n\nb;", "a\n\nb\n"},
+		{"f := fn () {\n\n  a;\n\n};", "f := fn () {\n  a\n}\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("unexpected error formatting %q: %v", tt.input, err)
+			continue
+		}
+
+		if out != tt.expected {
+			t.Errorf("formatting %q: expected\n%s\ngot\n%s", tt.input, tt.expected, out)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `# header
+x := 1;   # after x
+
+# about f
+f := fn () {
+    a; # after a
+  # before the end
+};
+h := {
+  # first
+  "a": 1,
+  "b": 2 # second
+};
+f(1, # first
+  2) # after
+a := [1, # one
+  2];
+`
+
+	expected := `# header
+x := 1 # after x
+
+# about f
+f := fn () {
+  a # after a
+  # before the end
+}
+h := {
+  # first
+  "a": 1,
+  "b": 2 # second
+}
+f(
+  1, # first
+  2
+) # after
+a := [
+  1, # one
+  2
+]
+`
+
+	out, err := Source(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	files, err := filepath.Glob("../../examples/*.lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		once, err := Source(string(src))
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		twice, err := Source(once)
+		if err != nil {
+			t.Errorf("%s: formatted code doesn't parse: %v", file, err)
+			continue
+		}
+
+		if once != twice {
+			t.Errorf("%s: formatting isn't idempotent:\n%s\nthen\n%s", file, once, twice)
+		}
+
+		// leaving out the semicolons mustn't change what the program means
+		if parse(string(src)) != parse(once) {
+			t.Errorf("%s: formatting changed the program:\n%s", file, once)
+		}
+
+		if strings.TrimSpace(once) == "" {
+			t.Errorf("%s: formatted to nothing", file)
+		}
+	}
+}
+
+func parse(src string) string {
+	return parser.New(lexer.New(src)).ParseProgram().String()
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Source("x := (;")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if perr, ok := err.(*ParseError); !ok || len(perr.Errors) == 0 {
+		t.Errorf("expected a ParseError, got %#v", err)
+	}
+}
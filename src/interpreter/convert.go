@@ -0,0 +1,472 @@
+package interpreter
+
+import (
+	"../evaluator"
+	"../object"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	objectType    = reflect.TypeOf((*object.Object)(nil)).Elem()
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// converter converts between Go values and objects. Structs of registered
+// types are converted to and from instances of their models.
+type converter struct {
+	models map[reflect.Type]*object.Model
+}
+
+// ToObject converts a Go value to an object. Numbers, strings and bools
+// become their object equivalents, slices and arrays become arrays, maps
+// with string keys and structs become hashes, functions become builtins
+// and nil becomes null. Objects are returned as they are.
+func ToObject(v interface{}) (object.Object, error) {
+	return converter{}.toObject(reflect.ValueOf(v))
+}
+
+// FromObject converts an object to a Go value: integers become int64s,
+// other numbers become float64s, strings become strings, characters become
+// runes, booleans become bools, arrays become []interface{}, hashes become
+// map[string]interface{} and null becomes nil. Hashes with keys which
+// aren't strings become map[interface{}]interface{} instead, with arrays in
+// their keys as Go arrays, like [2]interface{}, so they can be compared.
+// Other objects, like functions and models, are returned as they are.
+func FromObject(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value
+	case *object.Number:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Char:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return nil
+	case *object.Array:
+		elems := make([]interface{}, len(obj.Elements))
+		for i, e := range obj.Elements {
+			elems[i] = FromObject(e)
+		}
+
+		return elems
+	case *object.Hash:
+		if !stringKeys(obj) {
+			pairs := make(map[interface{}]interface{}, obj.Len())
+			for _, pair := range obj.Pairs() {
+				pairs[fromKey(pair.Key)] = FromObject(pair.Value)
+			}
+
+			return pairs
+		}
+
+		pairs := make(map[string]interface{}, obj.Len())
+		for _, pair := range obj.Pairs() {
+			pairs[pair.Key.(*object.String).Value] = FromObject(pair.Value)
+		}
+
+		return pairs
+	default:
+		return obj
+	}
+}
+
+func stringKeys(hash *object.Hash) bool {
+	for _, pair := range hash.Pairs() {
+		if _, ok := pair.Key.(*object.String); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fromKey converts a key of a hash like FromObject, except that arrays
+// become Go arrays rather than slices, since slices can't be map keys.
+func fromKey(key object.Object) interface{} {
+	arr, ok := key.(*object.Array)
+	if !ok {
+		return FromObject(key)
+	}
+
+	elems := reflect.New(reflect.ArrayOf(len(arr.Elements), interfaceType)).Elem()
+	for i, e := range arr.Elements {
+		if elem := fromKey(e); elem != nil {
+			elems.Index(i).Set(reflect.ValueOf(elem))
+		}
+	}
+
+	return elems.Interface()
+}
+
+// Decode converts an object into the Go value pointed to by target, which
+// can be of any type that ToObject accepts.
+func Decode(obj object.Object, target interface{}) error {
+	return converter{}.decodeInto(obj, target)
+}
+
+func (c converter) decodeInto(obj object.Object, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("expected a non-nil pointer to decode into, got %T", target)
+	}
+
+	val, err := c.decode(obj, ptr.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	ptr.Elem().Set(val)
+	return nil
+}
+
+func (c converter) toObject(v reflect.Value) (object.Object, error) {
+	if !v.IsValid() {
+		return evaluator.NULL, nil
+	}
+
+	if v.Type().Implements(objectType) && v.Kind() != reflect.Interface {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert %v: it's too large for an integer", v.Uint())
+		}
+
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Number{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+
+		elems := make([]object.Object, v.Len())
+		for i := range elems {
+			elem, err := c.toObject(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+
+			elems[i] = elem
+		}
+
+		return &object.Array{Elements: elems}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+
+		// the keys are sorted, so the hash is in the same order every time
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+
+		hash := object.NewHash(object.OBJECT_MODEL)
+		for _, key := range keys {
+			k, err := c.toObject(key)
+			if err != nil {
+				return nil, err
+			}
+
+			val, err := c.toObject(v.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+
+			if err := hash.Insert(k, val); err != nil {
+				return nil, fmt.Errorf("cannot convert a %v to an object: %s", v.Type(), err.Message)
+			}
+		}
+
+		return hash, nil
+	case reflect.Struct:
+		return c.structToObject(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+
+		return c.toObject(v.Elem())
+	case reflect.Func:
+		return c.wrapFunc("<go function>", v)
+	default:
+		return nil, fmt.Errorf("cannot convert a %v to an object", v.Type())
+	}
+}
+
+func (c converter) structToObject(v reflect.Value) (object.Object, error) {
+	model, ok := c.models[v.Type()]
+	if !ok {
+		model = object.OBJECT_MODEL
+	}
+
+	hash := object.NewHash(model)
+
+	for _, field := range structFields(v.Type()) {
+		val, err := c.toObject(v.FieldByIndex(field.index))
+		if err != nil {
+			return nil, err
+		}
+
+		hash.Set(field.name, val)
+	}
+
+	return hash, nil
+}
+
+func (c converter) decode(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface {
+		if t.NumMethod() == 0 {
+			if obj == nil {
+				return reflect.Zero(t), nil
+			}
+
+			return valueOf(FromObject(obj), t), nil
+		}
+
+		if reflect.TypeOf(obj).Implements(t) {
+			return reflect.ValueOf(obj).Convert(t), nil
+		}
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := obj.(*object.Number); ok {
+			return reflect.Value{}, fmt.Errorf("expected an integer, got %v", n.Inspect())
+		}
+
+		if n, ok := obj.(*object.Integer); ok {
+			val := reflect.New(t).Elem()
+			if isUnsigned(t) {
+				if n.Value < 0 || val.OverflowUint(uint64(n.Value)) {
+					return reflect.Value{}, fmt.Errorf("%v is out of range for a %v", n.Inspect(), t)
+				}
+
+				val.SetUint(uint64(n.Value))
+			} else {
+				if val.OverflowInt(n.Value) {
+					return reflect.Value{}, fmt.Errorf("%v is out of range for a %v", n.Inspect(), t)
+				}
+
+				val.SetInt(n.Value)
+			}
+
+			return val, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := object.ToFloat(obj); ok {
+			return reflect.ValueOf(n).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+
+		if ch, ok := obj.(*object.Char); ok {
+			return reflect.ValueOf(string(ch.Value)).Convert(t), nil
+		}
+	case reflect.Slice:
+		if _, ok := obj.(*object.Null); ok {
+			return reflect.Zero(t), nil
+		}
+
+		if arr, ok := obj.(*object.Array); ok {
+			slice := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			for i, e := range arr.Elements {
+				elem, err := c.decode(e, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("element %d: %v", i, err)
+				}
+
+				slice.Index(i).Set(elem)
+			}
+
+			return slice, nil
+		}
+	case reflect.Array:
+		if arr, ok := obj.(*object.Array); ok {
+			if len(arr.Elements) != t.Len() {
+				return reflect.Value{}, fmt.Errorf("expected an array of length %d, got %d",
+					t.Len(), len(arr.Elements))
+			}
+
+			array := reflect.New(t).Elem()
+			for i, e := range arr.Elements {
+				elem, err := c.decode(e, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("element %d: %v", i, err)
+				}
+
+				array.Index(i).Set(elem)
+			}
+
+			return array, nil
+		}
+	case reflect.Map:
+		if _, ok := obj.(*object.Null); ok {
+			return reflect.Zero(t), nil
+		}
+
+		if hash, ok := obj.(*object.Hash); ok {
+			m := reflect.MakeMapWithSize(t, hash.Len())
+			for _, pair := range hash.Pairs() {
+				key, err := c.decode(pair.Key, t.Key())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %s: %v", pair.Key.Inspect(), err)
+				}
+
+				val, err := c.decode(pair.Value, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %s: %v", pair.Key.Inspect(), err)
+				}
+
+				m.SetMapIndex(key, val)
+			}
+
+			return m, nil
+		}
+	case reflect.Struct:
+		if hash, ok := obj.(*object.Hash); ok {
+			s := reflect.New(t).Elem()
+			if err := c.decodeStruct(hash, s); err != nil {
+				return reflect.Value{}, err
+			}
+
+			return s, nil
+		}
+	case reflect.Ptr:
+		if _, ok := obj.(*object.Null); ok {
+			return reflect.Zero(t), nil
+		}
+
+		elem, err := c.decode(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot convert %v to a %v", describe(obj), t)
+}
+
+// decodeStruct sets the fields of a struct from the properties of a hash.
+// Properties which the hash doesn't have are left alone.
+func (c converter) decodeStruct(hash *object.Hash, s reflect.Value) error {
+	for _, field := range structFields(s.Type()) {
+		val, ok := hash.Lookup(&object.String{Value: field.name})
+		if !ok {
+			continue
+		}
+
+		decoded, err := c.decode(val, field.typ)
+		if err != nil {
+			return fmt.Errorf("property %s: %v", field.name, err)
+		}
+
+		s.FieldByIndex(field.index).Set(decoded)
+	}
+
+	return nil
+}
+
+type structField struct {
+	name  string
+	index []int
+	typ   reflect.Type
+}
+
+// structFields lists the exported fields of a struct type, with the names
+// they're given in hashes. A field's name can be set with a `lang:"name"`
+// tag, or the field left out with `lang:"-"`. Otherwise, it's the Go name
+// with the first letter in lower case.
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Tag.Get("lang")
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = lowerFirst(f.Name)
+		}
+
+		fields = append(fields, structField{name: name, index: f.Index, typ: f.Type})
+	}
+
+	return fields
+}
+
+func lowerFirst(s string) string {
+	for i, r := range s {
+		return string(unicode.ToLower(r)) + s[i+len(string(r)):]
+	}
+
+	return s
+}
+
+func isUnsigned(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	default:
+		return false
+	}
+}
+
+// valueOf returns the reflect.Value of v as type t, which is the zero value
+// of t if v is nil.
+func valueOf(v interface{}, t reflect.Type) reflect.Value {
+	if v == nil {
+		return reflect.Zero(t)
+	}
+
+	return reflect.ValueOf(v)
+}
+
+func describe(obj object.Object) string {
+	return strings.ToLower(string(obj.Type()))
+}
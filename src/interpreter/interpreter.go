@@ -0,0 +1,419 @@
+// Package interpreter lets Go programs embed the language, running source
+// code with their own input and output streams, and giving it access to Go
+// functions and types.
+package interpreter
+
+import (
+	"../ast"
+	"../evaluator"
+	"../lexer"
+	"../object"
+	"../parser"
+	"../token"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+var (
+	builtinFuncType = reflect.TypeOf(object.BuiltinFunction(nil))
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Interpreter runs programs in an environment which persists between
+// calls to Eval, so later programs can use the names declared by earlier
+// ones. An Interpreter isn't safe to use from more than one goroutine at
+// once.
+type Interpreter struct {
+	env  *object.Environment
+	conv converter
+}
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithStdin sets where the input builtin reads from.
+func WithStdin(r io.Reader) Option {
+	return func(i *Interpreter) { i.env.Runtime().Stdin = r }
+}
+
+// WithStdout sets where the print builtin writes to.
+func WithStdout(w io.Writer) Option {
+	return func(i *Interpreter) { i.env.Runtime().Stdout = w }
+}
+
+// WithStderr sets where the eprint builtin writes to.
+func WithStderr(w io.Writer) Option {
+	return func(i *Interpreter) { i.env.Runtime().Stderr = w }
+}
+
+// WithLimits sets the limits on the resources each call to Eval or Call
+// can use. Going over a limit raises an error in the program, of a kind
+// such as object.STEP_ERROR or object.MEMORY_ERROR, which it can catch.
+func WithLimits(limits object.Limits) Option {
+	return func(i *Interpreter) { i.env.Runtime().Limits = limits }
+}
+
+// WithSearchPaths adds directories to search for imported files.
+func WithSearchPaths(dirs ...string) Option {
+	return func(i *Interpreter) {
+		rt := i.env.Runtime()
+		rt.SearchPaths = append(rt.SearchPaths, dirs...)
+	}
+}
+
+func New(options ...Option) *Interpreter {
+	i := &Interpreter{
+		env:  object.NewEnvironment(),
+		conv: converter{models: make(map[reflect.Type]*object.Model)},
+	}
+
+	for _, option := range options {
+		option(i)
+	}
+
+	return i
+}
+
+// Env returns the environment programs are evaluated in.
+func (i *Interpreter) Env() *object.Environment {
+	return i.env
+}
+
+// Define declares a variable holding a Go value, converted with ToObject.
+// Structs of types registered with RegisterModel become instances of
+// their models.
+func (i *Interpreter) Define(name string, value interface{}) error {
+	obj, err := i.ToObject(value)
+	if err != nil {
+		return err
+	}
+
+	i.env.Declare(name, obj)
+	return nil
+}
+
+// ToObject converts a Go value to an object, like the ToObject function,
+// but also converting structs of registered types to model instances.
+func (i *Interpreter) ToObject(v interface{}) (object.Object, error) {
+	return i.conv.toObject(reflect.ValueOf(v))
+}
+
+// Decode converts an object into the Go value pointed to by target, like
+// the Decode function, but also decoding instances of registered models.
+func (i *Interpreter) Decode(obj object.Object, target interface{}) error {
+	return i.conv.decodeInto(obj, target)
+}
+
+// RegisterFunc declares a builtin which calls a Go function. The arguments
+// it's called with are converted to the function's parameter types, and
+// its result is converted back with ToObject. The function can return
+// nothing, a value, an error, or a value and an error; a non-nil error is
+// raised as a runtime error in the program.
+//
+// A function which is already an object.BuiltinFunction is used as it is.
+func (i *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fmt.Errorf("cannot register %s: expected a function, got %T", name, fn)
+	}
+
+	builtin, err := i.conv.wrapFunc(name, v)
+	if err != nil {
+		return err
+	}
+
+	i.env.Declare(name, builtin)
+	return nil
+}
+
+// RegisterModel declares a model made from a Go struct type, given as a
+// struct or a pointer to one. The model's properties are the struct's
+// exported fields, named as described in ToObject, and its methods are
+// the exported methods of a pointer to the struct. When a method is
+// called, the instance is decoded into a new struct, and any changes the
+// method makes to the struct are copied back to the instance afterwards.
+func (i *Interpreter) RegisterModel(name string, prototype interface{}) (*object.Model, error) {
+	t := reflect.TypeOf(prototype)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot register %s: expected a struct, got %T", name, prototype)
+	}
+
+	model := object.NewModel()
+	model.Name = name
+
+	for _, field := range structFields(t) {
+		model.Properties = append(model.Properties, newID(field.name))
+	}
+
+	ptr := reflect.PtrTo(t)
+	for m := 0; m < ptr.NumMethod(); m++ {
+		method := ptr.Method(m)
+		if err := checkResults(method.Type); err != nil {
+			return nil, fmt.Errorf("cannot register %s.%s: %v", name, method.Name, err)
+		}
+
+		model.Methods[newID(lowerFirst(method.Name))] = &object.Builtin{
+			Fn: i.conv.method(name, t, method),
+		}
+	}
+
+	i.conv.models[t] = model
+	i.env.Declare(name, model)
+
+	return model, nil
+}
+
+// Eval runs a program and returns the value of its last statement,
+// converted with FromObject. Evaluation stops with an error if ctx is
+// cancelled before the program finishes.
+func (i *Interpreter) Eval(ctx context.Context, source string) (interface{}, error) {
+	obj, err := i.EvalObject(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return FromObject(obj), nil
+}
+
+// EvalObject is like Eval, but returns the result without converting it.
+func (i *Interpreter) EvalObject(ctx context.Context, source string) (object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l := lexer.New(source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.ErrorList()}
+	}
+
+	return i.run(ctx, func() object.Object {
+		return evaluator.Eval(program, i.env)
+	})
+}
+
+// Call calls a function declared in the interpreter's environment with
+// the given arguments, converted with ToObject, and returns its result
+// converted with FromObject.
+func (i *Interpreter) Call(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	fn, ok := i.env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined", name)
+	}
+
+	objs := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := i.ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %v", n+1, name, err)
+		}
+
+		objs[n] = obj
+	}
+
+	result, err := i.run(ctx, func() object.Object {
+		return evaluator.ApplyFunction(fn, nil, objs, i.env)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return FromObject(result), nil
+}
+
+func (i *Interpreter) run(ctx context.Context, f func() object.Object) (object.Object, error) {
+	rt := i.env.Runtime()
+	rt.SetContext(ctx)
+	rt.ResetSteps()
+	defer rt.SetContext(context.Background())
+
+	result := f()
+
+	if err, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Err: err, cause: ctx.Err()}
+	}
+
+	return result, nil
+}
+
+// ParseError is returned when a program can't be parsed.
+type ParseError struct {
+	Errors []*parser.Error
+}
+
+func (e *ParseError) Error() string {
+	msgs := []string{}
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// RuntimeError is returned when a program stops with an error which it
+// didn't catch.
+type RuntimeError struct {
+	Err *object.Error
+
+	// cause is the context's error, if the program was stopped because
+	// its context was cancelled
+	cause error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Err.Pos.IsValid() {
+		return fmt.Sprintf("%v: %s", e.Err.Pos, e.Err.Message)
+	}
+
+	return e.Err.Message
+}
+
+// Unwrap returns the context's error if the program was cancelled, so
+// errors.Is(err, context.DeadlineExceeded) works as expected.
+func (e *RuntimeError) Unwrap() error {
+	return e.cause
+}
+
+func (c converter) wrapFunc(name string, fn reflect.Value) (*object.Builtin, error) {
+	if fn.Type().ConvertibleTo(builtinFuncType) {
+		return &object.Builtin{Fn: fn.Convert(builtinFuncType).Interface().(object.BuiltinFunction)}, nil
+	}
+
+	if err := checkResults(fn.Type()); err != nil {
+		return nil, fmt.Errorf("cannot register %s: %v", name, err)
+	}
+
+	return &object.Builtin{
+		Fn: func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+			return c.call(name, fn, nil, args)
+		},
+	}, nil
+}
+
+func (c converter) method(model string, t reflect.Type, method reflect.Method) object.BuiltinFunction {
+	name := model + "." + lowerFirst(method.Name)
+
+	return func(env *object.Environment, this object.Object, args ...object.Object) object.Object {
+		hash, ok := this.(*object.Hash)
+		if !ok {
+			return newKindError(object.TYPE_ERROR, "%s must be called on an instance of %s", name, model)
+		}
+
+		receiver := reflect.New(t)
+		if err := c.decodeStruct(hash, receiver.Elem()); err != nil {
+			return newKindError(object.TYPE_ERROR, "%s: %v", name, err)
+		}
+
+		result := c.call(name, method.Func, []reflect.Value{receiver}, args)
+
+		for _, field := range structFields(t) {
+			if val, err := c.toObject(receiver.Elem().FieldByIndex(field.index)); err == nil {
+				hash.Set(field.name, val)
+			}
+		}
+
+		return result
+	}
+}
+
+// call calls a Go function with the given objects as its arguments, after
+// any fixed arguments in prefix.
+func (c converter) call(name string, fn reflect.Value, prefix []reflect.Value, args []object.Object) (result object.Object) {
+	t := fn.Type()
+	params := t.NumIn() - len(prefix)
+
+	if t.IsVariadic() {
+		if len(args) < params-1 {
+			return newKindError(object.ARGUMENT_ERROR, "%s expects at least %d arguments, got %d",
+				name, params-1, len(args))
+		}
+	} else if len(args) != params {
+		return newKindError(object.ARGUMENT_ERROR, "%s expects %d arguments, got %d",
+			name, params, len(args))
+	}
+
+	in := append([]reflect.Value{}, prefix...)
+
+	for n, arg := range args {
+		var paramType reflect.Type
+		if index := len(prefix) + n; t.IsVariadic() && index >= t.NumIn()-1 {
+			paramType = t.In(t.NumIn() - 1).Elem()
+		} else {
+			paramType = t.In(index)
+		}
+
+		val, err := c.decode(arg, paramType)
+		if err != nil {
+			return newKindError(object.TYPE_ERROR, "argument %d to %s: %v", n+1, name, err)
+		}
+
+		in = append(in, val)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("%s panicked: %v", name, r)
+		}
+	}()
+
+	out := fn.Call(in)
+
+	if n := len(out); n > 0 && t.Out(n-1) == errorType {
+		if err := out[n-1]; !err.IsNil() {
+			if langErr, ok := err.Interface().(*object.Error); ok {
+				return langErr
+			}
+
+			return newError("%v", err.Interface())
+		}
+
+		out = out[:n-1]
+	}
+
+	if len(out) == 0 {
+		return evaluator.NULL
+	}
+
+	obj, err := c.toObject(out[0])
+	if err != nil {
+		return newKindError(object.TYPE_ERROR, "the result of %s: %v", name, err)
+	}
+
+	return obj
+}
+
+// checkResults checks that a function returns nothing, a value, an error,
+// or a value and an error.
+func checkResults(t reflect.Type) error {
+	switch t.NumOut() {
+	case 0, 1:
+		return nil
+	case 2:
+		if t.Out(1) == errorType {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("a function can only return a value and an error")
+}
+
+func newID(name string) *ast.Identifier {
+	return &ast.Identifier{Token: token.New(token.ID, name), Value: name}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return newKindError(object.RUNTIME_ERROR, format, a...)
+}
+
+func newKindError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: kind}
+}
@@ -0,0 +1,332 @@
+package interpreter
+
+import (
+	"../object"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 + 2;", int64(3)},
+		{"1.5 * 2;", 3.0},
+		{`"a" + "b";`, "ab"},
+		{"1 < 2;", true},
+		{"null;", nil},
+		{"[1, \"x\", [true]];", []interface{}{int64(1), "x", []interface{}{true}}},
+		{"{a: 1, b: [2]};", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2)}}},
+		{`{1: "a", "1": "b", 'y': 'c', [1, null]: true};`, map[interface{}]interface{}{
+			int64(1): "a", "1": "b", 'y': 'c', [2]interface{}{int64(1), nil}: true,
+		}},
+	}
+
+	for _, tt := range tests {
+		result, err := New().Eval(context.Background(), tt.input)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tt.input, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("expected %q to give %#v, got %#v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestPersistentEnvironment(t *testing.T) {
+	i := New()
+	ctx := context.Background()
+
+	if _, err := i.Eval(ctx, "double := fn (x) { x * 2; };"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := i.Call(ctx, "double", 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != int64(42) {
+		t.Errorf("expected 42, got %v", result)
+	}
+}
+
+func TestConcurrentInterpreters(t *testing.T) {
+	var wg sync.WaitGroup
+
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := New().Eval(context.Background(), "v := model (x, y); v(1, 2).y;")
+			if err != nil || result != int64(2) {
+				t.Errorf("expected 2, got %v, %v", result, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestBuiltinModelsAreShared(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		change   string
+		input    string
+		expected interface{}
+	}{
+		{`string.upper = fn () { "changed"; };`, `"abc".upper();`, "ABC"},
+		{`array.len = fn () { 99; };`, `[1].len();`, int64(1)},
+	}
+
+	for _, tt := range tests {
+		if _, err := New().Eval(ctx, tt.change); err == nil {
+			t.Errorf("expected an error for %q", tt.change)
+		}
+
+		result, err := New().Eval(ctx, tt.input)
+		if err != nil || result != tt.expected {
+			t.Errorf("expected %q to give %v, got %v, %v", tt.input, tt.expected, result, err)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	i := New(
+		WithStdin(strings.NewReader("world\n")),
+		WithStdout(&stdout),
+		WithStderr(&stderr),
+	)
+
+	_, err := i.Eval(context.Background(), `name := input("name? "); print("hello", name); eprint("oops");`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "name? hello world \n" {
+		t.Errorf("wrong stdout: %q", stdout.String())
+	}
+
+	if stderr.String() != "oops \n" {
+		t.Errorf("wrong stderr: %q", stderr.String())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	i := New()
+	ctx := context.Background()
+
+	_, err := i.Eval(ctx, "x := ;")
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("expected a *ParseError, got %#v", err)
+	}
+
+	_, err = i.Eval(ctx, `1 + "a";`)
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected a *RuntimeError, got %#v", err)
+	}
+
+	if rerr.Err.Kind != object.TYPE_ERROR {
+		t.Errorf("expected a type error, got %s", rerr.Err.Kind)
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	i := New()
+	ctx := context.Background()
+
+	i.RegisterFunc("repeat", strings.Repeat)
+	i.RegisterFunc("sum", func(ns ...int) int {
+		total := 0
+		for _, n := range ns {
+			total += n
+		}
+		return total
+	})
+	i.RegisterFunc("fail", func(msg string) error {
+		return fmt.Errorf("failed: %s", msg)
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`repeat("ab", 3);`, "ababab"},
+		{"sum(1, 2, 3);", "6"},
+		{"sum();", "0"},
+		{`try { fail("x"); } catch (e) { e.message; };`, "failed: x"},
+		{`try { repeat("ab"); } catch (e) { e.kind; };`, "argument"},
+		{`try { repeat("ab", 1.5); } catch (e) { e.kind; };`, "type"},
+	}
+
+	for _, tt := range tests {
+		result, err := i.EvalObject(ctx, tt.input)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tt.input, err)
+			continue
+		}
+
+		if result.Inspect() != tt.expected {
+			t.Errorf("expected %q to give %s, got %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+type counter struct {
+	Count int
+	Label string `lang:"name"`
+}
+
+func (c *counter) Add(n int) int {
+	c.Count += n
+	return c.Count
+}
+
+func TestRegisterModel(t *testing.T) {
+	i := New()
+	ctx := context.Background()
+
+	if _, err := i.RegisterModel("counter", counter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := i.EvalObject(ctx, `c := counter(1, "a"); c.add(2); c.add(3); [c.count, c.name];`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Inspect() != "[6, a]" {
+		t.Errorf("expected [6, a], got %s", result.Inspect())
+	}
+
+	if err := i.Define("d", &counter{Count: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = i.EvalObject(ctx, "d.add(1);")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Inspect() != "11" {
+		t.Errorf("expected 11, got %s", result.Inspect())
+	}
+
+	c, _ := i.Env().Get("c")
+
+	var decoded counter
+	if err := i.Decode(c, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != (counter{Count: 6, Label: "a"}) {
+		t.Errorf("wrong decoded counter: %+v", decoded)
+	}
+}
+
+func TestConversions(t *testing.T) {
+	obj, err := ToObject(map[string][]int{"a": {1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m map[string][]int
+	if err := Decode(obj, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(m, map[string][]int{"a": {1, 2}}) {
+		t.Errorf("wrong round trip: %v", m)
+	}
+
+	obj, err = ToObject(map[int]string{2: "b", 1: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if obj.Inspect() != "{1: a, 2: b}" {
+		t.Errorf("expected a map with integer keys to convert to {1: a, 2: b}, got %s", obj.Inspect())
+	}
+
+	var ints map[int]string
+	if err := Decode(obj, &ints); err != nil || !reflect.DeepEqual(ints, map[int]string{1: "a", 2: "b"}) {
+		t.Errorf("wrong round trip: %v, %v", ints, err)
+	}
+
+	var s string
+	if err := Decode(&object.Char{Value: 'é'}, &s); err != nil || s != "é" {
+		t.Errorf("expected a character to decode to \"é\", got %q, %v", s, err)
+	}
+
+	if ch := FromObject(&object.Char{Value: 'x'}); ch != 'x' {
+		t.Errorf("expected a character to convert to a rune, got %v", ch)
+	}
+
+	var n uint8
+	if err := Decode(&object.Number{Value: 300}, &n); err == nil {
+		t.Errorf("expected an error decoding 300 into a uint8")
+	}
+
+	if _, err := ToObject(make(chan int)); err == nil {
+		t.Errorf("expected an error converting a channel")
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := New().Eval(ctx, "while (true) { 1; };")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Err.Kind != object.TIMEOUT_ERROR {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+
+	_, err = New().Eval(ctx, "1;")
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected an already cancelled context to fail, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	i := New(WithLimits(object.Limits{MaxSteps: 1000}))
+	ctx := context.Background()
+
+	result, err := i.Eval(ctx, "try { while (true) { 1; }; } catch (e) { e.kind; };")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != object.STEP_ERROR {
+		t.Errorf("expected a step error to be caught, got %v", result)
+	}
+
+	// the budget is for each call to Eval, so the interpreter can still be
+	// used afterwards
+	result, err = i.Eval(ctx, "1 + 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != int64(2) {
+		t.Errorf("expected 2, got %v", result)
+	}
+}
@@ -2,29 +2,73 @@ package lexer
 
 import (
 	"../token"
+	"bytes"
+	"fmt"
 	"strings"
 )
 
 type Lexer struct {
 	input        string
+	file         string
 	position     int
 	readPosition int
 	ch           byte
+	line         int
+	column       int
+
+	// comments are kept, even though the parser doesn't see them, so
+	// tools like the formatter can put them back
+	comments []token.Token
+
+	errors []*Error
+
+	// the type of the last token returned, so a run of blank lines only
+	// gives one NEWLINE, and none at all after a semicolon
+	last token.TokenType
+
+	// tokens which have been read but not returned yet, from a string with
+	// interpolations in it
+	pending []token.Token
+}
+
+// Error explains why the lexer produced an ILLEGAL token, such as for a
+// malformed number literal.
+type Error struct {
+	Pos     token.Position
+	Message string
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile creates a lexer for the given input, recording the file name in the
+// position of each token it produces.
+func NewFile(file, input string) *Lexer {
+	l := &Lexer{input: input, file: file, line: 1}
 	l.readChar()
 	return l
 }
 
+// NextToken reads the next token from the input. Newlines are returned as
+// NEWLINE tokens, since they can end statements, but a run of them (with
+// any comments between) is only returned once, and not at all at the start
+// of the input or after a semicolon.
 func (l *Lexer) NextToken() token.Token {
+	tok := l.next()
+	l.last = tok.Type
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
 
 	// skip comments and whitespace
 	for {
 		if l.ch == '#' {
 			l.skipComment()
+		} else if l.ch == '\n' && !l.skipNewline() {
+			break
 		} else if l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' {
 			l.skipWhitespace()
 		} else {
@@ -32,7 +76,11 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	}
 
+	pos := l.currentPosition()
+
 	switch l.ch {
+	case '\n':
+		tok = token.New(token.NL, "\n")
 	case ':':
 		if l.peekChar() == '=' {
 			l.readChar()
@@ -44,24 +92,27 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = token.New(token.EQ, "==")
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.New(token.ARROW, "=>")
 		} else {
 			tok = token.New(token.ASSIGN, "=")
 		}
 	case '+':
-		tok = token.New(token.PLUS, "+")
+		tok = l.compound(token.PLUS, token.PLUS_ASSIGN, "+")
 	case '-':
-		tok = token.New(token.MINUS, "-")
+		tok = l.compound(token.MINUS, token.MINUS_ASSIGN, "-")
 	case '*':
 		if l.peekChar() == '*' {
 			l.readChar()
-			tok = token.New(token.EXP, "**")
+			tok = l.compound(token.EXP, token.EXP_ASSIGN, "**")
 		} else {
-			tok = token.New(token.STAR, "*")
+			tok = l.compound(token.STAR, token.STAR_ASSIGN, "*")
 		}
 	case '/':
-		tok = token.New(token.SLASH, "/")
+		tok = l.compound(token.SLASH, token.SLASH_ASSIGN, "/")
 	case '%':
-		tok = token.New(token.MOD, "%")
+		tok = l.compound(token.MOD, token.MOD_ASSIGN, "%")
 	case '\\':
 		tok = token.New(token.BACKSLASH, "\\")
 	case '!':
@@ -77,7 +128,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok = token.New(token.LTE, "<=")
 		} else if l.peekChar() == '<' {
 			l.readChar()
-			tok = token.New(token.BIT_LEFT, "<<")
+			tok = l.compound(token.BIT_LEFT, token.BIT_LEFT_ASSIGN, "<<")
 		} else {
 			tok = token.New(token.LT, "<")
 		}
@@ -87,12 +138,16 @@ func (l *Lexer) NextToken() token.Token {
 			tok = token.New(token.GTE, ">=")
 		} else if l.peekChar() == '>' {
 			l.readChar()
-			tok = token.New(token.BIT_RIGHT, ">>")
+			tok = l.compound(token.BIT_RIGHT, token.BIT_RIGHT_ASSIGN, ">>")
 		} else {
 			tok = token.New(token.GT, ">")
 		}
 	case '.':
-		if l.startsWith("..<") {
+		if l.startsWith("...") {
+			l.readChar()
+			l.readChar()
+			tok = token.New(token.ELLIPSIS, "...")
+		} else if l.startsWith("..<") {
 			l.readChar()
 			l.readChar()
 			tok = token.New(token.XRANGE, "..<")
@@ -107,17 +162,17 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			tok = token.New(token.AND, "&&")
 		} else {
-			tok = token.New(token.BIT_AND, "&")
+			tok = l.compound(token.BIT_AND, token.BIT_AND_ASSIGN, "&")
 		}
 	case '|':
 		if l.peekChar() == '|' {
 			l.readChar()
 			tok = token.New(token.OR, "||")
 		} else {
-			tok = token.New(token.VLINE, "|")
+			tok = l.compound(token.VLINE, token.BIT_OR_ASSIGN, "|")
 		}
 	case '^':
-		tok = token.New(token.BIT_XOR, "^")
+		tok = l.compound(token.BIT_XOR, token.BIT_XOR_ASSIGN, "^")
 	case ',':
 		tok = token.New(token.COMMA, ",")
 	case ';':
@@ -136,18 +191,30 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.New(token.RBRACKET, "]")
 	case '~':
 		tok = token.New(token.BIT_NOT, "~")
-	case '"':
-		tok = token.New(token.STRING, l.readString())
+	case '\'':
+		tok = l.readCharLiteral(pos)
+	case '"', '`':
+		// strings set their own positions, since an invalid one is put
+		// where its first error is
+		if l.ch == '"' {
+			tok = l.readString(pos)
+		} else {
+			tok = l.readRawString(pos)
+		}
+
+		l.readChar()
+		return tok
 	case 0:
 		tok = token.New(token.EOF, "")
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.NUM
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber(pos)
+			tok.Pos = pos
 			return tok
 		} else {
 			tok = token.New(token.ILLEGAL, string(l.ch))
@@ -155,10 +222,36 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos = pos
 	return tok
 }
 
+// compound returns the token for an operator which has just been read, or
+// for its compound assignment form if it's followed by '='.
+func (l *Lexer) compound(tokType, assignType token.TokenType, operator string) token.Token {
+	if l.peekChar() == '=' {
+		l.readChar()
+		return token.New(assignType, operator+"=")
+	}
+
+	return token.New(tokType, operator)
+}
+
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		File:   l.file,
+		Line:   l.line,
+		Column: l.column,
+		Offset: l.position,
+	}
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -167,6 +260,11 @@ func (l *Lexer) readChar() {
 
 	l.position = l.readPosition
 	l.readPosition++
+
+	// continuation bytes of a multi-byte character don't start a new column
+	if l.ch&0xC0 != 0x80 {
+		l.column++
+	}
 }
 
 func (l *Lexer) startsWith(str string) bool {
@@ -183,84 +281,178 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) skipComment() {
-	for l.ch != '\n' {
+	pos := l.currentPosition()
+
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
+
+	text := strings.TrimRight(l.input[pos.Offset:l.position], " \t\r")
+
+	tok := token.New(token.COMMENT, text)
+	tok.Pos = pos
+	l.comments = append(l.comments, tok)
+}
+
+// Comments returns the comments which have been skipped over so far, in
+// the order they appear in.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// Errors returns the problems found with the tokens read so far.
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+// skipNewline reports whether a newline can be skipped like any other
+// whitespace, because it couldn't end a statement.
+func (l *Lexer) skipNewline() bool {
+	return l.last == "" || l.last == token.NL || l.last == token.SEMI
 }
 
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' {
+	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || (l.ch == '\n' && l.skipNewline()) {
 		l.readChar()
 	}
 }
 
-func (l *Lexer) readNumber() string {
-	dotted := false
-	literal := ""
-
-	for {
-		if isDigit(l.ch) {
-			literal += string(l.ch)
-		} else if l.ch == '.' && !dotted && len(literal) > 0 {
-			dotted = true
+// readNumber reads an integer literal, or a float literal if it has a
+// decimal point or an exponent, returning the type of token and its
+// literal. Integers can be written in hexadecimal, octal or binary with a
+// 0x, 0o or 0b prefix, and digits can be separated by underscores. A
+// malformed literal is returned as an ILLEGAL token.
+func (l *Lexer) readNumber(pos token.Position) (token.TokenType, string) {
+	position := l.position
 
+	if l.ch == '0' {
+		if base, name := numberBase(l.peekChar()); base != 0 {
+			l.readChar()
 			l.readChar()
-			if isDigit(l.ch) {
-				literal += "." + string(l.ch)
-			} else {
-				l.readPosition--
-				l.position--
-				return literal
+
+			digits := l.position
+			for isLetter(l.ch) || isDigit(l.ch) {
+				l.readChar()
 			}
-		} else {
-			break
+
+			literal := l.input[position:l.position]
+
+			if msg := checkDigits(l.input[digits:l.position], base); msg != "" {
+				return l.illegal(pos, literal, "%s in %s literal %s", msg, name, literal)
+			}
+
+			return token.INT, literal
 		}
+	}
+
+	var tokType token.TokenType = token.INT
+	l.readDigits()
 
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokType = token.NUM
 		l.readChar()
+		l.readDigits()
+	} else if l.ch == '.' && l.peekChar() != '.' && !isLetter(l.peekChar()) {
+		// a trailing dot, as in "1.", makes a float too
+		tokType = token.NUM
+		l.readChar()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		tokType = token.NUM
+		l.readChar()
+
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+
+		if !isDigit(l.ch) {
+			literal := l.input[position:l.position]
+			return l.illegal(pos, literal, "missing digits in the exponent of %s", literal)
+		}
+
+		l.readDigits()
 	}
 
-	return literal
+	literal := l.input[position:l.position]
+
+	for _, part := range strings.FieldsFunc(literal, func(r rune) bool { return r == '.' || r == 'e' || r == 'E' }) {
+		if msg := checkDigits(strings.TrimLeft(part, "+-"), 10); msg != "" {
+			return l.illegal(pos, literal, "%s in number literal %s", msg, literal)
+		}
+	}
+
+	return tokType, literal
 }
 
-func (l *Lexer) readString() string {
-	str := ""
-	for {
+// readDigits reads decimal digits and the underscores separating them.
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
+	}
+}
 
-		if l.ch == '"' || l.ch == 0 {
-			break
-		}
+// numberBase returns the base given by the character after the 0 at the
+// start of a number literal, and its name, or 0 if it doesn't give one.
+func numberBase(ch byte) (int, string) {
+	switch ch {
+	case 'x', 'X':
+		return 16, "hexadecimal"
+	case 'o', 'O':
+		return 8, "octal"
+	case 'b', 'B':
+		return 2, "binary"
+	default:
+		return 0, ""
+	}
+}
 
-		if l.ch == '\\' {
-			l.readChar()
-			switch l.ch {
-			case '\n', '\\':
-				str += "\\"
-			case '\'':
-				str += "'"
-			case '"':
-				str += "\""
-			case 'a':
-				str += "\a"
-			case 'b':
-				str += "\b"
-			case 'f':
-				str += "\f"
-			case 'n':
-				str += "\n"
-			case 'r':
-				str += "\r"
-			case 't':
-				str += "\t"
-			case 'v':
-				str += "\v"
+// checkDigits checks the digits of a number in the given base, returning
+// what's wrong with them or an empty string if they're valid. Underscores
+// can only appear between two digits.
+func checkDigits(digits string, base int) string {
+	if strings.Trim(digits, "_") == "" {
+		return "missing digits"
+	}
+
+	for i := 0; i < len(digits); i++ {
+		ch := digits[i]
+
+		if ch == '_' {
+			if i == 0 || i == len(digits)-1 || digits[i-1] == '_' {
+				return "'_' must separate digits"
 			}
-		} else {
-			str += string(l.ch)
+
+			continue
+		}
+
+		if digitValue(ch) >= base {
+			return fmt.Sprintf("invalid digit %q", ch)
 		}
 	}
 
-	return str
+	return ""
+}
+
+// digitValue returns the value of a digit in any base up to 36.
+func digitValue(ch byte) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'z':
+		return int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'Z':
+		return int(ch-'A') + 10
+	default:
+		return 36
+	}
+}
+
+// illegal records why a token is malformed, returning it as an ILLEGAL
+// token.
+func (l *Lexer) illegal(pos token.Position, literal, format string, a ...interface{}) (token.TokenType, string) {
+	l.errors = append(l.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+	return token.ILLEGAL, literal
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -282,3 +474,35 @@ func isLetter(ch byte) bool {
 func isID(ch byte) bool {
 	return isLetter(ch) || isDigit(ch)
 }
+
+// Excerpt returns the line of input referred to by pos, followed by a second
+// line with a caret underneath the column that pos points at.
+func Excerpt(input string, pos token.Position) string {
+	if !pos.IsValid() {
+		return ""
+	}
+
+	lines := strings.Split(input, "\n")
+	if pos.Line > len(lines) {
+		return ""
+	}
+
+	line := strings.TrimRight(lines[pos.Line-1], "\r")
+
+	var caret bytes.Buffer
+	for i, ch := range []rune(line) {
+		if i >= pos.Column-1 {
+			break
+		}
+
+		if ch == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+
+	caret.WriteRune('^')
+
+	return line + "\n" + caret.String()
+}
@@ -7,7 +7,7 @@ import (
 
 func TestNextToken(t *testing.T) {
 	input := `id 3.27 "string" := = + - * / \ !
-  < > <= >= == != .. ..< && || , ; : . |
+  < > <= >= == != .. ..< ... && || , ; : . | =>
   (){}[] fn return true false if else elif while for break next`
 
 	tests := []struct {
@@ -34,6 +34,7 @@ func TestNextToken(t *testing.T) {
 		{token.NOT_EQ, "!="},
 		{token.RANGE, ".."},
 		{token.XRANGE, "..<"},
+		{token.ELLIPSIS, "..."},
 		{token.AND, "&&"},
 		{token.OR, "||"},
 		{token.COMMA, ","},
@@ -41,6 +42,7 @@ func TestNextToken(t *testing.T) {
 		{token.COLON, ":"},
 		{token.DOT, "."},
 		{token.VLINE, "|"},
+		{token.ARROW, "=>"},
 		{token.NL, "\n"},
 		{token.LPAREN, "("},
 		{token.RPAREN, ")"},
@@ -77,3 +79,231 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestPositions(t *testing.T) {
+	input := "a := 1;\n  b.c(\"é\", d);"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+		expectedOffset  int
+	}{
+		{"a", 1, 1, 0},
+		{":=", 1, 3, 2},
+		{"1", 1, 6, 5},
+		{";", 1, 7, 6},
+		{"b", 2, 3, 10},
+		{".", 2, 4, 11},
+		{"c", 2, 5, 12},
+		{"(", 2, 6, 13},
+		{"é", 2, 7, 14},
+		{",", 2, 10, 18},
+		{"d", 2, 12, 20},
+	}
+
+	l := NewFile("test.lang", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.File != "test.lang" {
+			t.Fatalf("tests[%d] - file wrong. got=%q", i, tok.Pos.File)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+
+		if tok.Pos.Offset != tt.expectedOffset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d, got=%d",
+				i, tt.expectedOffset, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"42", token.INT, "42", ""},
+		{"1_000_000", token.INT, "1_000_000", ""},
+		{"0xFF_ff", token.INT, "0xFF_ff", ""},
+		{"0o17", token.INT, "0o17", ""},
+		{"0b1010", token.INT, "0b1010", ""},
+		{"3.27", token.NUM, "3.27", ""},
+		{"1.", token.NUM, "1.", ""},
+		{"1.5e-3", token.NUM, "1.5e-3", ""},
+		{"2E+10", token.NUM, "2E+10", ""},
+		{"0x", token.ILLEGAL, "0x", "missing digits in hexadecimal literal 0x"},
+		{"0b102", token.ILLEGAL, "0b102", "invalid digit '2' in binary literal 0b102"},
+		{"0o8", token.ILLEGAL, "0o8", "invalid digit '8' in octal literal 0o8"},
+		{"1__0", token.ILLEGAL, "1__0", "'_' must separate digits in number literal 1__0"},
+		{"1_", token.ILLEGAL, "1_", "'_' must separate digits in number literal 1_"},
+		{"0x_1", token.ILLEGAL, "0x_1", "'_' must separate digits in hexadecimal literal 0x_1"},
+		{"1e+", token.ILLEGAL, "1e+", "missing digits in the exponent of 1e+"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input + ";")
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("%s: expected %s %q, got %s %q",
+				tt.input, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		if next := l.NextToken(); next.Type != token.SEMI {
+			t.Errorf("%s: expected the whole literal to be read, got %q next", tt.input, next.Literal)
+		}
+
+		errors := l.Errors()
+		if tt.expectedError == "" && len(errors) != 0 {
+			t.Errorf("%s: unexpected error %q", tt.input, errors[0].Message)
+		} else if tt.expectedError != "" && (len(errors) != 1 || errors[0].Message != tt.expectedError) {
+			t.Errorf("%s: expected the error %q, got %v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedError   string
+	}{
+		{`"abc"`, token.STRING, "abc", ""},
+		{`"a\tb\n\"c\" \\ \$"`, token.STRING, "a\tb\n\"c\" \\ $", ""},
+		{`"\u{41}\u{1F600}"`, token.STRING, "A\U0001F600", ""},
+		{"\"a \\\n  b\"", token.STRING, "a   b", ""},
+		{"`a\\n ${b}\n\"`", token.RAW_STRING, "a\\n ${b}\n\"", ""},
+		{`"\q"`, token.ILLEGAL, `"\q"`, `unknown escape sequence \q`},
+		{`"\u41"`, token.ILLEGAL, `"\u41"`, `expected \u{...} with the hex code of a character`},
+		{`"\u{D800}"`, token.ILLEGAL, `"\u{D800}"`, `invalid character code \u{D800}`},
+		{`"\u{41"`, token.ILLEGAL, `"\u{41"`, `expected \u{...} with the hex code of a character`},
+		{`"abc`, token.ILLEGAL, `"abc`, "unterminated string"},
+		{"`abc", token.ILLEGAL, "`abc", "unterminated string"},
+		{`"a ${b`, token.ILLEGAL, `"a ${b`, "unterminated string"},
+		{`'x'`, token.CHAR, "x", ""},
+		{`'é'`, token.CHAR, "é", ""},
+		{`'\''`, token.CHAR, "'", ""},
+		{`'\u{1F600}'`, token.CHAR, "\U0001F600", ""},
+		{`'"'`, token.CHAR, `"`, ""},
+		{`''`, token.ILLEGAL, `''`, "expected one character in a character literal. got ''"},
+		{`'ab'`, token.ILLEGAL, `'ab'`, "expected one character in a character literal. got 'ab'"},
+		{`'\q'`, token.ILLEGAL, `'\q'`, `unknown escape sequence \q`},
+		{`'a`, token.ILLEGAL, `'a`, "unterminated character literal"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("%s: expected %s %q, got %s %q",
+				tt.input, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("%s: expected the whole literal to be read, got %q next", tt.input, next.Literal)
+		}
+
+		errors := l.Errors()
+		if tt.expectedError == "" && len(errors) != 0 {
+			t.Errorf("%s: unexpected error %q", tt.input, errors[0].Message)
+		} else if tt.expectedError != "" && (len(errors) != 1 || errors[0].Message != tt.expectedError) {
+			t.Errorf("%s: expected the error %q, got %v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	input := `"x = ${a + "${b}"}!" """
+    first
+      ${c}
+    last
+    """`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING_START, `"`},
+		{token.STRING, "x = "},
+		{token.INTERP_START, "${"},
+		{token.ID, "a"},
+		{token.PLUS, "+"},
+		{token.STRING_START, `"`},
+		{token.INTERP_START, "${"},
+		{token.ID, "b"},
+		{token.INTERP_END, "}"},
+		{token.STRING_END, `"`},
+		{token.INTERP_END, "}"},
+		{token.STRING, "!"},
+		{token.STRING_END, `"`},
+		{token.STRING_START, `"""`},
+		{token.STRING, "first\n  "},
+		{token.INTERP_START, "${"},
+		{token.ID, "c"},
+		{token.INTERP_END, "}"},
+		{token.STRING, "\nlast"},
+		{token.STRING_END, `"""`},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Errorf("unexpected errors: %v", l.Errors())
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	input := "+= -= *= **= /= %= <<= >>= &= |= ^= ** <= >= == = ^"
+
+	expected := []token.TokenType{
+		token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.STAR_ASSIGN, token.EXP_ASSIGN,
+		token.SLASH_ASSIGN, token.MOD_ASSIGN, token.BIT_LEFT_ASSIGN, token.BIT_RIGHT_ASSIGN,
+		token.BIT_AND_ASSIGN, token.BIT_OR_ASSIGN, token.BIT_XOR_ASSIGN, token.EXP,
+		token.LTE, token.GTE, token.EQ, token.ASSIGN, token.BIT_XOR, token.EOF,
+	}
+
+	l := New(input)
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - expected %s, got %s %q", i, tt, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	input := "a := 1;\n\tb + c;"
+	pos := token.Position{Line: 2, Column: 4}
+
+	expected := "\tb + c;\n\t  ^"
+	if got := Excerpt(input, pos); got != expected {
+		t.Errorf("excerpt wrong. expected=%q, got=%q", expected, got)
+	}
+}
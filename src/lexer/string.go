@@ -0,0 +1,436 @@
+package lexer
+
+import (
+	"../token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A string literal is read in one go, including any expressions
+// interpolated into it, so a triple quoted string's indentation can be
+// stripped knowing all of its lines. A string with interpolations, or any
+// triple quoted string, is given to the parser as several tokens:
+//
+//	STRING_START            the opening quote, " or """
+//	STRING                  each literal part, with its escapes replaced
+//	INTERP_START ... INTERP_END
+//	                        the tokens of each interpolated expression
+//	STRING_END              the closing quote
+//
+// The tokens after the first are queued up in pending.
+
+// stringPart is a literal part of a string, as it was written, or the
+// tokens of an interpolated expression.
+type stringPart struct {
+	raw    string
+	pos    token.Position
+	tokens []token.Token
+}
+
+// next returns the next queued token, or reads one.
+func (l *Lexer) next() token.Token {
+	if len(l.pending) > 0 {
+		tok := l.pending[0]
+		l.pending = l.pending[1:]
+		return tok
+	}
+
+	return l.readToken()
+}
+
+// readString reads a string literal starting at the current character,
+// returning its first token and leaving the current character at the end
+// of the closing quote.
+func (l *Lexer) readString(pos token.Position) token.Token {
+	quote := `"`
+	if l.startsWith(`"""`) {
+		quote = `"""`
+		l.readChar()
+		l.readChar()
+	}
+
+	l.readChar()
+
+	parts := []stringPart{}
+	part := stringPart{pos: l.currentPosition()}
+
+	// the position of the first invalid escape sequence, which the ILLEGAL
+	// token is given so the parser can find its error
+	var invalid *token.Position
+
+	for !l.startsWith(quote) {
+		switch {
+		case l.ch == 0:
+			return l.illegalToken(pos, "unterminated string")
+		case l.ch == '\\':
+			escapePos := l.currentPosition()
+			escape := l.readEscape()
+
+			if _, msg := unescape(escape); msg != "" {
+				l.illegal(escapePos, escape, "%s", msg)
+				if invalid == nil {
+					invalid = &escapePos
+				}
+			}
+
+			part.raw += escape
+		case l.ch == '$' && l.peekChar() == '{':
+			parts = append(parts, part)
+
+			tokens, ok := l.readInterpolation()
+			if !ok {
+				return l.illegalToken(pos, "unterminated string")
+			}
+
+			parts = append(parts, stringPart{tokens: tokens})
+			part = stringPart{pos: l.currentPosition()}
+		default:
+			part.raw += l.input[l.position:l.readPosition]
+			l.readChar()
+		}
+	}
+
+	parts = append(parts, part)
+	end := l.currentPosition()
+
+	for i := 1; i < len(quote); i++ {
+		l.readChar()
+	}
+
+	if invalid != nil {
+		return token.Token{Type: token.ILLEGAL, Literal: l.input[pos.Offset:l.readPosition], Pos: *invalid}
+	}
+
+	if quote == `"""` {
+		dedent(parts)
+	}
+
+	if len(parts) == 1 && quote == `"` {
+		value, _ := unescape(parts[0].raw)
+		return token.Token{Type: token.STRING, Literal: value, Pos: pos}
+	}
+
+	tokens := []token.Token{}
+	for _, part := range parts {
+		if part.tokens != nil {
+			tokens = append(tokens, part.tokens...)
+			continue
+		}
+
+		if part.raw != "" {
+			value, _ := unescape(part.raw)
+			tokens = append(tokens, token.Token{Type: token.STRING, Literal: value, Pos: part.pos})
+		}
+	}
+
+	l.pending = append(tokens, token.Token{Type: token.STRING_END, Literal: quote, Pos: end})
+
+	return token.Token{Type: token.STRING_START, Literal: quote, Pos: pos}
+}
+
+// readInterpolation reads the tokens of an expression in "${...}", starting
+// at the '$', and returns them between INTERP_START and INTERP_END tokens.
+// It returns false if the input ends first.
+func (l *Lexer) readInterpolation() ([]token.Token, bool) {
+	tokens := []token.Token{{Type: token.INTERP_START, Literal: "${", Pos: l.currentPosition()}}
+
+	l.readChar()
+	l.readChar()
+
+	depth := 0
+	for {
+		tok := l.next()
+
+		switch tok.Type {
+		case token.EOF:
+			return nil, false
+		case token.NL:
+			continue
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				tok.Type = token.INTERP_END
+				return append(tokens, tok), true
+			}
+
+			depth--
+		}
+
+		tokens = append(tokens, tok)
+	}
+}
+
+// readEscape reads an escape sequence starting at the backslash, and
+// returns it as it was written.
+func (l *Lexer) readEscape() string {
+	start := l.position
+	l.readChar()
+
+	if l.ch == 'u' && l.peekChar() == '{' {
+		l.readChar()
+		l.readChar()
+
+		for digitValue(l.ch) < 16 {
+			l.readChar()
+		}
+
+		if l.ch == '}' {
+			l.readChar()
+		}
+
+		return l.input[start:l.position]
+	}
+
+	if l.ch != 0 {
+		l.readChar()
+
+		// the rest of a multi-byte character
+		for l.ch&0xC0 == 0x80 {
+			l.readChar()
+		}
+	}
+
+	return l.input[start:l.position]
+}
+
+// readRawString reads a string between backticks, which can span several
+// lines and has no escape sequences or interpolations.
+func (l *Lexer) readRawString(pos token.Position) token.Token {
+	l.readChar()
+	start := l.position
+
+	for l.ch != '`' {
+		if l.ch == 0 {
+			return l.illegalToken(pos, "unterminated string")
+		}
+
+		l.readChar()
+	}
+
+	return token.Token{Type: token.RAW_STRING, Literal: l.input[start:l.position], Pos: pos}
+}
+
+// readCharLiteral reads a character literal, like 'x' or '\n', leaving the
+// current character at the closing quote.
+func (l *Lexer) readCharLiteral(pos token.Position) token.Token {
+	l.readChar()
+	raw := ""
+
+	for l.ch != '\'' {
+		switch l.ch {
+		case 0, '\n':
+			return l.illegalToken(pos, "unterminated character literal")
+		case '\\':
+			raw += l.readEscape()
+		default:
+			raw += l.input[l.position:l.readPosition]
+			l.readChar()
+		}
+	}
+
+	literal := l.input[pos.Offset:l.readPosition]
+
+	value, msg := unescape(raw)
+	if msg != "" {
+		tokType, literal := l.illegal(pos, literal, "%s", msg)
+		return token.Token{Type: tokType, Literal: literal, Pos: pos}
+	}
+
+	if utf8.RuneCountInString(value) != 1 {
+		tokType, literal := l.illegal(pos, literal, "expected one character in a character literal. got %s", literal)
+		return token.Token{Type: tokType, Literal: literal, Pos: pos}
+	}
+
+	return token.Token{Type: token.CHAR, Literal: value, Pos: pos}
+}
+
+func (l *Lexer) illegalToken(pos token.Position, msg string) token.Token {
+	end := l.position
+	if end > len(l.input) {
+		end = len(l.input)
+	}
+
+	tokType, literal := l.illegal(pos, l.input[pos.Offset:end], "%s", msg)
+	return token.Token{Type: tokType, Literal: literal, Pos: pos}
+}
+
+var escapes = map[byte]string{
+	'\\': "\\",
+	'\'': "'",
+	'"':  "\"",
+	'$':  "$",
+	'a':  "\a",
+	'b':  "\b",
+	'f':  "\f",
+	'n':  "\n",
+	'r':  "\r",
+	't':  "\t",
+	'v':  "\v",
+	// a backslash at the end of a line joins it to the next one
+	'\n': "",
+}
+
+// unescape replaces the escape sequences in part of a string literal,
+// returning what's wrong with the first invalid one if there is one.
+func unescape(raw string) (string, string) {
+	var out strings.Builder
+
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			out.WriteByte(raw[i])
+			continue
+		}
+
+		if i+1 == len(raw) {
+			return "", "unterminated escape sequence"
+		}
+
+		i++
+		if s, ok := escapes[raw[i]]; ok {
+			out.WriteString(s)
+			continue
+		}
+
+		if raw[i] != 'u' {
+			ch, _ := utf8.DecodeRuneInString(raw[i:])
+			return "", "unknown escape sequence \\" + string(ch)
+		}
+
+		end := strings.IndexByte(raw[i:], '}')
+		if !strings.HasPrefix(raw[i:], "u{") || end < 0 {
+			return "", `expected \u{...} with the hex code of a character`
+		}
+
+		digits := raw[i+2 : i+end]
+		code, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || len(digits) == 0 || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+			return "", "invalid character code \\u{" + digits + "}"
+		}
+
+		out.WriteRune(rune(code))
+		i += end
+	}
+
+	return out.String(), ""
+}
+
+// dedent strips the indentation from the lines of a triple quoted string.
+// A line break straight after the opening quote, and the line the closing
+// quote is on if there's nothing else on it, are dropped. Then the
+// indentation every other line starts with is removed, ignoring lines of
+// only whitespace, which are left empty, and the first line if it's on the
+// same line as the opening quote.
+func dedent(parts []stringPart) {
+	// the parts alternate between text and interpolations, which are
+	// replaced by a placeholder while the lines are looked at
+	const placeholder = "\x00"
+
+	texts := []string{}
+	for i := 0; i < len(parts); i += 2 {
+		texts = append(texts, parts[i].raw)
+	}
+
+	lines := strings.Split(strings.Join(texts, placeholder), "\n")
+	blank := func(line string) bool {
+		return strings.Trim(line, " \t\r") == ""
+	}
+
+	first := 1
+	if blank(lines[0]) {
+		lines, first = lines[1:], 0
+	}
+
+	if len(lines) > 1 && blank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	indent := ""
+	found := false
+	for i := first; i < len(lines); i++ {
+		if blank(lines[i]) {
+			continue
+		}
+
+		lead := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+		if !found {
+			indent, found = lead, true
+		}
+
+		for !strings.HasPrefix(lead, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+
+	for i := first; i < len(lines); i++ {
+		if blank(lines[i]) {
+			lines[i] = ""
+		} else {
+			lines[i] = strings.TrimPrefix(lines[i], indent)
+		}
+	}
+
+	texts = strings.Split(strings.Join(lines, "\n"), placeholder)
+	for i := range texts {
+		parts[i*2].raw = texts[i]
+	}
+}
+
+// Quote writes a string as a string literal, escaping the characters which
+// need it.
+func Quote(s string) string {
+	return `"` + Escape(s, false) + `"`
+}
+
+// QuoteChar writes a character as a character literal.
+func QuoteChar(ch rune) string {
+	if ch == '\'' {
+		return `'\''`
+	}
+
+	// a double quote doesn't need escaping between single quotes
+	if ch == '"' {
+		return `'"'`
+	}
+
+	return "'" + Escape(string(ch), false) + "'"
+}
+
+// Escape escapes the characters in a string which need it in a string
+// literal. In a triple quoted string, line breaks are left as they are, and
+// quotes are only escaped where they'd end the string.
+func Escape(s string, multiline bool) string {
+	var out strings.Builder
+
+	for i, ch := range s {
+		switch {
+		case multiline && (ch == '\n' || ch == '"' && !strings.HasPrefix(s[i:], `"""`)):
+			out.WriteRune(ch)
+		case ch == '"':
+			out.WriteString(`\"`)
+		case ch == '$' && strings.HasPrefix(s[i:], "${"):
+			out.WriteString(`\$`)
+		case ch == '\\':
+			out.WriteString(`\\`)
+		case ch == '\a':
+			out.WriteString(`\a`)
+		case ch == '\b':
+			out.WriteString(`\b`)
+		case ch == '\f':
+			out.WriteString(`\f`)
+		case ch == '\n':
+			out.WriteString(`\n`)
+		case ch == '\r':
+			out.WriteString(`\r`)
+		case ch == '\t':
+			out.WriteString(`\t`)
+		case ch == '\v':
+			out.WriteString(`\v`)
+		default:
+			out.WriteRune(ch)
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,420 @@
+package lsp
+
+import (
+	"../ast"
+	"../check"
+	"../lexer"
+	"../parser"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is an open file, along with what's known about its code.
+type document struct {
+	uri  string
+	text string
+
+	// lineStarts are the offsets of the start of each line
+	lineStarts []int
+
+	errors []*parser.Error
+
+	// program is nil if the text doesn't parse, and so are its problems
+	program  *ast.Program
+	problems []*check.Problem
+
+	// parsed is the last version of the text which parsed, which the
+	// bindings and members below were found in. Code which is being typed
+	// usually doesn't parse, so they're kept from it until the text parses
+	// again, and only the ones outside the part which has changed since
+	// are used.
+	parsed   string
+	bindings []*check.Binding
+	members  []*member
+
+	// refs maps every identifier referring to a binding to the binding
+	refs map[*ast.Identifier]*check.Binding
+
+	// fields are the identifiers after a dot, which name a field of a
+	// hash or a method of a model
+	fields []*ast.Identifier
+
+	// names are what completion suggests
+	names []CompletionItem
+
+	// prefix and suffix are the lengths of the start and end which the
+	// text has in common with parsed
+	prefix, suffix int
+}
+
+// member is a property or method of a model declared in the document.
+type member struct {
+	model *check.Binding
+	name  *ast.Identifier
+
+	// value is the function assigned to a method, or nil for a property
+	value ast.Expression
+}
+
+func newDocument(uri, text string, prev *document) *document {
+	d := &document{uri: uri, text: text, lineStarts: []int{0}}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+
+	l := lexer.NewFile(uri, text)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		d.errors = p.ErrorList()
+
+		if prev != nil {
+			d.parsed = prev.parsed
+			d.bindings = prev.bindings
+			d.members = prev.members
+			d.refs = prev.refs
+			d.fields = prev.fields
+			d.names = prev.names
+			d.prefix, d.suffix = common(d.parsed, text)
+		}
+
+		return d
+	}
+
+	d.program = program
+	d.parsed = text
+	d.problems = check.Program(program)
+	d.bindings = check.Resolve(program)
+	d.refs = make(map[*ast.Identifier]*check.Binding)
+
+	for _, b := range d.bindings {
+		d.refs[b.Decl] = b
+		for _, ref := range b.Refs {
+			d.refs[ref] = b
+		}
+	}
+
+	d.findMembers()
+	d.names = d.completionNames()
+
+	return d
+}
+
+// findMembers finds the properties and methods of the models declared in
+// the document, and every field access.
+func (d *document) findMembers() {
+	for _, b := range d.bindings {
+		if model, ok := b.Value.(*ast.ModelLiteral); ok {
+			for _, prop := range model.Parameters {
+				d.members = append(d.members, &member{model: b, name: prop})
+			}
+		}
+	}
+
+	ast.Inspect(d.program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.AssignExpression:
+			// a method is added to a model by assigning to one of its
+			// fields
+			dot, ok := node.Name.(*ast.InfixExpression)
+			if !ok || dot.Operator != "." || node.Operator != "" {
+				break
+			}
+
+			left, lok := dot.Left.(*ast.Identifier)
+			right, rok := dot.Right.(*ast.Identifier)
+			if !lok || !rok {
+				break
+			}
+
+			if b, ok := d.refs[left]; ok {
+				if _, ok := b.Value.(*ast.ModelLiteral); ok {
+					d.members = append(d.members, &member{model: b, name: right, value: node.Value})
+				}
+			}
+		case *ast.InfixExpression:
+			if node.Operator != "." {
+				break
+			}
+
+			if right, ok := node.Right.(*ast.Identifier); ok {
+				d.fields = append(d.fields, right)
+			}
+		}
+
+		return true
+	})
+}
+
+func (d *document) position(offset int) Position {
+	line := sort.Search(len(d.lineStarts), func(i int) bool {
+		return d.lineStarts[i] > offset
+	}) - 1
+
+	start := d.lineStarts[line]
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+
+	return Position{Line: line, Character: utf16Len(d.text[start:offset])}
+}
+
+// offset converts a position, whose character is counted in UTF-16 code
+// units, to an offset into the text.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+
+	offset := d.lineStarts[pos.Line]
+	for units := 0; units < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		offset += size
+
+		units++
+		if r >= 0x10000 {
+			units++
+		}
+	}
+
+	return offset
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// common returns the lengths of the start and end which two strings have
+// in common, without them overlapping in either.
+func common(a, b string) (prefix, suffix int) {
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-suffix-1] == b[len(b)-suffix-1] {
+		suffix++
+	}
+
+	return prefix, suffix
+}
+
+// start returns the offset of an identifier from the parsed text in the
+// current one, and whether it's still there.
+func (d *document) start(id *ast.Identifier) (int, bool) {
+	offset := id.Token.Pos.Offset
+
+	switch {
+	case d.program != nil:
+		return offset, true
+	case offset+len(id.Value) <= d.prefix:
+	case offset >= len(d.parsed)-d.suffix:
+		offset += len(d.text) - len(d.parsed)
+	default:
+		return 0, false
+	}
+
+	// it's a different name if it's been made longer
+	end := offset + len(id.Value)
+	if offset > 0 && isIdentChar(d.text[offset-1]) || end < len(d.text) && isIdentChar(d.text[end]) {
+		return 0, false
+	}
+
+	return offset, true
+}
+
+func (d *document) rangeOf(id *ast.Identifier) (Range, bool) {
+	start, ok := d.start(id)
+	return Range{Start: d.position(start), End: d.position(start + len(id.Value))}, ok
+}
+
+func (d *document) location(id *ast.Identifier) (Location, bool) {
+	r, ok := d.rangeOf(id)
+	return Location{URI: d.uri, Range: r}, ok
+}
+
+// wordRange returns the range of the word starting at an offset, or of one
+// character if there isn't a word there.
+func (d *document) wordRange(offset int) Range {
+	end := offset
+	for end < len(d.text) && isIdentChar(d.text[end]) {
+		end++
+	}
+
+	if end == offset && end < len(d.text) {
+		end++
+	}
+
+	return Range{Start: d.position(offset), End: d.position(end)}
+}
+
+func isIdentChar(ch byte) bool {
+	return ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9'
+}
+
+func (d *document) contains(id *ast.Identifier, offset int) bool {
+	start, ok := d.start(id)
+	return ok && start <= offset && offset <= start+len(id.Value)
+}
+
+// bindingAt returns the binding which the identifier at an offset refers
+// to, along with the identifier.
+func (d *document) bindingAt(offset int) (*check.Binding, *ast.Identifier) {
+	for id, b := range d.refs {
+		if d.contains(id, offset) {
+			return b, id
+		}
+	}
+
+	return nil, nil
+}
+
+// fieldAt returns the field name or model member at an offset.
+func (d *document) fieldAt(offset int) *ast.Identifier {
+	for _, field := range d.fields {
+		if d.contains(field, offset) {
+			return field
+		}
+	}
+
+	for _, m := range d.members {
+		if d.contains(m.name, offset) {
+			return m.name
+		}
+	}
+
+	return nil
+}
+
+// membersNamed returns the properties and methods with the given name.
+func (d *document) membersNamed(name string) []*member {
+	members := []*member{}
+	for _, m := range d.members {
+		if m.name.Value == name {
+			members = append(members, m)
+		}
+	}
+
+	return members
+}
+
+// identifierAt returns the identifier, or the part of one, which ends at
+// an offset, and whether it comes after a dot.
+func (d *document) identifierAt(offset int) (string, bool) {
+	start := offset
+	for start > 0 && isIdentChar(d.text[start-1]) {
+		start--
+	}
+
+	return d.text[start:offset], start > 0 && d.text[start-1] == '.'
+}
+
+// signature describes the value a binding is declared with.
+func signature(b *check.Binding) string {
+	switch value := b.Value.(type) {
+	case *ast.FunctionLiteral:
+		return b.Name + " := fn (" + params(value.Parameters) + ")"
+	case *ast.LambdaExpression:
+		return b.Name + " := \\(" + params(value.Parameters) + ")"
+	case *ast.ModelLiteral:
+		sig := b.Name + " := model (" + params(value.Parameters) + ")"
+
+		if value.ParentName != nil {
+			args := []string{}
+			for _, arg := range value.ParentArgs {
+				args = append(args, arg.String())
+			}
+
+			sig += " : " + (*value.ParentName).String() + " (" + strings.Join(args, ", ") + ")"
+		}
+
+		return sig
+	default:
+		return b.Name
+	}
+}
+
+func params(ids []*ast.Identifier) string {
+	names := []string{}
+	for _, id := range ids {
+		names = append(names, id.Value)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// memberSignature describes a property or method of a model.
+func memberSignature(m *member) string {
+	switch value := m.value.(type) {
+	case *ast.FunctionLiteral:
+		return m.model.Name + "." + m.name.Value + " = fn (" + params(value.Parameters) + ")"
+	case *ast.LambdaExpression:
+		return m.model.Name + "." + m.name.Value + " = \\(" + params(value.Parameters) + ")"
+	case nil:
+		return "property " + m.name.Value + " of " + m.model.Name
+	default:
+		return m.model.Name + "." + m.name.Value
+	}
+}
+
+// completionNames returns the names declared in the document, and the
+// members of its models, for completion.
+func (d *document) completionNames() []CompletionItem {
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+
+	for _, b := range d.bindings {
+		if seen[b.Name] {
+			continue
+		}
+
+		seen[b.Name] = true
+		items = append(items, CompletionItem{Label: b.Name, Kind: completionKind(b.Value), Detail: signature(b)})
+	}
+
+	for _, m := range d.members {
+		if seen["."+m.name.Value] {
+			continue
+		}
+
+		seen["."+m.name.Value] = true
+
+		kind := CompletionMethod
+		if m.value == nil {
+			kind = CompletionField
+		}
+
+		// members are marked with a dot, which is removed when they're
+		// suggested after one
+		items = append(items, CompletionItem{Label: "." + m.name.Value, Kind: kind, Detail: memberSignature(m)})
+	}
+
+	return items
+}
+
+func completionKind(value ast.Expression) int {
+	switch value.(type) {
+	case *ast.FunctionLiteral, *ast.LambdaExpression:
+		return CompletionFunction
+	case *ast.ModelLiteral:
+		return CompletionClass
+	default:
+		return CompletionVariable
+	}
+}
@@ -0,0 +1,334 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///test.lang"
+
+const source = `animal := model (name, sound);
+
+animal.speak = fn (times) {
+  print(this.name, "says", this.sound);
+};
+
+dog := model (name) : animal (name, "woof");
+
+double := \(x) = x * 2;
+
+d := dog("rex");
+d.speak(double(1));
+print(missing);
+`
+
+// client is a scripted client, which writes its messages to a buffer
+// before the server is run.
+type client struct {
+	in     bytes.Buffer
+	nextID int
+}
+
+func (c *client) request(method string, params interface{}) int {
+	c.nextID++
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	return c.nextID
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (c *client) send(msg interface{}) {
+	if err := writeMessage(&c.in, msg); err != nil {
+		panic(err)
+	}
+}
+
+func (c *client) at(line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": char},
+	}
+}
+
+type received struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+// run runs the server on the scripted messages, returning the responses
+// by ID and the notifications in order.
+func (c *client) run(t *testing.T) (map[int]received, []received) {
+	var out bytes.Buffer
+	if err := Serve(&c.in, &out); err != nil {
+		t.Fatalf("server failed: %v", err)
+	}
+
+	responses := make(map[int]received)
+	notifications := []received{}
+
+	r := bufio.NewReader(&out)
+	for {
+		content, err := readMessage(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		var msg received
+		if err := json.Unmarshal(content, &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.ID != nil {
+			responses[*msg.ID] = msg
+		} else {
+			notifications = append(notifications, msg)
+		}
+	}
+
+	return responses, notifications
+}
+
+func decodeResult(t *testing.T, msg received, v interface{}) {
+	if msg.Error != nil {
+		t.Fatalf("unexpected error: %v", msg.Error.Message)
+	}
+
+	if err := json.Unmarshal(msg.Result, v); err != nil {
+		t.Fatalf("couldn't decode %s: %v", msg.Result, err)
+	}
+}
+
+func ranges(locations []Location) []string {
+	strs := []string{}
+	for _, l := range locations {
+		strs = append(strs, fmt.Sprintf("%d:%d-%d", l.Range.Start.Line, l.Range.Start.Character, l.Range.End.Character))
+	}
+
+	return strs
+}
+
+func TestSession(t *testing.T) {
+	c := &client{}
+
+	beforeInit := c.request("textDocument/hover", c.at(0, 0))
+	initID := c.request("initialize", map[string]interface{}{})
+	c.notify("initialized", map[string]interface{}{})
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "version": 1, "text": source},
+	})
+
+	defAnimal := c.request("textDocument/definition", c.at(6, 23))
+	defSpeak := c.request("textDocument/definition", c.at(11, 3))
+	defProp := c.request("textDocument/definition", c.at(3, 14))
+
+	refs := c.request("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": 0, "character": 2},
+		"context":      map[string]bool{"includeDeclaration": true},
+	})
+
+	hoverDouble := c.request("textDocument/hover", c.at(11, 10))
+	hoverAnimal := c.request("textDocument/hover", c.at(0, 1))
+	hoverBuiltin := c.request("textDocument/hover", c.at(12, 2))
+
+	symbols := c.request("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+	})
+
+	unknown := c.request("textDocument/formatting", map[string]interface{}{})
+
+	// completion works on the last version which parsed
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": source + "d.sp\ndou"}},
+	})
+
+	completeField := c.request("textDocument/completion", c.at(13, 4))
+	completeName := c.request("textDocument/completion", c.at(14, 3))
+
+	// and so do definitions, references, hovers and symbols, moved to
+	// where they are in the new text
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 3},
+		"contentChanges": []map[string]string{{"text": ")\n" + source + "d.sp\ndou"}},
+	})
+
+	staleDef := c.request("textDocument/definition", c.at(7, 23))
+	staleRefs := c.request("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": 1, "character": 2},
+		"context":      map[string]bool{"includeDeclaration": true},
+	})
+	staleHover := c.request("textDocument/hover", c.at(12, 10))
+	staleSymbols := c.request("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+	})
+
+	shutdown := c.request("shutdown", nil)
+	c.notify("exit", nil)
+
+	responses, notifications := c.run(t)
+
+	if err := responses[beforeInit].Error; err == nil || err.Code != codeNotInitialized {
+		t.Errorf("expected a request before initialising to fail, got %+v", responses[beforeInit])
+	}
+
+	var init InitializeResult
+	decodeResult(t, responses[initID], &init)
+	if !init.Capabilities.DefinitionProvider || init.Capabilities.TextDocumentSync != 1 {
+		t.Errorf("wrong capabilities: %+v", init.Capabilities)
+	}
+
+	tests := []struct {
+		id       int
+		expected []string
+	}{
+		{defAnimal, []string{"0:0-6"}},
+		{defSpeak, []string{"2:7-12"}},
+		{defProp, []string{"0:17-21", "6:14-18"}},
+		{refs, []string{"0:0-6", "2:0-6", "6:22-28"}},
+		{staleDef, []string{"1:0-6"}},
+		{staleRefs, []string{"1:0-6", "3:0-6", "7:22-28"}},
+	}
+
+	for _, tt := range tests {
+		var locations []Location
+		decodeResult(t, responses[tt.id], &locations)
+
+		if got := ranges(locations); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("request %d: expected %v, got %v", tt.id, tt.expected, got)
+		}
+	}
+
+	hovers := []struct {
+		id       int
+		contains []string
+	}{
+		{hoverDouble, []string{"double := \\(x)"}},
+		{hoverAnimal, []string{"animal := model (name, sound)", "animal.speak = fn (times)"}},
+		{hoverBuiltin, []string{"builtin function print"}},
+		{staleHover, []string{"double := \\(x)"}},
+	}
+
+	for _, tt := range hovers {
+		var hover Hover
+		decodeResult(t, responses[tt.id], &hover)
+
+		for _, s := range tt.contains {
+			if !strings.Contains(hover.Contents.Value, s) {
+				t.Errorf("expected hover %d to contain %q, got %q", tt.id, s, hover.Contents.Value)
+			}
+		}
+	}
+
+	for _, id := range []int{symbols, staleSymbols} {
+		var syms []DocumentSymbol
+		decodeResult(t, responses[id], &syms)
+
+		names := []string{}
+		for _, sym := range syms {
+			names = append(names, sym.Name)
+		}
+
+		if !reflect.DeepEqual(names, []string{"animal", "dog", "double", "d"}) {
+			t.Errorf("wrong symbols: %v", names)
+		}
+
+		if len(syms) > 0 && (syms[0].Kind != SymbolClass || len(syms[0].Children) != 3) {
+			t.Errorf("expected animal to be a model with three members, got %+v", syms[0])
+		}
+	}
+
+	if err := responses[unknown].Error; err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("expected an unknown method to fail, got %+v", responses[unknown])
+	}
+
+	completions := []struct {
+		id       int
+		expected string
+	}{
+		{completeField, "speak"},
+		{completeName, "double"},
+	}
+
+	for _, tt := range completions {
+		var items []CompletionItem
+		decodeResult(t, responses[tt.id], &items)
+
+		if len(items) != 1 || items[0].Label != tt.expected {
+			t.Errorf("expected completion %d to suggest only %s, got %+v", tt.id, tt.expected, items)
+		}
+	}
+
+	if _, ok := responses[shutdown]; !ok {
+		t.Errorf("expected a response to shutdown")
+	}
+
+	if len(notifications) != 3 {
+		t.Fatalf("expected three sets of diagnostics, got %d", len(notifications))
+	}
+
+	var diags PublishDiagnosticsParams
+	if err := json.Unmarshal(notifications[0].Params, &diags); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []string{}
+	for _, d := range diags.Diagnostics {
+		messages = append(messages, fmt.Sprintf("%d:%d %s", d.Range.Start.Line, d.Range.Start.Character, d.Message))
+	}
+
+	expected := []string{"12:6 identifier not found: missing"}
+
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected diagnostics %q, got %q", expected, messages)
+	}
+
+	if err := json.Unmarshal(notifications[1].Params, &diags); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diags.Diagnostics) == 0 || diags.Diagnostics[0].Severity != SeverityError {
+		t.Errorf("expected a parser error after the change, got %+v", diags.Diagnostics)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	d := newDocument(uri, "a := \"😀é\"; b;\nc;", nil)
+
+	tests := []struct {
+		offset int
+		pos    Position
+	}{
+		{0, Position{0, 0}},
+		{5, Position{0, 5}},
+		{6, Position{0, 6}},
+		{10, Position{0, 8}},
+		{13, Position{0, 10}},
+		{17, Position{0, 14}},
+		{18, Position{1, 0}},
+	}
+
+	for _, tt := range tests {
+		if got := d.position(tt.offset); got != tt.pos {
+			t.Errorf("position(%d): expected %v, got %v", tt.offset, tt.pos, got)
+		}
+
+		if got := d.offset(tt.pos); got != tt.offset {
+			t.Errorf("offset(%v): expected %d, got %d", tt.pos, tt.offset, got)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+// Package lsp implements a Language Server Protocol server, so editors can
+// show errors in programs as they're written, jump to where names are
+// declared, and suggest completions.
+package lsp
+
+import (
+	"../ast"
+	"../check"
+	"../evaluator"
+	"../object"
+	"../token"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+type handler func(s *server, params json.RawMessage) (interface{}, error)
+
+var handlers map[string]handler
+
+func init() {
+	handlers = map[string]handler{
+		"initialize":                  (*server).initialize,
+		"initialized":                 nop,
+		"shutdown":                    (*server).stop,
+		"textDocument/didOpen":        (*server).didOpen,
+		"textDocument/didChange":      (*server).didChange,
+		"textDocument/didSave":        nop,
+		"textDocument/didClose":       (*server).didClose,
+		"textDocument/definition":     (*server).definition,
+		"textDocument/references":     (*server).references,
+		"textDocument/hover":          (*server).hover,
+		"textDocument/documentSymbol": (*server).documentSymbol,
+		"textDocument/completion":     (*server).completion,
+	}
+}
+
+func nop(s *server, params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// Serve reads requests from in and writes responses to out until the
+// client asks it to exit or closes the input.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+
+	for {
+		content, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exited without being shut down")
+			}
+
+			return nil
+		}
+
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs the handler for a request, replying to it if it isn't a
+// notification.
+func (s *server) handle(req *request) error {
+	h, ok := handlers[req.Method]
+
+	var (
+		result interface{}
+		err    error
+	)
+
+	switch {
+	case !ok:
+		err = &responseError{codeMethodNotFound, "method not found: " + req.Method}
+	case !s.initialized && req.Method != "initialize":
+		err = &responseError{codeNotInitialized, "the server hasn't been initialised"}
+	default:
+		result, err = h(s, req.Params)
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+
+	return s.reply(req.ID, result, err)
+}
+
+func (s *server) reply(id *json.RawMessage, result interface{}, err error) error {
+	resp := response{JSONRPC: "2.0", ID: id}
+
+	if err != nil {
+		rerr, ok := err.(*responseError)
+		if !ok {
+			rerr = &responseError{codeInvalidRequest, err.Error()}
+		}
+
+		resp.Error = rerr
+	} else {
+		content, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+
+		raw := json.RawMessage(content)
+		resp.Result = &raw
+	}
+
+	return writeMessage(s.out, resp)
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{codeInvalidParams, err.Error()}
+	}
+
+	return nil
+}
+
+func (s *server) initialize(params json.RawMessage) (interface{}, error) {
+	s.initialized = true
+
+	result := InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:       1,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+			CompletionProvider:     &CompletionOptions{TriggerCharacters: []string{"."}},
+		},
+	}
+	result.ServerInfo.Name = "lang"
+
+	return result, nil
+}
+
+func (s *server) stop(params json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *server) didOpen(params json.RawMessage) (interface{}, error) {
+	var p DidOpenTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *server) didChange(params json.RawMessage) (interface{}, error) {
+	var p DidChangeTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	// the whole text is sent each time, so only the last change matters
+	if len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+
+	return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+}
+
+func (s *server) didClose(params json.RawMessage) (interface{}, error) {
+	var p DidCloseTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	delete(s.docs, p.TextDocument.URI)
+
+	return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         p.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+}
+
+// update analyses the new text of a document and publishes its
+// diagnostics.
+func (s *server) update(uri, text string) error {
+	doc := newDocument(uri, text, s.docs[uri])
+	s.docs[uri] = doc
+
+	diagnostics := []Diagnostic{}
+
+	for _, err := range doc.errors {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    doc.wordRange(err.Pos.Offset),
+			Severity: SeverityError,
+			Source:   "lang",
+			Message:  err.Message,
+		})
+	}
+
+	for _, problem := range doc.problems {
+		severity := SeverityError
+		if problem.Warning {
+			severity = SeverityWarning
+		}
+
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    doc.wordRange(problem.Pos.Offset),
+			Severity: severity,
+			Source:   "lang",
+			Message:  problem.Message,
+		})
+	}
+
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnostics,
+	})
+}
+
+// document finds the document and offset a request is about.
+func (s *server) document(params json.RawMessage, p *TextDocumentPositionParams) (*document, int, error) {
+	if err := decode(params, p); err != nil {
+		return nil, 0, err
+	}
+
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, 0, &responseError{codeInvalidParams, "unknown document: " + p.TextDocument.URI}
+	}
+
+	return doc, doc.offset(p.Position), nil
+}
+
+func (s *server) definition(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	doc, offset, err := s.document(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []*ast.Identifier{}
+
+	if b, _ := doc.bindingAt(offset); b != nil {
+		ids = append(ids, b.Decl)
+	} else if field := doc.fieldAt(offset); field != nil {
+		for _, m := range doc.membersNamed(field.Value) {
+			ids = append(ids, m.name)
+		}
+	}
+
+	locations := []Location{}
+	for _, id := range ids {
+		if loc, ok := doc.location(id); ok {
+			locations = append(locations, loc)
+		}
+	}
+
+	return locations, nil
+}
+
+func (s *server) references(params json.RawMessage) (interface{}, error) {
+	var p ReferenceParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	doc, offset, err := s.document(params, &p.TextDocumentPositionParams)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []*ast.Identifier{}
+
+	if b, _ := doc.bindingAt(offset); b != nil {
+		if p.Context.IncludeDeclaration {
+			ids = append(ids, b.Decl)
+		}
+
+		ids = append(ids, b.Refs...)
+	} else if field := doc.fieldAt(offset); field != nil {
+		if p.Context.IncludeDeclaration {
+			for _, m := range doc.membersNamed(field.Value) {
+				ids = append(ids, m.name)
+			}
+		}
+
+		for _, f := range doc.fields {
+			if f.Value == field.Value {
+				ids = append(ids, f)
+			}
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Token.Pos.Offset < ids[j].Token.Pos.Offset
+	})
+
+	locations := []Location{}
+	for i, id := range ids {
+		// a method declaration is also a field access
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+
+		if loc, ok := doc.location(id); ok {
+			locations = append(locations, loc)
+		}
+	}
+
+	return locations, nil
+}
+
+func (s *server) hover(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	doc, offset, err := s.document(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lines []string
+		id    *ast.Identifier
+	)
+
+	if b, ref := doc.bindingAt(offset); b != nil {
+		id = ref
+		lines = append(lines, signature(b))
+
+		for _, m := range doc.members {
+			if m.model == b && m.value != nil {
+				lines = append(lines, memberSignature(m))
+			}
+		}
+	} else if field := doc.fieldAt(offset); field != nil {
+		id = field
+		for _, m := range doc.membersNamed(field.Value) {
+			lines = append(lines, memberSignature(m))
+		}
+	} else if name, _ := doc.identifierAt(doc.wordEnd(offset)); name != "" {
+		return builtinHover(name), nil
+	}
+
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	r, _ := doc.rangeOf(id)
+
+	return Hover{Contents: codeBlock(lines), Range: &r}, nil
+}
+
+// wordEnd returns the offset of the end of the word containing an offset.
+func (d *document) wordEnd(offset int) int {
+	for offset < len(d.text) && isIdentChar(d.text[offset]) {
+		offset++
+	}
+
+	return offset
+}
+
+func builtinHover(name string) interface{} {
+	builtin, ok := evaluator.LookupBuiltin(name)
+	if !ok {
+		return nil
+	}
+
+	if model, ok := builtin.(*object.Model); ok {
+		return Hover{Contents: codeBlock([]string{name + " := " + model.Inspect()})}
+	}
+
+	return Hover{Contents: codeBlock([]string{"builtin function " + name})}
+}
+
+func codeBlock(lines []string) MarkupContent {
+	return MarkupContent{Kind: "markdown", Value: "```lang\n" + strings.Join(lines, "\n") + "\n```"}
+}
+
+func (s *server) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p DocumentSymbolParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, &responseError{codeInvalidParams, "unknown document: " + p.TextDocument.URI}
+	}
+
+	symbols := []DocumentSymbol{}
+
+	for _, b := range doc.bindings {
+		if !b.TopLevel {
+			continue
+		}
+
+		r, ok := doc.rangeOf(b.Decl)
+		if !ok {
+			continue
+		}
+
+		symbol := DocumentSymbol{Name: b.Name, Detail: signature(b), Range: r, SelectionRange: r}
+
+		switch b.Value.(type) {
+		case *ast.FunctionLiteral, *ast.LambdaExpression:
+			symbol.Kind = SymbolFunction
+		case *ast.ModelLiteral:
+			symbol.Kind = SymbolClass
+			symbol.Children = doc.memberSymbols(b)
+		default:
+			symbol.Kind = SymbolVariable
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols, nil
+}
+
+func (d *document) memberSymbols(model *check.Binding) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	for _, m := range d.members {
+		if m.model != model {
+			continue
+		}
+
+		kind := SymbolMethod
+		if m.value == nil {
+			kind = SymbolProperty
+		}
+
+		r, ok := d.rangeOf(m.name)
+		if !ok {
+			continue
+		}
+
+		symbols = append(symbols, DocumentSymbol{
+			Name:           m.name.Value,
+			Detail:         memberSignature(m),
+			Kind:           kind,
+			Range:          r,
+			SelectionRange: r,
+		})
+	}
+
+	return symbols
+}
+
+func (s *server) completion(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	doc, offset, err := s.document(params, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, afterDot := doc.identifierAt(offset)
+	items := []CompletionItem{}
+
+	if afterDot {
+		for _, item := range doc.names {
+			if strings.HasPrefix(item.Label, "."+prefix) {
+				item.Label = item.Label[1:]
+				items = append(items, item)
+			}
+		}
+
+		return items, nil
+	}
+
+	for _, item := range doc.names {
+		if !strings.HasPrefix(item.Label, ".") && strings.HasPrefix(item.Label, prefix) {
+			items = append(items, item)
+		}
+	}
+
+	for _, name := range evaluator.BuiltinNames() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		kind := CompletionFunction
+		if _, ok := object.DefaultModels[name]; ok {
+			kind = CompletionClass
+		}
+
+		items = append(items, CompletionItem{Label: name, Kind: kind, Detail: "builtin"})
+	}
+
+	keywords := token.Keywords()
+	sort.Strings(keywords)
+
+	for _, word := range keywords {
+		if strings.HasPrefix(word, prefix) {
+			items = append(items, CompletionItem{Label: word, Kind: CompletionKeyword})
+		}
+	}
+
+	return items, nil
+}
@@ -1,56 +1,186 @@
 package main
 
 import (
+	"./compiler"
 	"./evaluator"
 	"./lexer"
+	"./lsp"
 	"./object"
 	"./parser"
 	"./repl"
+	"./token"
+	"./vm"
+	"context"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// searchPaths is a flag which can be given more than once, each time adding
+// another directory to search for imported files.
+type searchPaths []string
+
+func (s *searchPaths) String() string {
+	return strings.Join(*s, string(os.PathListSeparator))
+}
+
+func (s *searchPaths) Set(dir string) error {
+	*s = append(*s, dir)
+	return nil
+}
+
+var (
+	paths searchPaths
+	useVM = flag.Bool("vm", false, "run the file with the bytecode virtual machine")
+
+	timeout  = flag.Duration("timeout", 0, "stop the program after this long (0 for no limit)")
+	maxSteps = flag.Int("max-steps", 0, "the most steps the program can take (0 for no limit)")
+	maxDepth = flag.Int("max-depth", object.DefaultLimits.MaxDepth, "how deeply calls can be nested (0 for no limit)")
+	maxAlloc = flag.Int("max-alloc", 0, "the largest array or string the program can build (0 for no limit)")
 )
 
 func main() {
 	if len(os.Args) > 1 {
-		runFile()
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(runFmt(os.Args[2:]))
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "debug":
+			os.Exit(runDebug(os.Args[2:]))
+		case "lsp":
+			if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			return
+		}
+	}
+
+	flag.Var(&paths, "path", "a directory to search for imported files (can be repeated)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s fmt [-w | -check] [path ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s check [-q] [path ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s debug file | -dap\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lsp\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		runFile(flag.Arg(0))
 	} else {
 		startREPL()
 	}
 }
 
-func runFile() {
-	fileName := os.Args[1]
+func newEnvironment() *object.Environment {
+	env := object.NewEnvironment()
+
+	rt := env.Runtime()
+	rt.SearchPaths = paths
+	rt.Limits = object.Limits{
+		MaxSteps:      *maxSteps,
+		MaxDepth:      *maxDepth,
+		MaxAllocation: *maxAlloc,
+	}
 
+	return env
+}
+
+func runFile(fileName string) {
 	bytes, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		fmt.Println(err)
+		return
 	}
 
 	text := string(bytes)
 
-	env := object.NewEnvironment()
-	l := lexer.New(text)
+	env := newEnvironment()
+	env.Runtime().Sources[fileName] = text
+
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+
+		env.Runtime().SetContext(ctx)
+	}
+
+	// so that the file importing itself is detected as a cycle
+	if abs, err := filepath.Abs(fileName); err == nil {
+		env.Runtime().Importing = []string{abs}
+	}
+
+	l := lexer.NewFile(fileName, text)
 	p := parser.New(l)
 
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		printParserErrors(p.Errors())
+		printParserErrors(p.ErrorList(), text)
 		return
 	}
 
-	result := evaluator.Eval(program, env)
-	if result.Type() == object.ERROR_OBJ {
-		fmt.Println(result.Inspect())
+	var result object.Object
+
+	if *useVM {
+		c := compiler.New()
+		bytecode := c.Compile(program)
+		if len(c.Errors()) != 0 {
+			printCompilerErrors(c.Errors(), text)
+			return
+		}
+
+		result = vm.New(bytecode, env).Run()
+	} else {
+		result = evaluator.Eval(program, env)
+	}
+
+	if err, ok := result.(*object.Error); ok {
+		printRuntimeError(err, env.Runtime().Sources[err.Pos.File])
 		return
 	}
 }
 
-func printParserErrors(errors []string) {
+func printParserErrors(errors []*parser.Error, text string) {
 	fmt.Println("parser errors:")
-	for _, msg := range errors {
-		fmt.Println("  " + msg)
+	for _, err := range errors {
+		fmt.Println("  " + err.Error())
+		printExcerpt(text, err.Pos)
+	}
+}
+
+func printCompilerErrors(errors []*compiler.Error, text string) {
+	fmt.Println("compiler errors:")
+	for _, err := range errors {
+		fmt.Println("  " + err.Error())
+		printExcerpt(text, err.Pos)
+	}
+}
+
+func printRuntimeError(err *object.Error, text string) {
+	fmt.Printf("%v: %v\n", err.Pos, err.Inspect())
+	printExcerpt(text, err.Pos)
+
+	if tb := err.Traceback(); tb != "" {
+		fmt.Println(tb)
+	}
+}
+
+func printExcerpt(text string, pos token.Position) {
+	excerpt := lexer.Excerpt(text, pos)
+	if excerpt == "" {
+		return
+	}
+
+	for _, line := range strings.Split(excerpt, "\n") {
+		fmt.Println("    " + line)
 	}
 }
 
@@ -61,5 +191,5 @@ func startREPL() {
 	}
 
 	fmt.Printf("Hello %s!\n", user.Username)
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, newEnvironment())
 }
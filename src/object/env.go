@@ -1,33 +1,56 @@
 package object
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store   map[string]Object
+	outer   *Environment
+	runtime *Runtime
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	env := &Environment{store: s, outer: nil}
+	env := &Environment{store: s, outer: nil, runtime: NewRuntime()}
+	return env
+}
+
+// NewModuleEnvironment creates a new top-level environment, which shares
+// the given runtime with the environment of the program importing it.
+func NewModuleEnvironment(rt *Runtime) *Environment {
+	s := make(map[string]Object)
+	env := &Environment{store: s, outer: nil, runtime: rt}
 	return env
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+	s := make(map[string]Object)
+	env := &Environment{store: s, outer: outer, runtime: outer.runtime}
 	return env
 }
 
-func (e *Environment) Get(name string) (Object, bool) {
-	for k, v := range DefaultModels {
-		if k == name {
-			return v, true
-		}
-	}
+// Outer returns the environment this one is enclosed in, or nil if it's a
+// top-level environment.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Runtime returns the state shared by this environment and every other
+// environment in the same program.
+func (e *Environment) Runtime() *Runtime {
+	return e.runtime
+}
 
+func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+		return e.outer.Get(name)
+	}
+
+	// names declared in the program shadow the builtin models
+	if !ok {
+		if model, isModel := DefaultModels[name]; isModel {
+			return model, true
+		}
 	}
+
 	return obj, ok
 }
 
@@ -36,12 +59,26 @@ func (e *Environment) Declare(name string, val Object) Object {
 	return val
 }
 
+// Assign sets the value of a name in the innermost environment it's
+// declared in, or declares it in this environment if it isn't declared
+// anywhere.
 func (e *Environment) Assign(name string, val Object) Object {
-	if e.outer != nil {
-		if _, ok := e.outer.store[name]; ok {
-			return e.outer.Assign(name, val)
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return env.Declare(name, val)
 		}
 	}
 
 	return e.Declare(name, val)
 }
+
+// Bindings returns a copy of the names declared directly in this
+// environment, not including any outer environments.
+func (e *Environment) Bindings() map[string]Object {
+	bindings := make(map[string]Object, len(e.store))
+	for k, v := range e.store {
+		bindings[k] = v
+	}
+
+	return bindings
+}
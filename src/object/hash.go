@@ -1,39 +1,172 @@
 package object
 
 import (
+	"../lexer"
+	"../token"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 )
 
+// Hash maps keys to values, remembering the order the keys were added in.
+// Keys can be numbers, booleans, strings, characters, null, or arrays of
+// them.
 type Hash struct {
-	Pairs map[String]Object
 	Model *Model
+
+	pairs map[HashKey]HashPair
+	order []HashKey
+}
+
+// HashPair is a key in a hash and its value.
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// HashKey identifies a key in a hash. Keys which are equal have the same
+// HashKey, so 1 and 1.0 are the same key.
+type HashKey struct {
+	Type  ObjectType
+	Value string
 }
 
 func NewHash(m *Model) *Hash {
-	return &Hash{Pairs: make(map[String]Object), Model: m}
+	return &Hash{pairs: make(map[HashKey]HashPair), Model: m}
+}
+
+// HashKeyOf returns the HashKey of a value, and false if it can't be used
+// as a key.
+func HashKeyOf(obj Object) (HashKey, bool) {
+	return hashKeyOf(obj, nil)
+}
+
+// hashKeyOf returns the HashKey of a value inside the arrays which are
+// being made into keys, so an array which contains itself can't be used
+// as a key instead of never ending.
+func hashKeyOf(obj Object, outer map[*Array]bool) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case *String:
+		return HashKey{Type: STRING_OBJ, Value: obj.Value}, true
+	case *Char:
+		// a character is the same key as the string holding just it
+		return HashKey{Type: STRING_OBJ, Value: string(obj.Value)}, true
+	case *Integer:
+		return HashKey{Type: NUMBER_OBJ, Value: strconv.FormatInt(obj.Value, 10)}, true
+	case *Number:
+		if obj.Value == math.Trunc(obj.Value) && math.Abs(obj.Value) < math.MaxInt64 {
+			return HashKey{Type: NUMBER_OBJ, Value: strconv.FormatInt(int64(obj.Value), 10)}, true
+		}
+
+		return HashKey{Type: NUMBER_OBJ, Value: strconv.FormatFloat(obj.Value, 'g', -1, 64)}, true
+	case *Boolean:
+		return HashKey{Type: BOOLEAN_OBJ, Value: strconv.FormatBool(obj.Value)}, true
+	case *Null:
+		return HashKey{Type: NULL_OBJ}, true
+	case *Array:
+		if outer[obj] {
+			return HashKey{}, false
+		}
+
+		if outer == nil {
+			outer = make(map[*Array]bool)
+		}
+
+		outer[obj] = true
+		defer delete(outer, obj)
+
+		// each element's key is prefixed with its length, so the keys of
+		// different arrays can't run together into the same string
+		var out strings.Builder
+		for _, elem := range obj.Elements {
+			key, ok := hashKeyOf(elem, outer)
+			if !ok {
+				return HashKey{}, false
+			}
+
+			fmt.Fprintf(&out, "%s:%d:%s", key.Type, len(key.Value), key.Value)
+		}
+
+		return HashKey{Type: ARRAY_OBJ, Value: out.String()}, true
+	default:
+		return HashKey{}, false
+	}
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 
+// Inspect writes the hash's keys like names if they all could be names, as
+// in {x: 1, y: 2}. Otherwise, string and character keys are quoted, so keys
+// like 1 and "1" can be told apart.
 func (h *Hash) Inspect() string {
+	names := true
+	for _, pair := range h.Pairs() {
+		if s, ok := pair.Key.(*String); !ok || !isName(s.Value) {
+			names = false
+			break
+		}
+	}
+
 	pairs := []string{}
-	for k, v := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", k.Value, v.Inspect()))
+	for _, pair := range h.Pairs() {
+		key := pair.Key.Inspect()
+		if !names {
+			key = inspectKey(pair.Key)
+		}
+
+		pairs = append(pairs, fmt.Sprintf("%s: %s", key, pair.Value.Inspect()))
 	}
 
 	return fmt.Sprintf("{%v}", strings.Join(pairs, ", "))
 }
 
+// inspectKey writes a key with its strings and characters quoted.
+func inspectKey(key Object) string {
+	switch key := key.(type) {
+	case *String:
+		return lexer.Quote(key.Value)
+	case *Char:
+		return lexer.QuoteChar(key.Value)
+	case *Array:
+		elements := make([]string, len(key.Elements))
+		for i, elem := range key.Elements {
+			elements[i] = inspectKey(elem)
+		}
+
+		return "[" + strings.Join(elements, ", ") + "]"
+	default:
+		return key.Inspect()
+	}
+}
+
+// isName reports whether a string could be written as a name, like a key
+// in {x: 1}.
+func isName(s string) bool {
+	if s == "" || token.LookupIdent(s) != token.ID {
+		return false
+	}
+
+	for i, ch := range s {
+		letter := 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+		if !letter && (i == 0 || ch < '0' || ch > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (h *Hash) Equals(other Object) bool {
 	switch other := other.(type) {
 	case *Hash:
-		if !h.Model.Equals(other.Model) {
+		if !h.Model.Equals(other.Model) || h.Len() != other.Len() {
 			return false
 		}
 
-		for k, v := range h.Pairs {
-			if !v.Equals(other.Get(k.Value)) {
+		for key, pair := range h.pairs {
+			o, ok := other.pairs[key]
+			if !ok || !pair.Value.Equals(o.Value) {
 				return false
 			}
 		}
@@ -44,18 +177,90 @@ func (h *Hash) Equals(other Object) bool {
 	}
 }
 
-func (h *Hash) Get(name string) Object {
-	if meth, ok := h.Model.GetMethod(name); ok {
-		meth.Hash = h
-		return meth
+// Len returns the number of pairs in the hash.
+func (h *Hash) Len() int {
+	return len(h.order)
+}
+
+// Pairs returns the pairs in the hash, in the order their keys were added.
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, len(h.order))
+	for i, key := range h.order {
+		pairs[i] = h.pairs[key]
+	}
+
+	return pairs
+}
+
+// Lookup returns the value of a key, and false if the hash doesn't have it.
+func (h *Hash) Lookup(key Object) (Object, bool) {
+	hashKey, ok := HashKeyOf(key)
+	if !ok {
+		return nil, false
+	}
+
+	pair, ok := h.pairs[hashKey]
+	return pair.Value, ok
+}
+
+// Insert sets the value of a key, adding the key after the others if the
+// hash doesn't have it already. It returns an error if the key can't be
+// used in a hash.
+func (h *Hash) Insert(key, val Object) *Error {
+	if err := CheckHashKey(key); err != nil {
+		return err
+	}
+
+	hashKey, _ := HashKeyOf(key)
+	if pair, ok := h.pairs[hashKey]; ok {
+		h.pairs[hashKey] = HashPair{Key: pair.Key, Value: val}
+		return nil
+	}
+
+	h.pairs[hashKey] = HashPair{Key: copyKey(key), Value: val}
+	h.order = append(h.order, hashKey)
+
+	return nil
+}
+
+// Delete removes a key from the hash, and reports whether it was there.
+func (h *Hash) Delete(key Object) bool {
+	hashKey, ok := HashKeyOf(key)
+	if !ok {
+		return false
+	}
+
+	if _, ok := h.pairs[hashKey]; !ok {
+		return false
 	}
 
-	for k, v := range h.Pairs {
-		if k.Value == name {
-			return v
+	delete(h.pairs, hashKey)
+	for i, k := range h.order {
+		if k == hashKey {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
 		}
 	}
 
+	return true
+}
+
+// Field returns the method of the hash's model with the given name, or
+// else the value of the string key, and false if there's neither.
+func (h *Hash) Field(name string) (Object, bool) {
+	if meth, ok := h.Model.GetMethod(name); ok {
+		meth.This = h
+		return meth, true
+	}
+
+	return h.Lookup(&String{Value: name})
+}
+
+func (h *Hash) Get(name string) Object {
+	if val, ok := h.Field(name); ok {
+		return val
+	}
+
 	return &Null{}
 }
 
@@ -64,14 +269,45 @@ func (h *Hash) Set(name string, val Object) {
 		return
 	}
 
-	h.Pairs[String{Value: name}] = val
+	h.Insert(&String{Value: name}, val)
+}
+
+// copyKey copies an array used as a key, so changing the array afterwards
+// doesn't change the key.
+func copyKey(key Object) Object {
+	arr, ok := key.(*Array)
+	if !ok {
+		return key
+	}
+
+	elements := make([]Object, len(arr.Elements))
+	for i, elem := range arr.Elements {
+		elements[i] = copyKey(elem)
+	}
+
+	return &Array{Elements: elements}
 }
 
 // Method Instance
 
+// MethodInstance is a method bound to the value it was accessed on, which
+// is a hash, or a value of a builtin type like a string.
 type MethodInstance struct {
 	Function *Object
-	Hash     *Hash
+	This     Object
+	Name     string
+	Model    *Model
+}
+
+// FullName returns the method's name qualified by the name of the model it
+// was defined on, e.g. "vector._plus".
+func (mi *MethodInstance) FullName() string {
+	model := "<model>"
+	if mi.Model != nil && mi.Model.Name != "" {
+		model = mi.Model.Name
+	}
+
+	return model + "." + mi.Name
 }
 
 func (mi *MethodInstance) Type() ObjectType { return METHOD_INSTANCE_OBJ }
@@ -79,7 +315,7 @@ func (mi *MethodInstance) Inspect() string  { return "<method instance>" }
 func (mi *MethodInstance) Equals(other Object) bool {
 	switch other := other.(type) {
 	case *MethodInstance:
-		return (*mi.Function).Equals(*other.Function) && mi.Hash.Equals(other.Hash)
+		return (*mi.Function).Equals(*other.Function) && mi.This.Equals(other.This)
 	default:
 		return false
 	}
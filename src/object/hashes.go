@@ -0,0 +1,138 @@
+package object
+
+import "../ast"
+
+// HASH_MODEL holds the methods every hash has, like keys. It isn't the
+// model of any hash: its methods are only found when a hash has no model
+// method or key with the same name, so they never get in their way. They
+// can always be called through the model instead, like hash.keys(h), and
+// calling the model makes an empty hash.
+var HASH_MODEL = &Model{
+	Name:       "hash",
+	Parent:     nil,
+	Properties: []*ast.Identifier{},
+	Methods:    map[*ast.Identifier]Object{},
+	Id:         -6,
+}
+
+func initialiseHashModel() bool {
+	HASH_MODEL.Methods = map[*ast.Identifier]Object{
+		newID("_new"): &Builtin{
+			Fn: func(env *Environment, this Object, args ...Object) Object {
+				return NewHash(OBJECT_MODEL)
+			},
+		},
+		newID("len"): hashMethod("len", 0, 0, func(env *Environment, h *Hash, args []Object) Object {
+			return &Integer{Value: int64(h.Len())}
+		}),
+		newID("keys"): hashMethod("keys", 0, 0, func(env *Environment, h *Hash, args []Object) Object {
+			keys := []Object{}
+			for _, pair := range h.Pairs() {
+				keys = append(keys, pair.Key)
+			}
+
+			return &Array{Elements: keys}
+		}),
+		newID("values"): hashMethod("values", 0, 0, func(env *Environment, h *Hash, args []Object) Object {
+			values := []Object{}
+			for _, pair := range h.Pairs() {
+				values = append(values, pair.Value)
+			}
+
+			return &Array{Elements: values}
+		}),
+		newID("items"): hashMethod("items", 0, 0, func(env *Environment, h *Hash, args []Object) Object {
+			items := []Object{}
+			for _, pair := range h.Pairs() {
+				items = append(items, &Array{Elements: []Object{pair.Key, pair.Value}})
+			}
+
+			return &Array{Elements: items}
+		}),
+		newID("has"): hashMethod("has", 1, 1, func(env *Environment, h *Hash, args []Object) Object {
+			if err := CheckHashKey(args[0]); err != nil {
+				return err
+			}
+
+			_, ok := h.Lookup(args[0])
+			return &Boolean{Value: ok}
+		}),
+		newID("get"): hashMethod("get", 1, 2, func(env *Environment, h *Hash, args []Object) Object {
+			if err := CheckHashKey(args[0]); err != nil {
+				return err
+			}
+
+			if val, ok := h.Lookup(args[0]); ok {
+				return val
+			}
+
+			if len(args) == 2 {
+				return args[1]
+			}
+
+			return &Null{}
+		}),
+		newID("delete"): hashMethod("delete", 1, 1, func(env *Environment, h *Hash, args []Object) Object {
+			if err := CheckHashKey(args[0]); err != nil {
+				return err
+			}
+
+			return &Boolean{Value: h.Delete(args[0])}
+		}),
+		newID("merge"): hashMethod("merge", 0, -1, func(env *Environment, h *Hash, args []Object) Object {
+			result := NewHash(h.Model)
+			size := 0
+
+			for i, arg := range append([]Object{h}, args...) {
+				other, ok := arg.(*Hash)
+				if !ok {
+					return newKindError(TYPE_ERROR, "expected argument %d of hash.merge to be a hash. got %s",
+						i, arg.Type())
+				}
+
+				size += other.Len()
+				if err := env.Runtime().CheckAllocation(size); err != nil {
+					return err
+				}
+
+				for _, pair := range other.Pairs() {
+					result.Insert(pair.Key, pair.Value)
+				}
+			}
+
+			return result
+		}),
+	}
+
+	return true
+}
+
+// hashMethod makes a method of every hash, which checks that it's called on
+// a hash, with between min and max arguments. A max of -1 means any number.
+func hashMethod(name string, min, max int, fn func(env *Environment, h *Hash, args []Object) Object) *Builtin {
+	return &Builtin{
+		Fn: func(env *Environment, this Object, args ...Object) Object {
+			h, ok := this.(*Hash)
+			if !ok {
+				return newKindError(TYPE_ERROR, "hash.%s must be called on a hash", name)
+			}
+
+			if err := CheckArgs("hash."+name, min, max, len(args)); err != nil {
+				return err
+			}
+
+			return fn(env, h, args)
+		},
+	}
+}
+
+// CheckHashKey returns an error if a value can't be used as a key in a hash.
+func CheckHashKey(key Object) *Error {
+	if _, ok := HashKeyOf(key); !ok {
+		return newKindError(TYPE_ERROR, "unusable as hash key: %s", key.Type())
+	}
+
+	return nil
+}
+
+var _ = initialiseHashModel()
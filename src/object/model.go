@@ -4,13 +4,20 @@ import (
 	"../ast"
 	"fmt"
 	"strings"
+	"sync/atomic"
 )
 
+// nextModelId is shared by every runtime, so it's only changed atomically
 var nextModelId int64 = 0
 
 type Model struct {
+	Name       string
 	Parent     *Model
 	ParentArgs []ast.Expression
+	// ParentInit, if set, is called with the arguments given when the model
+	// is instantiated, and returns an array of arguments for the parent
+	// instead of evaluating ParentArgs.
+	ParentInit Object
 	Properties []*ast.Identifier
 	Methods    map[*ast.Identifier]Object
 	Id         int64
@@ -21,19 +28,24 @@ func NewModel() *Model {
 }
 
 func NewModelWithParent(parent *Model) *Model {
-	nextModelId += 1
-
 	model := &Model{
 		Parent:     parent,
 		Properties: []*ast.Identifier{},
 		Methods:    make(map[*ast.Identifier]Object),
-		Id:         nextModelId,
+		Id:         atomic.AddInt64(&nextModelId, 1),
 	}
 
 	return model
 }
 
+// Instantiate makes a hash of the model, setting its properties to the
+// arguments. It returns an error if there isn't one argument per property.
 func (m *Model) Instantiate(args []Object) Object {
+	if len(args) != len(m.Properties) {
+		return newKindError(ARGUMENT_ERROR, "invalid number of arguments. expected %v, got %v",
+			len(m.Properties), len(args))
+	}
+
 	hash := NewHash(m)
 
 	for i, prop := range m.Properties {
@@ -46,7 +58,7 @@ func (m *Model) Instantiate(args []Object) Object {
 func (m *Model) GetMethod(name string) (*MethodInstance, bool) {
 	for k, v := range m.Methods {
 		if k.Value == name {
-			return &MethodInstance{Function: &v}, true
+			return &MethodInstance{Function: &v, Name: name, Model: m}, true
 		}
 	}
 
@@ -59,6 +71,12 @@ func (m *Model) GetMethod(name string) (*MethodInstance, bool) {
 	return nil, false
 }
 
+// Builtin reports whether the model is one of the builtin models, like
+// string. They're shared by every runtime, so programs can't change them.
+func (m *Model) Builtin() bool {
+	return m.Id < 0
+}
+
 func (m *Model) Type() ObjectType { return MODEL_OBJ }
 
 func (m *Model) Inspect() string {
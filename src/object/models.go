@@ -3,6 +3,7 @@ package object
 import (
 	"../ast"
 	"../token"
+	"fmt"
 	"math"
 )
 
@@ -12,6 +13,7 @@ func newID(val string) *ast.Identifier {
 
 var (
 	OBJECT_MODEL = &Model{
+		Name:       "object",
 		Parent:     nil,
 		Properties: []*ast.Identifier{},
 		Methods:    map[*ast.Identifier]Object{},
@@ -19,36 +21,51 @@ var (
 	}
 
 	VECTOR_MODEL = &Model{
+		Name:       "vec",
 		Parent:     OBJECT_MODEL,
 		Properties: []*ast.Identifier{newID("x"), newID("y")},
 		Methods:    map[*ast.Identifier]Object{},
 		Id:         -2,
 	}
+
+	ERROR_MODEL = &Model{
+		Name:       "error",
+		Parent:     OBJECT_MODEL,
+		Properties: []*ast.Identifier{newID("message"), newID("kind")},
+		Methods:    map[*ast.Identifier]Object{},
+		Id:         -3,
+	}
+
+	// ARRAY_MODEL is the model of arrays. Its methods are added by the
+	// evaluator, since some of them call functions.
+	ARRAY_MODEL = &Model{
+		Name:       "array",
+		Parent:     OBJECT_MODEL,
+		Properties: []*ast.Identifier{newID("value")},
+		Methods:    map[*ast.Identifier]Object{},
+		Id:         -5,
+	}
 )
 
 func InitialiseBuiltinModels() bool {
 	OBJECT_MODEL.Methods = map[*ast.Identifier]Object{
 		newID("type"): &Builtin{
-			Fn: func(this Object, args ...Object) Object {
+			Fn: func(env *Environment, this Object, args ...Object) Object {
 				if len(args) != 0 {
 					return newError("no arguments expected to object.type")
 				}
 
-				thisHash := this.(*Hash)
-
-				return thisHash.Model
+				return ModelOf(this)
 			},
 		},
 		newID("parent"): &Builtin{
-			Fn: func(this Object, args ...Object) Object {
+			Fn: func(env *Environment, this Object, args ...Object) Object {
 				if len(args) != 0 {
 					return newError("no arguments expected to object.type")
 				}
 
-				thisHash := this.(*Hash)
-
-				if thisHash.Model.Parent != nil {
-					return thisHash.Model.Parent
+				if parent := ModelOf(this).Parent; parent != nil {
+					return parent
 				} else {
 					return &Null{}
 				}
@@ -58,18 +75,18 @@ func InitialiseBuiltinModels() bool {
 
 	VECTOR_MODEL.Methods = map[*ast.Identifier]Object{
 		newID("_new"): &Builtin{
-			Fn: func(this Object, args ...Object) Object {
+			Fn: func(env *Environment, this Object, args ...Object) Object {
 				if len(args) != 0 {
 					return newError("no arguments expected to vec._new")
 				}
 
 				thisHash := this.(*Hash)
 
-				if _, ok := thisHash.Get("x").(*Number); !ok {
+				if !IsNumber(thisHash.Get("x")) {
 					return newError("the 'x' property of a vec must be a number")
 				}
 
-				if _, ok := thisHash.Get("y").(*Number); !ok {
+				if !IsNumber(thisHash.Get("y")) {
 					return newError("the 'y' property of a vec must be a number")
 				}
 
@@ -77,7 +94,7 @@ func InitialiseBuiltinModels() bool {
 			},
 		},
 		newID("_plus"): &Builtin{
-			Fn: func(this Object, args ...Object) Object {
+			Fn: func(env *Environment, this Object, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("expected exactly one argument to vec._plus")
 				}
@@ -93,20 +110,14 @@ func InitialiseBuiltinModels() bool {
 					return newError("expected the first argument of vec._plus to be another hash")
 				}
 
-				leftX := left.Get("x").(*Number).Value
-				leftY := left.Get("y").(*Number).Value
-
-				rightX := right.Get("x").(*Number).Value
-				rightY := right.Get("y").(*Number).Value
-
 				return VECTOR_MODEL.Instantiate([]Object{
-					&Number{Value: leftX + rightX},
-					&Number{Value: leftY + rightY},
+					addNumbers(left.Get("x"), right.Get("x")),
+					addNumbers(left.Get("y"), right.Get("y")),
 				})
 			},
 		},
 		newID("_mul"): &Builtin{
-			Fn: func(this Object, args ...Object) Object {
+			Fn: func(env *Environment, this Object, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("expected exactly one argument to vec._plus")
 				}
@@ -122,34 +133,28 @@ func InitialiseBuiltinModels() bool {
 					return newError("expected the first argument of vec._plus to be another vector")
 				}
 
-				leftX := left.Get("x").(*Number).Value
-				leftY := left.Get("y").(*Number).Value
-
-				rightX := right.Get("x").(*Number).Value
-				rightY := right.Get("y").(*Number).Value
-
 				return VECTOR_MODEL.Instantiate([]Object{
-					&Number{Value: leftX + rightX},
-					&Number{Value: leftY + rightY},
+					addNumbers(left.Get("x"), right.Get("x")),
+					addNumbers(left.Get("y"), right.Get("y")),
 				})
 			},
 		},
 		newID("len"): &Builtin{
-			Fn: func(this Object, args ...Object) Object {
+			Fn: func(env *Environment, this Object, args ...Object) Object {
 				if len(args) != 0 {
 					return newError("no arguments expected to vec._new")
 				}
 
 				hash := this.(*Hash)
 
-				x := hash.Get("x").(*Number).Value
-				y := hash.Get("y").(*Number).Value
+				x, _ := ToFloat(hash.Get("x"))
+				y, _ := ToFloat(hash.Get("y"))
 
 				return &Number{Value: math.Sqrt(x*x + y*y)}
 			},
 		},
 		newID("translate"): &Builtin{
-			Fn: func(this Object, args ...Object) Object {
+			Fn: func(env *Environment, this Object, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("expected one or two arguments to vec.translate")
 				}
@@ -165,14 +170,8 @@ func InitialiseBuiltinModels() bool {
 					return newError("expected the first argument of vec.translate to be another vector")
 				}
 
-				thisX := hash.Get("x").(*Number).Value
-				thisY := hash.Get("y").(*Number).Value
-
-				otherX := other.Get("x").(*Number).Value
-				otherY := other.Get("y").(*Number).Value
-
-				newX := &Number{Value: thisX + otherX}
-				newY := &Number{Value: thisY + otherY}
+				newX := addNumbers(hash.Get("x"), other.Get("x"))
+				newY := addNumbers(hash.Get("y"), other.Get("y"))
 
 				hash.Set("x", newX)
 				hash.Set("y", newY)
@@ -185,9 +184,66 @@ func InitialiseBuiltinModels() bool {
 	return true
 }
 
+// addNumbers adds the components of two vectors, keeping integers as
+// integers unless their sum overflows.
+func addNumbers(a, b Object) Object {
+	if x, ok := a.(*Integer); ok {
+		if y, ok := b.(*Integer); ok {
+			if sum, ok := AddIntegers(x.Value, y.Value); ok {
+				return &Integer{Value: sum}
+			}
+		}
+	}
+
+	x, _ := ToFloat(a)
+	y, _ := ToFloat(b)
+
+	return &Number{Value: x + y}
+}
+
+// ModelOf returns the model of a value: a hash's own model, or the builtin
+// model of a string or an array. It returns nil for any other value.
+func ModelOf(obj Object) *Model {
+	switch obj := obj.(type) {
+	case *Hash:
+		return obj.Model
+	case *String:
+		return STRING_MODEL
+	case *Array:
+		return ARRAY_MODEL
+	default:
+		return nil
+	}
+}
+
+// CheckArgs returns an error if a builtin method, like string.split, is
+// given fewer than min arguments or more than max. A max of -1 means any
+// number.
+func CheckArgs(method string, min, max, n int) *Error {
+	if n >= min && (max < 0 || n <= max) {
+		return nil
+	}
+
+	expected := fmt.Sprint(min)
+	switch {
+	case max < 0:
+		expected = "at least " + expected
+	case max == min+1:
+		expected = fmt.Sprintf("%d or %d", min, max)
+	case max > min:
+		expected = fmt.Sprintf("%d to %d", min, max)
+	}
+
+	return newKindError(ARGUMENT_ERROR, "invalid number of arguments to %s. expected %v, got %v", method, expected, n)
+}
+
 var DefaultModels = map[string]*Model{
 	"object": OBJECT_MODEL,
 	"vec":    VECTOR_MODEL,
+	"error":  ERROR_MODEL,
+	"string": STRING_MODEL,
+	"array":  ARRAY_MODEL,
+	"hash":   HASH_MODEL,
 }
 
 var _ = InitialiseBuiltinModels()
@@ -0,0 +1,77 @@
+package object
+
+import "math"
+
+// IsNumber reports whether an object is an Integer or a Number.
+func IsNumber(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *Number:
+		return true
+	default:
+		return false
+	}
+}
+
+// ToFloat returns the value of an Integer or a Number as a float64, and
+// false if the object is neither.
+func ToFloat(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *Number:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
+
+// AddIntegers returns a + b, and false if it overflows.
+func AddIntegers(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+// SubtractIntegers returns a - b, and false if it overflows.
+func SubtractIntegers(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
+
+// MultiplyIntegers returns a * b, and false if it overflows.
+func MultiplyIntegers(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+
+	c := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return c, false
+	}
+
+	return c, c/b == a
+}
+
+// PowerIntegers returns a ** b, for b >= 0, and false if it overflows.
+func PowerIntegers(a, b int64) (int64, bool) {
+	result := int64(1)
+
+	for b > 0 {
+		if b&1 == 1 {
+			var ok bool
+			if result, ok = MultiplyIntegers(result, a); !ok {
+				return 0, false
+			}
+		}
+
+		b >>= 1
+
+		if b > 0 {
+			var ok bool
+			if a, ok = MultiplyIntegers(a, a); !ok {
+				return 0, false
+			}
+		}
+	}
+
+	return result, true
+}
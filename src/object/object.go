@@ -2,18 +2,22 @@ package object
 
 import (
 	"../ast"
+	"../token"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 type ObjectType string
-type BuiltinFunction func(this Object, args ...Object) Object
+type BuiltinFunction func(env *Environment, this Object, args ...Object) Object
 
 const (
 	ERROR_OBJ                  = "ERROR"
 	NUMBER_OBJ                 = "NUMBER"
+	INTEGER_OBJ                = "INTEGER"
 	BOOLEAN_OBJ                = "BOOLEAN"
 	STRING_OBJ                 = "STRING"
+	CHAR_OBJ                   = "CHAR"
 	BUILTIN_OBJ                = "BUILTIN"
 	ARRAY_OBJ                  = "ARRAY"
 	HASH_OBJ                   = "HASH"
@@ -36,8 +40,34 @@ type Object interface {
 
 // Error
 
+// Kinds of error, which tell a program catching an error what went wrong.
+const (
+	RUNTIME_ERROR  = "runtime"
+	USER_ERROR     = "error"
+	TYPE_ERROR     = "type"
+	NAME_ERROR     = "name"
+	INDEX_ERROR    = "index"
+	ARGUMENT_ERROR = "argument"
+	IMPORT_ERROR   = "import"
+	MATCH_ERROR    = "match"
+
+	// errors raised by integer arithmetic, such as overflow and division by
+	// zero
+	ARITHMETIC_ERROR = "arithmetic"
+
+	// errors raised when a program goes over one of its runtime's limits
+	CANCELLED_ERROR = "cancelled"
+	TIMEOUT_ERROR   = "timeout"
+	STEP_ERROR      = "steps"
+	RECURSION_ERROR = "recursion"
+	MEMORY_ERROR    = "memory"
+)
+
 type Error struct {
 	Message string
+	Kind    string
+	Pos     token.Position
+	Trace   []Frame
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
@@ -51,18 +81,84 @@ func (e *Error) Equals(other Object) bool {
 	}
 }
 
+// Traceback describes the calls which were being made when the error
+// occurred, with the most recent call last. Each line gives the position
+// being evaluated in a function, and that function's name.
+func (e *Error) Traceback() string {
+	if len(e.Trace) == 0 {
+		return ""
+	}
+
+	lines := []string{"traceback (most recent call last):"}
+	for _, frame := range e.frames() {
+		lines = append(lines, "  "+frame.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// frames pairs the name of each function in the trace with the position
+// being evaluated inside it, outermost first.
+func (e *Error) frames() []Frame {
+	frames := []Frame{}
+
+	name := "<main>"
+	for _, frame := range e.Trace {
+		frames = append(frames, Frame{Name: name, Pos: frame.Pos})
+		name = frame.Name
+	}
+
+	return append(frames, Frame{Name: name, Pos: e.Pos})
+}
+
+// ToHash converts the error into an instance of the error model, which is
+// how a caught error is given to a program.
+func (e *Error) ToHash() *Hash {
+	hash := ERROR_MODEL.Instantiate([]Object{
+		&String{Value: e.Message},
+		&String{Value: e.Kind},
+	}).(*Hash)
+
+	hash.Set("position", &String{Value: e.Pos.String()})
+	hash.Set("file", &String{Value: e.Pos.File})
+	hash.Set("line", &Integer{Value: int64(e.Pos.Line)})
+	hash.Set("column", &Integer{Value: int64(e.Pos.Column)})
+
+	stack := []Object{}
+	for _, frame := range e.frames() {
+		stack = append(stack, &String{Value: frame.String()})
+	}
+
+	hash.Set("stack", &Array{Elements: stack})
+
+	return hash
+}
+
 // Number
 
+// Number is a floating point number. Numbers written without a decimal
+// point are Integers instead.
 type Number struct {
 	Value float64
 }
 
 func (n *Number) Type() ObjectType { return NUMBER_OBJ }
-func (n *Number) Inspect() string  { return fmt.Sprintf("%v", n.Value) }
+func (n *Number) Inspect() string {
+	s := strconv.FormatFloat(n.Value, 'g', -1, 64)
+
+	// so that a whole number can be told apart from an integer
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+
+	return s
+}
 func (n *Number) Equals(other Object) bool {
 	switch other := other.(type) {
 	case *Number:
 		return n.Value == other.Value
+	case *Integer:
+		return n.Value == float64(other.Value)
 	default:
 		return false
 	}
@@ -76,6 +172,25 @@ func (n *Number) IsPositive() bool {
 	return n.Value >= 0
 }
 
+// Integer
+
+type Integer struct {
+	Value int64
+}
+
+func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
+func (i *Integer) Inspect() string  { return strconv.FormatInt(i.Value, 10) }
+func (i *Integer) Equals(other Object) bool {
+	switch other := other.(type) {
+	case *Integer:
+		return i.Value == other.Value
+	case *Number:
+		return float64(i.Value) == other.Value
+	default:
+		return false
+	}
+}
+
 // Boolean
 
 type Boolean struct {
@@ -105,6 +220,28 @@ func (s *String) Equals(other Object) bool {
 	switch other := other.(type) {
 	case *String:
 		return s.Value == other.Value
+	case *Char:
+		return s.Value == string(other.Value)
+	default:
+		return false
+	}
+}
+
+// Char
+
+// Char is a single Unicode character, which is what a string is made of.
+type Char struct {
+	Value rune
+}
+
+func (c *Char) Type() ObjectType { return CHAR_OBJ }
+func (c *Char) Inspect() string  { return string(c.Value) }
+func (c *Char) Equals(other Object) bool {
+	switch other := other.(type) {
+	case *Char:
+		return c.Value == other.Value
+	case *String:
+		return string(c.Value) == other.Value
 	default:
 		return false
 	}
@@ -166,7 +303,9 @@ func (lcs *LoopControlStatement) Equals(other Object) bool {
 // Function
 
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
+	Patterns   []ast.Expression
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -193,7 +332,9 @@ func (f *Function) Equals(other Object) bool {
 // Lambda
 
 type Lambda struct {
+	Name       string
 	Parameters []*ast.Identifier
+	Patterns   []ast.Expression
 	Body       *ast.Expression
 	Env        *Environment
 }
@@ -217,6 +358,17 @@ func (l *Lambda) Equals(other Object) bool {
 	}
 }
 
+// Callable
+
+// Callable is implemented by functions which aren't evaluated from their
+// syntax tree, such as the closures of the virtual machine. The name is
+// used for the call's frame in tracebacks, and if it's empty the
+// function's own name is used instead.
+type Callable interface {
+	Object
+	Call(name string, this Object, args []Object) Object
+}
+
 // Builtin
 
 type Builtin struct {
@@ -264,5 +416,9 @@ func (a *Array) Equals(other Object) bool {
 }
 
 func newError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
+	return newKindError(RUNTIME_ERROR, format, a...)
+}
+
+func newKindError(kind string, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Kind: kind}
 }
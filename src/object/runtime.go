@@ -0,0 +1,264 @@
+package object
+
+import (
+	"../ast"
+	"../token"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Frame is a single entry in the call stack, recording the name of the
+// function which was called and the position it was called from.
+type Frame struct {
+	Name string
+	Pos  token.Position
+
+	// loops is the caller's Runtime.Loops, restored when the call returns
+	loops int
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%v in %v", f.Pos, f.Name)
+}
+
+// Debugger is told about each statement the evaluator runs, so it can
+// pause the program before the statement to let it be inspected.
+type Debugger interface {
+	Statement(stmt ast.Statement, env *Environment)
+}
+
+// Limits restrict the resources a program can use, so that untrusted
+// programs can be run without hanging or crashing the host. A limit of zero
+// means there is no limit.
+type Limits struct {
+	// MaxSteps is how many steps a program can take. A step is a node
+	// evaluated by the evaluator, or an instruction run by the virtual
+	// machine.
+	MaxSteps int
+
+	// MaxDepth is how deeply function calls can be nested.
+	MaxDepth int
+
+	// MaxAllocation is the largest array, in elements, or string, in bytes,
+	// which operators, loops and builtins can build.
+	MaxAllocation int
+}
+
+// DefaultLimits are the limits a new runtime starts with. Calls are nested
+// in the Go stack when a program is evaluated, so without a maximum depth,
+// deep recursion would crash the whole process.
+var DefaultLimits = Limits{
+	MaxDepth: 10000,
+}
+
+// limitGrace is how many more steps a program can take after it goes over
+// its step limit or its context is cancelled, so that catch and finally
+// blocks can handle the error.
+const limitGrace = 1000
+
+// Runtime holds the state shared by every environment in a running program,
+// such as the call stack and the position currently being evaluated.
+type Runtime struct {
+	Stack []Frame
+	Pos   token.Position
+
+	// Loops is how many loop bodies the evaluator is inside of in the
+	// current call, so break and next can be rejected outside of a loop.
+	Loops int
+
+	// SearchPaths are the directories, other than the importing file's own
+	// directory, which are searched for imported files.
+	SearchPaths []string
+
+	// Modules caches the bindings of each imported file by its absolute
+	// path, and Importing lists the files currently being imported.
+	Modules   map[string]*Hash
+	Importing []string
+
+	// Sources holds the text of each file that has been loaded, for
+	// showing excerpts in error messages.
+	Sources map[string]string
+
+	// Stdin, Stdout and Stderr are used by builtins such as input and
+	// print, instead of the process's own streams.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Limits Limits
+
+	// Debugger, if set, is told about each statement before it's
+	// evaluated.
+	Debugger Debugger
+
+	stdin       *bufio.Reader
+	stdinSource io.Reader
+
+	ctx  context.Context
+	done <-chan struct{}
+
+	// steps counts the steps taken so far, and stopped is set to the error
+	// which was raised when a limit was first exceeded
+	steps   int
+	stopped *Error
+	grace   int
+}
+
+func NewRuntime() *Runtime {
+	return &Runtime{
+		Stack:   []Frame{},
+		Modules: make(map[string]*Hash),
+		Sources: make(map[string]string),
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		Limits:  DefaultLimits,
+		ctx:     context.Background(),
+	}
+}
+
+// ReadLine reads a line from Stdin, without the trailing newline.
+func (r *Runtime) ReadLine() (string, error) {
+	if r.stdin == nil || r.stdinSource != r.Stdin {
+		r.stdin = bufio.NewReader(r.Stdin)
+		r.stdinSource = r.Stdin
+	}
+
+	line, err := r.stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// SetContext sets the context the program runs in. When it's cancelled,
+// the program is stopped at its next step.
+func (r *Runtime) SetContext(ctx context.Context) {
+	r.ctx = ctx
+	r.done = ctx.Done()
+	r.stopped = nil
+}
+
+func (r *Runtime) Context() context.Context {
+	return r.ctx
+}
+
+// Steps returns the number of steps taken since the runtime was made, or
+// since ResetSteps was last called.
+func (r *Runtime) Steps() int {
+	return r.steps
+}
+
+// ResetSteps starts counting steps from zero again, e.g. before each line
+// entered into a REPL.
+func (r *Runtime) ResetSteps() {
+	r.steps = 0
+	r.stopped = nil
+}
+
+// Step is called before each step of the program. It returns an error if
+// the program has taken too many steps or its context has been cancelled,
+// and nil otherwise. Once that happens, a few more steps are allowed, so
+// the error can be caught, after which every step returns an error again.
+func (r *Runtime) Step() *Error {
+	r.steps++
+
+	if r.stopped != nil {
+		if r.grace > 0 {
+			r.grace--
+			return nil
+		}
+
+		return newKindError(r.stopped.Kind, "%s", r.stopped.Message)
+	}
+
+	var err *Error
+
+	select {
+	case <-r.done:
+		if r.ctx.Err() == context.DeadlineExceeded {
+			err = newKindError(TIMEOUT_ERROR, "execution timed out")
+		} else {
+			err = newKindError(CANCELLED_ERROR, "execution cancelled")
+		}
+	default:
+		if r.Limits.MaxSteps > 0 && r.steps > r.Limits.MaxSteps {
+			err = newKindError(STEP_ERROR, "step limit of %v exceeded", r.Limits.MaxSteps)
+		}
+	}
+
+	if err != nil {
+		r.stopped = err
+		r.grace = limitGrace
+	}
+
+	return err
+}
+
+// Catch reports whether a try block can catch an error. Once a limit has
+// stopped the program, catching an error costs as many of the remaining
+// steps as there are frames in its trace, since giving the error's stack to
+// the catch block takes that long. When the steps run out, nothing can be
+// caught, so the program ends without going through every catch block on
+// its way out.
+func (r *Runtime) Catch(err *Error) bool {
+	if r.stopped == nil {
+		return true
+	}
+
+	if r.grace == 0 {
+		return false
+	}
+
+	r.grace -= len(err.Trace)
+	if r.grace < 0 {
+		r.grace = 0
+	}
+
+	return true
+}
+
+// CheckDepth returns an error if calls are nested more deeply than the
+// runtime's limit allows.
+func (r *Runtime) CheckDepth() *Error {
+	if r.Limits.MaxDepth > 0 && len(r.Stack) > r.Limits.MaxDepth {
+		return newKindError(RECURSION_ERROR, "maximum recursion depth of %v exceeded", r.Limits.MaxDepth)
+	}
+
+	return nil
+}
+
+// CheckAllocation returns an error if an array of n elements, or a string
+// of n bytes, is larger than the runtime's limit allows.
+func (r *Runtime) CheckAllocation(n int) *Error {
+	if r.Limits.MaxAllocation > 0 && n > r.Limits.MaxAllocation {
+		return newKindError(MEMORY_ERROR, "cannot allocate a value of size %v, the limit is %v",
+			n, r.Limits.MaxAllocation)
+	}
+
+	return nil
+}
+
+// Push adds a frame for a call to the named function, made from the position
+// currently being evaluated.
+func (r *Runtime) Push(name string) {
+	r.Stack = append(r.Stack, Frame{Name: name, Pos: r.Pos, loops: r.Loops})
+	r.Loops = 0
+}
+
+func (r *Runtime) Pop() {
+	r.Loops = r.Stack[len(r.Stack)-1].loops
+	r.Stack = r.Stack[:len(r.Stack)-1]
+}
+
+// Trace returns a copy of the current call stack, outermost call first.
+func (r *Runtime) Trace() []Frame {
+	trace := make([]Frame, len(r.Stack))
+	copy(trace, r.Stack)
+	return trace
+}
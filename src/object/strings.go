@@ -0,0 +1,352 @@
+package object
+
+import (
+	"../ast"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// STRING_MODEL is the model of strings. Its methods are called on string
+// values, like "abc".upper(), and calling it converts a value to a string
+// the same way str does.
+var STRING_MODEL = &Model{
+	Name:       "string",
+	Parent:     OBJECT_MODEL,
+	Properties: []*ast.Identifier{newID("value")},
+	Methods:    map[*ast.Identifier]Object{},
+	Id:         -4,
+}
+
+func initialiseStringModel() bool {
+	STRING_MODEL.Methods = map[*ast.Identifier]Object{
+		newID("_new"): &Builtin{
+			Fn: func(env *Environment, this Object, args ...Object) Object {
+				hash, ok := this.(*Hash)
+				if !ok {
+					return newKindError(TYPE_ERROR, "string._new must be called on a new string")
+				}
+
+				return &String{Value: hash.Get("value").Inspect()}
+			},
+		},
+		newID("len"): stringMethod("len", 0, 0, func(env *Environment, s string, args []Object) Object {
+			return &Integer{Value: int64(utf8.RuneCountInString(s))}
+		}),
+		newID("upper"): stringMethod("upper", 0, 0, func(env *Environment, s string, args []Object) Object {
+			return &String{Value: strings.ToUpper(s)}
+		}),
+		newID("lower"): stringMethod("lower", 0, 0, func(env *Environment, s string, args []Object) Object {
+			return &String{Value: strings.ToLower(s)}
+		}),
+		newID("trim"):       trimMethod("trim", strings.TrimFunc, strings.Trim),
+		newID("trim_left"):  trimMethod("trim_left", strings.TrimLeftFunc, strings.TrimLeft),
+		newID("trim_right"): trimMethod("trim_right", strings.TrimRightFunc, strings.TrimRight),
+		newID("split"): stringMethod("split", 0, 1, func(env *Environment, s string, args []Object) Object {
+			if len(args) == 0 {
+				return stringArray(strings.Fields(s))
+			}
+
+			sep, err := stringArg("split", args, 0)
+			if err != nil {
+				return err
+			}
+
+			if sep == "" {
+				return newKindError(ARGUMENT_ERROR, "cannot split a string by an empty separator")
+			}
+
+			return stringArray(strings.Split(s, sep))
+		}),
+		newID("lines"): stringMethod("lines", 0, 0, func(env *Environment, s string, args []Object) Object {
+			if s == "" {
+				return stringArray(nil)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimSuffix(line, "\r")
+			}
+
+			return stringArray(lines)
+		}),
+		newID("chars"): stringMethod("chars", 0, 0, func(env *Environment, s string, args []Object) Object {
+			chars := []Object{}
+			for _, ch := range s {
+				chars = append(chars, &Char{Value: ch})
+			}
+
+			return &Array{Elements: chars}
+		}),
+		newID("replace"): stringMethod("replace", 2, 2, func(env *Environment, s string, args []Object) Object {
+			old, err := stringArg("replace", args, 0)
+			if err != nil {
+				return err
+			}
+
+			replacement, err := stringArg("replace", args, 1)
+			if err != nil {
+				return err
+			}
+
+			if old != "" {
+				n := strings.Count(s, old)
+				if err := env.Runtime().CheckAllocation(len(s) + n*(len(replacement)-len(old))); err != nil {
+					return err
+				}
+			}
+
+			return &String{Value: strings.Replace(s, old, replacement, -1)}
+		}),
+		newID("starts_with"): stringMethod("starts_with", 1, 1, func(env *Environment, s string, args []Object) Object {
+			prefix, err := stringArg("starts_with", args, 0)
+			if err != nil {
+				return err
+			}
+
+			return &Boolean{Value: strings.HasPrefix(s, prefix)}
+		}),
+		newID("ends_with"): stringMethod("ends_with", 1, 1, func(env *Environment, s string, args []Object) Object {
+			suffix, err := stringArg("ends_with", args, 0)
+			if err != nil {
+				return err
+			}
+
+			return &Boolean{Value: strings.HasSuffix(s, suffix)}
+		}),
+		newID("find"): stringMethod("find", 1, 1, func(env *Environment, s string, args []Object) Object {
+			sub, err := stringArg("find", args, 0)
+			if err != nil {
+				return err
+			}
+
+			// the index is counted in characters, like indexing a string
+			i := strings.Index(s, sub)
+			if i >= 0 {
+				i = utf8.RuneCountInString(s[:i])
+			}
+
+			return &Integer{Value: int64(i)}
+		}),
+		newID("count"): stringMethod("count", 1, 1, func(env *Environment, s string, args []Object) Object {
+			sub, err := stringArg("count", args, 0)
+			if err != nil {
+				return err
+			}
+
+			if sub == "" {
+				return newKindError(ARGUMENT_ERROR, "cannot count an empty string")
+			}
+
+			return &Integer{Value: int64(strings.Count(s, sub))}
+		}),
+		newID("repeat"): stringMethod("repeat", 1, 1, func(env *Environment, s string, args []Object) Object {
+			n, err := intArg("repeat", args, 0)
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return newKindError(ARGUMENT_ERROR, "cannot repeat a string %d times", n)
+			}
+
+			if len(s) > 0 && n > math.MaxInt32/int64(len(s)) {
+				return newKindError(MEMORY_ERROR, "cannot repeat a string %d times", n)
+			}
+
+			if err := env.Runtime().CheckAllocation(len(s) * int(n)); err != nil {
+				return err
+			}
+
+			return &String{Value: strings.Repeat(s, int(n))}
+		}),
+		newID("pad_left"): padMethod("pad_left", func(s, padding string) string {
+			return padding + s
+		}),
+		newID("pad_right"): padMethod("pad_right", func(s, padding string) string {
+			return s + padding
+		}),
+		newID("format"): stringMethod("format", 0, -1, func(env *Environment, s string, args []Object) Object {
+			out, err := formatString(s, args)
+			if err != nil {
+				return err
+			}
+
+			if err := env.Runtime().CheckAllocation(len(out)); err != nil {
+				return err
+			}
+
+			return &String{Value: out}
+		}),
+	}
+
+	return true
+}
+
+// stringMethod makes a method of the string model, which checks that it's
+// called on a string, with between min and max arguments. A max of -1 means
+// any number.
+func stringMethod(name string, min, max int, fn func(env *Environment, s string, args []Object) Object) *Builtin {
+	return &Builtin{
+		Fn: func(env *Environment, this Object, args ...Object) Object {
+			s, ok := this.(*String)
+			if !ok {
+				return newKindError(TYPE_ERROR, "string.%s must be called on a string", name)
+			}
+
+			if err := CheckArgs("string."+name, min, max, len(args)); err != nil {
+				return err
+			}
+
+			return fn(env, s.Value, args)
+		},
+	}
+}
+
+// trimMethod makes a method which trims whitespace from a string, or any of
+// the characters in a string it's given.
+func trimMethod(
+	name string,
+	space func(string, func(rune) bool) string,
+	cutset func(string, string) string,
+) *Builtin {
+	return stringMethod(name, 0, 1, func(env *Environment, s string, args []Object) Object {
+		if len(args) == 0 {
+			return &String{Value: space(s, unicode.IsSpace)}
+		}
+
+		chars, err := stringArg(name, args, 0)
+		if err != nil {
+			return err
+		}
+
+		return &String{Value: cutset(s, chars)}
+	})
+}
+
+// padMethod makes a method which pads a string to a width, in characters,
+// with spaces or a character it's given. pad adds the padding to the string.
+func padMethod(name string, pad func(s, padding string) string) *Builtin {
+	return stringMethod(name, 1, 2, func(env *Environment, s string, args []Object) Object {
+		width, err := intArg(name, args, 0)
+		if err != nil {
+			return err
+		}
+
+		fill := " "
+		if len(args) == 2 {
+			// a string of one character is as good as the character
+			switch arg := args[1].(type) {
+			case *Char:
+				fill = string(arg.Value)
+			case *String:
+				if n := utf8.RuneCountInString(arg.Value); n != 1 {
+					return newKindError(ARGUMENT_ERROR, "expected argument 2 of string.%s to be one character long. got %d",
+						name, n)
+				}
+
+				fill = arg.Value
+			default:
+				return newKindError(TYPE_ERROR, "expected argument 2 of string.%s to be a character. got %s",
+					name, args[1].Type())
+			}
+		}
+
+		n := width - int64(utf8.RuneCountInString(s))
+		if n <= 0 {
+			return &String{Value: s}
+		}
+
+		if n > math.MaxInt32 {
+			return newKindError(MEMORY_ERROR, "cannot pad a string to a width of %d", width)
+		}
+
+		if err := env.Runtime().CheckAllocation(len(s) + int(n)*len(fill)); err != nil {
+			return err
+		}
+
+		return &String{Value: pad(s, strings.Repeat(fill, int(n)))}
+	})
+}
+
+// formatString replaces the placeholders in a format string with the
+// arguments, written the same way str writes them. "{}" is the next
+// argument, "{n}" is the nth, counting from 0, and "{{" and "}}" are
+// literal braces.
+func formatString(s string, args []Object) (string, *Error) {
+	var out strings.Builder
+	next := 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			out.WriteByte(s[i])
+			i++
+		case s[i] == '}':
+			return "", newKindError(ARGUMENT_ERROR, "unmatched } in a format string")
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", newKindError(ARGUMENT_ERROR, "unterminated placeholder in a format string")
+			}
+
+			spec := s[i+1 : i+end]
+			n := next
+			if spec == "" {
+				next++
+			} else {
+				index, err := strconv.Atoi(spec)
+				if err != nil || index < 0 {
+					return "", newKindError(ARGUMENT_ERROR, "invalid placeholder {%s} in a format string", spec)
+				}
+
+				n = index
+			}
+
+			if n >= len(args) {
+				return "", newKindError(ARGUMENT_ERROR,
+					"not enough arguments for a format string. expected at least %d, got %d", n+1, len(args))
+			}
+
+			out.WriteString(args[n].Inspect())
+			i += end
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+
+	return out.String(), nil
+}
+
+func stringArg(method string, args []Object, i int) (string, *Error) {
+	s, ok := args[i].(*String)
+	if !ok {
+		return "", newKindError(TYPE_ERROR, "expected argument %d of string.%s to be a string. got %s",
+			i+1, method, args[i].Type())
+	}
+
+	return s.Value, nil
+}
+
+func intArg(method string, args []Object, i int) (int64, *Error) {
+	n, ok := args[i].(*Integer)
+	if !ok {
+		return 0, newKindError(TYPE_ERROR, "expected argument %d of string.%s to be an integer. got %s",
+			i+1, method, args[i].Type())
+	}
+
+	return n.Value, nil
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &String{Value: s}
+	}
+
+	return &Array{Elements: elements}
+}
+
+var _ = initialiseStringModel()
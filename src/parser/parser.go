@@ -6,6 +6,8 @@ import (
 	"../token"
 	"fmt"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -60,6 +62,38 @@ var precedences = map[token.TokenType]int{
 	token.IN:        IN,
 }
 
+// compoundAssignments are the tokens of assignments which apply an operator
+// to the current value, like "+=".
+var compoundAssignments = []token.TokenType{
+	token.PLUS_ASSIGN,
+	token.MINUS_ASSIGN,
+	token.STAR_ASSIGN,
+	token.SLASH_ASSIGN,
+	token.MOD_ASSIGN,
+	token.EXP_ASSIGN,
+	token.BIT_LEFT_ASSIGN,
+	token.BIT_RIGHT_ASSIGN,
+	token.BIT_AND_ASSIGN,
+	token.BIT_OR_ASSIGN,
+	token.BIT_XOR_ASSIGN,
+}
+
+func init() {
+	for _, t := range compoundAssignments {
+		precedences[t] = ASSIGN
+	}
+}
+
+// Precedence returns how tightly an infix operator binds, which is LOWEST
+// for tokens which aren't infix operators.
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+
+	return LOWEST
+}
+
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
@@ -81,13 +115,30 @@ type (
 	infixParseFn  func(ast.Expression) ast.Expression
 )
 
+// Error is a syntax error found at a particular position in the source.
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %s", e.Pos, e.Message)
+}
+
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []*Error
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// newline is whether there was a newline between curToken and
+	// peekToken, and nested is how many brackets the parser is inside of in
+	// the current block. A newline ends a statement, unless it's nested in
+	// brackets or the statement can't end there.
+	newline bool
+	nested  int
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -95,15 +146,18 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []*Error{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.ID, p.parseIdentifier)
+	p.registerPrefix(token.INT, p.parseIntLiteral)
 	p.registerPrefix(token.NUM, p.parseNumLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.PLUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.NULL, p.parseNull)
@@ -112,11 +166,18 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.MODEL, p.parseModelLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.RAW_STRING, p.parseStringLiteral)
+	p.registerPrefix(token.CHAR, p.parseCharLiteral)
+	p.registerPrefix(token.STRING_START, p.parseInterpolatedString)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.WHILE, p.parseWhileExpression)
 	p.registerPrefix(token.FOR, p.parseForExpression)
 	p.registerPrefix(token.BACKSLASH, p.parseLambdaExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
+	p.registerPrefix(token.ELLIPSIS, p.parseRestElement)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -135,6 +196,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(token.DECLARE, p.parseDeclareExpression)
 	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	for _, t := range compoundAssignments {
+		p.registerInfix(t, p.parseAssignExpression)
+	}
 	p.registerInfix(token.RANGE, p.parseInfixExpression)
 	p.registerInfix(token.XRANGE, p.parseInfixExpression)
 	p.registerInfix(token.OR, p.parseInfixExpression)
@@ -153,10 +217,30 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// Errors returns the messages of every error found while parsing, each
+// prefixed by the position it occurred at.
 func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		msgs[i] = err.Error()
+	}
+
+	return msgs
+}
+
+// ErrorList returns the errors found while parsing, with their positions
+// kept separate from the messages.
+func (p *Parser) ErrorList() []*Error {
 	return p.errors
 }
 
+func (p *Parser) addError(pos token.Position, format string, a ...interface{}) {
+	p.errors = append(p.errors, &Error{
+		Pos:     pos,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
@@ -166,14 +250,43 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, but got %s",
+	p.addError(p.peekToken.Pos, "expected next token to be %s, but got %s",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	p.newline = false
+
+	for p.peekToken.Type == token.NL {
+		p.peekToken = p.l.NextToken()
+		p.newline = true
+	}
+}
+
+// atNewline reports whether a newline after the current token ends the
+// expression being parsed.
+func (p *Parser) atNewline() bool {
+	return p.newline && p.nested == 0
+}
+
+// atStatementEnd reports whether the current token can be the last one in a
+// statement, which it can if it's followed by a semicolon, a newline, the
+// end of a block or the end of the file.
+func (p *Parser) atStatementEnd() bool {
+	return p.peekTokenIs(token.SEMI) || p.atNewline() ||
+		p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF)
+}
+
+// enter and leave are called around anything in brackets, where newlines
+// don't end statements.
+func (p *Parser) enter() {
+	p.nested++
+}
+
+func (p *Parser) leave() {
+	p.nested--
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -188,6 +301,8 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.nextToken()
 	}
 
+	p.checkRestElements(program)
+
 	return program
 }
 
@@ -207,7 +322,11 @@ func (p *Parser) parseStatement() ast.Statement {
 		stmt = p.parseExpressionStatement()
 	}
 
-	if !p.expectPeek(token.SEMI) {
+	// the semicolon is optional at the end of a line or block
+	if p.peekTokenIs(token.SEMI) {
+		p.nextToken()
+	} else if !p.atStatementEnd() {
+		p.peekError(token.SEMI)
 		return nil
 	}
 
@@ -223,8 +342,11 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
-	if p.peekToken.Type == token.SEMI {
-		return &ast.ReturnStatement{Token: p.curToken, ReturnValue: &ast.Null{}}
+	if p.atStatementEnd() {
+		return &ast.ReturnStatement{
+			Token:       p.curToken,
+			ReturnValue: &ast.Null{Token: token.Token{Type: token.NULL, Pos: p.curToken.Pos}},
+		}
 	}
 
 	stmt := &ast.ReturnStatement{Token: p.curToken}
@@ -258,7 +380,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	leftExp := prefix()
 
-	for !p.peekTokenIs(token.SEMI) && precedence < p.peekPrecedence() {
+	for !p.peekTokenIs(token.SEMI) && !p.atNewline() && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 
 		if infix == nil {
@@ -277,13 +399,38 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseIntLiteral() ast.Expression {
+	lit := &ast.IntegerLiteral{Token: p.curToken}
+
+	digits, base := strings.Replace(p.curToken.Literal, "_", "", -1), 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			digits, base = digits[2:], 16
+		case 'o', 'O':
+			digits, base = digits[2:], 8
+		case 'b', 'B':
+			digits, base = digits[2:], 2
+		}
+	}
+
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		p.addError(p.curToken.Pos, "integer literal %s is too large", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
 func (p *Parser) parseNumLiteral() ast.Expression {
 	lit := &ast.NumberLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	value, err := strconv.ParseFloat(strings.Replace(p.curToken.Literal, "_", "", -1), 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as a number", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken.Pos, "number literal %s is too large", p.curToken.Literal)
 		return nil
 	}
 
@@ -292,6 +439,20 @@ func (p *Parser) parseNumLiteral() ast.Expression {
 	return lit
 }
 
+// parseIllegal reports a token the lexer couldn't make sense of, with the
+// lexer's explanation if it gave one.
+func (p *Parser) parseIllegal() ast.Expression {
+	for _, err := range p.l.Errors() {
+		if err.Pos == p.curToken.Pos {
+			p.addError(err.Pos, "%s", err.Message)
+			return nil
+		}
+	}
+
+	p.noPrefixParseFnError(p.curToken.Type)
+	return nil
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -304,9 +465,66 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseCharLiteral() ast.Expression {
+	ch, _ := utf8.DecodeRuneInString(p.curToken.Literal)
+	return &ast.CharLiteral{Token: p.curToken, Value: ch}
+}
+
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+
+	p.enter()
+	defer p.leave()
+
+	for !p.peekTokenIs(token.STRING_END) {
+		switch p.peekToken.Type {
+		case token.STRING:
+			p.nextToken()
+			str.Parts = append(str.Parts, p.parseStringLiteral())
+		case token.INTERP_START:
+			p.nextToken()
+			p.nextToken()
+
+			errs := len(p.errors)
+			part := p.parseExpression(LOWEST)
+			if len(p.errors) > errs || !p.expectPeek(token.INTERP_END) {
+				p.skipString()
+				return nil
+			}
+
+			str.Parts = append(str.Parts, part)
+		default:
+			p.peekError(token.STRING_END)
+			return nil
+		}
+	}
+
+	p.nextToken()
+
+	return str
+}
+
+// skipString skips to the end of the string the parser is in the middle of,
+// so nothing more is reported about it once there's been an error.
+func (p *Parser) skipString() {
+	depth := 0
+
+	for p.nextToken(); !p.curTokenIs(token.EOF); p.nextToken() {
+		switch p.curToken.Type {
+		case token.STRING_START:
+			depth++
+		case token.STRING_END:
+			if depth == 0 {
+				return
+			}
+
+			depth--
+		}
+	}
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken.Pos, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -337,6 +555,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	p.enter()
+	defer p.leave()
+
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
@@ -385,6 +606,76 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// parseMatchExpression parses "match value { pattern if guard => body, ... }".
+// The arms are separated by commas or newlines, and each body is either an
+// expression or a block.
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	// like a block, the arms' bodies end at newlines
+	nested := p.nested
+	p.nested = 0
+	defer func() { p.nested = nested }()
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		arm := &ast.MatchArm{Pattern: p.matchPattern(p.parseExpression(LOWEST))}
+		if arm.Pattern == nil {
+			return nil
+		}
+
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			p.nextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		if p.peekTokenIs(token.LBRACE) {
+			p.nextToken()
+			arm.Body = p.parseBlockStatement()
+		} else {
+			arrow := p.curToken
+			p.nextToken()
+
+			arm.Body = &ast.BlockStatement{
+				Token:      arrow,
+				Statements: []ast.Statement{p.parseExpressionStatement()},
+			}
+		}
+
+		expression.Arms = append(expression.Arms, arm)
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.peekTokenIs(token.RBRACE) && !p.newline {
+			p.peekError(token.COMMA)
+			return nil
+		}
+	}
+
+	p.nextToken()
+	expression.End = p.curToken.Pos
+
+	if len(expression.Arms) == 0 {
+		p.addError(expression.Token.Pos, "expected at least one arm in a match expression")
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parseWhileExpression() ast.Expression {
 	expression := &ast.WhileExpression{Token: p.curToken}
 
@@ -405,8 +696,32 @@ func (p *Parser) parseForExpression() ast.Expression {
 
 	_ = p.expectPeek(token.LPAREN)
 
+	p.enter()
+
 	p.nextToken()
-	exp.Var = p.parseIdentifier()
+	if p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE) {
+		p.addError(p.curToken.Pos, "expected a name for the counter before the pattern, as in for (i, [a, b] | set)")
+		return nil
+	}
+
+	exp.Var = p.parseIdentifier().(*ast.Identifier)
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+
+		if p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE) {
+			// a pattern is parsed up to the '|', which would otherwise be
+			// an operator
+			exp.Element = p.pattern(p.parseExpression(BIT_OR))
+			if !isPattern(exp.Element) {
+				p.addError(p.curToken.Pos, "expected a name or pattern for the element")
+				return nil
+			}
+		} else {
+			exp.Element = p.parseIdentifier()
+		}
+	}
 
 	if !p.expectPeek(token.VLINE) {
 		return nil
@@ -415,6 +730,7 @@ func (p *Parser) parseForExpression() ast.Expression {
 	p.nextToken()
 	exp.Set = p.parseExpression(LOWEST)
 
+	p.leave()
 	_ = p.expectPeek(token.RPAREN)
 
 	if !p.expectPeek(token.LBRACE) {
@@ -426,10 +742,103 @@ func (p *Parser) parseForExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	exp := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	exp.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+
+			if !p.expectPeek(token.ID) {
+				return nil
+			}
+
+			exp.ErrName = p.parseIdentifier().(*ast.Identifier)
+
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		} else if p.peekTokenIs(token.ID) {
+			p.nextToken()
+			exp.ErrName = p.parseIdentifier().(*ast.Identifier)
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		exp.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		exp.Finally = p.parseBlockStatement()
+	}
+
+	if exp.Catch == nil && exp.Finally == nil {
+		p.addError(exp.Token.Pos, "expected a catch or finally block after try")
+		return nil
+	}
+
+	return exp
+}
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	exp := &ast.ImportExpression{Token: p.curToken}
+
+	// import a, b from "path"
+	if p.peekTokenIs(token.ID) {
+		p.nextToken()
+		exp.Names = append(exp.Names, p.parseIdentifier().(*ast.Identifier))
+
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+
+			if !p.expectPeek(token.ID) {
+				return nil
+			}
+
+			exp.Names = append(exp.Names, p.parseIdentifier().(*ast.Identifier))
+		}
+
+		// 'from' isn't a keyword, so it can still be used as a name elsewhere
+		if !p.peekTokenIs(token.ID) || p.peekToken.Literal != "from" {
+			p.addError(p.peekToken.Pos, "expected 'from' after the names to import, but got %s",
+				p.peekToken.Type)
+			return nil
+		}
+
+		p.nextToken()
+	}
+
+	p.nextToken()
+	exp.Path = p.parseExpression(LOWEST)
+
+	return exp
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
 
+	// statements in the block end at newlines, even if it's in brackets
+	nested := p.nested
+	p.nested = 0
+	defer func() { p.nested = nested }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
@@ -440,51 +849,102 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	block.End = p.curToken.Pos
+
 	return block
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// parseFunctionParameters parses a list of parameters, returning the
+// pattern each one is destructured with as well, if any of them are.
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.Expression) {
 	identifiers := []*ast.Identifier{}
+	patterns := []ast.Expression{}
+	hasPatterns := false
+
+	p.enter()
+	defer p.leave()
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, nil
 	}
 
-	if !p.expectPeek(token.ID) {
-		return nil
+	ident, pattern := p.parseParameter()
+	if ident == nil {
+		return nil, nil
 	}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
+	patterns = append(patterns, pattern)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		if !p.expectPeek(token.ID) {
-			return nil
+		ident, pattern := p.parseParameter()
+		if ident == nil {
+			return nil, nil
 		}
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
+		patterns = append(patterns, pattern)
 	}
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil
+	}
+
+	names := []*ast.Identifier{}
+	for i, ident := range identifiers {
+		if patterns[i] != nil {
+			names = append(names, ast.PatternNames(patterns[i])...)
+			hasPatterns = true
+		} else {
+			names = append(names, ident)
+		}
 	}
 
-	for i, ida := range identifiers {
-		for j, idb := range identifiers {
+	for i, ida := range names {
+		for j, idb := range names {
 			if i == j {
 				continue
 			}
 
 			if ida.Value == idb.Value {
-				p.errors = append(p.errors, "all function parameters must be unique")
-				return nil
+				p.addError(idb.Token.Pos, "all function parameters must be unique")
+				return nil, nil
 			}
 		}
 	}
 
-	return identifiers
+	if !hasPatterns {
+		patterns = nil
+	}
+
+	return identifiers, patterns
+}
+
+// parseParameter parses a parameter, which is either a name or an array or
+// hash pattern. A pattern is given a placeholder name which can't be
+// referred to, so the parameter can still be treated like any other.
+func (p *Parser) parseParameter() (*ast.Identifier, ast.Expression) {
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+
+		pattern := p.pattern(p.parseExpression(ASSIGN))
+		if !isPattern(pattern) {
+			if pattern != nil {
+				p.addError(pattern.Pos(), "expected a parameter name or pattern")
+			}
+
+			return nil, nil
+		}
+
+		return &ast.Identifier{Token: token.Token{Type: token.ID, Pos: pattern.Pos()}, Value: pattern.String()}, pattern
+	}
+
+	if !p.expectPeek(token.ID) {
+		return nil, nil
+	}
+
+	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}, nil
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
@@ -494,7 +954,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.Patterns = p.parseFunctionParameters()
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -512,7 +972,13 @@ func (p *Parser) parseModelLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	params, patterns := p.parseFunctionParameters()
+	if patterns != nil {
+		p.addError(lit.Token.Pos, "the properties of a model can't be patterns")
+		return nil
+	}
+
+	lit.Parameters = params
 
 	if p.peekTokenIs(token.COLON) {
 		p.nextToken()
@@ -537,20 +1003,31 @@ func (p *Parser) parseModelLiteral() ast.Expression {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.End = p.curToken.Pos
 	return exp
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.End = p.curToken.Pos
 	return array
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	p.enter()
+	defer p.leave()
+
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		exp.Index = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		return p.parseSliceExpression(exp)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -559,14 +1036,62 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseSliceExpression parses the rest of a slice, like a[1:3:2], once the
+// parser is up to the first ':'. The index already parsed is its start.
+func (p *Parser) parseSliceExpression(index *ast.IndexExpression) ast.Expression {
+	slice := &ast.SliceExpression{Token: index.Token, Left: index.Left, Start: index.Index}
+
+	p.nextToken()
+	slice.End = p.parseSliceBound()
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		slice.Step = p.parseSliceBound()
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return slice
+}
+
+// parseSliceBound parses one of the parts of a slice after a ':', or returns
+// nil if it's been left out.
+func (p *Parser) parseSliceBound() ast.Expression {
+	if p.peekTokenIs(token.COLON) || p.peekTokenIs(token.RBRACKET) {
+		return nil
+	}
+
+	p.nextToken()
+	return p.parseExpression(LOWEST)
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
+	p.enter()
+	defer p.leave()
+
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 		key := p.parseExpression(LOWEST)
 
+		// a name on its own is short for "name: name", which is mostly
+		// useful in patterns
+		if p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE) {
+			if name, value := shorthand(key); name != nil {
+				hash.Pairs[name] = value
+
+				if !p.peekTokenIs(token.RBRACE) {
+					p.nextToken()
+				}
+
+				continue
+			}
+		}
+
 		if !p.expectPeek(token.COLON) {
 			return nil
 		}
@@ -585,11 +1110,13 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		return nil
 	}
 
+	hash.End = p.curToken.Pos
+
 	return hash
 }
 
 func (p *Parser) parseDeclareExpression(left ast.Expression) ast.Expression {
-	exp := &ast.DeclareExpression{Token: p.curToken, Name: left}
+	exp := &ast.DeclareExpression{Token: p.curToken, Name: p.pattern(left)}
 
 	p.nextToken()
 	exp.Value = p.parseExpression(LOWEST)
@@ -598,7 +1125,15 @@ func (p *Parser) parseDeclareExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
-	exp := &ast.AssignExpression{Token: p.curToken, Name: left}
+	exp := &ast.AssignExpression{
+		Token:    p.curToken,
+		Name:     left,
+		Operator: strings.TrimSuffix(p.curToken.Literal, "="),
+	}
+
+	if exp.Operator == "" {
+		exp.Name = p.pattern(left)
+	}
 
 	p.nextToken()
 	exp.Value = p.parseExpression(LOWEST)
@@ -615,7 +1150,7 @@ func (p *Parser) parseLambdaExpression() ast.Expression {
 
 	p.nextToken()
 
-	exp.Parameters = p.parseFunctionParameters()
+	exp.Parameters, exp.Patterns = p.parseFunctionParameters()
 
 	if !p.peekTokenIs(token.ASSIGN) {
 		return nil
@@ -631,6 +1166,9 @@ func (p *Parser) parseLambdaExpression() ast.Expression {
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
+	p.enter()
+	defer p.leave()
+
 	if p.peekTokenIs(end) {
 		p.nextToken()
 		return list
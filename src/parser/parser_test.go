@@ -24,7 +24,12 @@ func TestLiterals(t *testing.T) {
 	runTests(t, []test{
 		{"5;", "5"},
 		{"5.3;", "5.3"},
-		{"1.;", "1"},
+		{"1.;", "1."},
+		{"0x1F_ff;", "0x1F_ff"},
+		{"1_000.5e-3;", "1_000.5e-3"},
+		{"0b12;", "ERROR: invalid digit '2' in binary literal 0b12"},
+		{"9223372036854775808;", "ERROR: integer literal 9223372036854775808 is too large"},
+		{"1e400;", "ERROR: number literal 1e400 is too large"},
 		{"true;", "true"},
 		{"false;", "false"},
 		{`"Hello, world";`, "\"Hello, world\""},
@@ -33,11 +38,30 @@ func TestLiterals(t *testing.T) {
 	})
 }
 
+func TestStrings(t *testing.T) {
+	runTests(t, []test{
+		{"`a\\n`;", `"a\n"`},
+		{`"a ${b + 1} c";`, `"a ${(b + 1)} c"`},
+		{`"${a}${"${b}"}";`, `"${a}${"${b}"}"`},
+		{`"${f(x, {y: 1}.y)}";`, `"${(f(x, ({y: 1} . y)))}"`},
+		{"x := \"\"\"\n  a\n    b\n  \"\"\";", "(x := \"a\n  b\")"},
+		{`"\q";`, `ERROR: unknown escape sequence \q`},
+		{`'a';`, `'a'`},
+		{`'\n' + 'é';`, "('\n' + 'é')"},
+		{`'ab';`, "ERROR: expected one character in a character literal. got 'ab'"},
+		{`match c { 'a'..'z' => 1 };`, "(match c { ('a' .. 'z') => 1 })"},
+		{`"a ${b";`, "ERROR: unterminated string"},
+		{`"a ${b c}";`, "ERROR: expected next token to be INTERP_END, but got ID"},
+		{`"a ${}";`, "ERROR: no prefix parse function for INTERP_END found"},
+	})
+}
+
 func TestUnaryOps(t *testing.T) {
 	runTests(t, []test{
 		{"-5;", "(-5)"},
 		{"!3;", "(!3)"},
 		{"-++--1;", "(-(+(+(-(-1)))))"},
+		{"~a ^ ~b;", "((~a) ^ (~b))"},
 		{"=5", "ERROR: no prefix parse function for = found"},
 	})
 }
@@ -58,6 +82,11 @@ func TestInfixOps(t *testing.T) {
 		{"a.b;", "(a . b)"},
 		{"a := b;", "(a := b)"},
 		{"a = b;", "(a = b)"},
+		{"a += b;", "(a += b)"},
+		{"a **= b;", "(a **= b)"},
+		{"a <<= b;", "(a <<= b)"},
+		{"a.b ^= c;", "((a . b) ^= c)"},
+		{"a[0] -= b = c;", "((a[0]) -= (b = c))"},
 		{"a..b;", "(a .. b)"},
 		{"a..<b;", "(a ..< b)"},
 		{"a || b;", "(a || b)"},
@@ -72,6 +101,19 @@ func TestInfixOps(t *testing.T) {
 	})
 }
 
+func TestSlices(t *testing.T) {
+	runTests(t, []test{
+		{"a[1:2];", "(a[1:2])"},
+		{"a[:-1];", "(a[:(-1)])"},
+		{"a[b + 1:];", "(a[(b + 1):])"},
+		{"a[:];", "(a[:])"},
+		{"a[::-1];", "(a[::(-1)])"},
+		{"a[1:2:3][0];", "((a[1:2:3])[0])"},
+		{"a[1:] = b;", "((a[1:]) = b)"},
+		{"a[1:2:3:4];", "ERROR: expected next token to be ], but got :"},
+	})
+}
+
 func TestIfExpr(t *testing.T) {
 	runTests(t, []test{
 		{"if cond { a + b; };", "(if cond (a + b))"},
@@ -105,6 +147,61 @@ func TestFunctions(t *testing.T) {
 	})
 }
 
+func TestPatterns(t *testing.T) {
+	runTests(t, []test{
+		{"[a, b] := c;", "([a, b] := c)"},
+		{"[a, ...b] := c;", "([a, ...b] := c)"},
+		{"[a, [b, c = 1]] = d;", "([a, [b, c = 1]] = d)"},
+		{"[a[0], b.c] = d;", "([(a[0]), (b . c)] = d)"},
+		{`{a, b: c, "d": e, ...f} := g;`, `({a, b: c, "d": e, ...f} := g)`},
+		{"{a = 1, b: [c]} := d;", "({a = 1, b: [c]} := d)"},
+		{"fn ([a, b], {c}) {};", "(fn([a, b], {c}) )"},
+		{`\([a, ...b]) = b;`, `(\([a, ...b]) = b)`},
+		{"for (i, [k, v] | pairs) { k; };", "(for (i, [k, v] | pairs) { k })"},
+		{"for (k, v | pairs) { k; };", "(for (k, v | pairs) { k })"},
+		{"for ([k, v] | pairs) { k; };", "ERROR: expected a name for the counter before the pattern, as in for (i, [a, b] | set)"},
+		{"[...a, b] := c;", "ERROR: '...' can only be used at the end of a pattern"},
+		{"{...a, b} := c;", "ERROR: '...' can only be used at the end of a pattern"},
+		{"print(...a);", "ERROR: '...' can only be used at the end of a pattern"},
+		{"x := [...y];", "ERROR: '...' can only be used at the end of a pattern"},
+		{"x := {a, ...y};", "ERROR: '...' can only be used at the end of a pattern"},
+		{"f := fn () { {a: [...b]}; };", "ERROR: '...' can only be used at the end of a pattern"},
+		{"{[a]: b} := c;", "ERROR: expected a name or a string as a key in a pattern. got [a]"},
+		{"fn ([a, a]) {};", "ERROR: duplicate parameter a"},
+	})
+}
+
+func TestMatch(t *testing.T) {
+	runTests(t, []test{
+		{"match x { 1 => a, _ => b };", "(match x { 1 => a, _ => b })"},
+		{"match (x) { [a, ...b] if a > 0 => b };", "(match x { [a, ...b] if (a > 0) => b })"},
+		{"match x { {a, b: 0..9} => a, vec(x, -1) => x };", "(match x { {a, b: (0 .. 9)} => a, vec(x, (-1)) => x })"},
+		{"match x {\n  1 => a\n  2 => { b; }\n};", "(match x { 1 => a, 2 => b })"},
+		{"match x { 1 => a 2 => b };", "ERROR: expected next token to be ,, but got INT"},
+		{"match x { a + 1 => b };", "ERROR: expected a pattern. got (a + 1)"},
+		{"match x { [a = 1] => b };", "ERROR: expected a pattern. got (a = 1)"},
+		{"match x {};", "ERROR: expected at least one arm in a match expression"},
+	})
+}
+
+func TestTryExpr(t *testing.T) {
+	runTests(t, []test{
+		{"try { a; } catch (e) { b; };", "(try a catch (e) b)"},
+		{"try { a; } catch e { b; } finally { c; };", "(try a catch (e) b finally c)"},
+		{"try { a; } finally { c; };", "(try a finally c)"},
+		{"try { a; };", "ERROR: expected a catch or finally block after try"},
+	})
+}
+
+func TestImports(t *testing.T) {
+	runTests(t, []test{
+		{`import "lib.lang";`, `(import "lib.lang")`},
+		{`lib := import "lib.lang";`, `(lib := (import "lib.lang"))`},
+		{`import a, b from "lib.lang";`, `(import a, b from "lib.lang")`},
+		{`import a, b "lib.lang";`, "ERROR: expected 'from' after the names to import"},
+	})
+}
+
 func TestModels(t *testing.T) {
 	runTests(t, []test{
 		{"model (x, y);", "(model (x, y))"},
@@ -112,6 +209,26 @@ func TestModels(t *testing.T) {
 	})
 }
 
+func TestOptionalSemicolons(t *testing.T) {
+	runTests(t, []test{
+		{"a\nb", "ab"},
+		{"a := 1\n\n# comment\nb", "(a := 1)b"},
+		{"a +\n  b", "(a + b)"},
+		{"a.\n  b", "(a . b)"},
+		{"a\n-b", "a(-b)"},
+		{"a\n(b)", "ab"},
+		{"f(a,\n  b\n)", "(f(a, b))"},
+		{"(a\n  + b)", "(a + b)"},
+		{"[a\n  - b]", "[(a - b)]"},
+		{"fn () { a\n  b }", "(fn() ab)"},
+		{"f(fn () {\n  a\n  -b\n})", "(f((fn() a(-b))))"},
+		{"fn () {\n  return\n}", "(fn() return ;)"},
+		{"if a { b }\nelse { c }", "(if a b else c)"},
+		{"if a { b } c", "ERROR: expected next token to be ;, but got ID"},
+		{"a b", "ERROR: expected next token to be ;, but got ID"},
+	})
+}
+
 func runTests(t *testing.T, tests []test) {
 	for _, test := range tests {
 		l := lexer.New(test.input)
@@ -131,3 +248,23 @@ func runTests(t *testing.T, tests []test) {
 		}
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	l := lexer.NewFile("test.lang", "a := 1;\nb c;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.ErrorList()
+	if len(errors) == 0 {
+		t.Fatalf("expected an error")
+	}
+
+	if errors[0].Pos.String() != "test.lang:2:3" {
+		t.Errorf("expected the error at test.lang:2:3, got %v", errors[0].Pos)
+	}
+
+	expected := "test.lang:2:3: expected next token to be ;, but got ID"
+	if p.Errors()[0] != expected {
+		t.Errorf("expected %q, got %q", expected, p.Errors()[0])
+	}
+}
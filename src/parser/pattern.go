@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"../ast"
+	"../token"
+)
+
+// Patterns are parsed as array and hash literals, since there's no way to
+// tell them apart until the := or = after them, and are then turned into
+// patterns.
+
+// parseRestElement parses "...name", which is only valid at the end of a
+// pattern.
+func (p *Parser) parseRestElement() ast.Expression {
+	rest := &ast.RestElement{Token: p.curToken}
+
+	if !p.expectPeek(token.ID) {
+		return nil
+	}
+
+	rest.Name = p.parseIdentifier().(*ast.Identifier)
+
+	return rest
+}
+
+// checkRestElements reports an error for each "...name" in a program which
+// wasn't turned into part of a pattern, like the one in "print(...a)".
+func (p *Parser) checkRestElements(program *ast.Program) {
+	ast.Inspect(program, func(node ast.Node) bool {
+		if rest, ok := node.(*ast.RestElement); ok {
+			p.addError(rest.Token.Pos, "'...' can only be used at the end of a pattern")
+		}
+
+		return true
+	})
+}
+
+// shorthand returns the key and value a hash literal's key stands for when
+// it's on its own, like "x" in "{x, y: 2}", or nil if it can't be.
+func shorthand(key ast.Expression) (ast.Expression, ast.Expression) {
+	switch key := key.(type) {
+	case *ast.Identifier:
+		return key, key
+	case *ast.RestElement:
+		return key, key
+	case *ast.AssignExpression:
+		// a default, in "{x = 1} := h"
+		if id, ok := key.Name.(*ast.Identifier); ok && key.Operator == "" {
+			return id, key
+		}
+	}
+
+	return nil, nil
+}
+
+// pattern turns the left hand side of a declaration or assignment into a
+// pattern, if it's an array or hash literal. Anything else is returned as
+// it is.
+func (p *Parser) pattern(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.ArrayLiteral:
+		return p.arrayPattern(e, p.patternElement)
+	case *ast.HashLiteral:
+		return p.hashPattern(e, p.patternElement)
+	case *ast.RestElement:
+		p.addError(e.Token.Pos, "'...' can only be used at the end of a pattern")
+		return nil
+	default:
+		return e
+	}
+}
+
+func isPattern(e ast.Expression) bool {
+	switch e.(type) {
+	case *ast.ArrayPattern, *ast.HashPattern:
+		return true
+	default:
+		return false
+	}
+}
+
+// patternElement turns an element of an array or hash into a pattern, where
+// "a = 1" gives a default value.
+func (p *Parser) patternElement(e ast.Expression) ast.Expression {
+	if assign, ok := e.(*ast.AssignExpression); ok && assign.Operator == "" {
+		target := p.pattern(assign.Name)
+		if target == nil {
+			return nil
+		}
+
+		return &ast.DefaultPattern{Token: assign.Token, Target: target, Default: assign.Value}
+	}
+
+	return p.pattern(e)
+}
+
+// matchPattern turns the pattern of a match arm into a pattern. As well as
+// names, arrays and hashes, it can have literals and ranges, which are
+// compared against the value, and calls, which check its model.
+func (p *Parser) matchPattern(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case nil:
+		return nil
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.NumberLiteral, *ast.StringLiteral, *ast.CharLiteral,
+		*ast.Boolean, *ast.Null:
+		return e
+	case *ast.PrefixExpression:
+		switch e.Right.(type) {
+		case *ast.IntegerLiteral, *ast.NumberLiteral:
+			if e.Operator == "-" {
+				return e
+			}
+		}
+	case *ast.InfixExpression:
+		if e.Operator == ".." || e.Operator == "..<" {
+			return e
+		}
+	case *ast.ArrayLiteral:
+		return p.arrayPattern(e, p.matchPattern)
+	case *ast.HashLiteral:
+		return p.hashPattern(e, p.matchPattern)
+	case *ast.CallExpression:
+		pattern := &ast.ModelPattern{Token: e.Token, Model: e.Function}
+
+		for _, arg := range e.Arguments {
+			arg := p.matchPattern(arg)
+			if arg == nil {
+				return nil
+			}
+
+			pattern.Arguments = append(pattern.Arguments, arg)
+		}
+
+		return pattern
+	}
+
+	p.addError(e.Pos(), "expected a pattern. got %v", e.String())
+	return nil
+}
+
+// arrayPattern turns an array literal into a pattern, using element to turn
+// each of its elements into a pattern.
+func (p *Parser) arrayPattern(array *ast.ArrayLiteral, element func(ast.Expression) ast.Expression) ast.Expression {
+	pattern := &ast.ArrayPattern{Token: array.Token}
+
+	for i, el := range array.Elements {
+		if rest, ok := el.(*ast.RestElement); ok {
+			if i != len(array.Elements)-1 {
+				p.addError(rest.Token.Pos, "'...' can only be used at the end of a pattern")
+				return nil
+			}
+
+			pattern.Rest = rest.Name
+			continue
+		}
+
+		elem := element(el)
+		if elem == nil {
+			return nil
+		}
+
+		pattern.Elements = append(pattern.Elements, elem)
+	}
+
+	return pattern
+}
+
+func (p *Parser) hashPattern(hash *ast.HashLiteral, element func(ast.Expression) ast.Expression) ast.Expression {
+	pattern := &ast.HashPattern{Token: hash.Token}
+
+	keys := hash.Keys()
+
+	for i, key := range keys {
+		switch key := key.(type) {
+		case *ast.RestElement:
+			if i != len(keys)-1 {
+				p.addError(key.Token.Pos, "'...' can only be used at the end of a pattern")
+				return nil
+			}
+
+			pattern.Rest = key.Name
+		case *ast.Identifier, *ast.StringLiteral:
+			value := element(hash.Pairs[key])
+			if value == nil {
+				return nil
+			}
+
+			pattern.Fields = append(pattern.Fields, &ast.PatternField{Key: key, Value: value})
+		default:
+			p.addError(key.Pos(), "expected a name or a string as a key in a pattern. got %v", key.String())
+			return nil
+		}
+	}
+
+	return pattern
+}
@@ -0,0 +1,189 @@
+package repl
+
+import (
+	"../object"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// command is a meta-command, which is entered into the REPL starting with
+// a colon and controls the REPL itself rather than being evaluated.
+type command struct {
+	name string
+	args string
+	help string
+
+	// run runs the command with the rest of the line as its argument,
+	// returning true if the REPL should exit
+	run func(r *repl, arg string) bool
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{":help", "", "show this help", (*repl).help},
+		{":env", "", "list the names declared in the environment", (*repl).listEnv},
+		{":type", "<expr>", "show the type of an expression", (*repl).showType},
+		{":ast", "<code>", "show how some code is parsed", (*repl).showAST},
+		{":load", "<file>", "evaluate a file in the environment", (*repl).load},
+		{":reset", "", "start again with an empty environment", (*repl).reset},
+		{":quit", "", "exit the REPL", func(r *repl, arg string) bool { return true }},
+	}
+}
+
+func (r *repl) command(input string) bool {
+	name, arg := input, ""
+	if i := strings.IndexAny(input, " \t"); i >= 0 {
+		name, arg = input[:i], strings.TrimSpace(input[i:])
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(r, arg)
+		}
+	}
+
+	fmt.Fprintf(r.out, "unknown command %s. type :help to see the commands\n", name)
+	return false
+}
+
+func (r *repl) help(arg string) bool {
+	for _, cmd := range commands {
+		usage := strings.TrimSpace(cmd.name + " " + cmd.args)
+		fmt.Fprintf(r.out, "  %-14s %s\n", usage, cmd.help)
+	}
+
+	return false
+}
+
+func (r *repl) listEnv(arg string) bool {
+	bindings := r.env.Bindings()
+
+	names := []string{}
+	for name := range bindings {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(r.out, "  %s = %s\n", name, summarise(bindings[name].Inspect()))
+	}
+
+	return false
+}
+
+// summarise shortens the inspected form of a value to its first line.
+func summarise(s string) string {
+	const max = 60
+
+	if i := strings.Index(s, "\n"); i >= 0 {
+		s = s[:i] + " ..."
+	}
+
+	if len(s) > max {
+		s = s[:max] + "..."
+	}
+
+	return s
+}
+
+func (r *repl) showType(arg string) bool {
+	if arg == "" {
+		io.WriteString(r.out, "usage: :type <expr>\n")
+		return false
+	}
+
+	program, ok := r.parse("", terminate(arg))
+	if !ok {
+		return false
+	}
+
+	evaluated := r.evalProgram(program)
+	if err, ok := evaluated.(*object.Error); ok {
+		r.printRuntimeError(err, arg)
+		return false
+	}
+
+	if evaluated == nil {
+		evaluated = &object.Null{}
+	}
+
+	if hash, ok := evaluated.(*object.Hash); ok && hash.Model != object.OBJECT_MODEL {
+		fmt.Fprintf(r.out, " => %s (%s)\n", evaluated.Type(), hash.Model.Name)
+	} else {
+		fmt.Fprintf(r.out, " => %s\n", evaluated.Type())
+	}
+
+	return false
+}
+
+func (r *repl) showAST(arg string) bool {
+	program, ok := r.parse("", terminate(arg))
+	if !ok {
+		return false
+	}
+
+	for _, stmt := range program.Statements {
+		io.WriteString(r.out, "  "+stmt.String()+"\n")
+	}
+
+	return false
+}
+
+// terminate adds a semicolon to the end of some code if it doesn't already
+// have one.
+func terminate(code string) string {
+	if strings.HasSuffix(code, ";") {
+		return code
+	}
+
+	return code + ";"
+}
+
+func (r *repl) load(arg string) bool {
+	if arg == "" {
+		io.WriteString(r.out, "usage: :load <file>\n")
+		return false
+	}
+
+	bytes, err := ioutil.ReadFile(arg)
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return false
+	}
+
+	text := string(bytes)
+	r.env.Runtime().Sources[arg] = text
+
+	program, ok := r.parse(arg, text)
+	if !ok {
+		return false
+	}
+
+	if err, ok := r.evalProgram(program).(*object.Error); ok {
+		r.printRuntimeError(err, text)
+	}
+
+	return false
+}
+
+// reset replaces the environment with an empty one, keeping the settings
+// of the old one's runtime.
+func (r *repl) reset(arg string) bool {
+	old := r.env.Runtime()
+
+	env := object.NewEnvironment()
+	rt := env.Runtime()
+	rt.SearchPaths = old.SearchPaths
+	rt.Limits = old.Limits
+	rt.Stdin, rt.Stdout, rt.Stderr = old.Stdin, old.Stdout, old.Stderr
+
+	r.env = env
+
+	return false
+}
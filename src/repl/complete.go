@@ -0,0 +1,139 @@
+package repl
+
+import (
+	"../evaluator"
+	"../lexer"
+	"../token"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// completions returns the names in the REPL's environment, the builtins
+// and builtin models, and the keywords which start with the word before
+// pos. At the start of a line, meta-commands are completed instead.
+func (r *repl) completions(line []rune, pos int) ([]string, int) {
+	start := pos
+	for start > 0 && isIDRune(line[start-1]) {
+		start--
+	}
+
+	word := string(line[start:pos])
+
+	var names []string
+	if start == 1 && line[0] == ':' {
+		start = 0
+		word = ":" + word
+
+		for _, cmd := range commands {
+			names = append(names, cmd.name)
+		}
+	} else {
+		if word == "" {
+			return nil, pos
+		}
+
+		for name := range r.env.Bindings() {
+			names = append(names, name)
+		}
+
+		names = append(names, evaluator.BuiltinNames()...)
+		names = append(names, token.Keywords()...)
+	}
+
+	seen := make(map[string]bool)
+	candidates := []string{}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, word) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+
+	sort.Strings(candidates)
+
+	return candidates, start
+}
+
+func isIDRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isIncomplete reports whether more lines are needed to finish the input:
+// when it's inside a string, has unclosed brackets, or ends with an
+// operator or separator which must be followed by something else.
+func isIncomplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+	last := token.Token{Type: token.EOF}
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth--
+		}
+
+		if tok.Type != token.NL {
+			last = tok
+		}
+	}
+
+	for _, err := range l.Errors() {
+		if err.Message == "unterminated string" {
+			return true
+		}
+	}
+
+	return depth > 0 || continuesLine[last.Type]
+}
+
+// continuesLine holds the types of token which can't end a statement.
+var continuesLine = map[token.TokenType]bool{
+	token.DECLARE:   true,
+	token.ASSIGN:    true,
+	token.PLUS:      true,
+	token.MINUS:     true,
+	token.STAR:      true,
+	token.SLASH:     true,
+	token.MOD:       true,
+	token.BACKSLASH: true,
+	token.BANG:      true,
+	token.LT:        true,
+	token.GT:        true,
+	token.LTE:       true,
+	token.GTE:       true,
+	token.EQ:        true,
+	token.NOT_EQ:    true,
+	token.RANGE:     true,
+	token.XRANGE:    true,
+	token.AND:       true,
+	token.OR:        true,
+	token.EXP:       true,
+	token.BIT_LEFT:  true,
+	token.BIT_RIGHT: true,
+	token.BIT_AND:   true,
+	token.BIT_XOR:   true,
+	token.BIT_NOT:   true,
+	token.IN:        true,
+	token.COMMA:     true,
+	token.COLON:     true,
+	token.DOT:       true,
+	token.VLINE:     true,
+	token.ARROW:     true,
+	token.ELSE:      true,
+	token.ELIF:      true,
+	token.IMPORT:    true,
+}
+
+func init() {
+	for _, t := range []token.TokenType{
+		token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.STAR_ASSIGN, token.SLASH_ASSIGN,
+		token.MOD_ASSIGN, token.EXP_ASSIGN, token.BIT_LEFT_ASSIGN, token.BIT_RIGHT_ASSIGN,
+		token.BIT_AND_ASSIGN, token.BIT_OR_ASSIGN, token.BIT_XOR_ASSIGN,
+	} {
+		continuesLine[t] = true
+	}
+}
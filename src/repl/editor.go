@@ -0,0 +1,311 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// errInterrupted is returned by ReadLine when the user presses ctrl-c.
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads lines of input, showing a prompt before each one.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// plainReader reads lines without any editing, for when the input isn't a
+// terminal.
+type plainReader struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(r.out, prompt)
+
+	line, err := r.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// completer returns the ways the word ending at pos in line could be
+// completed, along with the position that word starts at.
+type completer func(line []rune, pos int) (candidates []string, start int)
+
+// editor reads lines from a terminal in raw mode, handling the keys for
+// moving around and editing the line, going through the history and
+// completing words.
+type editor struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// raw puts the terminal into raw mode, returning a function to restore
+	// it afterwards
+	raw      func() (func(), error)
+	history  *history
+	complete completer
+
+	prompt string
+	line   []rune
+	pos    int
+}
+
+func newEditor(in io.Reader, out io.Writer, h *history, complete completer) *editor {
+	return &editor{
+		in:       bufio.NewReader(in),
+		out:      out,
+		raw:      func() (func(), error) { return func() {}, nil },
+		history:  h,
+		complete: complete,
+	}
+}
+
+// Key codes, as sent by the terminal in raw mode.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+func (e *editor) ReadLine(prompt string) (string, error) {
+	restore, err := e.raw()
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	e.prompt = prompt
+	e.line = []rune{}
+	e.pos = 0
+
+	// index is the history entry being shown, and draft is what had been
+	// typed before going back through the history
+	index := e.history.Len()
+	draft := []rune{}
+
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, '\n':
+			io.WriteString(e.out, "\r\n")
+			return string(e.line), nil
+		case keyCtrlC:
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+
+			e.deleteForward()
+		case keyBackspace, keyDelete:
+			if e.pos > 0 {
+				e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+				e.pos--
+			}
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.line)
+		case keyCtrlB:
+			e.moveBy(-1)
+		case keyCtrlF:
+			e.moveBy(1)
+		case keyCtrlK:
+			e.line = e.line[:e.pos]
+		case keyCtrlU:
+			e.line = e.line[e.pos:]
+			e.pos = 0
+		case keyCtrlW:
+			start := e.wordStart()
+			e.line = append(e.line[:start], e.line[e.pos:]...)
+			e.pos = start
+		case keyCtrlL:
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case keyCtrlP:
+			index, draft = e.showHistory(index-1, index, draft)
+		case keyCtrlN:
+			index, draft = e.showHistory(index+1, index, draft)
+		case keyTab:
+			e.completeWord()
+		case keyEscape:
+			switch e.readEscape() {
+			case "[A", "OA":
+				index, draft = e.showHistory(index-1, index, draft)
+			case "[B", "OB":
+				index, draft = e.showHistory(index+1, index, draft)
+			case "[C", "OC":
+				e.moveBy(1)
+			case "[D", "OD":
+				e.moveBy(-1)
+			case "[H", "OH", "[1~", "[7~":
+				e.pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				e.pos = len(e.line)
+			case "[3~":
+				e.deleteForward()
+			}
+		default:
+			if unicode.IsPrint(r) {
+				e.line = append(e.line[:e.pos], append([]rune{r}, e.line[e.pos:]...)...)
+				e.pos++
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+// readEscape reads the rest of an escape sequence, after the escape
+// character, e.g. "[A" for the up arrow.
+func (e *editor) readEscape() string {
+	first, _, err := e.in.ReadRune()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+
+	seq := []rune{first}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+
+		seq = append(seq, r)
+
+		// the final byte of a control sequence is in the range @ to ~
+		if r >= '@' && r <= '~' {
+			return string(seq)
+		}
+	}
+}
+
+func (e *editor) moveBy(n int) {
+	e.pos += n
+
+	if e.pos < 0 {
+		e.pos = 0
+	} else if e.pos > len(e.line) {
+		e.pos = len(e.line)
+	}
+}
+
+func (e *editor) deleteForward() {
+	if e.pos < len(e.line) {
+		e.line = append(e.line[:e.pos], e.line[e.pos+1:]...)
+	}
+}
+
+// wordStart returns the start of the word before the cursor, skipping any
+// spaces between the two.
+func (e *editor) wordStart() int {
+	start := e.pos
+	for start > 0 && unicode.IsSpace(e.line[start-1]) {
+		start--
+	}
+
+	for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+		start--
+	}
+
+	return start
+}
+
+// showHistory shows the history entry at index, or the draft when going
+// past the most recent entry, returning the new index and draft.
+func (e *editor) showHistory(index, current int, draft []rune) (int, []rune) {
+	if index < 0 || index > e.history.Len() {
+		return current, draft
+	}
+
+	if current == e.history.Len() {
+		draft = e.line
+	}
+
+	if index == e.history.Len() {
+		e.line = append([]rune{}, draft...)
+	} else {
+		e.line = []rune(e.history.At(index))
+	}
+
+	e.pos = len(e.line)
+
+	return index, draft
+}
+
+// completeWord completes the word before the cursor as far as it can. If
+// there's more than one way to complete it, and it can't be extended any
+// further, the candidates are listed.
+func (e *editor) completeWord() {
+	if e.complete == nil {
+		return
+	}
+
+	candidates, start := e.complete(e.line, e.pos)
+	if len(candidates) == 0 {
+		return
+	}
+
+	word := string(e.line[start:e.pos])
+	prefix := commonPrefix(candidates)
+
+	if len(prefix) > len(word) {
+		rest := []rune(prefix[len(word):])
+		e.line = append(e.line[:e.pos], append(rest, e.line[e.pos:]...)...)
+		e.pos += len(rest)
+		return
+	}
+
+	if len(candidates) > 1 {
+		io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	}
+}
+
+// refresh redraws the line, putting the cursor in the right place.
+func (e *editor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.line))
+
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
@@ -0,0 +1,110 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+)
+
+// maxHistory is the most lines that are kept in the history.
+const maxHistory = 1000
+
+// history is the list of lines which have been entered, which is saved to
+// a file so it's kept between sessions.
+type history struct {
+	path  string
+	lines []string
+}
+
+// loadHistory reads the history from a file, which doesn't have to exist
+// yet. An empty path means the history isn't saved.
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+
+	// the file only has lines added to it, so it's trimmed here to stop it
+	// growing forever
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+		h.save()
+	}
+
+	return h
+}
+
+func (h *history) save() {
+	f, err := os.OpenFile(h.path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, line := range h.lines {
+		w.WriteString(line + "\n")
+	}
+
+	w.Flush()
+}
+
+func (h *history) Len() int {
+	return len(h.lines)
+}
+
+func (h *history) At(i int) string {
+	return h.lines[i]
+}
+
+// Add adds a line to the end of the history, and appends it to the file.
+// Blank lines, and lines which are the same as the one before, are left
+// out.
+func (h *history) Add(line string) {
+	if line == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[1:]
+	}
+
+	if h.path == "" {
+		return
+	}
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	f.WriteString(line + "\n")
+}
+
+// defaultHistoryFile is the LANG_HISTORY environment variable if it's set,
+// and otherwise .lang_history in the user's home directory.
+func defaultHistoryFile() string {
+	if path := os.Getenv("LANG_HISTORY"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".lang_history")
+}
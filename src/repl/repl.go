@@ -1,52 +1,206 @@
 package repl
 
 import (
+	"../ast"
 	"../evaluator"
 	"../lexer"
 	"../object"
 	"../parser"
+	"../token"
 	"bufio"
-	"fmt"
+	"context"
 	"io"
+	"os"
+	"os/signal"
+	"strings"
 )
 
-const PROMPT = "> "
+const (
+	PROMPT              = "> "
+	CONTINUATION_PROMPT = ". "
+)
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// HistoryFile is where the lines entered into the REPL are saved, so
+// they're kept between sessions. If it's empty, they aren't saved.
+var HistoryFile = defaultHistoryFile()
 
-	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
+type repl struct {
+	env     *object.Environment
+	out     io.Writer
+	reader  lineReader
+	history *history
+}
+
+// Start runs the REPL until the input ends. If in is a terminal, lines can
+// be edited, the history can be gone through with the arrow keys, and
+// names can be completed with tab.
+func Start(in io.Reader, out io.Writer, env *object.Environment) {
+	r := &repl{env: env, out: out}
 
-		if !scanned {
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		r.history = loadHistory(HistoryFile)
+
+		e := newEditor(in, out, r.history, r.completions)
+		e.raw = func() (func(), error) { return makeRaw(int(f.Fd())) }
+		r.reader = e
+	} else {
+		r.history = loadHistory("")
+		r.reader = &plainReader{in: bufio.NewReader(in), out: out}
+	}
+
+	r.run()
+}
+
+func (r *repl) run() {
+	for {
+		input, err := r.readInput()
+		if err == errInterrupted {
+			continue
+		} else if err != nil {
 			return
 		}
 
-		line := scanner.Text()
+		trimmed := strings.TrimSpace(input)
 
-		l := lexer.New(line)
-		p := parser.New(l)
+		if trimmed == "" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ":") {
+			if quit := r.command(trimmed); quit {
+				return
+			}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
 			continue
 		}
 
-		// io.WriteString(out, " -> "+program.String()+"\n")
+		r.eval(input)
+	}
+}
+
+// readInput reads lines until they make a complete input, showing the
+// continuation prompt for each line after the first. An empty line ends
+// the input even if it's incomplete, so that the errors can be seen.
+func (r *repl) readInput() (string, error) {
+	lines := []string{}
+	prompt := PROMPT
+
+	for {
+		line, err := r.reader.ReadLine(prompt)
+		if err != nil {
+			return "", err
+		}
+
+		r.history.Add(line)
+
+		if len(lines) > 0 && strings.TrimSpace(line) == "" {
+			return strings.Join(lines, "\n"), nil
+		}
+
+		lines = append(lines, line)
+		input := strings.Join(lines, "\n")
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, " => "+evaluated.Inspect()+"\n")
+		if strings.HasPrefix(strings.TrimSpace(input), ":") || !isIncomplete(input) {
+			return input, nil
 		}
+
+		prompt = CONTINUATION_PROMPT
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+func (r *repl) eval(input string) {
+	program, ok := r.parse("", input)
+	if !ok {
+		return
+	}
+
+	evaluated := r.evalProgram(program)
+	if err, ok := evaluated.(*object.Error); ok {
+		r.printRuntimeError(err, input)
+	} else if evaluated != nil {
+		io.WriteString(r.out, " => "+evaluated.Inspect()+"\n")
+	}
+}
+
+// parse parses the input, printing any errors.
+func (r *repl) parse(file, input string) (*ast.Program, bool) {
+	l := lexer.NewFile(file, input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(r.out, p.ErrorList(), input)
+		return nil, false
+	}
+
+	return program, true
+}
+
+// evalProgram evaluates a program in the REPL's environment. Pressing
+// ctrl-c while it's running stops the program, rather than the REPL.
+func (r *repl) evalProgram(program *ast.Program) object.Object {
+	rt := r.env.Runtime()
+
+	// each input gets the full step budget
+	rt.ResetSteps()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	defer func() {
+		signal.Stop(interrupts)
+		cancel()
+		rt.SetContext(context.Background())
+	}()
+
+	rt.SetContext(ctx)
+
+	return evaluator.Eval(program, r.env)
+}
+
+// printRuntimeError prints an error with an excerpt of the source it came
+// from, which is the input unless it came from a file.
+func (r *repl) printRuntimeError(err *object.Error, input string) {
+	if source, ok := r.env.Runtime().Sources[err.Pos.File]; ok && err.Pos.File != "" {
+		input = source
+	}
+
+	printRuntimeError(r.out, err, input)
+}
+
+func printParserErrors(out io.Writer, errors []*parser.Error, line string) {
 	io.WriteString(out, "parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "  "+msg+"\n")
+	for _, err := range errors {
+		io.WriteString(out, "  "+err.Error()+"\n")
+		printExcerpt(out, line, err.Pos)
+	}
+}
+
+func printRuntimeError(out io.Writer, err *object.Error, line string) {
+	io.WriteString(out, " => "+err.Inspect()+"\n")
+	printExcerpt(out, line, err.Pos)
+
+	if tb := err.Traceback(); tb != "" {
+		io.WriteString(out, tb+"\n")
+	}
+}
+
+func printExcerpt(out io.Writer, line string, pos token.Position) {
+	excerpt := lexer.Excerpt(line, pos)
+	if excerpt == "" {
+		return
+	}
+
+	for _, l := range strings.Split(excerpt, "\n") {
+		io.WriteString(out, "    "+l+"\n")
 	}
 }
@@ -0,0 +1,202 @@
+package repl
+
+import (
+	"../object"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2;", false},
+		{"x := 5", false},
+		{"f := fn (x) {", true},
+		{"f := fn (x) {\n  x;\n};", false},
+		{"[1, 2,", true},
+		{"1 +", true},
+		{"x :=", true},
+		{"a.", true},
+		{`"abc`, true},
+		{`"a{bc";`, false},
+		{`"a\"";`, false},
+		{"x := \"\"\"\n  abc", true},
+		{"x := \"\"\"\n  abc\n\"\"\"", false},
+		{"x := `abc\n", true},
+		{"x := `a\\`", false},
+		{`"a ${b + `, true},
+		{`"a ${ {b: 1}.b }"`, false},
+		{`"\"}"`, false},
+		{"f(1, # a comment (\n", true},
+		{"x; # }\n", false},
+		{"x := 1 +  \n", true},
+		{"return\n", false},
+	}
+
+	for _, tt := range tests {
+		if got := isIncomplete(tt.input); got != tt.expected {
+			t.Errorf("isIncomplete(%q): expected %v, got %v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEditor(t *testing.T) {
+	h := loadHistory("")
+	h.Add("first")
+	h.Add("second")
+
+	complete := func(line []rune, pos int) ([]string, int) {
+		return []string{"counter", "count"}, 0
+	}
+
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"abc\r", "abc"},
+		{"abc\x1b[D\x1b[DX\r", "aXbc"},
+		{"abc\x01X\x05Y\r", "XabcY"},
+		{"abc\x7f\x7fd\r", "ad"},
+		{"hello world\x17\x17x\r", "x"},
+		{"abc\x02\x02\x0b\r", "a"},
+		{"\x1b[A\r", "second"},
+		{"\x1b[A\x1b[A\r", "first"},
+		{"new\x1b[A\x1b[B\r", "new"},
+		{"c\t\r", "count"},
+		{"héllo\x1b[D\x1b[3~\r", "héll"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		e := newEditor(strings.NewReader(tt.keys), &out, h, complete)
+
+		line, err := e.ReadLine("> ")
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tt.keys, err)
+			continue
+		}
+
+		if line != tt.expected {
+			t.Errorf("expected %q to give %q, got %q", tt.keys, tt.expected, line)
+		}
+	}
+
+	e := newEditor(strings.NewReader("abc\x03"), ioutil.Discard, h, nil)
+	if _, err := e.ReadLine("> "); err != errInterrupted {
+		t.Errorf("expected ctrl-c to interrupt, got %v", err)
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Declare("counter", &object.Null{})
+
+	r := &repl{env: env}
+
+	tests := []struct {
+		line       string
+		candidates []string
+		start      int
+	}{
+		{"x := cou", []string{"counter"}, 5},
+		{"pri", []string{"print"}, 0},
+		{"ve", []string{"vec"}, 0},
+		{"whi", []string{"while"}, 0},
+		{":lo", []string{":load"}, 0},
+		{"x := ", nil, 5},
+	}
+
+	for _, tt := range tests {
+		line := []rune(tt.line)
+		candidates, start := r.completions(line, len(line))
+
+		if len(candidates) == 0 && len(tt.candidates) == 0 {
+			candidates = nil
+		}
+
+		if !reflect.DeepEqual(candidates, tt.candidates) || start != tt.start {
+			t.Errorf("completing %q: expected %v from %d, got %v from %d",
+				tt.line, tt.candidates, tt.start, candidates, start)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "history")
+
+	h := loadHistory(path)
+	h.Add("one")
+	h.Add("one")
+	h.Add("")
+	h.Add("two")
+
+	h = loadHistory(path)
+	if !reflect.DeepEqual(h.lines, []string{"one", "two"}) {
+		t.Errorf("wrong history: %v", h.lines)
+	}
+}
+
+func TestStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "lib.lang")
+	if err := ioutil.WriteFile(file, []byte("loaded := 42;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		"double := fn (x) {",
+		"  x * 2;",
+		"};",
+		"double(21);",
+		":type double",
+		":ast 1 + 2 * 3",
+		":load " + file,
+		"loaded;",
+		":env",
+		":reset",
+		"loaded;",
+		":quit",
+		"1;",
+	}, "\n")
+
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out, object.NewEnvironment())
+
+	expected := []string{
+		". . ",
+		" => 42\n",
+		" => FUNCTION\n",
+		"  (1 + (2 * 3))\n",
+		"  double = fn (x) { (x * 2) }\n",
+		"  loaded = 42\n",
+		"identifier not found: loaded",
+	}
+
+	for _, s := range expected {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected the output to contain %q, got:\n%s", s, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), " => 1\n") {
+		t.Errorf("expected :quit to exit the REPL")
+	}
+}
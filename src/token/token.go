@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 const (
 	// Misc
 	ILLEGAL = "ILLEGAL"
@@ -9,8 +11,13 @@ const (
 
 	// Values
 	ID     = "ID"
+	INT    = "INT"
 	NUM    = "NUM"
 	STRING = "STRING"
+	CHAR   = "CHAR"
+
+	// A string between backticks, with no escapes or interpolations
+	RAW_STRING = "RAW_STRING"
 
 	// Operators
 	DECLARE   = ":="
@@ -30,6 +37,7 @@ const (
 	NOT_EQ    = "!="
 	RANGE     = ".."
 	XRANGE    = "..<"
+	ELLIPSIS  = "..."
 	AND       = "&&"
 	OR        = "||"
 	EXP       = "**"
@@ -40,14 +48,35 @@ const (
 	BIT_NOT   = "~"
 	IN        = "IN"
 
+	// Compound assignment operators
+	PLUS_ASSIGN      = "+="
+	MINUS_ASSIGN     = "-="
+	STAR_ASSIGN      = "*="
+	SLASH_ASSIGN     = "/="
+	MOD_ASSIGN       = "%="
+	EXP_ASSIGN       = "**="
+	BIT_LEFT_ASSIGN  = "<<="
+	BIT_RIGHT_ASSIGN = ">>="
+	BIT_AND_ASSIGN   = "&="
+	BIT_OR_ASSIGN    = "|="
+	BIT_XOR_ASSIGN   = "^="
+
 	// Separators
 	COMMA   = ","
 	SEMI    = ";"
 	COLON   = ":"
 	DOT     = "."
 	VLINE   = "|"
+	ARROW   = "=>"
 	NEWLINE = "NEWLINE"
 
+	// Strings with interpolations in them, and triple quoted strings,
+	// are split up into these around the STRINGs and the expressions
+	STRING_START = "STRING_START"
+	STRING_END   = "STRING_END"
+	INTERP_START = "${"
+	INTERP_END   = "INTERP_END"
+
 	// Parentheses
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -74,13 +103,56 @@ const (
 	FOR   = "FOR"
 	BREAK = "BREAK"
 	NEXT  = "NEXT"
+
+	// Pattern matching keywords
+	MATCH = "MATCH"
+
+	// Error handling keywords
+	TRY     = "TRY"
+	CATCH   = "CATCH"
+	FINALLY = "FINALLY"
+
+	// Module keywords
+	IMPORT = "IMPORT"
 )
 
 type TokenType string
 
+// Position describes a location in a source file. Lines and columns both
+// start at 1, and columns are counted in characters rather than bytes.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+// IsValid returns whether or not the position refers to an actual location,
+// as opposed to being the zero value.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.File != "" {
+			return p.File
+		}
+
+		return "-"
+	}
+
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
 }
 
 func New(tokenType TokenType, literal string) Token {
@@ -88,20 +160,35 @@ func New(tokenType TokenType, literal string) Token {
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"elif":   ELIF,
-	"return": RETURN,
-	"while":  WHILE,
-	"for":    FOR,
-	"break":  BREAK,
-	"next":   NEXT,
-	"null":   NULL,
-	"in":     IN,
-	"model":  MODEL,
+	"fn":      FUNCTION,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"elif":    ELIF,
+	"return":  RETURN,
+	"while":   WHILE,
+	"for":     FOR,
+	"break":   BREAK,
+	"next":    NEXT,
+	"null":    NULL,
+	"in":      IN,
+	"model":   MODEL,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"import":  IMPORT,
+	"match":   MATCH,
+}
+
+// Keywords returns every keyword, in no particular order.
+func Keywords() []string {
+	words := []string{}
+	for word := range keywords {
+		words = append(words, word)
+	}
+
+	return words
 }
 
 func LookupIdent(ident string) TokenType {
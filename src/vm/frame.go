@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"../compiler"
+	"../object"
+)
+
+// Closure is a compiled function along with the variables it captured
+// from the functions enclosing it.
+type Closure struct {
+	Fn   *compiler.CompiledFunction
+	Free []*object.Object
+	Name string
+
+	vm *VM
+}
+
+func (cl *Closure) Type() object.ObjectType {
+	if cl.Fn.Lambda {
+		return object.LAMBDA_OBJ
+	}
+
+	return object.FUNCTION_OBJ
+}
+
+func (cl *Closure) Inspect() string { return cl.Fn.Inspect() }
+func (cl *Closure) Equals(other object.Object) bool {
+	switch other := other.(type) {
+	case *Closure:
+		return cl.Inspect() == other.Inspect()
+	default:
+		return false
+	}
+}
+
+// Call runs the closure on the virtual machine it was made in, which lets
+// the evaluator's builtins and models call it.
+func (cl *Closure) Call(name string, this object.Object, args []object.Object) object.Object {
+	return cl.vm.call(cl, name, this, args)
+}
+
+// Frame is a call to a closure which is being run.
+type Frame struct {
+	cl *Closure
+	ip int
+
+	// bp is where the stack pointer is reset to when the call returns
+	bp int
+
+	// locals are stored in cells, which closures made in this frame can
+	// share with it
+	locals []*object.Object
+	this   object.Object
+	loops  []*loop
+
+	// traced is whether the call was added to the runtime's call stack
+	traced bool
+}
+
+func newFrame(cl *Closure, this object.Object, args []object.Object, bp int) *Frame {
+	n := cl.Fn.NumLocals
+	if len(args) > n {
+		n = len(args)
+	}
+
+	locals := make([]*object.Object, n)
+	for i, arg := range args {
+		val := arg
+		locals[i] = &val
+	}
+
+	return &Frame{cl: cl, bp: bp, locals: locals, this: this}
+}
+
+// loop is a for or while loop being run in a frame.
+type loop struct {
+	// sp is the stack pointer when the loop started, which a break or next
+	// resets the stack to, and start and end are the addresses they jump to
+	sp    int
+	start int
+	end   int
+
+	// for loops step through an array, string or hash, using index as the
+	// index of the next element, or of the next pair in pairs or character
+	// in chars
+	length int
+	index  int
+	pairs  []object.HashPair
+	chars  []rune
+	set    object.Object
+
+	// result collects the value of each iteration
+	result   object.Object
+	skipNull bool
+}
+
+// handler is a try expression's handler, which errors thrown inside it
+// jump to.
+type handler struct {
+	frame int
+	addr  int
+	sp    int
+	loops int
+}
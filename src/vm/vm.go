@@ -0,0 +1,848 @@
+package vm
+
+import (
+	"../ast"
+	"../code"
+	"../compiler"
+	"../evaluator"
+	"../object"
+	"fmt"
+	"strings"
+)
+
+// InitialStackSize is the number of slots the stack starts with. It grows
+// as needed.
+const InitialStackSize = 2048
+
+var (
+	NULL  = evaluator.NULL
+	TRUE  = evaluator.TRUE
+	FALSE = evaluator.FALSE
+)
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+	builtins    []object.Object
+	main        *compiler.CompiledFunction
+
+	stack []object.Object
+	sp    int
+
+	frames   []*Frame
+	handlers []handler
+
+	// env is passed to the evaluator's operations, which use it to find the
+	// runtime
+	env *object.Environment
+	rt  *object.Runtime
+}
+
+// New creates a virtual machine to run the given bytecode. The runtime of
+// env is shared with the program, and env is used when calling functions
+// which aren't compiled, like builtins.
+func New(bytecode *compiler.Bytecode, env *object.Environment) *VM {
+	builtins := []object.Object{}
+	for _, name := range evaluator.BuiltinNames() {
+		builtin, _ := evaluator.LookupBuiltin(name)
+		builtins = append(builtins, builtin)
+	}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, len(bytecode.GlobalNames)),
+		globalNames: bytecode.GlobalNames,
+		builtins:    builtins,
+		main:        bytecode.Main,
+		stack:       make([]object.Object, InitialStackSize),
+		frames:      []*Frame{},
+		handlers:    []handler{},
+		env:         env,
+		rt:          env.Runtime(),
+	}
+}
+
+// Run runs the program, returning the value of its last statement, or the
+// error which stopped it.
+func (vm *VM) Run() object.Object {
+	main := &Closure{Fn: vm.main, Name: vm.main.Name, vm: vm}
+	vm.frames = append(vm.frames, newFrame(main, nil, nil, vm.sp))
+
+	return vm.run(0)
+}
+
+// Globals returns the value of each global variable which has been set,
+// by name.
+func (vm *VM) Globals() map[string]object.Object {
+	globals := make(map[string]object.Object)
+
+	for i, name := range vm.globalNames {
+		if vm.globals[i] != nil {
+			globals[name] = vm.globals[i]
+		}
+	}
+
+	return globals
+}
+
+// call runs a closure from outside the virtual machine's own calls, e.g.
+// when it's called by a builtin.
+func (vm *VM) call(cl *Closure, name string, this object.Object, args []object.Object) object.Object {
+	base := len(vm.frames)
+
+	if err := vm.pushFrame(cl, name, this, args, vm.sp); err != nil {
+		return err
+	}
+
+	return vm.run(base)
+}
+
+func (vm *VM) pushFrame(
+	cl *Closure,
+	name string,
+	this object.Object,
+	args []object.Object,
+	bp int,
+) *object.Error {
+	if len(args) != cl.Fn.NumParameters {
+		return newKindError(object.ARGUMENT_ERROR, "invalid number of arguments. expected %v, got %v",
+			cl.Fn.NumParameters, len(args))
+	}
+
+	if name == "" {
+		name = cl.Name
+	}
+
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	frame := newFrame(cl, this, args, bp)
+	frame.traced = true
+	vm.rt.Push(name)
+
+	if err := vm.rt.CheckDepth(); err != nil {
+		vm.rt.Pop()
+		return err
+	}
+
+	vm.frames = append(vm.frames, frame)
+
+	return nil
+}
+
+func (vm *VM) popFrame() {
+	frame := vm.currentFrame()
+
+	if frame.traced {
+		vm.rt.Pop()
+	}
+
+	vm.sp = frame.bp
+	vm.frames = vm.frames[:len(vm.frames)-1]
+
+	// a frame's handlers are normally removed before it returns, but not
+	// when it's unwound by an error
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frame >= len(vm.frames) {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[len(vm.frames)-1]
+}
+
+// run executes instructions until the frame at index base returns,
+// returning its result, or until an error is thrown which isn't handled
+// in any frame from base upwards.
+func (vm *VM) run(base int) object.Object {
+	for {
+		frame := vm.currentFrame()
+
+		if err := vm.rt.Step(); err != nil {
+			err.Pos = frame.cl.Fn.PositionOf(frame.ip)
+			err.Trace = vm.rt.Trace()
+
+			if !vm.handle(err, base) {
+				return err
+			}
+
+			continue
+		}
+
+		ins := frame.cl.Fn.Instructions
+
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+		frame.ip++
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			index := vm.readUint16(frame)
+			vm.push(vm.constants[index])
+
+		case code.OpNull:
+			vm.push(NULL)
+
+		case code.OpTrue:
+			vm.push(TRUE)
+
+		case code.OpFalse:
+			vm.push(FALSE)
+
+		case code.OpArray:
+			n := vm.readUint16(frame)
+
+			elements := make([]object.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+
+			vm.push(&object.Array{Elements: elements})
+
+		case code.OpInterpolate:
+			n := vm.readUint16(frame)
+
+			parts := make([]object.Object, n)
+			copy(parts, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+
+			err = vm.pushResult(evaluator.Interpolate(parts, vm.env))
+
+		case code.OpHash:
+			n := vm.readUint16(frame)
+
+			keys := make([]object.Object, n)
+			values := make([]object.Object, n)
+
+			for i := 0; i < n; i++ {
+				values[i] = vm.stack[vm.sp-2*n+2*i]
+				keys[i] = vm.stack[vm.sp-2*n+2*i+1]
+			}
+			vm.sp -= 2 * n
+
+			err = vm.pushResult(evaluator.HashFromPairs(keys, values))
+
+		case code.OpClosure:
+			index := vm.readUint16(frame)
+			vm.push(vm.makeClosure(frame, vm.constants[index].(*compiler.CompiledFunction)))
+
+		case code.OpModel:
+			index := vm.readUint16(frame)
+			err = vm.makeModel(vm.constants[index].(*compiler.ModelTemplate))
+
+		case code.OpThis:
+			if frame.this == nil {
+				vm.push(NULL)
+			} else {
+				vm.push(frame.this)
+			}
+
+		case code.OpPop:
+			vm.sp--
+
+		case code.OpDup:
+			vm.push(vm.stack[vm.sp-1])
+
+		case code.OpInfix:
+			operator := code.Operators[vm.readUint8(frame)]
+
+			right := vm.pop()
+			left := vm.pop()
+
+			vm.rt.Pos = frame.cl.Fn.PositionOf(ip)
+			err = vm.pushResult(evaluator.InfixOperation(operator, left, right, vm.env))
+
+		case code.OpPrefix:
+			operator := code.Operators[vm.readUint8(frame)]
+			err = vm.pushResult(evaluator.PrefixOperation(operator, vm.pop(), vm.env))
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.IndexOperation(left, index))
+
+		case code.OpSetIndex:
+			index := vm.pop()
+			obj := vm.pop()
+			val := vm.pop()
+			err = vm.pushResult(evaluator.AssignIndex(obj, index, val))
+
+		case code.OpUpdateIndex:
+			operator := code.Operators[vm.readUint8(frame)]
+			val := vm.pop()
+			index := vm.pop()
+			obj := vm.pop()
+			err = vm.pushResult(evaluator.UpdateIndex(obj, index, operator, val, vm.env))
+
+		case code.OpSlice:
+			step, end, start := vm.pop(), vm.pop(), vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.SliceOperation(left, start, end, step, vm.env))
+
+		case code.OpSetSlice:
+			step, end, start := vm.pop(), vm.pop(), vm.pop()
+			obj := vm.pop()
+			val := vm.pop()
+			err = vm.pushResult(evaluator.AssignSlice(obj, start, end, step, val, vm.env))
+
+		case code.OpUpdateSlice:
+			operator := code.Operators[vm.readUint8(frame)]
+			val := vm.pop()
+			step, end, start := vm.pop(), vm.pop(), vm.pop()
+			obj := vm.pop()
+			err = vm.pushResult(evaluator.UpdateSlice(obj, start, end, step, operator, val, vm.env))
+
+		case code.OpUpdateField:
+			name := vm.constants[vm.readUint16(frame)].(*object.String).Value
+			operator := code.Operators[vm.readUint8(frame)]
+			val := vm.pop()
+			obj := vm.pop()
+			err = vm.pushResult(evaluator.UpdateField(obj, name, operator, val, vm.env))
+
+		case code.OpUnpackArray:
+			count := vm.readUint16(frame)
+			required := vm.readUint16(frame)
+			rest := vm.readUint8(frame) == 1
+
+			elements, unpackErr := evaluator.UnpackArray(vm.pop(), count, required, rest)
+			if unpackErr != nil {
+				err = unpackErr
+			} else {
+				vm.pushReversed(elements)
+			}
+
+		case code.OpUnpackHash:
+			keys := vm.constants[vm.readUint16(frame)].(*object.Array)
+			rest := vm.readUint8(frame) == 1
+
+			names := make([]string, len(keys.Elements))
+			for i, key := range keys.Elements {
+				names[i] = key.(*object.String).Value
+			}
+
+			values, unpackErr := evaluator.UnpackHash(vm.pop(), names, rest)
+			if unpackErr != nil {
+				err = unpackErr
+			} else {
+				vm.pushReversed(values)
+			}
+
+		case code.OpMatchArray:
+			count := vm.readUint16(frame)
+			rest := vm.readUint8(frame) == 1
+
+			elements, ok := evaluator.MatchArray(vm.pop(), count, rest)
+			vm.pushMatch(elements, ok)
+
+		case code.OpMatchHash:
+			keys := vm.constants[vm.readUint16(frame)].(*object.Array)
+			rest := vm.readUint8(frame) == 1
+
+			names := make([]string, len(keys.Elements))
+			for i, key := range keys.Elements {
+				names[i] = key.(*object.String).Value
+			}
+
+			values, ok := evaluator.MatchHash(vm.pop(), names, rest)
+			vm.pushMatch(values, ok)
+
+		case code.OpMatchModel:
+			count := vm.readUint16(frame)
+			model := vm.pop()
+
+			values, ok, matchErr := evaluator.MatchModel(vm.pop(), model, count)
+			if matchErr != nil {
+				err = matchErr
+			} else {
+				vm.pushMatch(values, ok)
+			}
+
+		case code.OpMatchRange:
+			inclusive := vm.readUint8(frame) == 1
+			high := vm.pop()
+			low := vm.pop()
+
+			ok, matchErr := evaluator.InRange(vm.pop(), low, high, inclusive)
+			if matchErr != nil {
+				err = matchErr
+			} else {
+				vm.pushMatch(nil, ok)
+			}
+
+		case code.OpMatchEqual:
+			literal := vm.pop()
+			vm.pushMatch(nil, literal.Equals(vm.pop()))
+
+		case code.OpNoMatch:
+			err = evaluator.NoMatch(vm.pop())
+
+		case code.OpGetField:
+			name := vm.constants[vm.readUint16(frame)].(*object.String).Value
+			err = vm.pushResult(evaluator.AccessField(vm.pop(), name))
+
+		case code.OpSetField:
+			name := vm.constants[vm.readUint16(frame)].(*object.String).Value
+			obj := vm.pop()
+			val := vm.pop()
+			err = vm.pushResult(evaluator.AssignField(obj, name, val))
+
+		case code.OpGetGlobal:
+			index := vm.readUint16(frame)
+
+			if val := vm.globals[index]; val != nil {
+				vm.push(val)
+			} else {
+				err = notFound(vm.globalNames[index])
+			}
+
+		case code.OpSetGlobal:
+			vm.globals[vm.readUint16(frame)] = vm.stack[vm.sp-1]
+
+		case code.OpGetLocal:
+			index := vm.readUint16(frame)
+
+			if cell := frame.locals[index]; cell != nil && *cell != nil {
+				vm.push(*cell)
+			} else {
+				err = notFound(frame.cl.Fn.LocalNames[index])
+			}
+
+		case code.OpSetLocal:
+			index := vm.readUint16(frame)
+
+			if frame.locals[index] == nil {
+				frame.locals[index] = new(object.Object)
+			}
+
+			*frame.locals[index] = vm.stack[vm.sp-1]
+
+		case code.OpGetFree:
+			index := vm.readUint16(frame)
+
+			if val := *frame.cl.Free[index]; val != nil {
+				vm.push(val)
+			} else {
+				err = notFound(frame.cl.Fn.Free[index].Name)
+			}
+
+		case code.OpSetFree:
+			*frame.cl.Free[vm.readUint16(frame)] = vm.stack[vm.sp-1]
+
+		case code.OpGetBuiltin:
+			vm.push(vm.builtins[vm.readUint16(frame)])
+
+		case code.OpClearLocals:
+			start := vm.readUint16(frame)
+			n := vm.readUint16(frame)
+
+			for i := start; i < start+n; i++ {
+				frame.locals[i] = nil
+			}
+
+		case code.OpJump:
+			frame.ip = vm.readUint16(frame)
+
+		case code.OpJumpNotNull:
+			addr := vm.readUint16(frame)
+
+			if vm.stack[vm.sp-1].Type() != object.NULL_OBJ {
+				frame.ip = addr
+			} else {
+				vm.pop()
+			}
+
+		case code.OpJumpNotTruthy:
+			addr := vm.readUint16(frame)
+
+			if !evaluator.IsTruthy(vm.pop()) {
+				frame.ip = addr
+			}
+
+		case code.OpCall:
+			n := vm.readUint8(frame)
+
+			vm.rt.Pos = frame.cl.Fn.PositionOf(ip)
+			err = vm.callValue(n)
+
+		case code.OpReturn:
+			result := vm.pop()
+			vm.popFrame()
+
+			if len(vm.frames) == base {
+				return result
+			}
+
+			vm.push(result)
+
+		case code.OpForInit:
+			end := vm.readUint16(frame)
+			err = vm.startForLoop(frame, vm.pop(), end)
+
+		case code.OpForNext:
+			end := vm.readUint16(frame)
+			l := frame.loops[len(frame.loops)-1]
+
+			if l.index >= l.length {
+				frame.ip = end
+				break
+			}
+
+			if l.pairs != nil {
+				vm.push(l.pairs[l.index].Key)
+			} else {
+				vm.push(&object.Integer{Value: int64(l.index)})
+			}
+
+			l.index++
+
+		case code.OpForElement:
+			vm.push(loopElement(frame.loops[len(frame.loops)-1]))
+
+		case code.OpWhileInit:
+			end := vm.readUint16(frame)
+
+			frame.loops = append(frame.loops, &loop{
+				sp:     vm.sp,
+				start:  frame.ip,
+				end:    end,
+				result: &object.Array{Elements: []object.Object{}},
+			})
+
+		case code.OpLoopAppend:
+			err = vm.appendLoopResult(frame.loops[len(frame.loops)-1], vm.pop())
+
+		case code.OpLoopEnd:
+			l := frame.loops[len(frame.loops)-1]
+			frame.loops = frame.loops[:len(frame.loops)-1]
+
+			vm.push(l.result)
+
+		case code.OpBreak:
+			l := frame.loops[len(frame.loops)-1]
+			vm.sp = l.sp
+			frame.ip = l.end
+
+		case code.OpNext:
+			l := frame.loops[len(frame.loops)-1]
+			vm.sp = l.sp
+			frame.ip = l.start
+
+		case code.OpSetupTry:
+			vm.handlers = append(vm.handlers, handler{
+				frame: len(vm.frames) - 1,
+				addr:  vm.readUint16(frame),
+				sp:    vm.sp,
+				loops: len(frame.loops),
+			})
+
+		case code.OpPopTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpErrorHash:
+			vm.stack[vm.sp-1] = vm.stack[vm.sp-1].(*object.Error).ToHash()
+
+		case code.OpThrow:
+			err = vm.pop().(*object.Error)
+
+		case code.OpImport:
+			names := vm.constants[vm.readUint16(frame)].(*object.Array)
+
+			vm.rt.Pos = frame.cl.Fn.PositionOf(ip)
+			err = vm.importModule(vm.pop(), names)
+
+		default:
+			err = newError("unknown opcode %d", op)
+		}
+
+		if err != nil {
+			// errors are given the position of the instruction they came
+			// from, along with the call stack at that point
+			if !err.Pos.IsValid() {
+				err.Pos = frame.cl.Fn.PositionOf(ip)
+				err.Trace = vm.rt.Trace()
+			}
+
+			if !vm.handle(err, base) {
+				return err
+			}
+		}
+	}
+}
+
+// handle jumps to the innermost handler for an error in a frame from base
+// upwards. If there isn't one, or a limit has stopped the program for good,
+// the frames are unwound down to base.
+func (vm *VM) handle(err *object.Error, base int) bool {
+	if n := len(vm.handlers); n > 0 && vm.handlers[n-1].frame >= base && vm.rt.Catch(err) {
+		h := vm.handlers[n-1]
+		vm.handlers = vm.handlers[:n-1]
+
+		for len(vm.frames)-1 > h.frame {
+			vm.popFrame()
+		}
+
+		frame := vm.currentFrame()
+		frame.loops = frame.loops[:h.loops]
+		frame.ip = h.addr
+
+		vm.sp = h.sp
+		vm.push(err)
+
+		return true
+	}
+
+	for len(vm.frames) > base {
+		vm.popFrame()
+	}
+
+	return false
+}
+
+func (vm *VM) callValue(n int) *object.Error {
+	bp := vm.sp - 1 - n
+	fn := vm.stack[bp]
+
+	switch fn := fn.(type) {
+	case *Closure:
+		if fn.vm == vm {
+			return vm.pushFrame(fn, "", nil, vm.stack[bp+1:vm.sp], bp)
+		}
+	case *object.MethodInstance:
+		if cl, ok := (*fn.Function).(*Closure); ok && cl.vm == vm {
+			return vm.pushFrame(cl, fn.FullName(), fn.This, vm.stack[bp+1:vm.sp], bp)
+		}
+	}
+
+	args := make([]object.Object, n)
+	copy(args, vm.stack[bp+1:vm.sp])
+	vm.sp = bp
+
+	return vm.pushResult(evaluator.ApplyFunction(fn, nil, args, vm.env))
+}
+
+func (vm *VM) makeClosure(frame *Frame, fn *compiler.CompiledFunction) *Closure {
+	free := make([]*object.Object, len(fn.Free))
+
+	for i, symbol := range fn.Free {
+		switch symbol.Scope {
+		case compiler.LocalScope:
+			// the variable might not have been set yet, e.g. if the
+			// closure refers to itself
+			if frame.locals[symbol.Index] == nil {
+				frame.locals[symbol.Index] = new(object.Object)
+			}
+
+			free[i] = frame.locals[symbol.Index]
+		case compiler.FreeScope:
+			free[i] = frame.cl.Free[symbol.Index]
+		}
+	}
+
+	return &Closure{Fn: fn, Free: free, Name: fn.Name, vm: vm}
+}
+
+func (vm *VM) makeModel(template *compiler.ModelTemplate) *object.Error {
+	model := object.NewModel()
+	model.Name = template.Name
+	model.Properties = template.Properties
+
+	if template.HasParent {
+		init := vm.pop()
+
+		parent, ok := vm.pop().(*object.Model)
+		if !ok {
+			return newKindError(object.TYPE_ERROR, "expected a model as the parent")
+		}
+
+		model.Parent = parent
+		model.ParentInit = init
+	}
+
+	vm.push(model)
+
+	return nil
+}
+
+func (vm *VM) startForLoop(frame *Frame, set object.Object, end int) *object.Error {
+	l := &loop{sp: vm.sp, start: frame.ip, end: end, skipNull: true, set: set}
+
+	switch set := set.(type) {
+	case *object.Array:
+		l.length = len(set.Elements)
+		l.result = &object.Array{Elements: []object.Object{}}
+	case *object.String:
+		l.chars = []rune(set.Value)
+		l.length = len(l.chars)
+		l.result = &object.String{Value: ""}
+	case *object.Hash:
+		l.pairs = set.Pairs()
+		l.length = len(l.pairs)
+		l.result = object.NewHash(object.OBJECT_MODEL)
+	default:
+		return newError("invalid set %v. expected an array or a hash", set.Inspect())
+	}
+
+	frame.loops = append(frame.loops, l)
+
+	return nil
+}
+
+// loopElement returns the element a for loop is up to, which is destructured
+// when the loop variable is a pattern.
+func loopElement(l *loop) object.Object {
+	i := l.index - 1
+
+	switch set := l.set.(type) {
+	case *object.Array:
+		return set.Elements[i]
+	case *object.String:
+		return &object.Char{Value: l.chars[i]}
+	case *object.Hash:
+		return l.pairs[i].Value
+	default:
+		return NULL
+	}
+}
+
+// pushReversed pushes values so that the first ends up on top of the stack,
+// to be destructured first.
+func (vm *VM) pushReversed(values []object.Object) {
+	for i := len(values) - 1; i >= 0; i-- {
+		vm.push(values[i])
+	}
+}
+
+// pushMatch pushes the result of one of the match opcodes: the parts of the
+// value, if it matched, and then whether it did.
+func (vm *VM) pushMatch(parts []object.Object, ok bool) {
+	if !ok {
+		vm.push(FALSE)
+		return
+	}
+
+	vm.pushReversed(parts)
+	vm.push(TRUE)
+}
+
+func (vm *VM) appendLoopResult(l *loop, val object.Object) *object.Error {
+	if l.skipNull && val == NULL {
+		return nil
+	}
+
+	switch result := l.result.(type) {
+	case *object.Array:
+		if err := vm.rt.CheckAllocation(len(result.Elements) + 1); err != nil {
+			return err
+		}
+
+		result.Elements = append(result.Elements, val)
+	case *object.String:
+		value := result.Value + val.Inspect()
+		if err := vm.rt.CheckAllocation(len(value)); err != nil {
+			return err
+		}
+
+		result.Value = value
+	case *object.Hash:
+		result.Insert(l.pairs[l.index-1].Key, val)
+	}
+
+	return nil
+}
+
+func (vm *VM) importModule(path object.Object, names *object.Array) *object.Error {
+	str, ok := path.(*object.String)
+	if !ok {
+		return newKindError(object.TYPE_ERROR, "expected a string path to import, got %v",
+			path.Inspect())
+	}
+
+	selected := []string{}
+	for _, name := range names.Elements {
+		selected = append(selected, name.(*object.String).Value)
+	}
+
+	module, err := evaluator.ImportModule(str.Value, selected, vm.rt.Pos.File, vm.rt, RunModule)
+	if err != nil {
+		return err
+	}
+
+	vm.push(module)
+
+	return nil
+}
+
+// RunModule compiles and runs an imported file, returning its globals. It
+// can be given to evaluator.ImportModule.
+func RunModule(program *ast.Program, rt *object.Runtime) (map[string]object.Object, *object.Error) {
+	c := compiler.New()
+	bytecode := c.Compile(program)
+
+	if len(c.Errors()) != 0 {
+		msgs := []string{}
+		for _, err := range c.Errors() {
+			msgs = append(msgs, err.Error())
+		}
+
+		return nil, newKindError(object.IMPORT_ERROR, "could not compile:\n  %s",
+			strings.Join(msgs, "\n  "))
+	}
+
+	machine := New(bytecode, object.NewModuleEnvironment(rt))
+	if err, ok := machine.Run().(*object.Error); ok {
+		return nil, err
+	}
+
+	return machine.Globals(), nil
+}
+
+func (vm *VM) pushResult(obj object.Object) *object.Error {
+	if err, ok := obj.(*object.Error); ok {
+		return err
+	}
+
+	vm.push(obj)
+
+	return nil
+}
+
+func (vm *VM) push(obj object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+
+	vm.stack[vm.sp] = obj
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) readUint16(frame *Frame) int {
+	val := int(code.ReadUint16(frame.cl.Fn.Instructions[frame.ip:]))
+	frame.ip += 2
+	return val
+}
+
+func (vm *VM) readUint8(frame *Frame) int {
+	val := int(code.ReadUint8(frame.cl.Fn.Instructions[frame.ip:]))
+	frame.ip++
+	return val
+}
+
+func notFound(name string) *object.Error {
+	return newKindError(object.NAME_ERROR, "identifier not found: %s", name)
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return newKindError(object.RUNTIME_ERROR, format, a...)
+}
+
+func newKindError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: kind}
+}
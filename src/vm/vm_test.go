@@ -0,0 +1,624 @@
+package vm
+
+import (
+	"../compiler"
+	"../evaluator"
+	"../lexer"
+	"../object"
+	"../parser"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type vmTest struct {
+	input    string
+	expected string
+}
+
+// runBoth runs a program with both the evaluator and the virtual machine,
+// so the tests check that they behave the same way.
+func runBoth(t *testing.T, input string) (object.Object, object.Object) {
+	return runBothWithLimits(t, input, object.DefaultLimits)
+}
+
+func runBothWithLimits(t *testing.T, input string, limits object.Limits) (object.Object, object.Object) {
+	l := lexer.NewFile("test.lang", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	env := object.NewEnvironment()
+	env.Runtime().Limits = limits
+	evaluated := evaluator.Eval(program, env)
+
+	c := compiler.New()
+	bytecode := c.Compile(program)
+	if len(c.Errors()) != 0 {
+		t.Fatalf("compiler errors for %q: %v", input, c.Errors())
+	}
+
+	env = object.NewEnvironment()
+	env.Runtime().Limits = limits
+	run := New(bytecode, env).Run()
+
+	return evaluated, run
+}
+
+func runTests(t *testing.T, tests []vmTest) {
+	for _, tt := range tests {
+		evaluated, run := runBoth(t, tt.input)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("evaluator: expected %q to give %s, got %s", tt.input, tt.expected, evaluated.Inspect())
+		}
+
+		if run.Inspect() != tt.expected {
+			t.Errorf("vm: expected %q to give %s, got %s", tt.input, tt.expected, run.Inspect())
+		}
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	runTests(t, []vmTest{
+		{"1 + 2 * 3;", "7"},
+		{"-(5 - 10) ** 2;", "25"},
+		{"!true == false;", "true"},
+		{`"a" + "b";`, "ab"},
+		{"3 in [1, 2, 3];", "true"},
+		{"1..4;", "[1, 2, 3, 4]"},
+		{"0..<3;", "[0, 1, 2]"},
+		{"[1, 2, 3][-1];", "3"},
+		{`{a: 1, "b": 2}.b;`, "2"},
+		{"if (1 > 2) { 1; } else { 2; };", "2"},
+		{"if (false) { 1; };", "<null>"},
+		{"x := 5; x = x + 1; x;", "6"},
+		{"a := [1, 2]; a[0] = 5; a;", "[5, 2]"},
+		{"h := {}; h.x = 3; h.x;", "3"},
+		{"x := 1\nx += 2 *\n  3\nx", "7"},
+		{"f := fn (n) {\n  if (n > 1) {\n    return n\n  }\n  -n\n}\n[f(1), f(2)]", "[-1, 2]"},
+	})
+}
+
+func TestNumbers(t *testing.T) {
+	runTests(t, []vmTest{
+		{"7 / 2;", "3"},
+		{"-7 / 2;", "-3"},
+		{"-7 % 3;", "-1"},
+		{"7.0 / 2;", "3.5"},
+		{"1.5 + 1;", "2.5"},
+		{"2.;", "2.0"},
+		{"2 ** -1;", "0.5"},
+		{"2 ** 62;", "4611686018427387904"},
+		{"9223372036854775807;", "9223372036854775807"},
+		{"0xff + 0o17 + 0b11 + 1_000;", "1273"},
+		{"0x7fff_ffff_ffff_ffff;", "9223372036854775807"},
+		{"1.5e3 + 2E-1;", "1500.2"},
+		{"1e2 == 100;", "true"},
+		{"1 == 1.0;", "true"},
+		{"1 < 1.5;", "true"},
+		{"1 << 62;", "4611686018427387904"},
+		{"~5;", "-6"},
+		{"~-1;", "0"},
+		{"6 ^ 3;", "5"},
+		{"~0 ^ 0xff;", "-256"},
+		{"1 ^ 2 & 3;", "3"},
+		{"~1.5;", "ERROR: expected an integer for '~'. got 1.5"},
+		{"1.5 ^ 1;", "ERROR: expected integers for '^'. got 1.5 and 1"},
+		{"3 in 9;", "true"},
+		{"0 in 9;", "false"},
+		{"[1, 2][1.0 - 1.0];", "ERROR: expected an integer for an index. got 0.0"},
+		{"9223372036854775807 + 1;", "ERROR: integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2;", "ERROR: integer overflow: -9223372036854775807 - 2"},
+		{"4294967296 * 4294967296;", "ERROR: integer overflow: 4294967296 * 4294967296"},
+		{"3 ** 50;", "ERROR: integer overflow: 3 ** 50"},
+		{"1 << 64;", "ERROR: integer overflow: 1 << 64"},
+		{"1 / 0;", "ERROR: division by zero: 1 / 0"},
+		{"1.0 / 0;", "+Inf"},
+		{"try { 5 % 0; } catch (e) { e.kind; };", "arithmetic"},
+		{"1.5 | 1;", "ERROR: expected integers for '|'. got 1.5 and 1"},
+		{"1..2.5;", "ERROR: expected integers for '..'. got 1 and 2.5"},
+	})
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	runTests(t, []vmTest{
+		{"x := 1; x += 2; x;", "3"},
+		{"x := 10; x -= 1; x *= 2; x /= 4; x;", "4"},
+		{"x := 2; x **= 3; x %= 5; x;", "3"},
+		{"x := 6; x ^= 3; x <<= 2; x |= 1; x &= 7; x >>= 1; x;", "2"},
+		{`s := "a"; s += "b"; s;`, "ab"},
+		{"x := 1; x += 1;", "2"},
+		{"a := [1, 2]; a[1] += 5; a;", "[1, 7]"},
+		{"h := {n: 1}; h.n *= 3; h.n;", "3"},
+		{`h := {"n": 1}; h["n"] -= 3; h.n;`, "-2"},
+		{"n := 0; f := fn () { n += 1; 0; }; a := [1]; a[f()] += 1; [a, n];", "[[2], 1]"},
+		{"x := 1; f := fn () { x += 1; }; f(); f(); x;", "3"},
+		{"v := model (x); v._plus = fn (o) { v(this.x + o.x); }; a := v(1); a += v(2); a.x;", "3"},
+		{"x += 1;", "ERROR: identifier not found: x"},
+		{"x := 9223372036854775807; x += 1;", "ERROR: integer overflow: 9223372036854775807 + 1"},
+		{"a := [1]; a[1] += 1;", "ERROR: index 1 out of range for length 1"},
+	})
+}
+
+func TestDestructuring(t *testing.T) {
+	runTests(t, []vmTest{
+		{"[a, b] := [1, 2]; a + b;", "3"},
+		{"[a, b] := [1, 2];", "[1, 2]"},
+		{"[a, ...rest] := [1, 2, 3]; [a, rest];", "[1, [2, 3]]"},
+		{"[a, ...rest] := [1]; rest;", "[]"},
+		{"[a, [b, c]] := [1, [2, 3]]; a + b + c;", "6"},
+		{"[a, b = 5] := [1]; b;", "5"},
+		{"[a, b = 5] := [1, null]; b;", "5"},
+		{"[a = 1, b = a + 1] := []; [a, b];", "[1, 2]"},
+		{"a := 1; b := 2; [a, b] = [b, a]; [a, b];", "[2, 1]"},
+		{"xs := [0, 0]; h := {}; [xs[1], h.x] = [3, 4]; [xs, h.x];", "[[0, 3], 4]"},
+		{"{x, y} := {x: 1, y: 2}; x - y;", "-1"},
+		{"{x: a, y: b} := {x: 1, y: 2}; [a, b];", "[1, 2]"},
+		{`{"some key": k} := {"some key": 7}; k;`, "7"},
+		{"{x, y = 10} := {x: 1}; [x, y];", "[1, 10]"},
+		{"{x} := {}; x;", "<null>"},
+		{"{a, ...others} := {a: 1, b: 2}; [a, others.b, others.a];", "[1, 2, <null>]"},
+		{"{p: [a, b], q: {c}} := {p: [1, 2], q: {c: 3}}; a + b + c;", "6"},
+		{"x := 0; f := fn () { [x] = [5]; }; f(); x;", "5"},
+		{"f := fn ([a, b], {c}) { a + b + c; }; f([1, 2], {c: 3});", "6"},
+		{"f := fn ([a, b = 1]) { a * b; }; f([4]);", "4"},
+		{"f := \\([a, ...rest]) = rest; f([1, 2, 3]);", "[2, 3]"},
+		{"for (i, [a, b] | [[1, 2], [3, 4]]) { a * b; };", "[2, 12]"},
+		{"for (i, {n} | [{n: 1}, {n: 2}]) { n; };", "[1, 2]"},
+		{"[a, b] := [1];", "ERROR: cannot destructure an array of length 1. expected 2 elements"},
+		{"[a, b = 1] := [1, 2, 3];", "ERROR: cannot destructure an array of length 3. expected 1 to 2 elements"},
+		{"[a, ...b] := [];", "ERROR: cannot destructure an array of length 0. expected at least 1 element"},
+		{"[a] := 1;", "ERROR: cannot destructure INTEGER as an array"},
+		{"{a} := [1];", "ERROR: cannot destructure ARRAY as a hash"},
+		{"f := fn ([a]) { a; }; f(5);", "ERROR: cannot destructure INTEGER as an array"},
+	})
+}
+
+func TestStrings(t *testing.T) {
+	runTests(t, []vmTest{
+		{`a := 2; "a + 1 = ${a + 1}";`, "a + 1 = 3"},
+		{`p := {x: [1, "b"]}; "x: ${p.x}, y: ${p.y}";`, "x: [1, b], y: <null>"},
+		{`n := "w"; "${"<${n}>"}!";`, "<w>!"},
+		{`"${ {a: 1}.a }";`, "1"},
+		{`f := fn (x) { "${x}${x}"; }; f(3) + "${f("-")}";`, "33--"},
+		{`"\${a}";`, "${a}"},
+		{"`\\n ${a}`;", "\\n ${a}"},
+		{`"\u{48}\u{e9}";`, "Hé"},
+		{"x := 5; \"\"\"\n    x is\n      ${x}\n    \"\"\";", "x is\n  5"},
+		{"\"\"\"one\n  two\"\"\";", "one\ntwo"},
+		{`"${a}";`, "ERROR: identifier not found: a"},
+	})
+}
+
+func TestChars(t *testing.T) {
+	runTests(t, []vmTest{
+		{`"héllo"[1];`, "é"},
+		{`"héllo"[1] == 'é';`, "true"},
+		{`"héllo"[-1] == "o";`, "true"},
+		{`s := "abc"; [s[0] == "a", "b" == s[1], s[2] != "c", s[0] == "ab", 'a' == "a"];`, "[true, true, false, false, true]"},
+		{`s := "abc"; [s[0] in ["a"], "b" in ['a', 'b'], {a: 1}[s[0]], {'a': 1}["a"]];`, "[true, true, 1, 1]"},
+		{`match ("abc"[0]) { "a" => 1, _ => 2 };`, "1"},
+		{`"héllo"[5];`, "ERROR: index 5 out of range for length 5"},
+		{`[len("héllo"), len([1, 2]), len({a: 1}), len("")];`, "[5, 2, 1, 0]"},
+		{`len(1);`, "ERROR: expected an array, string or hash to be passed to 'len'"},
+		{`[ord('a'), ord('\u{1F600}'), chr(97) == 'a'];`, "[97, 128512, true]"},
+		{`chr(55296);`, "ERROR: invalid character code 55296"},
+		{`ord("a");`, "ERROR: expected a character to be passed to 'ord'"},
+		{`['a' < 'b', 'b' <= 'a', 'z' >= 'z', 'a' != 'b'];`, "[true, false, true, true]"},
+		{`'a' + "bc" + 'd' + 'e';`, "abcde"},
+		{`'a' - 'b';`, "ERROR: unknown operator: CHAR - CHAR"},
+		{`'a' + 1;`, "ERROR: type mismatch: CHAR + INTEGER"},
+		{`['é' in "héllo", 'x' in "héllo", 'a' in ['a']];`, "[true, false, true]"},
+		{`for (i, [c, ...r] | ["ab", "c"]) { r + c; };`, "[ba, c]"},
+		{`for (i | "añb") { "${i}"; };`, "012"},
+		{`s := "añb"; for (i | s) { s[i] + "."; };`, "a.ñ.b."},
+		{`[a, b, ...c] := "héllo"; [a, b, c, c == "llo"];`, "[h, é, llo, true]"},
+		{`[a, b] := "a";  [a, b];`, "ERROR: cannot destructure an array of length 1. expected 2 elements"},
+		{`'\'' + '\n' + '"';`, "'\n\""},
+	})
+}
+
+func TestSlices(t *testing.T) {
+	runTests(t, []vmTest{
+		{`a := [1, 2, 3, 4, 5]; [a[1:3], a[:2], a[3:], a[:]];`, "[[2, 3], [1, 2], [4, 5], [1, 2, 3, 4, 5]]"},
+		{`a := [1, 2, 3, 4, 5]; [a[-2:], a[:-3], a[-10:2], a[3:10], a[3:1]];`, "[[4, 5], [1, 2], [1, 2], [4, 5], []]"},
+		{`a := [1, 2, 3, 4, 5]; [a[::2], a[1::2], a[::-1], a[3:0:-2], a[::10]];`,
+			"[[1, 3, 5], [2, 4], [5, 4, 3, 2, 1], [4, 2], [1]]"},
+		{`s := "héllo"; [s[1:3], s[::-1], s[-3:], s[5:] == ""];`, "[él, olléh, llo, true]"},
+		{`a := [1, 2]; b := a[:]; b[0] = 3; a;`, "[1, 2]"},
+		{`[1, 2][::0];`, "ERROR: the step of a slice cannot be zero"},
+		{`[1, 2][:"a"];`, "ERROR: expected an integer for the bound of a slice. got a"},
+		{`[1, 2][::1.5];`, "ERROR: expected an integer for the step of a slice. got 1.5"},
+		{`1[1:];`, "ERROR: slice operator not supported: INTEGER"},
+		{`a := [1, 2, 3, 4]; a[1:3] = ["x", "y", "z"]; a;`, "[1, x, y, z, 4]"},
+		{`a := [1, 2, 3]; a[1:1] = [4]; a[3:] = []; a;`, "[1, 4, 2]"},
+		{`a := [1, 2, 3]; a[:] = "ab"; a;`, "[a, b]"},
+		{`a := [1, 2, 3, 4, 5]; a[::2] = [0, 0, 0]; a;`, "[0, 2, 0, 4, 0]"},
+		{`a := [1, 2, 3]; a[::-1] = [4, 5, 6]; a;`, "[6, 5, 4]"},
+		{`a := [1, 2, 3]; a[::2] = [4];`, "ERROR: cannot assign 1 elements to a slice of 2 elements with a step of 2"},
+		{`a := [1, 2]; a[:] = 1;`, "ERROR: expected an array to assign to a slice. got INTEGER"},
+		{`s := "abc"; s[1:] = "x";`, "ERROR: cannot assign to a slice of a STRING"},
+		{`a := [[1, 2, 3]]; [[x, y]] := [[4, 5]]; a[0][1:] = [x, y]; a;`, "[[1, 4, 5]]"},
+		{`a := [1, 2]; [a[:1], b] = [[3, 4], 5]; [a, b];`, "[[3, 4, 2], 5]"},
+		{"r := model (n); r._slice = fn (a, b, c) { [a, b, c]; }; r(1)[1:];", "[1, <null>, <null>]"},
+		{"r := model (n); r._slice = fn (a, b, c) { this.n; }; r._set_slice = fn (a, b, c, v) { this.n = v; }; x := r(1); x[:] += 2; x.n;", "3"},
+		{"r := model (_slice); r(1)[1:];", "ERROR: _slice must be a method, not a property"},
+		{"{a: 1}[1:];", "ERROR: slicing not overloaded. to overload, use the special method _slice"},
+		{"h := {a: 1}; h[1:] = [1];", "ERROR: slicing not overloaded. to overload, use the special method _set_slice"},
+	})
+}
+
+func TestStringMethods(t *testing.T) {
+	runTests(t, []vmTest{
+		{`s := "  Héllo  "; [s.trim(), s.trim_left() + "|", s.trim_right(), "xxhixx".trim("x")];`, "[Héllo, Héllo  |,   Héllo, hi]"},
+		{`["Héllo".upper(), "Héllo".lower(), "héllo".len()];`, "[HÉLLO, héllo, 5]"},
+		{`["a,b,,c".split(","), " a  b ".split(), "a\nb\r\nc\n".lines(), "".lines()];`, "[[a, b, , c], [a, b], [a, b, c], []]"},
+		{`"héllo".chars();`, "[h, é, l, l, o]"},
+		{`["aaa".replace("a", "bb"), "héllo".find("l"), "abc".find("z"), "abab".count("ab")];`, "[bbbbbb, 2, -1, 2]"},
+		{`["abc".starts_with("ab"), "abc".ends_with("b")];`, "[true, false]"},
+		{`["ab".repeat(3), "7".pad_left(3, '0'), "é".pad_right(3) + "|", "long".pad_left(2)];`, "[ababab, 007, é  |, long]"},
+		{`"{} + {} = {2}, {{}}".format(1, 2, "three");`, "1 + 2 = three, {}"},
+		{`f := "abc".upper; f();`, "ABC"},
+		{`["a".type() == string, type("a") == string, "a".parent() == object, string(1.5) + "!"];`, "[true, true, true, 1.5!]"},
+		{`"a".nope();`, "ERROR: string has no method nope"},
+		{`"a".upper(1);`, "ERROR: invalid number of arguments to string.upper. expected 0, got 1"},
+		{`"a".pad_left();`, "ERROR: invalid number of arguments to string.pad_left. expected 1 or 2, got 0"},
+		{`"a".split(1);`, "ERROR: expected argument 1 of string.split to be a string. got INTEGER"},
+		{`"a".split("");`, "ERROR: cannot split a string by an empty separator"},
+		{`["5".pad_left(3, "0"), "é".pad_right(2, "ñ")];`, "[005, éñ]"},
+		{`"a".pad_left(3, "xy");`, "ERROR: expected argument 2 of string.pad_left to be one character long. got 2"},
+		{`"a".pad_left(3, 1);`, "ERROR: expected argument 2 of string.pad_left to be a character. got INTEGER"},
+		{`"a".repeat(-1);`, "ERROR: cannot repeat a string -1 times"},
+		{`"{} {}".format(1);`, "ERROR: not enough arguments for a format string. expected at least 2, got 1"},
+		{`"{x}".format(1);`, "ERROR: invalid placeholder {x} in a format string"},
+		{`"{".format();`, "ERROR: unterminated placeholder in a format string"},
+		{`"}".format();`, "ERROR: unmatched } in a format string"},
+		{`[string.upper("a"), array.map([1, 2], \(x) = x * 2), object.type("a") == string, vec.len(vec(3, 4))];`, "[A, [2, 4], true, 5.0]"},
+		{`string.upper(1);`, "ERROR: string.upper must be called on a string"},
+		{`vec.len(1);`, "ERROR: vec.len must be called on a vec"},
+		{`object.type();`, "ERROR: object.type must be called on an object"},
+		{`string.upper = fn () { "pwned"; };`, "ERROR: cannot change the builtin model string"},
+		{`try { object.nope = fn () {}; } catch (e) { e.kind; };`, "type"},
+		{`1.upper();`, "ERROR: cannot access 1, expected a hash, a model, a string or an array"},
+	})
+}
+
+func TestArrayMethods(t *testing.T) {
+	runTests(t, []vmTest{
+		{`a := [1, 2]; a.push(3, 4); [a.len(), a];`, "[4, [1, 2, 3, 4]]"},
+		{`a := [1, 2, 3]; [a.pop(), a.pop(0), a];`, "[3, 1, [2]]"},
+		{`a := [1, 2]; a.insert(0, 3); a.insert(-1, 4); a.insert(4, 5); a;`, "[3, 1, 4, 2, 5]"},
+		{`a := [1, 2, 1]; a.remove(1); [a, a.index_of(1), a.index_of(3)];`, "[[2, 1], 1, -1]"},
+		{`a := [3, 1, 2]; [a.sort(), a.reverse(), a];`, "[[1, 2, 3], [2, 1, 3], [3, 1, 2]]"},
+		{`["bb", "a", "cc"].sort(\(x, y) = x.len() < y.len());`, "[a, bb, cc]"},
+		{`[["b", "a", "ab", "B"].sort(), ['c', 'a', 'b'].sort(), {y: 1, x: 2}.keys().sort()];`, "[[B, a, ab, b], [a, b, c], [x, y]]"},
+		{`f := fn (x) { x * 2; }; [[1, 2].map(f), [1, 2, 3, 4].filter(\(x) = x % 2 == 0)];`, "[[2, 4], [2, 4]]"},
+		{`[[1, 2, 3].reduce(\(a, b) = a * b), [1, 2].reduce(\(a, b) = a + b, 10)];`, "[6, 13]"},
+		{`[[1, 2].any(\(x) = x > 1), [1, 2].all(\(x) = x > 1), [0, null].any(), [].all()];`, "[true, false, true, true]"},
+		{`[1, 2, 3].zip("ab", [true, false, null]);`, "[[1, a, true], [2, b, false]]"},
+		{`[["a", "b"].enumerate(), [[1, 2], 3, [[4]]].flatten(), [1, 2, 1, 3, 2].unique()];`, "[[[0, a], [1, b]], [1, 2, 3, [4]], [1, 2, 3]]"},
+		{`[[1, "a", 'c'].join(", "), [1, 2].join(), [1, 2.5].sum(), [].sum()];`, "[1, a, c, 12, 3.5, 0]"},
+		{`[[1].type() == array, array("ab"), array([1])];`, "[true, [a, b], [1]]"},
+		{`a := [1]; b := array(a); b.push(2); a;`, "[1]"},
+		{`[].pop();`, "ERROR: cannot pop from an empty array"},
+		{`[1].pop(1);`, "ERROR: index 1 out of range for length 1"},
+		{`[1].insert(2, 0);`, "ERROR: index 2 out of range for length 1"},
+		{`[1].remove(2);`, "ERROR: 2 is not in the array"},
+		{`[].reduce(\(a, b) = a);`, "ERROR: cannot reduce an empty array without an initial value"},
+		{`[1, "a"].sort();`, "ERROR: type mismatch: STRING < INTEGER"},
+		{`[3, 1, 2].sort(fn (x, y) { y - x; });`, "ERROR: expected the function given to array.sort to return a boolean. got INTEGER"},
+		{`[1, 2].map(\(x) = x / 0);`, "ERROR: division by zero: 1 / 0"},
+		{`[1].map(1);`, "ERROR: cannot call a INTEGER"},
+		{`[1].zip(1);`, "ERROR: expected argument 1 of array.zip to be an array. got INTEGER"},
+		{`[1].push();`, "ERROR: invalid number of arguments to array.push. expected at least 1, got 0"},
+		{`[1].nope;`, "ERROR: array has no method nope"},
+		{`array(1);`, "ERROR: cannot make an array of a INTEGER"},
+		{`array.len();`, "ERROR: array.len must be called on an array"},
+		{`array.len = fn () { 99; };`, "ERROR: cannot change the builtin model array"},
+	})
+}
+
+func TestHashes(t *testing.T) {
+	runTests(t, []vmTest{
+		{`{z: 1, a: 2, "m": 3};`, "{z: 1, a: 2, m: 3}"},
+		{`h := {b: 1}; h.a = 2; h["c"] = 3; h.b = 4; h;`, "{b: 4, a: 2, c: 3}"},
+		{`h := {1: "a", 2.0: "b", true: "c", 'd': "e", null: "f", [1, "x"]: "g"}; [h[1.0], h[2], h[true], h['d'], h[null], h[[1, "x"]]];`,
+			"[a, b, c, e, f, g]"},
+		{`h := {1: "a", "1": "b"}; [h[1], h["1"], h.len()];`, "[a, b, 2]"},
+		{`h := {}; h[[1]] = 1; h[[1.0]] += 1; h;`, "{[1]: 2}"},
+		{`k := [1]; h := {}; h[k] = 1; k.push(2); [h, h[[1]], h[k]];`, "[{[1]: 1}, 1, <null>]"},
+		{`{1: 2}[3];`, "<null>"},
+		{`h := {y: 1}; h['y'] = 5; [h, {1: "a", "1": "b"}, {[1, "1"]: 2}];`, `[{y: 5}, {1: a, "1": b}, {[1, "1"]: 2}]`},
+		{`[{"a b": 1, c: 2}, {"if": 1}, {"x\n": 1}, {_a1: 1}];`, `[{"a b": 1, "c": 2}, {"if": 1}, {"x\n": 1}, {_a1: 1}]`},
+		{`h := {b: 1, a: 2}; [h.keys(), h.values(), h.items()];`, "[[b, a], [1, 2], [[b, 1], [a, 2]]]"},
+		{`h := {a: 1, 2: null}; [h.has("a"), h.has(2), h.has("b"), h.get("a"), h.get("b"), h.get("b", 0)];`,
+			"[true, true, false, 1, <null>, 0]"},
+		{`h := {a: 1, b: 2, c: 3}; [h.delete("b"), h.delete("b"), h];`, "[true, false, {a: 1, c: 3}]"},
+		{`h := {a: 1, b: 2}; [h.merge({b: 3, c: 4}, {a: 5}), h];`, "[{a: 5, b: 3, c: 4}, {a: 1, b: 2}]"},
+		{`h := {keys: 1}; h.values = 2; [h.keys, h.values, h.items()];`, "[1, 2, [[keys, 1], [values, 2]]]"},
+		{"m := model (x); m.keys = fn () { \"mine\"; }; [m(1).keys(), m(1).values()];", "[mine, [1]]"},
+		{`for (k | {b: 1, a: 2, 3: 4}) { k; };`, "{\"b\": b, \"a\": a, 3: 3}"},
+		{`for (i, [k, v] | {b: 1, a: 2}.items()) { "${k}=${v}"; };`, "[b=1, a=2]"},
+		{`{a, ...rest} := {a: 1, 2: 2, b: 3}; rest;`, "{2: 2, \"b\": 3}"},
+		{`h := {}; h[{}] = 1;`, "ERROR: unusable as hash key: HASH"},
+		{`{[fn () {}]: 1};`, "ERROR: unusable as hash key: ARRAY"},
+		{`a := [1]; a[0] = a; h := {}; h[a] = 1;`, "ERROR: unusable as hash key: ARRAY"},
+		{`a := [1]; b := [a, a]; {[b]: 1}[[[[1], [1]]]];`, "1"},
+		{`{}.has({});`, "ERROR: unusable as hash key: HASH"},
+		{`{}.merge(1);`, "ERROR: expected argument 1 of hash.merge to be a hash. got INTEGER"},
+		{`{}.get();`, "ERROR: invalid number of arguments to hash.get. expected 1 or 2, got 0"},
+		{`h := {keys: 5, len: 1}; [hash.keys(h), hash.items(h), hash.len(h), hash.get(h, "x", 0)];`,
+			"[[keys, len], [[keys, 5], [len, 1]], 2, 0]"},
+		{`f := hash.values; [f({a: 1}), hash(), hash().type() == object];`, "[[1], {}, true]"},
+		{`hash.keys([1]);`, "ERROR: hash.keys must be called on a hash"},
+		{`hash.keys();`, "ERROR: hash.keys must be called on a hash"},
+	})
+}
+
+func TestMatch(t *testing.T) {
+	runTests(t, []vmTest{
+		{`match 1 { 0 => "a", 1 => "b", _ => "c" };`, "b"},
+		{`match 7 { 0 => "a", 1 => "b", _ => "c" };`, "c"},
+		{`match -2 { -2 => "neg", _ => "other" };`, "neg"},
+		{`match 2.0 { 2 => "two", _ => "other" };`, "two"},
+		{`match "hi" { "hello" => 1, "hi" => 2 };`, "2"},
+		{`match null { null => "nothing" };`, "nothing"},
+		{`match [1] { 1 => "int", [1] => "array" };`, "array"},
+		{`match 5 { 1..5 => "a", _ => "b" };`, "a"},
+		{`match 5 { 1..<5 => "a", _ => "b" };`, "b"},
+		{`match 2.5 { 1..3 => "a", _ => "b" };`, "a"},
+		{`match "x" { 1..3 => "a", _ => "b" };`, "b"},
+		{"match 3 { n => n * 2 };", "6"},
+		{"match [1, 2] { [a] => a, [a, b] => a + b, _ => 0 };", "3"},
+		{"match [1, 2, 3] { [a, b] => 0, [a, ...rest] => rest };", "[2, 3]"},
+		{"match [] { [a, ...rest] => 1, [] => 2 };", "2"},
+		{"match [[1, 5], 3] { [[1, a], 2] => a, [[b, 5], c] => b + c };", "4"},
+		{"match [1, [2, 3]] { [_, [_, x]] => x };", "3"},
+		{"f := fn (v) { a := 1; match v { [{k: 2}, b, c] => 0, [h, b, c] => a + b + c }; }; f([{k: 1}, 2, 3]);", "6"},
+		{"match {x: 1, y: 2} { {x, z} => 0, {x, y} => x + y };", "3"},
+		{"match {x: 1, y: 0} { {x, y: 1} => 0, {x, y: 0} => x };", "1"},
+		{"match {t: 1, u: 2} { {t, ...others} => others.u };", "2"},
+		{"match {a: null} { {a} => 1, _ => 2 };", "1"},
+		{"match vec(1, 2) { {x, y} => x + y };", "3"},
+		{"match vec(1, 2) { vec(x, y) => x + y };", "3"},
+		{"match vec(0, 2) { vec(1, y) => 1, vec(0, y) => y };", "2"},
+		{"match vec(1, 2) { error() => 1, vec() => 2 };", "2"},
+		{"m := model (a, b); match m(1, 2) { vec(x, y) => 0, m(a, b) => a - b };", "-1"},
+		{"p := model (a) : vec (a, a); match p(3) { vec(x, y) => x * y };", "9"},
+		{"match {x: 1, y: 2} { vec(x, y) => 0, _ => 1 };", "1"},
+		{"match 4 { n if n > 5 => 1, n if n > 3 => 2, _ => 3 };", "2"},
+		{"match [1, 2] { [a, b] if a > b => a, [a, b] => b };", "2"},
+		{"x := 1; match 2 { x => x }; x;", "1"},
+		{"n := 10; match 5 { 0..n => n };", "10"},
+		{"fs := for (i | [1, 2]) { match i { n => \\() = n } }; [fs[0](), fs[1]()];", "[0, 1]"},
+		{"for (i | 0..<4) { match i { 1 => { next; }, 3 => { break; }, _ => i } };", "[0, 2]"},
+		{"f := fn (x) { match x { 0 => { return \"zero\"; }, _ => 1 }; 2; }; [f(0), f(1)];", "[zero, 2]"},
+		{"match 5 { 1 => 1, 2 => 2 };", "ERROR: no pattern matched 5"},
+		{"try { match [1] { [] => 1 }; } catch (e) { e.kind; };", "match"},
+		{"match 1 { 1.. \"b\" => 1 };", "ERROR: expected numbers or characters for a range in a pattern. got 1 and b"},
+		{"match 'q' { 'a'..'m' => 1, 'n'..'z' => 2 };", "2"},
+		{"match 'q' { 0..200 => 1, _ => 2 };", "2"},
+		{"match 5 { 'a'..'z' => 1, _ => 2 };", "2"},
+		{"match \"hé\" { [a, b] => [b, a] };", "[é, h]"},
+		{"match 'a' { 'a'..2 => 1 };", "ERROR: expected numbers or characters for a range in a pattern. got a and 2"},
+		{"match vec(1, 2) { vec(x) => x };", "ERROR: invalid number of patterns for the properties of a model. expected 2, got 1"},
+		{"f := 1; match 1 { f(x) => x };", "ERROR: expected a model in a pattern. got INTEGER"},
+	})
+}
+
+func TestFunctions(t *testing.T) {
+	runTests(t, []vmTest{
+		{"f := fn (a, b) { a * b; }; f(3, 4);", "12"},
+		{"f := \\(x) = x + 1; f(1);", "2"},
+		{"fact := fn (n) { if (n <= 1) { return 1; }; n * fact(n - 1); }; fact(5);", "120"},
+		{"adder := fn (k) { \\(x) = x + k; }; adder(3)(4);", "7"},
+		{"counter := fn () { c := 0; \\() = c = c + 1; }; f := counter(); f(); f();", "2"},
+		{"outer := fn () { inner := fn (n) { if (n == 0) { return 0; }; inner(n - 1); }; inner(3); }; outer();", "0"},
+		{"x := 1; f := fn () { x = x + 1; }; f(); f(); x;", "3"},
+		{"f := fn (x) { x; }; f;", "fn (x) { x }"},
+	})
+}
+
+func TestLoops(t *testing.T) {
+	runTests(t, []vmTest{
+		{"for (i | [4, 5, 6]) { i * 2; };", "[0, 2, 4]"},
+		{"for (i | [1, 2, 3, 4]) { if (i == 2) { break; }; i; };", "[0, 1]"},
+		{"for (i | [1, 2, 3, 4]) { if (i % 2 == 0) { next; }; i; };", "[1, 3]"},
+		{`for (i | "abc") { i; };`, "012"},
+		{"for (k | {a: 1}) { 5; };", "{a: 5}"},
+		{"for (i, x | [5, 6]) { i * x; };", "[0, 6]"},
+		{`for (i, c | "ab") { "${i}${c}"; };`, "0a1b"},
+		{"for (k, v | {a: 1, b: 2}) { v * 10; };", "{a: 10, b: 20}"},
+		{"i := 0; while (i < 3) { i = i + 1; };", "[1, 2, 3]"},
+		{"i := 0; while (true) { i = i + 1; if (i > 2) { break; }; i; };", "[1, 2]"},
+		{"fs := for (i | [1, 2, 3]) { \\() = i; }; [fs[0](), fs[2]()];", "[0, 2]"},
+		{"f := fn () { for (i | [1, 2, 3]) { if (i == 1) { return 10; }; }; }; f();", "10"},
+		{"for (i | [1, 2]) { for (j | [1, 2]) { i * 10 + j; }; };", "[[0, 1], [10, 11]]"},
+	})
+}
+
+func TestModels(t *testing.T) {
+	runTests(t, []vmTest{
+		{"point := model (x, y); p := point(1, 2); p.x + p.y;", "3"},
+		{"point := model (x, y); point.sum = fn () { this.x + this.y; }; point(3, 4).sum();", "7"},
+		{"v := model (x); v._plus = fn (o) { v(this.x + o.x); }; (v(1) + v(2)).x;", "3"},
+		{"v := model (x); v._eq = fn (o) { true; }; v(1) == v(2);", "true"},
+		{"a := model (x); b := model (x, y) : a (x * 2); b(1, 2).x;", "2"},
+		{"a := model (x); b := model (y) : a (y); b(1).type() == b;", "true"},
+		{"a := model (x); a._new = fn () { this.x = this.x + 1; this; }; a(1).x;", "2"},
+		{"v := vec(1, 2) + vec(3, 4); [v.x, v.y];", "[4, 6]"},
+		{"b := model (n); b._bit_not = fn () { b(~this.n); }; (~b(1)).n;", "-2"},
+		{"b := model (n); b._bit_xor = fn (o) { b(this.n ^ o.n); }; (b(6) ^ b(3)).n;", "5"},
+		{"~{a: 1};", "ERROR: operator ~ not overloaded. to overload, use the special method _bit_not"},
+	})
+}
+
+func TestErrors(t *testing.T) {
+	runTests(t, []vmTest{
+		{"try { err(\"x\"); } catch (e) { e.message; };", "x"},
+		{"try { 1 + \"a\"; } catch (e) { e.kind; };", "type"},
+		{"try { 5; } catch { 6; };", "5"},
+		{"f := fn () { err(\"inner\"); }; try { f(); } catch (e) { e.message; };", "inner"},
+		{"x := 0; try { err(\"a\"); } catch { x = 1; } finally { x = x + 10; }; x;", "11"},
+		{"f := fn () { try { return 1; } finally { print(); }; }; f();", "1"},
+		{"r := for (i | [1, 2, 3]) { try { if (i == 1) { break; }; i; } finally { 0; }; }; r;", "[0]"},
+		{"try { try { err(\"a\"); } finally { 1; }; } catch (e) { e.message; };", "a"},
+		{"try { try { err(\"a\"); } catch (e) { err(\"b\"); }; } catch (e) { e.message; };", "b"},
+		{"try { [1][5]; } catch (e) { e.kind; };", "index"},
+		{"try { undefined; } catch (e) { e.message; };", "identifier not found: undefined"},
+		{"f := fn (a) { a; }; try { f(); } catch (e) { e.kind; };", "argument"},
+		{"try { for (i | nope) { i; }; } catch (e) { e.kind; };", "name"},
+		{"try { for (i | err(\"x\")) { i; }; } catch (e) { e.kind; };", "error"},
+		{"1 + true;", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	})
+}
+
+func TestErrorPositions(t *testing.T) {
+	input := `f := fn (x) {
+  x + "a";
+};
+
+f(1);`
+
+	evaluated, run := runBoth(t, input)
+
+	for _, result := range []object.Object{evaluated, run} {
+		err, ok := result.(*object.Error)
+		if !ok {
+			t.Fatalf("expected an error, got %s", result.Inspect())
+		}
+
+		if err.Pos.String() != "test.lang:2:5" {
+			t.Errorf("expected the error at test.lang:2:5, got %v", err.Pos)
+		}
+
+		if err.Traceback() != "traceback (most recent call last):\n  test.lang:5:2 in <main>\n  test.lang:2:5 in f" {
+			t.Errorf("wrong traceback:\n%s", err.Traceback())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	limits := object.Limits{MaxSteps: 300, MaxDepth: 50, MaxAllocation: 100}
+
+	tests := []vmTest{
+		{"while (true) { 1; };", "ERROR: step limit of 300 exceeded"},
+		{"try { while (true) { 1; }; } catch (e) { e.kind; };", "steps"},
+		{"f := fn () { f(); }; try { f(); } catch (e) { e.kind; };", "recursion"},
+		{"try { 1..1000; } catch (e) { e.kind; };", "memory"},
+		{"(1..100)[-1];", "100"},
+		{`s := "a"; try { while (true) { s = s + s; }; } catch (e) { e.kind; };`, "memory"},
+		{`try { for (i | "0123456789012345678901234567890123456789") { "abc"; }; } catch (e) { e.kind; };`, "memory"},
+		{`s := "0123456789"; try { "${s}${s}${s}${s}${s}${s}${s}${s}${s}${s}${s}"; } catch (e) { e.kind; };`, "memory"},
+		{`try { "ab".repeat(51); } catch (e) { e.kind; };`, "memory"},
+		{`try { "a".pad_left(1000); } catch (e) { e.kind; };`, "memory"},
+		{`a := 1..100; try { a.push(1); } catch (e) { e.kind; };`, "memory"},
+		{`try { (1..50).join(","); } catch (e) { e.kind; };`, "memory"},
+		{`try { for (i | 1..1000) { i; }; } catch (e) { e.kind; };`, "memory"},
+	}
+
+	for _, tt := range tests {
+		evaluated, run := runBothWithLimits(t, tt.input, limits)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("evaluator: expected %q to give %s, got %s", tt.input, tt.expected, evaluated.Inspect())
+		}
+
+		if run.Inspect() != tt.expected {
+			t.Errorf("vm: expected %q to give %s, got %s", tt.input, tt.expected, run.Inspect())
+		}
+	}
+}
+
+// TestCatchingTimeouts checks that a program which keeps catching the error
+// from its time limit still stops soon after the limit.
+func TestCatchingTimeouts(t *testing.T) {
+	input := "f := fn () { try { f(); } catch (e) { f(); }; }; f();"
+
+	program := parser.New(lexer.NewFile("test.lang", input)).ParseProgram()
+	bytecode := compiler.New().Compile(program)
+
+	backends := map[string]func(env *object.Environment) object.Object{
+		"evaluator": func(env *object.Environment) object.Object { return evaluator.Eval(program, env) },
+		"vm":        func(env *object.Environment) object.Object { return New(bytecode, env).Run() },
+	}
+
+	for name, run := range backends {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+
+		env := object.NewEnvironment()
+		env.Runtime().SetContext(ctx)
+
+		start := time.Now()
+		result := run(env)
+		elapsed := time.Since(start)
+		cancel()
+
+		if err, ok := result.(*object.Error); !ok || err.Kind != object.TIMEOUT_ERROR {
+			t.Errorf("%s: expected a timeout, got %s", name, result.Inspect())
+		}
+
+		if elapsed > time.Second {
+			t.Errorf("%s: expected the program to stop soon after its time limit, took %v", name, elapsed)
+		}
+	}
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []string{
+		"break;",
+		"f := fn () { next; };",
+		"a[0] := 1;",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		c := compiler.New()
+		c.Compile(program)
+
+		if len(c.Errors()) == 0 {
+			t.Errorf("expected a compiler error for %q", input)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := `scale := 3;
+times := fn (x) { x * scale; };`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "lib.lang"), []byte(lib), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	main := filepath.Join(dir, "main.lang")
+	input := `import times from "lib";
+lib := import "lib";
+[times(2), lib.scale];`
+
+	l := lexer.NewFile(main, input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	c := compiler.New()
+	result := New(c.Compile(program), object.NewEnvironment()).Run()
+
+	if result.Inspect() != "[6, 3]" {
+		t.Errorf("expected [6, 3], got %s", result.Inspect())
+	}
+}